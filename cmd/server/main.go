@@ -6,10 +6,26 @@ import (
 	"Datapolis/internal/repository"
 	"Datapolis/internal/routes"
 	service "Datapolis/internal/services"
+	"context"
+	"errors"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout — сколько ждать завершения текущих запросов при остановке
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+	// фоновые задачи (очистка корзины, очередь импорта) и HTTP-сервер
+	// останавливаются по SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	db.ConnectDB()
 	defer db.Pool.Close()
 
@@ -19,14 +35,42 @@ func main() {
 	authService := service.NewAuthService(userRepo)
 	authHandler := handlers.NewAuthHandler(authService)
 	geoJSONRepo := repository.NewGeoRepository(db.Pool)
-	geoJSONService := service.NewGeoService(geoJSONRepo)
-	geoJSONHandler := handlers.NewGeoJSONHandler(geoJSONService)
+	tileCacheSize, _ := strconv.Atoi(os.Getenv("TILE_CACHE_SIZE"))
+	tileCache := service.NewTileCache(tileCacheSize, os.Getenv("TILE_CACHE_DIR"))
+	geoJSONService := service.NewGeoService(geoJSONRepo, tileCache)
+	trashRetention, err := service.ParseTrashRetention(os.Getenv("TRASH_RETENTION"))
+	if err != nil {
+		log.Fatalf("TRASH_RETENTION: %v", err)
+	}
+	geoJSONService.StartTrashPurge(ctx, trashRetention)
+	importWorkers, _ := strconv.Atoi(os.Getenv("IMPORT_WORKERS"))
+	importJobs := service.NewImportJobs(
+		repository.NewImportJobRepository(db.Pool), geoJSONService, os.Getenv("IMPORT_DIR"), importWorkers)
+	if err := importJobs.Start(ctx); err != nil {
+		log.Fatalf("Не удалось запустить очередь импорта: %v", err)
+	}
+	geoJSONHandler := handlers.NewGeoJSONHandler(geoJSONService, importJobs)
+	ogcHandler := handlers.NewOGCHandler(geoJSONService)
 
-	router := routes.Router(userHandler, authHandler, geoJSONHandler)
+	router := routes.Router(userHandler, authHandler, geoJSONHandler, ogcHandler)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	router.Run(":" + port)
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP-сервер: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Остановка сервера")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP-сервер: %v", err)
+	}
 }
@@ -0,0 +1,204 @@
+// Package cql2 разбирает фильтры Common Query Language (OGC API – Features,
+// Part 3) в кодировках CQL2-text и CQL2-JSON и переводит их в SQL для PostGIS.
+package cql2
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"Datapolis/internal/geo"
+)
+
+// ErrUnsupported — в фильтре встретился оператор или функция, которые
+// сервер не поддерживает.
+var ErrUnsupported = errors.New("не поддерживается")
+
+// Error — ошибка разбора или проверки фильтра; всегда вина клиента.
+type Error struct {
+	Msg string
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return "CQL2: " + e.Msg + ": " + e.Err.Error()
+	}
+	return "CQL2: " + e.Msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func errorf(format string, args ...any) error {
+	return &Error{Msg: fmt.Sprintf(format, args...)}
+}
+
+func unsupported(what string) error {
+	return &Error{Msg: what, Err: ErrUnsupported}
+}
+
+// Expr — логическое выражение фильтра.
+type Expr interface{ expr() }
+
+// Operand — значение внутри выражения: свойство или литерал.
+type Operand interface{ operand() }
+
+type (
+	// Logical — and/or над несколькими выражениями.
+	Logical struct {
+		Op   string // "and" | "or"
+		Args []Expr
+	}
+	// Not — отрицание выражения.
+	Not struct{ Arg Expr }
+	// BoolLiteral — TRUE или FALSE на месте условия.
+	BoolLiteral struct{ Value bool }
+	// Comparison — =, <>, <, <=, >, >=.
+	Comparison struct {
+		Op          string
+		Left, Right Operand
+	}
+	// Like — сравнение строки с шаблоном (% и _).
+	Like struct {
+		Value   Operand
+		Pattern string
+	}
+	// Between — Value BETWEEN Low AND High.
+	Between struct{ Value, Low, High Operand }
+	// In — Value IN (List...).
+	In struct {
+		Value Operand
+		List  []Operand
+	}
+	// IsNull — значение отсутствует или равно null.
+	IsNull struct{ Value Operand }
+	// Spatial — пространственный предикат s_intersects, s_within и т. п.
+	Spatial struct {
+		Op          string
+		Left, Right Operand
+	}
+)
+
+type (
+	// Property — ссылка на свойство фичи. Имена geometry и id
+	// обозначают геометрию и идентификатор самой фичи.
+	Property struct{ Name string }
+	// String — строковый литерал.
+	String struct{ Value string }
+	// Number — числовой литерал.
+	Number struct{ Value float64 }
+	// Bool — логический литерал.
+	Bool struct{ Value bool }
+	// Timestamp — литерал TIMESTAMP или DATE.
+	Timestamp struct{ Value time.Time }
+	// GeometryLiteral — геометрия (WKT, GeoJSON или BBOX).
+	GeometryLiteral struct{ Geometry *geo.Geometry }
+	// Envelope — литерал BBOX(minx, miny, maxx, maxy).
+	Envelope struct{ MinX, MinY, MaxX, MaxY float64 }
+)
+
+func (Logical) expr()     {}
+func (Not) expr()         {}
+func (BoolLiteral) expr() {}
+func (Comparison) expr()  {}
+func (Like) expr()        {}
+func (Between) expr()     {}
+func (In) expr()          {}
+func (IsNull) expr()      {}
+func (Spatial) expr()     {}
+
+func (Property) operand()        {}
+func (String) operand()          {}
+func (Number) operand()          {}
+func (Bool) operand()            {}
+func (Timestamp) operand()       {}
+func (GeometryLiteral) operand() {}
+func (Envelope) operand()        {}
+
+// Имена свойств, которые ссылаются на столбцы geo_features, а не на properties.
+const (
+	GeometryProperty = "geometry"
+	IDProperty       = "id"
+)
+
+// spatialOps сопоставляет пространственные операторы CQL2 функциям PostGIS.
+var spatialOps = map[string]string{
+	"s_intersects": "ST_Intersects",
+	"s_disjoint":   "ST_Disjoint",
+	"s_contains":   "ST_Contains",
+	"s_within":     "ST_Within",
+	"s_touches":    "ST_Touches",
+	"s_crosses":    "ST_Crosses",
+	"s_overlaps":   "ST_Overlaps",
+	"s_equals":     "ST_Equals",
+}
+
+var comparisonOps = map[string]bool{"=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// isGeometry сообщает, является ли операнд геометрическим.
+func isGeometry(o Operand) bool {
+	switch v := o.(type) {
+	case GeometryLiteral, Envelope:
+		return true
+	case Property:
+		return v.Name == GeometryProperty
+	}
+	return false
+}
+
+// check проверяет типы операндов, чтобы перевод в SQL не мог завершиться ошибкой.
+func check(e Expr) error {
+	switch v := e.(type) {
+	case Logical:
+		for _, a := range v.Args {
+			if err := check(a); err != nil {
+				return err
+			}
+		}
+	case Not:
+		return check(v.Arg)
+	case Comparison:
+		if isGeometry(v.Left) || isGeometry(v.Right) {
+			return errorf("геометрию нельзя сравнивать оператором %s", v.Op)
+		}
+		_, err := commonKind(v.Left, v.Right)
+		return err
+	case Like:
+		if isGeometry(v.Value) {
+			return errorf("геометрию нельзя сравнивать с шаблоном")
+		}
+	case Between:
+		for _, o := range []Operand{v.Value, v.Low, v.High} {
+			if isGeometry(o) {
+				return errorf("геометрия в between")
+			}
+		}
+		_, err := commonKind(v.Value, v.Low, v.High)
+		return err
+	case In:
+		if isGeometry(v.Value) {
+			return errorf("геометрия в in")
+		}
+		for _, o := range v.List {
+			if _, ok := o.(Property); ok || isGeometry(o) {
+				return errorf("список in должен состоять из литералов")
+			}
+		}
+		_, err := commonKind(append([]Operand{v.Value}, v.List...)...)
+		return err
+	case IsNull:
+		if isGeometry(v.Value) {
+			return errorf("геометрия фичи не может быть null")
+		}
+	case Spatial:
+		if !isGeometry(v.Left) || !isGeometry(v.Right) {
+			return errorf("аргументы %s должны быть геометриями", v.Op)
+		}
+		for _, o := range []Operand{v.Left, v.Right} {
+			if g, ok := o.(GeometryLiteral); ok && g.Geometry.Coordinates == nil && len(g.Geometry.Geometries) == 0 {
+				return unsupported("пустая геометрия")
+			}
+		}
+	}
+	return nil
+}
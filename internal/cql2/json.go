@@ -0,0 +1,221 @@
+package cql2
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"Datapolis/internal/geo"
+)
+
+// ParseJSON разбирает фильтр в кодировке CQL2-JSON, например
+// {"op": ">", "args": [{"property": "floors"}, 5]}.
+func ParseJSON(data []byte) (Expr, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var node any
+	if err := dec.Decode(&node); err != nil {
+		return nil, &Error{Msg: "неверный JSON", Err: err}
+	}
+
+	e, err := jsonExpr(node)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func jsonExpr(node any) (Expr, error) {
+	if b, ok := node.(bool); ok {
+		return BoolLiteral{Value: b}, nil
+	}
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return nil, errorf("ожидалось логическое выражение")
+	}
+	op, _ := obj["op"].(string)
+	if op == "" {
+		return nil, errorf("у выражения нет op")
+	}
+	op = strings.ToLower(op)
+	args, _ := obj["args"].([]any)
+
+	switch op {
+	case "and", "or":
+		if len(args) < 2 {
+			return nil, errorf("%s требует не менее двух аргументов", op)
+		}
+		l := Logical{Op: op}
+		for _, a := range args {
+			e, err := jsonExpr(a)
+			if err != nil {
+				return nil, err
+			}
+			l.Args = append(l.Args, e)
+		}
+		return l, nil
+
+	case "not":
+		if len(args) != 1 {
+			return nil, errorf("not требует один аргумент")
+		}
+		e, err := jsonExpr(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return Not{Arg: e}, nil
+
+	case "like":
+		ops, err := jsonOperands(op, args, 2)
+		if err != nil {
+			return nil, err
+		}
+		pattern, ok := ops[1].(String)
+		if !ok {
+			return nil, errorf("шаблон like должен быть строкой")
+		}
+		return Like{Value: ops[0], Pattern: pattern.Value}, nil
+
+	case "between":
+		ops, err := jsonOperands(op, args, 3)
+		if err != nil {
+			return nil, err
+		}
+		return Between{Value: ops[0], Low: ops[1], High: ops[2]}, nil
+
+	case "in":
+		if len(args) != 2 {
+			return nil, errorf("in требует значение и список")
+		}
+		list, ok := args[1].([]any)
+		if !ok || len(list) == 0 {
+			return nil, errorf("второй аргумент in должен быть непустым массивом")
+		}
+		value, err := jsonOperand(args[0])
+		if err != nil {
+			return nil, err
+		}
+		in := In{Value: value}
+		for _, item := range list {
+			o, err := jsonOperand(item)
+			if err != nil {
+				return nil, err
+			}
+			in.List = append(in.List, o)
+		}
+		return in, nil
+
+	case "isnull":
+		ops, err := jsonOperands(op, args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return IsNull{Value: ops[0]}, nil
+	}
+
+	if comparisonOps[op] {
+		ops, err := jsonOperands(op, args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return Comparison{Op: op, Left: ops[0], Right: ops[1]}, nil
+	}
+	if _, ok := spatialOps[op]; ok {
+		ops, err := jsonOperands(op, args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return Spatial{Op: op, Left: ops[0], Right: ops[1]}, nil
+	}
+	return nil, unsupported("оператор " + op)
+}
+
+func jsonOperands(op string, args []any, n int) ([]Operand, error) {
+	if len(args) != n {
+		return nil, errorf("%s требует %d аргумента(ов)", op, n)
+	}
+	ops := make([]Operand, n)
+	for i, a := range args {
+		o, err := jsonOperand(a)
+		if err != nil {
+			return nil, err
+		}
+		ops[i] = o
+	}
+	return ops, nil
+}
+
+func jsonOperand(node any) (Operand, error) {
+	switch v := node.(type) {
+	case string:
+		return String{Value: v}, nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, errorf("неверное число %s", v)
+		}
+		return Number{Value: f}, nil
+	case bool:
+		return Bool{Value: v}, nil
+	case map[string]any:
+		return jsonObjectOperand(v)
+	}
+	return nil, errorf("ожидалось свойство или значение")
+}
+
+func jsonObjectOperand(obj map[string]any) (Operand, error) {
+	if name, ok := obj["property"].(string); ok {
+		return Property{Name: name}, nil
+	}
+	if s, ok := obj["timestamp"].(string); ok {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, errorf("неверный timestamp %q", s)
+		}
+		return Timestamp{Value: t}, nil
+	}
+	if s, ok := obj["date"].(string); ok {
+		t, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			return nil, errorf("неверная date %q", s)
+		}
+		return Timestamp{Value: t}, nil
+	}
+	if raw, ok := obj["bbox"].([]any); ok {
+		nums := make([]float64, len(raw))
+		for i, n := range raw {
+			num, ok := n.(json.Number)
+			if !ok {
+				return nil, errorf("bbox должен состоять из чисел")
+			}
+			f, err := num.Float64()
+			if err != nil {
+				return nil, errorf("неверное число %s", num)
+			}
+			nums[i] = f
+		}
+		return envelope(nums)
+	}
+	if _, ok := obj["type"]; ok {
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		g := new(geo.Geometry)
+		if err := json.Unmarshal(raw, g); err != nil {
+			return nil, &Error{Msg: "геометрия", Err: err}
+		}
+		return GeometryLiteral{Geometry: g}, nil
+	}
+	if op, ok := obj["op"].(string); ok {
+		return nil, unsupported("функция или оператор " + op + " в качестве значения")
+	}
+	if _, ok := obj["interval"]; ok {
+		return nil, unsupported("интервалы времени")
+	}
+	return nil, errorf("неизвестное значение")
+}
@@ -0,0 +1,200 @@
+package cql2
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SQL переводит выражение в условие WHERE по таблице geo_features.
+// Значения из фильтра в текст запроса не попадают — только через Bind.
+type SQL struct {
+	// Bind добавляет параметр запроса и возвращает его плейсхолдер.
+	Bind func(v any) string
+	// StorageSRID — SQL-выражение SRID хранения геометрии коллекции.
+	StorageSRID string
+	// FilterSRID — SRID геометрий-литералов фильтра.
+	FilterSRID int
+}
+
+// Тип, к которому приводятся обе стороны сравнения.
+type kind int
+
+const (
+	kindJSON kind = iota // свойство со свойством — сравнение jsonb
+	kindNumber
+	kindText
+	kindBool
+	kindTime
+)
+
+var kindCasts = map[kind]string{
+	kindNumber: "float8",
+	kindText:   "text",
+	kindBool:   "boolean",
+	kindTime:   "timestamptz",
+}
+
+// Where возвращает условие для выражения e.
+func (s SQL) Where(e Expr) (string, error) {
+	switch v := e.(type) {
+	case Logical:
+		parts := make([]string, len(v.Args))
+		for i, a := range v.Args {
+			p, err := s.Where(a)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = p
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(v.Op)+" ") + ")", nil
+
+	case Not:
+		p, err := s.Where(v.Arg)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + p, nil
+
+	case BoolLiteral:
+		if v.Value {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+
+	case Comparison:
+		k, err := commonKind(v.Left, v.Right)
+		if err != nil {
+			return "", err
+		}
+		return s.value(v.Left, k) + " " + v.Op + " " + s.value(v.Right, k), nil
+
+	case Like:
+		return s.value(v.Value, kindText) + " LIKE " + s.Bind(v.Pattern), nil
+
+	case Between:
+		k, err := commonKind(v.Value, v.Low, v.High)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s BETWEEN %s AND %s", s.value(v.Value, k), s.value(v.Low, k), s.value(v.High, k)), nil
+
+	case In:
+		k, err := commonKind(append([]Operand{v.Value}, v.List...)...)
+		if err != nil {
+			return "", err
+		}
+		items := make([]string, len(v.List))
+		for i, o := range v.List {
+			items[i] = s.value(o, k)
+		}
+		return s.value(v.Value, k) + " IN (" + strings.Join(items, ", ") + ")", nil
+
+	case IsNull:
+		p, ok := v.Value.(Property)
+		if !ok {
+			return "FALSE", nil
+		}
+		if p.Name == IDProperty {
+			return "id IS NULL", nil
+		}
+		path := s.Bind(propertyPath(p.Name))
+		return fmt.Sprintf("(properties #> %s IS NULL OR jsonb_typeof(properties #> %s) = 'null')", path, path), nil
+
+	case Spatial:
+		return fmt.Sprintf("%s(%s, %s)", spatialOps[v.Op], s.geometry(v.Left), s.geometry(v.Right)), nil
+	}
+	return "", fmt.Errorf("cql2: неизвестное выражение %T", e)
+}
+
+// commonKind выбирает тип сравнения по литералам среди операндов.
+// Идентификатор фичи — число; два свойства сравниваются как jsonb.
+func commonKind(ops ...Operand) (kind, error) {
+	k := kindJSON
+	for _, o := range ops {
+		var lk kind
+		switch v := o.(type) {
+		case Number:
+			lk = kindNumber
+		case String:
+			lk = kindText
+		case Bool:
+			lk = kindBool
+		case Timestamp:
+			lk = kindTime
+		case Property:
+			if v.Name != IDProperty {
+				continue
+			}
+			lk = kindNumber
+		default:
+			continue
+		}
+		if k != kindJSON && k != lk {
+			return 0, errorf("операнды разных типов нельзя сравнивать")
+		}
+		k = lk
+	}
+	return k, nil
+}
+
+// value возвращает SQL-выражение операнда, приведённое к типу k.
+// Значение свойства другого JSON-типа превращается в NULL, поэтому
+// сравнение с ним ложно, а не завершается ошибкой приведения.
+func (s SQL) value(o Operand, k kind) string {
+	switch v := o.(type) {
+	case Property:
+		if v.Name == IDProperty {
+			if k == kindText {
+				return "id::text"
+			}
+			return "id"
+		}
+		path := s.Bind(propertyPath(v.Name))
+		switch k {
+		case kindJSON:
+			return "properties #> " + path
+		case kindText:
+			return "properties #>> " + path
+		case kindNumber:
+			return fmt.Sprintf("CASE WHEN jsonb_typeof(properties #> %s) = 'number' THEN (properties #>> %s)::float8 END", path, path)
+		case kindBool:
+			return fmt.Sprintf("CASE WHEN jsonb_typeof(properties #> %s) = 'boolean' THEN (properties #>> %s)::boolean END", path, path)
+		case kindTime:
+			// cql2_timestamptz (миграция 009) не падает на невозможных датах
+			return fmt.Sprintf(`CASE WHEN properties #>> %s ~ '^\d{4}-\d{2}-\d{2}' THEN cql2_timestamptz(properties #>> %s) END`, path, path)
+		}
+	case Number:
+		return s.Bind(v.Value) + "::" + kindCasts[k]
+	case String:
+		return s.Bind(v.Value) + "::" + kindCasts[k]
+	case Bool:
+		return s.Bind(v.Value) + "::" + kindCasts[k]
+	case Timestamp:
+		return s.Bind(v.Value) + "::" + kindCasts[k]
+	}
+	return "NULL"
+}
+
+// geometry возвращает геометрический операнд в SRID хранения.
+func (s SQL) geometry(o Operand) string {
+	switch v := o.(type) {
+	case Property:
+		return "geometry"
+	case Envelope:
+		return fmt.Sprintf("ST_Transform(ST_MakeEnvelope(%s, %s, %s, %s, %s), %s)",
+			s.Bind(v.MinX), s.Bind(v.MinY), s.Bind(v.MaxX), s.Bind(v.MaxY),
+			s.Bind(s.FilterSRID), s.StorageSRID)
+	case GeometryLiteral:
+		// Geometry состоит из срезов и чисел, Marshal не может завершиться ошибкой
+		raw, _ := json.Marshal(v.Geometry)
+		return fmt.Sprintf("ST_Transform(ST_SetSRID(ST_GeomFromGeoJSON(%s), %s), %s)",
+			s.Bind(string(raw)), s.Bind(s.FilterSRID), s.StorageSRID)
+	}
+	return "NULL"
+}
+
+// propertyPath разбивает имя вида address.city на путь во вложенных объектах.
+func propertyPath(name string) []string {
+	return strings.Split(name, ".")
+}
@@ -0,0 +1,168 @@
+package cql2
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// whereSQL переводит выражение в SQL, собирая параметры запроса.
+func whereSQL(e Expr) (string, []any, error) {
+	var args []any
+	s := SQL{
+		Bind: func(v any) string {
+			args = append(args, v)
+			return fmt.Sprintf("$%d", len(args))
+		},
+		StorageSRID: "srid",
+		FilterSRID:  4326,
+	}
+	where, err := s.Where(e)
+	return where, args, err
+}
+
+func TestWhere(t *testing.T) {
+	numProp := func(p string) string {
+		return fmt.Sprintf("CASE WHEN jsonb_typeof(properties #> %s) = 'number' THEN (properties #>> %s)::float8 END", p, p)
+	}
+	tests := []struct {
+		name string
+		text string
+		json string // та же фильтрация в CQL2-JSON, "" — не проверяется
+		want string
+		args []any
+	}{
+		{
+			name: "число",
+			text: `floors > 5`,
+			json: `{"op":">","args":[{"property":"floors"},5]}`,
+			want: numProp("$1") + " > $2::float8",
+			args: []any{[]string{"floors"}, 5.0},
+		},
+		{
+			name: "строка и вложенное свойство",
+			text: `address.city = 'Москва'`,
+			json: `{"op":"=","args":[{"property":"address.city"},"Москва"]}`,
+			want: "properties #>> $1 = $2::text",
+			args: []any{[]string{"address", "city"}, "Москва"},
+		},
+		{
+			name: "литерал слева",
+			text: `5 <= floors`,
+			want: "$1::float8 <= " + numProp("$2"),
+			args: []any{5.0, []string{"floors"}},
+		},
+		{
+			name: "логическое",
+			text: `active = true`,
+			json: `{"op":"=","args":[{"property":"active"},true]}`,
+			want: "CASE WHEN jsonb_typeof(properties #> $1) = 'boolean' THEN (properties #>> $1)::boolean END = $2::boolean",
+			args: []any{[]string{"active"}, true},
+		},
+		{
+			name: "два свойства сравниваются как jsonb",
+			text: `a = b`,
+			json: `{"op":"=","args":[{"property":"a"},{"property":"b"}]}`,
+			want: "properties #> $1 = properties #> $2",
+			args: []any{[]string{"a"}, []string{"b"}},
+		},
+		{
+			name: "идентификатор",
+			text: `id IN (1, 2)`,
+			json: `{"op":"in","args":[{"property":"id"},[1,2]]}`,
+			want: "id IN ($1::float8, $2::float8)",
+			args: []any{1.0, 2.0},
+		},
+		{
+			name: "идентификатор как текст",
+			text: `id LIKE '1%'`,
+			json: `{"op":"like","args":[{"property":"id"},"1%"]}`,
+			want: "id::text LIKE $1",
+			args: []any{"1%"},
+		},
+		{
+			name: "BETWEEN",
+			text: `floors BETWEEN 2 AND 9`,
+			json: `{"op":"between","args":[{"property":"floors"},2,9]}`,
+			want: numProp("$1") + " BETWEEN $2::float8 AND $3::float8",
+			args: []any{[]string{"floors"}, 2.0, 9.0},
+		},
+		{
+			name: "IS NULL",
+			text: `name IS NULL`,
+			json: `{"op":"isNull","args":[{"property":"name"}]}`,
+			want: "(properties #> $1 IS NULL OR jsonb_typeof(properties #> $1) = 'null')",
+			args: []any{[]string{"name"}},
+		},
+		{
+			name: "AND, OR и NOT",
+			text: `a = 1 AND (b = 'x' OR NOT c = 2)`,
+			json: `{"op":"and","args":[{"op":"=","args":[{"property":"a"},1]},{"op":"or","args":[{"op":"=","args":[{"property":"b"},"x"]},{"op":"not","args":[{"op":"=","args":[{"property":"c"},2]}]}]}]}`,
+			want: "(" + numProp("$1") + " = $2::float8 AND (properties #>> $3 = $4::text OR NOT " + numProp("$5") + " = $6::float8))",
+			args: []any{[]string{"a"}, 1.0, []string{"b"}, "x", []string{"c"}, 2.0},
+		},
+		{
+			name: "BBOX",
+			text: `S_INTERSECTS(geometry, BBOX(30, 59, 31, 60))`,
+			json: `{"op":"s_intersects","args":[{"property":"geometry"},{"bbox":[30,59,31,60]}]}`,
+			want: "ST_Intersects(geometry, ST_Transform(ST_MakeEnvelope($1, $2, $3, $4, $5), srid))",
+			args: []any{30.0, 59.0, 31.0, 60.0, 4326},
+		},
+		{
+			name: "геометрия",
+			text: `S_WITHIN(geometry, POINT(30 60))`,
+			json: `{"op":"s_within","args":[{"property":"geometry"},{"type":"Point","coordinates":[30,60]}]}`,
+			want: "ST_Within(geometry, ST_Transform(ST_SetSRID(ST_GeomFromGeoJSON($1), $2), srid))",
+			args: []any{`{"type":"Point","coordinates":[30,60]}`, 4326},
+		},
+		{
+			name: "кавычки в строке не попадают в запрос",
+			text: `name = 'O''Brien; DROP TABLE geo_features'`,
+			want: "properties #>> $1 = $2::text",
+			args: []any{[]string{"name"}, "O'Brien; DROP TABLE geo_features"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := func(enc string, e Expr, err error) {
+				t.Helper()
+				if err != nil {
+					t.Fatalf("%s: %v", enc, err)
+				}
+				got, args, err := whereSQL(e)
+				if err != nil {
+					t.Fatalf("%s: %v", enc, err)
+				}
+				if got != tt.want {
+					t.Errorf("%s: получили\n%s\nждали\n%s", enc, got, tt.want)
+				}
+				if !reflect.DeepEqual(args, tt.args) {
+					t.Errorf("%s: параметры %#v, ждали %#v", enc, args, tt.args)
+				}
+			}
+			e, err := ParseText(tt.text)
+			check("text", e, err)
+			if tt.json != "" {
+				e, err := ParseJSON([]byte(tt.json))
+				check("json", e, err)
+			}
+		})
+	}
+}
+
+func TestWhereTypeMismatch(t *testing.T) {
+	// разбор текста отсекает такие выражения сам, здесь они собраны вручную
+	for _, e := range []Expr{
+		Comparison{Op: "=", Left: Number{Value: 1}, Right: String{Value: "1"}},
+		Comparison{Op: "=", Left: Property{Name: IDProperty}, Right: Bool{Value: true}},
+		Between{Value: Property{Name: "floors"}, Low: Number{Value: 1}, High: String{Value: "z"}},
+		In{Value: Property{Name: "name"}, List: []Operand{String{Value: "a"}, Number{Value: 1}}},
+	} {
+		where, _, err := whereSQL(e)
+		var cqlErr *Error
+		if !errors.As(err, &cqlErr) {
+			t.Errorf("%#v: ждали *cql2.Error, получили %q, %v", e, where, err)
+		}
+	}
+}
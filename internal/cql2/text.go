@@ -0,0 +1,541 @@
+package cql2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"Datapolis/internal/geo"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// is сообщает, является ли токен ключевым словом kw (без учёта регистра).
+func (t token) is(kw string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+var reservedWords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "LIKE": true, "BETWEEN": true,
+	"IN": true, "IS": true, "NULL": true,
+}
+
+// ParseText разбирает фильтр в кодировке CQL2-text, например
+// floors > 5 AND S_INTERSECTS(geometry, POLYGON((...))).
+func ParseText(s string) (Expr, error) {
+	p := &textParser{src: s}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	e, err := p.orExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("неожиданный %q", p.tok.text)
+	}
+	if err := check(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+type textParser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *textParser) errorf(format string, args ...any) error {
+	return &Error{Msg: fmt.Sprintf("позиция %d: ", p.tok.pos+1) + fmt.Sprintf(format, args...)}
+}
+
+// advance читает следующий токен в p.tok.
+func (p *textParser) advance() error {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		p.tok = token{tokLParen, "(", start}
+	case c == ')':
+		p.pos++
+		p.tok = token{tokRParen, ")", start}
+	case c == ',':
+		p.pos++
+		p.tok = token{tokComma, ",", start}
+	case c == '=' || c == '<' || c == '>' || c == '!':
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '=' || (c == '<' && p.src[p.pos] == '>')) {
+			p.pos++
+		}
+		p.tok = token{tokOp, p.src[start:p.pos], start}
+	case c == '\'':
+		s, err := p.quoted('\'')
+		if err != nil {
+			return err
+		}
+		p.tok = token{tokString, s, start}
+	case c == '"':
+		s, err := p.quoted('"')
+		if err != nil {
+			return err
+		}
+		p.tok = token{tokQuotedIdent, s, start}
+	case isDigit(c) || ((c == '-' || c == '+' || c == '.') && p.pos+1 < len(p.src) && (isDigit(p.src[p.pos+1]) || p.src[p.pos+1] == '.')):
+		p.pos++
+		for p.pos < len(p.src) {
+			ch := p.src[p.pos]
+			if isDigit(ch) || ch == '.' || ch == 'e' || ch == 'E' ||
+				((ch == '-' || ch == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+				p.pos++
+				continue
+			}
+			break
+		}
+		p.tok = token{tokNumber, p.src[start:p.pos], start}
+	case unicode.IsLetter(rune(c)) || c == '_' || c >= 0x80:
+		for p.pos < len(p.src) {
+			r := rune(p.src[p.pos])
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == ':' || r >= 0x80 {
+				p.pos++
+				continue
+			}
+			break
+		}
+		p.tok = token{tokIdent, p.src[start:p.pos], start}
+	default:
+		p.tok = token{pos: start}
+		return p.errorf("недопустимый символ %q", c)
+	}
+	return nil
+}
+
+// quoted читает строку в кавычках q; удвоенная кавычка означает саму кавычку.
+func (p *textParser) quoted(q byte) (string, error) {
+	var b strings.Builder
+	p.pos++
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		if c == q {
+			if p.pos < len(p.src) && p.src[p.pos] == q {
+				b.WriteByte(q)
+				p.pos++
+				continue
+			}
+			return b.String(), nil
+		}
+		b.WriteByte(c)
+	}
+	return "", errorf("незакрытая кавычка")
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func (p *textParser) expect(kind tokenKind, what string) error {
+	if p.tok.kind != kind {
+		return p.errorf("ожидалось %s", what)
+	}
+	return p.advance()
+}
+
+func (p *textParser) orExpr() (Expr, error) {
+	return p.logical("or", p.andExpr)
+}
+
+func (p *textParser) andExpr() (Expr, error) {
+	return p.logical("and", p.notExpr)
+}
+
+func (p *textParser) logical(op string, next func() (Expr, error)) (Expr, error) {
+	first, err := next()
+	if err != nil {
+		return nil, err
+	}
+	args := []Expr{first}
+	for p.tok.is(op) {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e, err := next()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+	if len(args) == 1 {
+		return first, nil
+	}
+	return Logical{Op: op, Args: args}, nil
+}
+
+func (p *textParser) notExpr() (Expr, error) {
+	if p.tok.is("not") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Arg: e}, nil
+	}
+	return p.primary()
+}
+
+func (p *textParser) primary() (Expr, error) {
+	if p.tok.kind == tokLParen {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e, err := p.orExpr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(tokRParen, "')'")
+	}
+
+	if p.tok.kind == tokIdent {
+		name := strings.ToLower(p.tok.text)
+		if _, ok := spatialOps[name]; ok {
+			return p.spatial(name)
+		}
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := left.(Bool); ok && p.tok.kind != tokOp {
+		return BoolLiteral(b), nil
+	}
+	return p.predicate(left)
+}
+
+func (p *textParser) spatial(op string) (Expr, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokComma, "','"); err != nil {
+		return nil, err
+	}
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return Spatial{Op: op, Left: left, Right: right}, p.expect(tokRParen, "')'")
+}
+
+// predicate разбирает продолжение условия после левого операнда.
+func (p *textParser) predicate(left Operand) (Expr, error) {
+	if p.tok.kind == tokOp {
+		op := p.tok.text
+		if !comparisonOps[op] {
+			return nil, unsupported("оператор " + op)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return Comparison{Op: op, Left: left, Right: right}, nil
+	}
+
+	if p.tok.is("is") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		negate := p.tok.is("not")
+		if negate {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		if !p.tok.is("null") {
+			return nil, p.errorf("ожидалось NULL")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return negated(IsNull{Value: left}, negate), nil
+	}
+
+	negate := p.tok.is("not")
+	if negate {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case p.tok.is("like"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		pattern, ok := p.tok, p.tok.kind == tokString
+		if !ok {
+			return nil, p.errorf("шаблон LIKE должен быть строкой")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return negated(Like{Value: left, Pattern: pattern.text}, negate), nil
+
+	case p.tok.is("between"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		low, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.tok.is("and") {
+			return nil, p.errorf("ожидалось AND в BETWEEN")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		high, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return negated(Between{Value: left, Low: low, High: high}, negate), nil
+
+	case p.tok.is("in"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokLParen, "'('"); err != nil {
+			return nil, err
+		}
+		var list []Operand
+		for {
+			o, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, o)
+			if p.tok.kind != tokComma {
+				break
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return negated(In{Value: left, List: list}, negate), nil
+	}
+
+	if p.tok.kind == tokEOF {
+		return nil, p.errorf("неожиданный конец фильтра")
+	}
+	return nil, p.errorf("неожиданный %q", p.tok.text)
+}
+
+func negated(e Expr, negate bool) Expr {
+	if negate {
+		return Not{Arg: e}
+	}
+	return e
+}
+
+// operand разбирает свойство или литерал.
+func (p *textParser) operand() (Operand, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		return String{Value: tok.text}, p.advance()
+	case tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("неверное число %q", tok.text)
+		}
+		return Number{Value: v}, p.advance()
+	case tokQuotedIdent:
+		return Property{Name: tok.text}, p.advance()
+	case tokIdent:
+	default:
+		return nil, p.errorf("ожидалось свойство или значение")
+	}
+
+	upper := strings.ToUpper(tok.text)
+	switch {
+	case upper == "TRUE" || upper == "FALSE":
+		return Bool{Value: upper == "TRUE"}, p.advance()
+	case upper == "TIMESTAMP" || upper == "DATE":
+		return p.timestamp(upper)
+	case upper == "BBOX":
+		return p.bbox()
+	case geo.IsWKTKeyword(upper):
+		return p.wkt()
+	case reservedWords[upper]:
+		return nil, p.errorf("неожиданное ключевое слово %s", upper)
+	}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokLParen {
+		return nil, unsupported("функция " + tok.text)
+	}
+	return Property{Name: tok.text}, nil
+}
+
+func (p *textParser) timestamp(kind string) (Operand, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokString {
+		return nil, p.errorf("ожидалась строка с датой")
+	}
+	t, err := parseTime(kind, p.tok.text)
+	if err != nil {
+		return nil, p.errorf("неверная дата %q", p.tok.text)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return Timestamp{Value: t}, p.expect(tokRParen, "')'")
+}
+
+func parseTime(kind, s string) (time.Time, error) {
+	if kind == "DATE" {
+		return time.Parse(time.DateOnly, s)
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (p *textParser) bbox() (Operand, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+	var nums []float64
+	for {
+		if p.tok.kind != tokNumber {
+			return nil, p.errorf("ожидалось число в BBOX")
+		}
+		v, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("неверное число %q", p.tok.text)
+		}
+		nums = append(nums, v)
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokComma {
+			break
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	return envelope(nums)
+}
+
+func envelope(nums []float64) (Operand, error) {
+	switch len(nums) {
+	case 4:
+		return Envelope{nums[0], nums[1], nums[2], nums[3]}, nil
+	case 6:
+		return Envelope{nums[0], nums[1], nums[3], nums[4]}, nil
+	}
+	return nil, errorf("BBOX должен содержать 4 или 6 чисел")
+}
+
+// wkt вырезает геометрию WKT из исходной строки до парной скобки
+// и разбирает её целиком, после чего продолжает чтение токенов.
+func (p *textParser) wkt() (Operand, error) {
+	start, i := p.tok.pos, p.pos
+
+	// необязательные Z, M, ZM или EMPTY после ключевого слова
+	for {
+		for i < len(p.src) && unicode.IsSpace(rune(p.src[i])) {
+			i++
+		}
+		j := i
+		for j < len(p.src) && unicode.IsLetter(rune(p.src[j])) {
+			j++
+		}
+		switch strings.ToUpper(p.src[i:j]) {
+		case "Z", "M", "ZM", "EMPTY":
+			i = j
+			continue
+		}
+		break
+	}
+
+	end := i
+	if i < len(p.src) && p.src[i] == '(' {
+		depth := 0
+		for ; end < len(p.src); end++ {
+			if p.src[end] == '(' {
+				depth++
+			} else if p.src[end] == ')' {
+				depth--
+				if depth == 0 {
+					end++
+					break
+				}
+			}
+		}
+		if depth != 0 {
+			return nil, p.errorf("незакрытая скобка в геометрии")
+		}
+	}
+
+	g, err := geo.ParseWKT(p.src[start:end])
+	if err != nil {
+		return nil, &Error{Msg: "геометрия", Err: err}
+	}
+	p.pos = end
+	return GeometryLiteral{Geometry: g}, p.advance()
+}
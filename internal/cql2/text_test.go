@@ -0,0 +1,79 @@
+package cql2
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimeLiterals(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func() (Expr, error)
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:  "text TIMESTAMP",
+			parse: func() (Expr, error) { return ParseText(`updated > TIMESTAMP('2024-03-01T10:00:00+03:00')`) },
+			want:  time.Date(2024, 3, 1, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "text DATE",
+			parse: func() (Expr, error) { return ParseText(`updated > DATE('2024-02-29')`) },
+			want:  time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "json timestamp",
+			parse: func() (Expr, error) {
+				return ParseJSON([]byte(`{"op":">","args":[{"property":"updated"},{"timestamp":"2024-03-01T10:00:00Z"}]}`))
+			},
+			want: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{name: "text DATE вне календаря", parse: func() (Expr, error) { return ParseText(`updated > DATE('2023-02-29')`) }, wantErr: true},
+		{name: "text DATE с месяцем 13", parse: func() (Expr, error) { return ParseText(`updated > DATE('2024-13-01')`) }, wantErr: true},
+		{name: "text TIMESTAMP без зоны", parse: func() (Expr, error) { return ParseText(`updated > TIMESTAMP('2024-03-01T10:00:00')`) }, wantErr: true},
+		{name: "text TIMESTAMP с часом 25", parse: func() (Expr, error) { return ParseText(`updated > TIMESTAMP('2024-03-01T25:00:00Z')`) }, wantErr: true},
+		{name: "text DATE со временем", parse: func() (Expr, error) { return ParseText(`updated > DATE('2024-03-01T00:00:00Z')`) }, wantErr: true},
+		{
+			name: "json date вне календаря",
+			parse: func() (Expr, error) {
+				return ParseJSON([]byte(`{"op":"=","args":[{"property":"d"},{"date":"2024-04-31"}]}`))
+			},
+			wantErr: true,
+		},
+		{
+			name: "json timestamp без зоны",
+			parse: func() (Expr, error) {
+				return ParseJSON([]byte(`{"op":"=","args":[{"property":"d"},{"timestamp":"2024-03-01 10:00"}]}`))
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := tt.parse()
+			if tt.wantErr {
+				var cqlErr *Error
+				if !errors.As(err, &cqlErr) {
+					t.Fatalf("ждали *cql2.Error, получили %v (%#v)", err, e)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, ok := e.(Comparison)
+			if !ok {
+				t.Fatalf("ждали Comparison, получили %#v", e)
+			}
+			ts, ok := c.Right.(Timestamp)
+			if !ok {
+				t.Fatalf("ждали Timestamp, получили %#v", c.Right)
+			}
+			if !ts.Value.Equal(tt.want) {
+				t.Errorf("литерал %v, ждали %v", ts.Value, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,512 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// CSVOptions — параметры чтения CSV. Пустые поля определяются по файлу.
+type CSVOptions struct {
+	Delimiter rune              // 0 — по заголовку: запятая, точка с запятой, табуляция или |
+	Encoding  encoding.Encoding // nil — UTF-8, поля с неверным UTF-8 читаются как CP1251
+	LatColumn string            // столбцы координат; без них и без WKTColumn
+	LonColumn string            // ищутся lat/lon, latitude/longitude, широта/долгота...
+	WKTColumn string            // столбец с геометрией WKT (допускается EWKT с SRID=)
+}
+
+// Имена столбцов, которые узнаются без явного указания.
+var (
+	latNames = []string{"lat", "latitude", "y", "широта"}
+	lonNames = []string{"lon", "lng", "long", "longitude", "x", "долгота"}
+	wktNames = []string{"wkt", "geometry", "geom", "the_geom", "wkt_geom", "геометрия"}
+)
+
+// CSVReader превращает строки CSV в точечные фичи по столбцам координат
+// или в фичи с геометрией из столбца WKT. Остальные столбцы становятся
+// свойствами; тип столбца — число, логическое, дата или строка —
+// выводится по всем его значениям первым проходом по файлу.
+type CSVReader struct {
+	src       io.ReadSeeker
+	enc       encoding.Encoding
+	delim     rune
+	r         *csv.Reader
+	header    []string
+	kinds     []int // тип столбца свойства, csvKind*
+	lat, lon  int   // индексы столбцов; -1 — нет
+	wkt       int
+	decimal   bool // допускается десятичная запятая
+	utf8Check bool // кодировка не задана: неверный UTF-8 читается как CP1251
+	srid      int  // из EWKT
+	line      int
+	lines     []int // строки файла прочитанных записей, для Locate
+}
+
+// NewCSVReader читает заголовок, находит столбцы геометрии и выводит
+// типы столбцов свойств; файл читается дважды, поэтому нужен io.ReadSeeker.
+func NewCSVReader(r io.ReadSeeker, opts CSVOptions) (*CSVReader, error) {
+	c := &CSVReader{
+		src:       r,
+		enc:       opts.Encoding,
+		delim:     opts.Delimiter,
+		lat:       -1,
+		lon:       -1,
+		wkt:       -1,
+		utf8Check: opts.Encoding == nil,
+	}
+	header, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	c.header = make([]string, len(header))
+	for i, h := range header {
+		c.header[i] = strings.TrimSpace(c.text(h))
+	}
+
+	find := func(explicit string, names []string) (int, error) {
+		if explicit != "" {
+			for i, h := range c.header {
+				if strings.EqualFold(h, explicit) {
+					return i, nil
+				}
+			}
+			return -1, fmt.Errorf("%w: csv: нет столбца %q", ErrInvalidFile, explicit)
+		}
+		for _, name := range names {
+			for i, h := range c.header {
+				if strings.EqualFold(h, name) {
+					return i, nil
+				}
+			}
+		}
+		return -1, nil
+	}
+	if c.wkt, err = find(opts.WKTColumn, wktNames); err != nil {
+		return nil, err
+	}
+	if opts.WKTColumn == "" || opts.LatColumn != "" || opts.LonColumn != "" {
+		if c.lat, err = find(opts.LatColumn, latNames); err != nil {
+			return nil, err
+		}
+		if c.lon, err = find(opts.LonColumn, lonNames); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case c.lat >= 0 && c.lon >= 0:
+		// явно указанные или найденные координаты важнее найденного WKT
+		if opts.WKTColumn == "" {
+			c.wkt = -1
+		}
+	case c.wkt >= 0:
+		c.lat, c.lon = -1, -1
+	default:
+		return nil, fmt.Errorf("%w: csv: не найдены столбцы координат (lat/lon) или WKT: укажите их явно", ErrInvalidFile)
+	}
+	if c.wkt >= 0 && c.lat >= 0 {
+		return nil, fmt.Errorf("%w: csv: укажите либо столбцы координат, либо столбец WKT", ErrInvalidFile)
+	}
+
+	if err := c.inferKinds(); err != nil {
+		return nil, err
+	}
+	if _, err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// open начинает чтение файла с начала и читает заголовок. Разделитель
+// без явного указания определяется по заголовку при первом открытии.
+func (c *CSVReader) open() ([]string, error) {
+	if _, err := c.src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(c.src)
+	if c.enc != nil && c.enc != unicode.UTF8 {
+		br = bufio.NewReader(c.enc.NewDecoder().Reader(br))
+	}
+	// метка порядка байт UTF-8, которую добавляет Excel
+	if bom, _ := br.Peek(3); string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+
+	if c.delim == 0 {
+		first, _ := br.Peek(br.Size())
+		if i := strings.IndexByte(string(first), '\n'); i >= 0 {
+			first = first[:i]
+		}
+		c.delim = detectDelimiter(string(first))
+	}
+	c.decimal = c.delim != ','
+
+	c.r = csv.NewReader(br)
+	c.r.Comma = c.delim
+	c.r.FieldsPerRecord = -1
+	c.r.LazyQuotes = true
+	c.r.ReuseRecord = true
+
+	header, err := c.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("файл пуст")
+		}
+		return nil, fmt.Errorf("%w: csv: заголовок: %v", ErrInvalidFile, err)
+	}
+	c.line = 1
+	return header, nil
+}
+
+// inferKinds проходит файл и выбирает тип каждого столбца: столбец
+// становится числовым, логическим или датой, только если к этому типу
+// приводятся все его непустые значения, иначе он остаётся строковым.
+func (c *CSVReader) inferKinds() error {
+	c.kinds = make([]int, len(c.header))
+	for {
+		record, err := c.r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: csv: %v", ErrInvalidFile, err)
+		}
+		for i := range c.header {
+			if i >= len(record) {
+				break
+			}
+			if s := strings.TrimSpace(c.text(record[i])); s != "" {
+				c.kinds[i] = mergeCSVKinds(c.kinds[i], c.valueKind(s))
+			}
+		}
+	}
+}
+
+// detectDelimiter выбирает разделитель, которого в строке заголовка больше.
+func detectDelimiter(header string) rune {
+	best, count := ',', 0
+	for _, d := range []rune{',', ';', '\t', '|'} {
+		if n := strings.Count(header, string(d)); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+// SRID возвращает систему координат из EWKT (SRID=...;) или 0.
+func (c *CSVReader) SRID() (int, error) {
+	return c.srid, nil
+}
+
+// Next возвращает фичу очередной строки. Строка, координаты которой не
+// разобрать, даёт *RecordError с номером строки файла.
+func (c *CSVReader) Next() (*models.GeoJSONFeature, error) {
+	for {
+		record, err := c.r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: csv: %v", ErrInvalidFile, err)
+		}
+		c.line, _ = c.r.FieldPos(0)
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue // пустая строка
+		}
+		c.lines = append(c.lines, c.line)
+		return c.feature(record)
+	}
+}
+
+// Locate возвращает строку файла и столбцы геометрии записи index.
+func (c *CSVReader) Locate(index int) string {
+	if index < 0 || index >= len(c.lines) {
+		return ""
+	}
+	return fmt.Sprintf("строка %d, %s", c.lines[index], c.geometryColumns())
+}
+
+// geometryColumns называет столбцы, из которых строится геометрия.
+func (c *CSVReader) geometryColumns() string {
+	if c.wkt >= 0 {
+		return fmt.Sprintf("столбец %q", c.header[c.wkt])
+	}
+	return fmt.Sprintf("столбцы %q и %q", c.header[c.lat], c.header[c.lon])
+}
+
+func (c *CSVReader) feature(record []string) (*models.GeoJSONFeature, error) {
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(c.text(record[i]))
+	}
+
+	var (
+		g   *geo.Geometry
+		err error
+	)
+	if c.wkt >= 0 {
+		if g, err = c.wktGeometry(field(c.wkt)); err != nil {
+			err = fmt.Errorf("столбец %q: %v", c.header[c.wkt], err)
+		}
+	} else {
+		g, err = c.pointGeometry(field(c.lat), field(c.lon))
+	}
+	if err != nil {
+		return nil, &RecordError{Reason: fmt.Sprintf("строка %d, %v", c.line, err)}
+	}
+
+	props := make(map[string]any, len(c.header))
+	for i, name := range c.header {
+		if i == c.lat || i == c.lon || i == c.wkt {
+			continue
+		}
+		props[name] = c.value(i, field(i))
+	}
+	propsJSON, err := json.Marshal(props)
+	if err != nil {
+		return nil, err
+	}
+	geomJSON, err := json.Marshal(g)
+	if err != nil {
+		return nil, err
+	}
+	return &models.GeoJSONFeature{
+		Type:       "Feature",
+		Properties: models.JSONData(propsJSON),
+		Geometry:   models.JSONData(geomJSON),
+	}, nil
+}
+
+func (c *CSVReader) pointGeometry(lat, lon string) (*geo.Geometry, error) {
+	if lat == "" && lon == "" {
+		return nil, fmt.Errorf("%s: нет координат", c.geometryColumns())
+	}
+	y, err := c.parseCoord(lat)
+	if err != nil {
+		return nil, fmt.Errorf("столбец %q (широта) %q: %v", c.header[c.lat], lat, err)
+	}
+	x, err := c.parseCoord(lon)
+	if err != nil {
+		return nil, fmt.Errorf("столбец %q (долгота) %q: %v", c.header[c.lon], lon, err)
+	}
+	return &geo.Geometry{Type: "Point", Coordinates: geo.Coord{x, y}}, nil
+}
+
+func (c *CSVReader) parseCoord(s string) (float64, error) {
+	if s == "" {
+		return 0, errors.New("пусто")
+	}
+	if c.decimal {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, errors.New("не число")
+	}
+	return v, nil
+}
+
+var ewktSRIDRe = regexp.MustCompile(`(?i)^SRID=(\d+);`)
+
+func (c *CSVReader) wktGeometry(s string) (*geo.Geometry, error) {
+	if s == "" {
+		return nil, errors.New("нет геометрии")
+	}
+	if m := ewktSRIDRe.FindStringSubmatch(s); m != nil {
+		srid, _ := strconv.Atoi(m[1])
+		if c.srid != 0 && c.srid != srid {
+			return nil, fmt.Errorf("SRID=%d не совпадает с SRID=%d предыдущих строк", srid, c.srid)
+		}
+		c.srid = srid
+		s = s[len(m[0]):]
+	}
+	return geo.ParseWKT(s)
+}
+
+// text декодирует поле, если кодировка не задана и поле не UTF-8.
+func (c *CSVReader) text(s string) string {
+	if !c.utf8Check || utf8.ValidString(s) {
+		return s
+	}
+	out, err := charmap.Windows1251.NewDecoder().String(s)
+	if err != nil {
+		return s
+	}
+	return out
+}
+
+// Типы столбцов свойств CSV.
+const (
+	csvKindEmpty = iota // в столбце только пустые значения
+	csvKindInt
+	csvKindFloat
+	csvKindBool
+	csvKindDate
+	csvKindTime
+	csvKindString
+)
+
+var (
+	// без знака + и ведущих нулей: телефоны и почтовые индексы — строки
+	csvIntRe     = regexp.MustCompile(`^-?(0|[1-9]\d*)$`)
+	csvFloatRe   = regexp.MustCompile(`^-?(0|[1-9]\d*)?[.,]\d+([eE][+-]?\d+)?$|^-?(0|[1-9]\d*)[eE][+-]?\d+$`)
+	csvDateForms = []string{"2006-01-02", "02.01.2006"}
+	csvTimeForms = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "02.01.2006 15:04:05", "02.01.2006 15:04"}
+)
+
+// valueKind возвращает самый узкий тип, к которому приводится значение.
+func (c *CSVReader) valueKind(s string) int {
+	if csvIntRe.MatchString(s) {
+		// целое вне int64 (длинные номера) не округляется до float
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return csvKindInt
+		}
+		return csvKindString
+	}
+	if _, ok := c.parseFloat(s); ok {
+		return csvKindFloat
+	}
+	switch strings.ToLower(s) {
+	case "true", "false":
+		return csvKindBool
+	}
+	if _, ok := parseCSVTime(csvDateForms, s); ok {
+		return csvKindDate
+	}
+	if _, ok := parseCSVTime(csvTimeForms, s); ok {
+		return csvKindTime
+	}
+	return csvKindString
+}
+
+// mergeCSVKinds — тип столбца, к которому приводятся значения типов a и b:
+// целые и дробные числа дают дробный, иначе разные типы дают строку.
+func mergeCSVKinds(a, b int) int {
+	switch {
+	case a == csvKindEmpty || a == b:
+		return b
+	case (a == csvKindInt && b == csvKindFloat) || (a == csvKindFloat && b == csvKindInt):
+		return csvKindFloat
+	}
+	return csvKindString
+}
+
+// value приводит значение столбца i к его типу: пустое — null, дата —
+// YYYY-MM-DD, время — RFC 3339.
+func (c *CSVReader) value(i int, s string) any {
+	if s == "" {
+		return nil
+	}
+	switch c.kinds[i] {
+	case csvKindInt:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case csvKindFloat:
+		if v, ok := c.parseFloat(s); ok {
+			return v
+		}
+	case csvKindBool:
+		return strings.EqualFold(s, "true")
+	case csvKindDate:
+		if t, ok := parseCSVTime(csvDateForms, s); ok {
+			return t.Format(time.DateOnly)
+		}
+	case csvKindTime:
+		if t, ok := parseCSVTime(csvTimeForms, s); ok {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return s
+}
+
+// parseFloat разбирает дробное число; десятичная запятая допускается,
+// только если разделитель полей — не запятая.
+func (c *CSVReader) parseFloat(s string) (float64, bool) {
+	if !csvIntRe.MatchString(s) && !csvFloatRe.MatchString(s) {
+		return 0, false
+	}
+	if strings.Contains(s, ",") && !c.decimal {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+func parseCSVTime(forms []string, s string) (time.Time, bool) {
+	for _, f := range forms {
+		if t, err := time.Parse(f, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// CSVWriter пишет фичи в CSV: ID фичи, геометрия в WKT (столбец WKT,
+// как принято в GDAL), затем свойства, развёрнутые FlattenProperties.
+type CSVWriter struct {
+	w     *csv.Writer
+	index map[string]int // ключ свойства → номер столбца
+	row   []string
+}
+
+// NewCSVWriter пишет заголовок со столбцами свойств keys.
+func NewCSVWriter(w io.Writer, keys []string) (*CSVWriter, error) {
+	header := []string{"id", "WKT"}
+	cw := &CSVWriter{w: csv.NewWriter(w), index: make(map[string]int, len(keys))}
+	for i, name := range columnNames(header, keys) {
+		cw.index[keys[i]] = len(header)
+		header = append(header, name)
+	}
+	cw.row = make([]string, len(header))
+	return cw, cw.w.Write(header)
+}
+
+func (w *CSVWriter) Write(f *models.GeoJSONFeature) error {
+	props, err := FlattenProperties(f.Properties)
+	if err != nil {
+		return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+	}
+	g, err := featureGeometry(f)
+	if err != nil {
+		return err
+	}
+	for i := range w.row {
+		w.row[i] = ""
+	}
+	w.row[0] = strconv.Itoa(f.ID)
+	if g != nil {
+		w.row[1] = geo.FormatWKT(g)
+	}
+	for _, p := range props {
+		if i, ok := w.index[p.Key]; ok {
+			w.row[i] = PropertyText(p.Value)
+		}
+	}
+	return w.w.Write(w.row)
+}
+
+func (w *CSVWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
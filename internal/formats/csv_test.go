@@ -0,0 +1,99 @@
+package formats
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readCSV читает все фичи CSV и возвращает их свойства.
+func readCSV(t *testing.T, data string) []map[string]any {
+	t.Helper()
+	r, err := NewCSVReader(strings.NewReader(data), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []map[string]any
+	for {
+		f, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var props map[string]any
+		if err := json.Unmarshal(f.Properties, &props); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, props)
+	}
+}
+
+func TestCSVColumnTypes(t *testing.T) {
+	got := readCSV(t, `lat,lon,zip,phone,n,x,flag,day,mixed,big,empty
+59.9,30.3,01234,+79001234567,1,1,true,2024-03-01,1,12345678901234567890,
+59.8,30.2,12345,89001234567,-2,2.5,FALSE,01.03.2024,x,1,
+59.7,30.1,,,,3,,,2,,
+`)
+	want := []map[string]any{
+		{"zip": "01234", "phone": "+79001234567", "n": 1.0, "x": 1.0, "flag": true, "day": "2024-03-01", "mixed": "1", "big": "12345678901234567890", "empty": nil},
+		{"zip": "12345", "phone": "89001234567", "n": -2.0, "x": 2.5, "flag": false, "day": "2024-03-01", "mixed": "x", "big": "1", "empty": nil},
+		{"zip": nil, "phone": nil, "n": nil, "x": 3.0, "flag": nil, "day": nil, "mixed": "2", "big": nil, "empty": nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("свойства:\n%v\nждали:\n%v", got, want)
+	}
+}
+
+func TestCSVDecimalComma(t *testing.T) {
+	got := readCSV(t, "lat;lon;area;code\n59,9;30,3;1,5;007\n59,8;30,2;2;+7\n")
+	want := []map[string]any{
+		{"area": 1.5, "code": "007"},
+		{"area": 2.0, "code": "+7"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("свойства %v, ждали %v", got, want)
+	}
+}
+
+func TestCSVRecordError(t *testing.T) {
+	r, err := NewCSVReader(strings.NewReader("lat,lon,name\n59.9,30.3,a\nx,30.3,b\n"), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Next()
+	var recErr *RecordError
+	if !errors.As(err, &recErr) || !strings.Contains(recErr.Reason, `строка 3, столбец "lat"`) {
+		t.Fatalf("ждали *RecordError для строки 3 и столбца lat, получили %v", err)
+	}
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("ждали io.EOF, получили %v", err)
+	}
+}
+
+func TestCSVLocate(t *testing.T) {
+	r, err := NewCSVReader(strings.NewReader("name;geom\na;POINT(1 2)\n\nb;POINT(3 4)\n"), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := r.Next(); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// пустая строка записью не считается
+	for i, want := range []string{`строка 2, столбец "geom"`, `строка 4, столбец "geom"`, ""} {
+		if got := r.Locate(i); got != want {
+			t.Errorf("Locate(%d) = %q, ждали %q", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// dbfField — описание поля таблицы атрибутов dBase.
+type dbfField struct {
+	name     string
+	kind     byte // C, N, F, L, D, ...
+	length   int
+	decimals int
+}
+
+// dbfReader последовательно читает записи файла .dbf.
+type dbfReader struct {
+	r       *bufio.Reader
+	fields  []dbfField
+	recLen  int
+	records int
+	read    int
+	enc     encoding.Encoding // nil — UTF-8, если текст корректен, иначе CP1251
+	buf     []byte
+}
+
+// codePages — кодировки по байту language driver ID заголовка dBase.
+var codePages = map[byte]encoding.Encoding{
+	0x01: charmap.CodePage437,
+	0x02: charmap.CodePage850,
+	0x03: charmap.Windows1252,
+	0x26: charmap.CodePage866,
+	0x57: charmap.Windows1252,
+	0x64: charmap.CodePage852,
+	0x65: charmap.CodePage866,
+	0xC8: charmap.Windows1250,
+	0xC9: charmap.Windows1251,
+}
+
+// ParseCodePage разбирает содержимое .cpg или параметр encoding:
+// "UTF-8", "CP1251", "1251", "ANSI 1251", "windows-1251", "cp866" и т. п.
+func ParseCodePage(s string) (encoding.Encoding, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.NewReplacer("ANSI", "", "WINDOWS", "", "CP", "", "-", "", "_", "", " ", "").Replace(v)
+	switch v {
+	case "UTF8":
+		return unicode.UTF8, nil
+	case "1250":
+		return charmap.Windows1250, nil
+	case "1251":
+		return charmap.Windows1251, nil
+	case "1252":
+		return charmap.Windows1252, nil
+	case "866":
+		return charmap.CodePage866, nil
+	case "437":
+		return charmap.CodePage437, nil
+	case "KOI8R":
+		return charmap.KOI8R, nil
+	}
+	return nil, fmt.Errorf("неизвестная кодировка %q", s)
+}
+
+// newDBFReader читает заголовок .dbf. Кодировка enc, если не nil,
+// важнее объявленной в заголовке.
+func newDBFReader(r io.Reader, enc encoding.Encoding) (*dbfReader, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, 32)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, fmt.Errorf("%w: dbf: заголовок: %v", ErrInvalidFile, err)
+	}
+	d := &dbfReader{
+		r:       br,
+		records: int(binary.LittleEndian.Uint32(head[4:8])),
+		recLen:  int(binary.LittleEndian.Uint16(head[10:12])),
+		enc:     enc,
+	}
+	headerLen := int(binary.LittleEndian.Uint16(head[8:10]))
+	if d.enc == nil {
+		d.enc = codePages[head[29]]
+	}
+
+	// описания полей по 32 байта до терминатора 0x0D
+	consumed := 32
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, fmt.Errorf("%w: dbf: описание полей: %v", ErrInvalidFile, err)
+		}
+		if b[0] == 0x0D {
+			break
+		}
+		desc := make([]byte, 32)
+		if _, err := io.ReadFull(br, desc); err != nil {
+			return nil, fmt.Errorf("%w: dbf: описание полей: %v", ErrInvalidFile, err)
+		}
+		consumed += 32
+		name := desc[:11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		d.fields = append(d.fields, dbfField{
+			name:     d.text(name),
+			kind:     desc[11],
+			length:   int(desc[16]),
+			decimals: int(desc[17]),
+		})
+	}
+	// терминатор и возможный хвост заголовка (backlink Visual FoxPro)
+	if _, err := br.Discard(headerLen - consumed); err != nil {
+		return nil, fmt.Errorf("%w: dbf: заголовок: %v", ErrInvalidFile, err)
+	}
+
+	width := 1 // флаг удаления
+	for _, f := range d.fields {
+		width += f.length
+	}
+	if width != d.recLen {
+		return nil, fmt.Errorf("%w: dbf: длина записи %d не совпадает с полями (%d)", ErrInvalidFile, d.recLen, width)
+	}
+	d.buf = make([]byte, d.recLen)
+	return d, nil
+}
+
+// next возвращает атрибуты очередной записи и признак её удаления;
+// io.EOF — записи закончились.
+func (d *dbfReader) next() (map[string]any, bool, error) {
+	if d.read >= d.records {
+		return nil, false, io.EOF
+	}
+	if _, err := io.ReadFull(d.r, d.buf); err != nil {
+		return nil, false, fmt.Errorf("%w: dbf: запись %d: %v", ErrInvalidFile, d.read, err)
+	}
+	d.read++
+
+	deleted := d.buf[0] == '*'
+	props := make(map[string]any, len(d.fields))
+	pos := 1
+	for _, f := range d.fields {
+		props[f.name] = d.value(f, d.buf[pos:pos+f.length])
+		pos += f.length
+	}
+	return props, deleted, nil
+}
+
+// value переводит значение поля в JSON-совместимое; пустое — nil.
+func (d *dbfReader) value(f dbfField, raw []byte) any {
+	s := strings.TrimSpace(string(raw))
+	switch f.kind {
+	case 'N', 'F':
+		if s == "" || strings.Trim(s, "*") == "" {
+			return nil
+		}
+		if f.decimals == 0 {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return n
+			}
+		}
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v
+		}
+		return nil
+	case 'L':
+		switch s {
+		case "T", "t", "Y", "y":
+			return true
+		case "F", "f", "N", "n":
+			return false
+		}
+		return nil
+	case 'D':
+		if len(s) != 8 || strings.Trim(s, "0") == "" {
+			return nil
+		}
+		return s[:4] + "-" + s[4:6] + "-" + s[6:]
+	}
+	text := d.text(bytes.TrimRight(raw, " \x00"))
+	if text == "" {
+		return nil
+	}
+	return strings.TrimSpace(text)
+}
+
+// text декодирует строку в кодировке файла. Без объявленной кодировки
+// корректный UTF-8 оставляется как есть, иначе считается CP1251.
+func (d *dbfReader) text(b []byte) string {
+	enc := d.enc
+	if enc == nil {
+		if utf8.Valid(b) {
+			return string(b)
+		}
+		enc = charmap.Windows1251
+	}
+	out, err := enc.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(out)
+}
+
+// dbfWriter пишет таблицу атрибутов dBase III в UTF-8 (кодировку
+// объявляет файл .cpg). Число записей дописывается в заголовок в Close.
+type dbfWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	fields  []dbfField
+	offsets []int          // начало поля в записи
+	keys    map[string]int // ключ свойства → номер поля
+	records uint32
+	rec     []byte
+}
+
+// dbfFields строит поля dBase по схеме свойств: имена укорачиваются
+// до 10 байт и делаются уникальными, тип и ширина — по значениям.
+func dbfFields(schema *PropertySchema) ([]dbfField, error) {
+	if len(schema.Fields) > 255 {
+		return nil, fmt.Errorf("у фич %d свойств, а dBase допускает не больше 255 полей", len(schema.Fields))
+	}
+	used := map[string]bool{}
+	fields := make([]dbfField, len(schema.Fields))
+	for i, sf := range schema.Fields {
+		name := truncateUTF8(sf.Key, 10)
+		if name == "" {
+			name = "field"
+		}
+		for n := 1; used[strings.ToUpper(name)]; n++ {
+			suffix := "_" + strconv.Itoa(n)
+			name = truncateUTF8(sf.Key, 10-len(suffix)) + suffix
+		}
+		used[strings.ToUpper(name)] = true
+
+		f := dbfField{name: name}
+		switch sf.Kind {
+		case KindInt:
+			f.kind, f.length = 'N', min(max(sf.Width, 1), 20)
+		case KindFloat:
+			f.kind, f.length, f.decimals = 'N', 24, min(max(sf.Decimals, 1), 15)
+		case KindBool:
+			f.kind, f.length = 'L', 1
+		default:
+			f.kind, f.length = 'C', min(max(sf.Width, 1), 254)
+		}
+		fields[i] = f
+	}
+	return fields, nil
+}
+
+// truncateUTF8 укорачивает строку до n байт, не разрезая символы.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func newDBFWriter(path string, fields []dbfField, keys []string) (*dbfWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &dbfWriter{f: f, w: bufio.NewWriter(f), fields: fields, keys: make(map[string]int, len(keys))}
+	for i, k := range keys {
+		d.keys[k] = i
+	}
+
+	recLen := 1 // флаг удаления
+	for _, fd := range fields {
+		d.offsets = append(d.offsets, recLen)
+		recLen += fd.length
+	}
+	d.rec = make([]byte, recLen)
+
+	head := make([]byte, 32)
+	head[0] = 0x03
+	now := time.Now()
+	head[1], head[2], head[3] = byte(now.Year()-1900), byte(now.Month()), byte(now.Day())
+	binary.LittleEndian.PutUint16(head[8:], uint16(32+32*len(fields)+1))
+	binary.LittleEndian.PutUint16(head[10:], uint16(recLen))
+	d.w.Write(head)
+	for _, fd := range fields {
+		desc := make([]byte, 32)
+		copy(desc[:10], fd.name)
+		desc[11] = fd.kind
+		desc[16] = byte(fd.length)
+		desc[17] = byte(fd.decimals)
+		d.w.Write(desc)
+	}
+	d.w.WriteByte(0x0D)
+	return d, nil
+}
+
+func (d *dbfWriter) write(props []Property) error {
+	for i := range d.rec {
+		d.rec[i] = ' '
+	}
+	for _, p := range props {
+		i, ok := d.keys[p.Key]
+		if !ok || p.Value == nil {
+			continue
+		}
+		fd := d.fields[i]
+		cell := d.rec[d.offsets[i] : d.offsets[i]+fd.length]
+		switch fd.kind {
+		case 'N':
+			text := PropertyText(p.Value)
+			if fd.decimals > 0 {
+				if v, err := strconv.ParseFloat(text, 64); err == nil {
+					text = strconv.FormatFloat(v, 'f', fd.decimals, 64)
+				}
+			}
+			if len(text) > fd.length {
+				text = strings.Repeat("*", fd.length) // значение не помещается
+			}
+			copy(cell[fd.length-len(text):], text)
+		case 'L':
+			cell[0] = 'F'
+			if p.Value == true {
+				cell[0] = 'T'
+			}
+		default:
+			copy(cell, truncateUTF8(PropertyText(p.Value), fd.length))
+		}
+	}
+	d.records++
+	_, err := d.w.Write(d.rec)
+	return err
+}
+
+// Close дописывает признак конца файла и число записей в заголовок.
+func (d *dbfWriter) Close() error {
+	d.w.WriteByte(0x1A)
+	err := d.w.Flush()
+	if err == nil {
+		var n [4]byte
+		binary.LittleEndian.PutUint32(n[:], d.records)
+		_, err = d.f.WriteAt(n[:], 4)
+	}
+	if cerr := d.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// featureGeometry разбирает геометрию фичи; у фичи без геометрии — nil.
+func featureGeometry(f *models.GeoJSONFeature) (*geo.Geometry, error) {
+	if len(f.Geometry) == 0 || string(f.Geometry) == "null" {
+		return nil, nil
+	}
+	var g geo.Geometry
+	if err := json.Unmarshal(f.Geometry, &g); err != nil {
+		return nil, fmt.Errorf("фича %d: геометрия: %w", f.ID, err)
+	}
+	return &g, nil
+}
+
+// WKTWriter пишет геометрии фич в WKT, по одной на строку; фичи без
+// геометрии пропускаются.
+type WKTWriter struct {
+	w *bufio.Writer
+}
+
+func NewWKTWriter(w io.Writer) *WKTWriter {
+	return &WKTWriter{w: bufio.NewWriter(w)}
+}
+
+func (w *WKTWriter) Write(f *models.GeoJSONFeature) error {
+	g, err := featureGeometry(f)
+	if err != nil || g == nil {
+		return err
+	}
+	w.w.WriteString(geo.FormatWKT(g))
+	return w.w.WriteByte('\n')
+}
+
+func (w *WKTWriter) Flush() error {
+	return w.w.Flush()
+}
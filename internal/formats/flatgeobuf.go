@@ -0,0 +1,576 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// fgbMagic — сигнатура FlatGeobuf версии 3.
+var fgbMagic = []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+
+// fgbIndexNodeSize — число потомков узла пространственного индекса.
+const fgbIndexNodeSize = 16
+
+// Типы геометрий FlatGeobuf (enum GeometryType в header.fbs).
+var fgbGeometryTypes = map[string]byte{
+	"Point":              1,
+	"LineString":         2,
+	"Polygon":            3,
+	"MultiPoint":         4,
+	"MultiLineString":    5,
+	"MultiPolygon":       6,
+	"GeometryCollection": 7,
+}
+
+// Типы столбцов FlatGeobuf (enum ColumnType в header.fbs).
+const (
+	fgbBool   = 2
+	fgbLong   = 7
+	fgbDouble = 10
+	fgbString = 11
+)
+
+// FlatGeobufWriter выгружает коллекцию в FlatGeobuf с упакованным
+// R-деревом Гильберта. Свойства становятся типизированными столбцами,
+// первый столбец — id фичи. Запись в два прохода: Scan собирает схему
+// и типы геометрий, Write кодирует фичи во временный файл, Close пишет
+// заголовок, индекс и фичи в порядке кривой Гильберта, как требует индекс.
+// Фичи без геометрии в индекс не попадают, поэтому при их наличии файл
+// пишется без индекса.
+type FlatGeobufWriter struct {
+	dir     string
+	name    string
+	org     string
+	code    int
+	wkt     string
+	schema  *PropertySchema
+	types   map[byte]bool
+	hasZ    bool
+	noIndex bool // есть фичи без геометрии
+
+	columns []SchemaField
+	index   map[string]int // ключ свойства → номер столбца
+	tmp     *os.File
+	tmpW    *bufio.Writer
+	tmpSize int64
+	items   []fgbItem
+	env     geo.Envelope
+	b       *flatbuffers.Builder
+	props   []byte
+}
+
+// fgbItem — закодированная фича во временном файле.
+type fgbItem struct {
+	env    [4]float64 // minX, minY, maxX, maxY
+	offset int64
+	size   int
+}
+
+// NewFlatGeobufWriter создаёт запись с временным файлом в каталоге dir;
+// name — имя слоя, org, code и wkt описывают систему координат.
+func NewFlatGeobufWriter(dir, name, org string, code int, wkt string) *FlatGeobufWriter {
+	return &FlatGeobufWriter{
+		dir:    dir,
+		name:   name,
+		org:    org,
+		code:   code,
+		wkt:    wkt,
+		schema: NewPropertySchema(),
+		types:  map[byte]bool{},
+		env:    geo.EmptyEnvelope(),
+		b:      flatbuffers.NewBuilder(1024),
+	}
+}
+
+// Scan учитывает фичу при первом проходе.
+func (w *FlatGeobufWriter) Scan(f *models.GeoJSONFeature) error {
+	props, err := FlattenProperties(f.Properties)
+	if err != nil {
+		return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+	}
+	w.schema.Add(props)
+	g, err := featureGeometry(f)
+	if err != nil {
+		return err
+	}
+	if g == nil {
+		return nil
+	}
+	w.types[fgbGeometryTypes[g.Type]] = true
+	w.hasZ = w.hasZ || geo.HasZ(g)
+	return nil
+}
+
+// Write кодирует фичу при втором проходе.
+func (w *FlatGeobufWriter) Write(f *models.GeoJSONFeature) error {
+	if w.tmp == nil {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	props, err := FlattenProperties(f.Properties)
+	if err != nil {
+		return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+	}
+	g, err := featureGeometry(f)
+	if err != nil {
+		return err
+	}
+
+	w.props = w.props[:0]
+	w.props = binary.LittleEndian.AppendUint16(w.props, 0)
+	w.props = binary.LittleEndian.AppendUint64(w.props, uint64(f.ID))
+	for _, p := range props {
+		i, ok := w.index[p.Key]
+		if !ok {
+			continue
+		}
+		v := TypedValue(w.columns[i].Kind, p.Value)
+		if v == nil {
+			continue
+		}
+		w.props = binary.LittleEndian.AppendUint16(w.props, uint16(i))
+		switch v := v.(type) {
+		case int64:
+			w.props = binary.LittleEndian.AppendUint64(w.props, uint64(v))
+		case float64:
+			w.props = binary.LittleEndian.AppendUint64(w.props, math.Float64bits(v))
+		case bool:
+			if v {
+				w.props = append(w.props, 1)
+			} else {
+				w.props = append(w.props, 0)
+			}
+		case string:
+			w.props = binary.LittleEndian.AppendUint32(w.props, uint32(len(v)))
+			w.props = append(w.props, v...)
+		}
+	}
+
+	b := w.b
+	b.Reset()
+	var geom flatbuffers.UOffsetT
+	if g != nil {
+		geom = w.geometry(g)
+	}
+	propsOff := b.CreateByteVector(w.props)
+	b.StartObject(3)
+	if g != nil {
+		b.PrependUOffsetTSlot(0, geom, 0)
+	}
+	b.PrependUOffsetTSlot(1, propsOff, 0)
+	b.FinishSizePrefixed(b.EndObject())
+	buf := b.FinishedBytes()
+
+	item := fgbItem{offset: w.tmpSize, size: len(buf)}
+	if e := geo.GeometryEnvelope(g); !e.Empty {
+		item.env = [4]float64{e.MinX, e.MinY, e.MaxX, e.MaxY}
+		w.env.Extend(e)
+	}
+	if g == nil {
+		w.noIndex = true
+	}
+	w.items = append(w.items, item)
+	w.tmpSize += int64(len(buf))
+	_, err = w.tmpW.Write(buf)
+	return err
+}
+
+// start создаёт временный файл и столбцы по собранной схеме.
+func (w *FlatGeobufWriter) start() error {
+	var err error
+	if w.tmp, err = os.CreateTemp(w.dir, "features-*.fgb"); err != nil {
+		return err
+	}
+	w.tmpW = bufio.NewWriter(w.tmp)
+
+	w.columns = []SchemaField{{Key: "id", Kind: KindInt}}
+	w.index = map[string]int{}
+	for i, name := range columnNames([]string{"id"}, w.schema.Keys()) {
+		w.index[w.schema.Fields[i].Key] = len(w.columns)
+		w.columns = append(w.columns, SchemaField{Key: name, Kind: w.schema.Fields[i].Kind})
+	}
+	if len(w.columns) > math.MaxUint16 {
+		return fmt.Errorf("FlatGeobuf: слишком много свойств (%d)", len(w.columns))
+	}
+	return nil
+}
+
+// geometry добавляет в буфер таблицу Geometry из feature.fbs.
+func (w *FlatGeobufWriter) geometry(g *geo.Geometry) flatbuffers.UOffsetT {
+	b := w.b
+	var lines [][]geo.Coord
+	var parts []*geo.Geometry
+	switch c := g.Coordinates.(type) {
+	case geo.Coord:
+		if len(c) >= 2 {
+			lines = [][]geo.Coord{{c}}
+		}
+	case []geo.Coord:
+		lines = [][]geo.Coord{c}
+	case [][]geo.Coord:
+		lines = c
+	case [][][]geo.Coord:
+		for _, p := range c {
+			parts = append(parts, &geo.Geometry{Type: "Polygon", Coordinates: p})
+		}
+	}
+	if g.Type == "GeometryCollection" {
+		for _, child := range g.Geometries {
+			if child != nil {
+				parts = append(parts, child)
+			}
+		}
+	}
+
+	var partsOff, endsOff, xyOff, zOff flatbuffers.UOffsetT
+	if len(parts) > 0 {
+		offs := make([]flatbuffers.UOffsetT, len(parts))
+		for i, p := range parts {
+			offs[i] = w.geometry(p)
+		}
+		partsOff = b.CreateVectorOfTables(offs)
+	}
+	n := 0
+	for _, l := range lines {
+		n += len(l)
+	}
+	if len(lines) > 1 {
+		b.StartVector(4, len(lines), 4)
+		end := n
+		for i := len(lines) - 1; i >= 0; i-- {
+			b.PrependUint32(uint32(end))
+			end -= len(lines[i])
+		}
+		endsOff = b.EndVector(len(lines))
+	}
+	if n > 0 {
+		b.StartVector(8, 2*n, 8)
+		for i := len(lines) - 1; i >= 0; i-- {
+			for j := len(lines[i]) - 1; j >= 0; j-- {
+				c := lines[i][j]
+				b.PrependFloat64(c[1])
+				b.PrependFloat64(c[0])
+			}
+		}
+		xyOff = b.EndVector(2 * n)
+		if w.hasZ {
+			b.StartVector(8, n, 8)
+			for i := len(lines) - 1; i >= 0; i-- {
+				for j := len(lines[i]) - 1; j >= 0; j-- {
+					z := 0.0
+					if c := lines[i][j]; len(c) > 2 {
+						z = c[2]
+					}
+					b.PrependFloat64(z)
+				}
+			}
+			zOff = b.EndVector(n)
+		}
+	}
+
+	b.StartObject(8)
+	if partsOff != 0 {
+		b.PrependUOffsetTSlot(7, partsOff, 0)
+	}
+	if zOff != 0 {
+		b.PrependUOffsetTSlot(2, zOff, 0)
+	}
+	if xyOff != 0 {
+		b.PrependUOffsetTSlot(1, xyOff, 0)
+	}
+	if endsOff != 0 {
+		b.PrependUOffsetTSlot(0, endsOff, 0)
+	}
+	b.PrependByteSlot(6, fgbGeometryTypes[g.Type], 0)
+	return b.EndObject()
+}
+
+// header кодирует заголовок файла с префиксом длины.
+func (w *FlatGeobufWriter) header(indexed bool) []byte {
+	b := w.b
+	b.Reset()
+
+	colOffs := make([]flatbuffers.UOffsetT, len(w.columns))
+	for i, col := range w.columns {
+		name := b.CreateString(col.Key)
+		b.StartObject(11)
+		b.PrependUOffsetTSlot(0, name, 0)
+		b.PrependByteSlot(1, fgbColumnType(col.Kind), 0)
+		if i == 0 {
+			b.PrependBoolSlot(7, false, true) // id всегда заполнен
+		}
+		colOffs[i] = b.EndObject()
+	}
+	columns := b.CreateVectorOfTables(colOffs)
+
+	var crs flatbuffers.UOffsetT
+	if w.code > 0 {
+		org := b.CreateString(w.org)
+		var wkt flatbuffers.UOffsetT
+		if w.wkt != "" {
+			wkt = b.CreateString(w.wkt)
+		}
+		b.StartObject(6)
+		b.PrependUOffsetTSlot(0, org, 0)
+		b.PrependInt32Slot(1, int32(w.code), 0)
+		if wkt != 0 {
+			b.PrependUOffsetTSlot(4, wkt, 0)
+		}
+		crs = b.EndObject()
+	}
+
+	var envelope flatbuffers.UOffsetT
+	if !w.env.Empty {
+		b.StartVector(8, 4, 8)
+		b.PrependFloat64(w.env.MaxY)
+		b.PrependFloat64(w.env.MaxX)
+		b.PrependFloat64(w.env.MinY)
+		b.PrependFloat64(w.env.MinX)
+		envelope = b.EndVector(4)
+	}
+	name := b.CreateString(w.name)
+
+	// смешанные типы — Unknown (0), тогда тип указан у каждой геометрии
+	var geomType byte
+	if len(w.types) == 1 {
+		for t := range w.types {
+			geomType = t
+		}
+	}
+	nodeSize := uint16(0)
+	if indexed {
+		nodeSize = fgbIndexNodeSize
+	}
+
+	b.StartObject(14)
+	// число фич — по второму проходу: именно они записаны в файл
+	b.PrependUint64Slot(8, uint64(len(w.items)), 0)
+	b.PrependUOffsetTSlot(0, name, 0)
+	if envelope != 0 {
+		b.PrependUOffsetTSlot(1, envelope, 0)
+	}
+	b.PrependUOffsetTSlot(7, columns, 0)
+	if crs != 0 {
+		b.PrependUOffsetTSlot(10, crs, 0)
+	}
+	b.PrependUint16Slot(9, nodeSize, fgbIndexNodeSize)
+	b.PrependByteSlot(2, geomType, 0)
+	b.PrependBoolSlot(3, w.hasZ, false)
+	b.FinishSizePrefixed(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func fgbColumnType(kind int) byte {
+	switch kind {
+	case KindInt:
+		return fgbLong
+	case KindFloat:
+		return fgbDouble
+	case KindBool:
+		return fgbBool
+	}
+	return fgbString
+}
+
+// Close пишет файл в out: заголовок, индекс и фичи.
+func (w *FlatGeobufWriter) Close(out io.Writer) error {
+	if w.tmp == nil {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if err := w.tmpW.Flush(); err != nil {
+		return err
+	}
+	indexed := !w.noIndex && len(w.items) > 0
+
+	bw := bufio.NewWriter(out)
+	bw.Write(fgbMagic)
+	bw.Write(w.header(indexed))
+	if indexed {
+		w.sortHilbert()
+		if err := w.writeIndex(bw); err != nil {
+			return err
+		}
+	}
+	var buf []byte
+	for _, it := range w.items {
+		if cap(buf) < it.size {
+			buf = make([]byte, it.size)
+		}
+		buf = buf[:it.size]
+		if _, err := w.tmp.ReadAt(buf, it.offset); err != nil {
+			return err
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return w.tmp.Close()
+}
+
+// Discard закрывает временный файл прерванной выгрузки; каталог удаляет
+// вызывающий.
+func (w *FlatGeobufWriter) Discard() {
+	if w.tmp != nil {
+		w.tmp.Close()
+	}
+}
+
+// sortHilbert упорядочивает фичи по значению кривой Гильберта центров
+// их прямоугольников, как эталонная реализация (по убыванию).
+func (w *FlatGeobufWriter) sortHilbert() {
+	width, height := w.env.MaxX-w.env.MinX, w.env.MaxY-w.env.MinY
+	values := make([]uint32, len(w.items))
+	for i, it := range w.items {
+		var x, y uint32
+		if width != 0 {
+			x = uint32(math.Floor(0xFFFF * ((it.env[0]+it.env[2])/2 - w.env.MinX) / width))
+		}
+		if height != 0 {
+			y = uint32(math.Floor(0xFFFF * ((it.env[1]+it.env[3])/2 - w.env.MinY) / height))
+		}
+		values[i] = hilbert(x, y)
+	}
+	sort.Stable(hilbertOrder{w.items, values})
+}
+
+type hilbertOrder struct {
+	items  []fgbItem
+	values []uint32
+}
+
+func (h hilbertOrder) Len() int           { return len(h.items) }
+func (h hilbertOrder) Less(i, j int) bool { return h.values[i] > h.values[j] }
+func (h hilbertOrder) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.values[i], h.values[j] = h.values[j], h.values[i]
+}
+
+// hilbert — номер точки (x, y) сетки 2^16×2^16 на кривой Гильберта
+// (алгоритм без ветвлений из эталонной реализации FlatGeobuf).
+func hilbert(x, y uint32) uint32 {
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+	i0 = interleave(i0)
+	i1 = interleave(i1)
+	return (i1 << 1) | i0
+}
+
+// interleave раздвигает 16 младших бит через один.
+func interleave(v uint32) uint32 {
+	v = (v | (v << 8)) & 0x00FF00FF
+	v = (v | (v << 4)) & 0x0F0F0F0F
+	v = (v | (v << 2)) & 0x33333333
+	return (v | (v << 1)) & 0x55555555
+}
+
+// fgbLevelBounds возвращает границы уровней дерева в массиве узлов,
+// начиная с листьев: листья лежат в конце массива, корень — первый.
+func fgbLevelBounds(n, nodeSize int) [][2]int {
+	levels := []int{n}
+	numNodes := n
+	for {
+		n = (n + nodeSize - 1) / nodeSize
+		numNodes += n
+		levels = append(levels, n)
+		if n == 1 {
+			break
+		}
+	}
+	bounds := make([][2]int, len(levels))
+	for i, size := range levels {
+		numNodes -= size
+		bounds[i] = [2]int{numNodes, numNodes + size}
+	}
+	return bounds
+}
+
+// writeIndex пишет упакованное R-дерево: у листа — смещение фичи от
+// начала данных фич, у внутреннего узла — номер первого потомка.
+func (w *FlatGeobufWriter) writeIndex(out io.Writer) error {
+	type node struct {
+		env    [4]float64
+		offset uint64
+	}
+	bounds := fgbLevelBounds(len(w.items), fgbIndexNodeSize)
+	nodes := make([]node, bounds[0][1])
+	var offset uint64
+	for i, it := range w.items {
+		nodes[bounds[0][0]+i] = node{env: it.env, offset: offset}
+		offset += uint64(it.size)
+	}
+	for l := 0; l < len(bounds)-1; l++ {
+		pos, end, up := bounds[l][0], bounds[l][1], bounds[l+1][0]
+		for pos < end {
+			n := node{
+				env:    [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)},
+				offset: uint64(pos),
+			}
+			for j := 0; j < fgbIndexNodeSize && pos < end; j++ {
+				c := nodes[pos].env
+				n.env[0], n.env[1] = math.Min(n.env[0], c[0]), math.Min(n.env[1], c[1])
+				n.env[2], n.env[3] = math.Max(n.env[2], c[2]), math.Max(n.env[3], c[3])
+				pos++
+			}
+			nodes[up] = n
+			up++
+		}
+	}
+
+	buf := make([]byte, 0, 40)
+	for _, n := range nodes {
+		buf = buf[:0]
+		for _, v := range n.env {
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+		}
+		buf = binary.LittleEndian.AppendUint64(buf, n.offset)
+		if _, err := out.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
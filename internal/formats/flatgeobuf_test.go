@@ -0,0 +1,416 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// fgbFile — разобранный файл FlatGeobuf.
+type fgbFile struct {
+	name      string
+	geomType  byte
+	hasZ      bool
+	count     uint64
+	nodeSize  uint16
+	envelope  []float64
+	crsCode   int32
+	columns   []string
+	colTypes  []byte
+	index     []fgbTestNode
+	features  []fgbTestFeature
+	dataStart int // смещение первой фичи в файле
+}
+
+type fgbTestNode struct {
+	env    [4]float64
+	offset uint64
+}
+
+type fgbTestFeature struct {
+	offset int // от начала данных фич
+	geom   *geo.Geometry
+	props  map[string]any
+}
+
+// fgbSlot возвращает смещение поля slot таблицы или 0, если его нет.
+func fgbSlot(t *flatbuffers.Table, slot int) flatbuffers.UOffsetT {
+	return flatbuffers.UOffsetT(t.Offset(flatbuffers.VOffsetT(4 + 2*slot)))
+}
+
+// fgbTable читает корневую таблицу буфера с префиксом длины.
+func fgbTable(b []byte) (*flatbuffers.Table, int) {
+	size := int(binary.LittleEndian.Uint32(b))
+	buf := b[4 : 4+size]
+	return &flatbuffers.Table{Bytes: buf, Pos: flatbuffers.GetUOffsetT(buf)}, 4 + size
+}
+
+// readFGB разбирает файл по схемам header.fbs и feature.fbs.
+func readFGB(t *testing.T, data []byte) *fgbFile {
+	t.Helper()
+	if !bytes.Equal(data[:8], fgbMagic) {
+		t.Fatalf("сигнатура % x", data[:8])
+	}
+	h, n := fgbTable(data[8:])
+	f := &fgbFile{nodeSize: fgbIndexNodeSize}
+	if o := fgbSlot(h, 0); o != 0 {
+		f.name = h.String(h.Pos + o)
+	}
+	if o := fgbSlot(h, 1); o != 0 {
+		v := h.Vector(o)
+		for i := 0; i < h.VectorLen(o); i++ {
+			f.envelope = append(f.envelope, h.GetFloat64(v+flatbuffers.UOffsetT(8*i)))
+		}
+	}
+	if o := fgbSlot(h, 2); o != 0 {
+		f.geomType = h.GetByte(h.Pos + o)
+	}
+	if o := fgbSlot(h, 3); o != 0 {
+		f.hasZ = h.GetBool(h.Pos + o)
+	}
+	if o := fgbSlot(h, 7); o != 0 {
+		v := h.Vector(o)
+		for i := 0; i < h.VectorLen(o); i++ {
+			col := &flatbuffers.Table{Bytes: h.Bytes, Pos: h.Indirect(v + flatbuffers.UOffsetT(4*i))}
+			f.columns = append(f.columns, col.String(col.Pos+fgbSlot(col, 0)))
+			var typ byte
+			if o := fgbSlot(col, 1); o != 0 {
+				typ = col.GetByte(col.Pos + o)
+			}
+			f.colTypes = append(f.colTypes, typ)
+		}
+	}
+	if o := fgbSlot(h, 8); o != 0 {
+		f.count = h.GetUint64(h.Pos + o)
+	}
+	if o := fgbSlot(h, 9); o != 0 {
+		f.nodeSize = h.GetUint16(h.Pos + o)
+	}
+	if o := fgbSlot(h, 10); o != 0 {
+		crs := &flatbuffers.Table{Bytes: h.Bytes, Pos: h.Indirect(h.Pos + o)}
+		if o := fgbSlot(crs, 1); o != 0 {
+			f.crsCode = crs.GetInt32(crs.Pos + o)
+		}
+	}
+
+	pos := 8 + n
+	if f.nodeSize > 0 && f.count > 0 {
+		nodes := fgbLevelBounds(int(f.count), int(f.nodeSize))[0][1]
+		for i := 0; i < nodes; i++ {
+			var nd fgbTestNode
+			for j := range nd.env {
+				nd.env[j] = math.Float64frombits(binary.LittleEndian.Uint64(data[pos+8*j:]))
+			}
+			nd.offset = binary.LittleEndian.Uint64(data[pos+32:])
+			f.index = append(f.index, nd)
+			pos += 40
+		}
+	}
+
+	f.dataStart = pos
+	for pos < len(data) {
+		ft, n := fgbTable(data[pos:])
+		feat := fgbTestFeature{offset: pos - f.dataStart, props: map[string]any{}}
+		if o := fgbSlot(ft, 0); o != 0 {
+			g := &flatbuffers.Table{Bytes: ft.Bytes, Pos: ft.Indirect(ft.Pos + o)}
+			feat.geom = fgbDecodeGeometry(t, g, f.geomType)
+		}
+		if o := fgbSlot(ft, 1); o != 0 {
+			props := ft.ByteVector(ft.Pos + o)
+			for len(props) > 0 {
+				col := binary.LittleEndian.Uint16(props)
+				props = props[2:]
+				switch f.colTypes[col] {
+				case fgbLong:
+					feat.props[f.columns[col]] = float64(int64(binary.LittleEndian.Uint64(props)))
+					props = props[8:]
+				case fgbDouble:
+					feat.props[f.columns[col]] = math.Float64frombits(binary.LittleEndian.Uint64(props))
+					props = props[8:]
+				case fgbBool:
+					feat.props[f.columns[col]] = props[0] == 1
+					props = props[1:]
+				case fgbString:
+					size := binary.LittleEndian.Uint32(props)
+					feat.props[f.columns[col]] = string(props[4 : 4+size])
+					props = props[4+size:]
+				default:
+					t.Fatalf("тип столбца %d", f.colTypes[col])
+				}
+			}
+		}
+		f.features = append(f.features, feat)
+		pos += n
+	}
+	return f
+}
+
+// fgbDecodeGeometry собирает геометрию GeoJSON из таблицы Geometry.
+func fgbDecodeGeometry(t *testing.T, g *flatbuffers.Table, headerType byte) *geo.Geometry {
+	typ := headerType
+	if o := fgbSlot(g, 6); o != 0 {
+		typ = g.GetByte(g.Pos + o)
+	}
+	var name string
+	for n, code := range fgbGeometryTypes {
+		if code == typ {
+			name = n
+		}
+	}
+	out := &geo.Geometry{Type: name}
+
+	var coords []geo.Coord
+	if o := fgbSlot(g, 1); o != 0 {
+		xy := g.Vector(o)
+		var z flatbuffers.UOffsetT
+		if oz := fgbSlot(g, 2); oz != 0 {
+			z = g.Vector(oz)
+		}
+		for i := 0; i < g.VectorLen(o)/2; i++ {
+			c := geo.Coord{g.GetFloat64(xy + flatbuffers.UOffsetT(16*i)), g.GetFloat64(xy + flatbuffers.UOffsetT(16*i+8))}
+			if z != 0 {
+				c = append(c, g.GetFloat64(z+flatbuffers.UOffsetT(8*i)))
+			}
+			coords = append(coords, c)
+		}
+	}
+	lines := [][]geo.Coord{coords}
+	if o := fgbSlot(g, 0); o != 0 {
+		lines = nil
+		ends := g.Vector(o)
+		start := 0
+		for i := 0; i < g.VectorLen(o); i++ {
+			end := int(g.GetUint32(ends + flatbuffers.UOffsetT(4*i)))
+			lines = append(lines, coords[start:end])
+			start = end
+		}
+	}
+	var parts []*geo.Geometry
+	if o := fgbSlot(g, 7); o != 0 {
+		v := g.Vector(o)
+		for i := 0; i < g.VectorLen(o); i++ {
+			p := &flatbuffers.Table{Bytes: g.Bytes, Pos: g.Indirect(v + flatbuffers.UOffsetT(4*i))}
+			ptype := fgbGeometryTypes["Polygon"]
+			if name == "GeometryCollection" {
+				ptype = 0
+			}
+			parts = append(parts, fgbDecodeGeometry(t, p, ptype))
+		}
+	}
+
+	switch name {
+	case "Point":
+		out.Coordinates = coords[0]
+	case "LineString", "MultiPoint":
+		out.Coordinates = coords
+	case "Polygon", "MultiLineString":
+		out.Coordinates = lines
+	case "MultiPolygon":
+		var polys [][][]geo.Coord
+		for _, p := range parts {
+			polys = append(polys, p.Coordinates.([][]geo.Coord))
+		}
+		out.Coordinates = polys
+	case "GeometryCollection":
+		out.Geometries = parts
+	default:
+		t.Fatalf("тип геометрии %d", typ)
+	}
+	return out
+}
+
+// exportFGB выгружает фичи в FlatGeobuf.
+func exportFGB(t *testing.T, feats []*models.GeoJSONFeature) []byte {
+	t.Helper()
+	w := NewFlatGeobufWriter(t.TempDir(), "слой", "EPSG", 4326, "")
+	for _, f := range feats {
+		if err := w.Scan(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range feats {
+		if err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := w.Close(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// checkFGBFeatures сравнивает фичи файла с выгруженными по id.
+func checkFGBFeatures(t *testing.T, f *fgbFile, feats []*models.GeoJSONFeature) {
+	t.Helper()
+	if len(f.features) != len(feats) || f.count != uint64(len(feats)) {
+		t.Fatalf("фич в файле %d, features_count %d, ждали %d", len(f.features), f.count, len(feats))
+	}
+	byID := map[int]*models.GeoJSONFeature{}
+	for _, w := range feats {
+		byID[w.ID] = w
+	}
+	for _, got := range f.features {
+		id, _ := got.props["id"].(float64)
+		w := byID[int(id)]
+		if w == nil {
+			t.Fatalf("в файле лишняя фича %v", got.props)
+		}
+		delete(byID, int(id))
+
+		geomJSON := []byte("null")
+		if got.geom != nil {
+			geomJSON, _ = json.Marshal(got.geom)
+		}
+		assertJSONEqual(t, fmt.Sprintf("фича %d: геометрия", w.ID), geomJSON, w.Geometry)
+		delete(got.props, "id")
+		props, _ := json.Marshal(got.props)
+		assertPropsSubset(t, props, w.Properties)
+	}
+}
+
+func TestFlatGeobufRoundTrip(t *testing.T) {
+	feats := []*models.GeoJSONFeature{
+		feature(1, `{"name":"a","n":1,"x":1.5,"ok":true}`, `{"type":"Point","coordinates":[30.5,59.9,10]}`),
+		feature(2, `{"name":"б","n":2}`, `{"type":"LineString","coordinates":[[0,0,1],[1,1,2]]}`),
+		feature(3, `{"ok":false}`, `{"type":"Polygon","coordinates":[[[0,0,0],[1,0,0],[1,1,0],[0,0,0]],[[0.2,0.1,0],[0.3,0.1,0],[0.3,0.2,0],[0.2,0.1,0]]]}`),
+		feature(4, `{}`, `{"type":"MultiPolygon","coordinates":[[[[5,5,0],[6,5,0],[6,6,0],[5,5,0]]],[[[7,7,0],[8,7,0],[8,8,0],[7,7,0]]]]}`),
+		feature(5, `{"name":"gc"}`, `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2,3]},{"type":"MultiLineString","coordinates":[[[0,0,0],[1,1,1]],[[2,2,2],[3,3,3]]]}]}`),
+	}
+	f := readFGB(t, exportFGB(t, feats))
+
+	if f.name != "слой" || f.crsCode != 4326 || !f.hasZ {
+		t.Errorf("заголовок: имя %q, crs %d, z %v", f.name, f.crsCode, f.hasZ)
+	}
+	if f.geomType != 0 {
+		t.Errorf("тип геометрии смешанного слоя %d, ждали 0 (Unknown)", f.geomType)
+	}
+	if want := []string{"id", "name", "n", "x", "ok"}; !reflect.DeepEqual(f.columns, want) {
+		t.Errorf("столбцы %v, ждали %v", f.columns, want)
+	}
+	if want := []byte{fgbLong, fgbString, fgbLong, fgbDouble, fgbBool}; !bytes.Equal(f.colTypes, want) {
+		t.Errorf("типы столбцов %v, ждали %v", f.colTypes, want)
+	}
+	if want := []float64{0, 0, 30.5, 59.9}; !reflect.DeepEqual(f.envelope, want) {
+		t.Errorf("охват %v, ждали %v", f.envelope, want)
+	}
+	checkFGBFeatures(t, f, feats)
+}
+
+func TestFlatGeobufIndex(t *testing.T) {
+	// 40 точек — два уровня дерева: 3 узла над листьями и корень
+	var feats []*models.GeoJSONFeature
+	for i := range 40 {
+		x, y := float64(i%8), float64(i/8)
+		feats = append(feats, feature(i+1, `{"n":`+fmt.Sprint(i)+`}`,
+			fmt.Sprintf(`{"type":"Point","coordinates":[%g,%g]}`, x, y)))
+	}
+	f := readFGB(t, exportFGB(t, feats))
+	checkFGBFeatures(t, f, feats)
+
+	if f.geomType != fgbGeometryTypes["Point"] || f.nodeSize != fgbIndexNodeSize {
+		t.Fatalf("тип геометрии %d, размер узла %d", f.geomType, f.nodeSize)
+	}
+	bounds := fgbLevelBounds(40, fgbIndexNodeSize)
+	if want := [][2]int{{4, 44}, {1, 4}, {0, 1}}; !reflect.DeepEqual(bounds, want) {
+		t.Fatalf("уровни дерева %v, ждали %v", bounds, want)
+	}
+	if len(f.index) != 44 {
+		t.Fatalf("узлов индекса %d, ждали 44", len(f.index))
+	}
+
+	// листья — по порядку фич в файле, со смещениями и охватами фич
+	centre := func(e [4]float64) (uint32, uint32) {
+		return uint32(math.Floor(0xFFFF * ((e[0]+e[2])/2 - f.envelope[0]) / (f.envelope[2] - f.envelope[0]))),
+			uint32(math.Floor(0xFFFF * ((e[1]+e[3])/2 - f.envelope[1]) / (f.envelope[3] - f.envelope[1])))
+	}
+	prev := uint32(math.MaxUint32)
+	for i, feat := range f.features {
+		leaf := f.index[bounds[0][0]+i]
+		if leaf.offset != uint64(feat.offset) {
+			t.Errorf("лист %d: смещение %d, фича по смещению %d", i, leaf.offset, feat.offset)
+		}
+		c := feat.geom.Coordinates.(geo.Coord)
+		if leaf.env != [4]float64{c[0], c[1], c[0], c[1]} {
+			t.Errorf("лист %d: охват %v, точка %v", i, leaf.env, c)
+		}
+		if h := hilbert(centre(leaf.env)); h > prev {
+			t.Errorf("лист %d: значение Гильберта %d после %d, ждали убывания", i, h, prev)
+		} else {
+			prev = h
+		}
+	}
+
+	// внутренний узел ссылается на первого потомка и охватывает потомков
+	for l := 1; l < len(bounds); l++ {
+		child := bounds[l-1][0]
+		for i := bounds[l][0]; i < bounds[l][1]; i++ {
+			n := f.index[i]
+			if n.offset != uint64(child) {
+				t.Errorf("узел %d: первый потомок %d, ждали %d", i, n.offset, child)
+			}
+			end := min(child+fgbIndexNodeSize, bounds[l-1][1])
+			want := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+			for ; child < end; child++ {
+				e := f.index[child].env
+				want = [4]float64{min(want[0], e[0]), min(want[1], e[1]), max(want[2], e[2]), max(want[3], e[3])}
+			}
+			if n.env != want {
+				t.Errorf("узел %d: охват %v, ждали %v", i, n.env, want)
+			}
+		}
+	}
+	if root := f.index[0].env; root != [4]float64{0, 0, 7, 4} {
+		t.Errorf("охват корня %v", root)
+	}
+}
+
+func TestFlatGeobufWithoutGeometry(t *testing.T) {
+	feats := []*models.GeoJSONFeature{
+		feature(1, `{"a":"x"}`, `{"type":"Point","coordinates":[1,2]}`),
+		feature(2, `{"a":"y"}`, `null`),
+	}
+	f := readFGB(t, exportFGB(t, feats))
+	if f.nodeSize != 0 || len(f.index) != 0 {
+		t.Errorf("фича без геометрии, а индекс есть: размер узла %d", f.nodeSize)
+	}
+	checkFGBFeatures(t, f, feats)
+	// без индекса фичи идут в порядке выгрузки
+	if f.features[0].props["a"] != "x" || f.features[1].geom != nil {
+		t.Errorf("порядок фич %+v", f.features)
+	}
+}
+
+// TestHilbert проверяет свойства кривой: первые 4^k номеров заполняют
+// квадрат 2^k×2^k у начала координат, соседние номера — соседние клетки.
+func TestHilbert(t *testing.T) {
+	const side = 64
+	cells := make([][2]uint32, side*side)
+	seen := make([]bool, side*side)
+	for x := uint32(0); x < side; x++ {
+		for y := uint32(0); y < side; y++ {
+			h := hilbert(x, y)
+			if h >= side*side || seen[h] {
+				t.Fatalf("hilbert(%d, %d) = %d: вне квадрата или повтор", x, y, h)
+			}
+			seen[h] = true
+			cells[h] = [2]uint32{x, y}
+		}
+	}
+	for i := 1; i < len(cells); i++ {
+		a, b := cells[i-1], cells[i]
+		dx, dy := int(a[0])-int(b[0]), int(a[1])-int(b[1])
+		if dx*dx+dy*dy != 1 {
+			t.Fatalf("номера %d и %d — клетки %v и %v не соседние", i-1, i, a, b)
+		}
+	}
+}
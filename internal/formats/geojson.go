@@ -0,0 +1,137 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// GeoJSONReader потоково читает FeatureCollection: массив features
+// разбирается по одной фиче, поэтому память не зависит от размера файла.
+type GeoJSONReader struct {
+	dec   *json.Decoder
+	crs   json.RawMessage
+	state int
+}
+
+const (
+	geoJSONStart = iota
+	geoJSONFeatures
+	geoJSONDone
+)
+
+// NewGeoJSONReader создаёт читатель FeatureCollection из r.
+func NewGeoJSONReader(r io.Reader) *GeoJSONReader {
+	return &GeoJSONReader{dec: json.NewDecoder(r)}
+}
+
+// Next возвращает следующую фичу массива features или io.EOF.
+func (g *GeoJSONReader) Next() (*models.GeoJSONFeature, error) {
+	switch g.state {
+	case geoJSONDone:
+		return nil, io.EOF
+	case geoJSONStart:
+		if err := g.expect(json.Delim('{')); err != nil {
+			return nil, err
+		}
+		found, err := g.members(true)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			g.state = geoJSONDone
+			return nil, io.EOF
+		}
+		g.state = geoJSONFeatures
+	}
+
+	if !g.dec.More() {
+		// закрываем массив и дочитываем члены после него: crs бывает и в конце
+		if err := g.expect(json.Delim(']')); err != nil {
+			return nil, err
+		}
+		if _, err := g.members(false); err != nil {
+			return nil, err
+		}
+		g.state = geoJSONDone
+		return nil, io.EOF
+	}
+
+	var f struct {
+		Type       string          `json:"type"`
+		Properties json.RawMessage `json:"properties"`
+		Geometry   json.RawMessage `json:"geometry"`
+	}
+	if err := g.dec.Decode(&f); err != nil {
+		return nil, g.errorf("фича: %v", err)
+	}
+	if f.Type != "Feature" {
+		return nil, g.errorf("ожидался объект Feature, получен %q", f.Type)
+	}
+	return &models.GeoJSONFeature{
+		Type:       f.Type,
+		Properties: models.JSONData(f.Properties),
+		Geometry:   models.JSONData(f.Geometry),
+	}, nil
+}
+
+// SRID возвращает систему координат из члена crs или 0.
+func (g *GeoJSONReader) SRID() (int, error) {
+	return geo.ParseCRSMember(g.crs)
+}
+
+// members читает члены объекта верхнего уровня. При untilFeatures
+// чтение останавливается на открытом массиве features (found = true);
+// иначе — на закрывающей скобке объекта.
+func (g *GeoJSONReader) members(untilFeatures bool) (found bool, err error) {
+	for g.dec.More() {
+		tok, err := g.dec.Token()
+		if err != nil {
+			return false, g.errorf("%v", err)
+		}
+		key, _ := tok.(string)
+		switch {
+		case key == "features" && untilFeatures:
+			if err := g.expect(json.Delim('[')); err != nil {
+				return false, err
+			}
+			return true, nil
+		case key == "type":
+			var typ string
+			if err := g.dec.Decode(&typ); err != nil {
+				return false, g.errorf("type: %v", err)
+			}
+			if typ != "FeatureCollection" {
+				return false, g.errorf("ожидался FeatureCollection, получен %q", typ)
+			}
+		case key == "crs":
+			if err := g.dec.Decode(&g.crs); err != nil {
+				return false, g.errorf("crs: %v", err)
+			}
+		default:
+			var skip json.RawMessage
+			if err := g.dec.Decode(&skip); err != nil {
+				return false, g.errorf("%s: %v", key, err)
+			}
+		}
+	}
+	return false, g.expect(json.Delim('}'))
+}
+
+func (g *GeoJSONReader) expect(want json.Delim) error {
+	tok, err := g.dec.Token()
+	if err != nil {
+		return g.errorf("%v", err)
+	}
+	if tok != want {
+		return g.errorf("ожидался символ %q", string(want))
+	}
+	return nil
+}
+
+func (g *GeoJSONReader) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: GeoJSON, байт %d: %s", ErrInvalidFile, g.dec.InputOffset(), fmt.Sprintf(format, args...))
+}
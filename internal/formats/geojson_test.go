@@ -0,0 +1,134 @@
+package formats
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestGeoJSONReaderCRS(t *testing.T) {
+	const feature = `{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[500000,6651411]}}`
+	const crs = `"crs":{"type":"name","properties":{"name":"urn:ogc:def:crs:EPSG::32636"}}`
+	tests := []struct {
+		name string
+		doc  string
+		want int
+	}{
+		{"crs перед features", `{"type":"FeatureCollection",` + crs + `,"features":[` + feature + `]}`, 32636},
+		{"crs после features", `{"type":"FeatureCollection","features":[` + feature + `],` + crs + `}`, 32636},
+		{"без crs", `{"type":"FeatureCollection","features":[` + feature + `]}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewGeoJSONReader(strings.NewReader(tt.doc))
+			// crs может оказаться после фич: SRID известен, когда фичи прочитаны
+			for {
+				_, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			srid, err := r.SRID()
+			if err != nil || srid != tt.want {
+				t.Errorf("SRID() = %d, %v; ждали %d", srid, err, tt.want)
+			}
+		})
+	}
+
+	r := NewGeoJSONReader(strings.NewReader(`{"type":"FeatureCollection","crs":{"type":"link"},"features":[]}`))
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatal(err)
+	}
+	// неподдерживаемый crs — не ошибка разбора файла: её сообщает импорт
+	if _, err := r.SRID(); err == nil || errors.Is(err, ErrInvalidFile) {
+		t.Errorf("crs типа link: %v", err)
+	}
+}
+
+func TestGeoJSONReader(t *testing.T) {
+	doc := `{"type":"FeatureCollection","bbox":[0,0,1,1],"metadata":{"features":[1,2]},"features":[
+		{"type":"Feature","properties":{"n":1},"geometry":{"type":"Point","coordinates":[0,0]}},
+		{"type":"Feature","id":7,"properties":null,"geometry":{"type":"Point","coordinates":[1,1]}},
+		{"geometry":{"type":"LineString","coordinates":[[0,0],[1,1]]},"type":"Feature"}
+	]}`
+	r := NewGeoJSONReader(strings.NewReader(doc))
+	want := []struct{ props, geom string }{
+		{`{"n":1}`, `{"type":"Point","coordinates":[0,0]}`},
+		{`null`, `{"type":"Point","coordinates":[1,1]}`},
+		{``, `{"type":"LineString","coordinates":[[0,0],[1,1]]}`},
+	}
+	for i, w := range want {
+		f, err := r.Next()
+		if err != nil {
+			t.Fatalf("фича %d: %v", i, err)
+		}
+		if string(f.Properties) != w.props || string(f.Geometry) != w.geom {
+			t.Errorf("фича %d: %s %s", i, f.Properties, f.Geometry)
+		}
+	}
+	for range 2 {
+		if _, err := r.Next(); err != io.EOF {
+			t.Fatalf("после фич: %v", err)
+		}
+	}
+
+	// без массива features фич нет
+	r = NewGeoJSONReader(strings.NewReader(`{"type":"FeatureCollection"}`))
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("без features: %v", err)
+	}
+}
+
+func TestGeoJSONReaderErrors(t *testing.T) {
+	const point = `{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}`
+	tests := []struct {
+		name string
+		doc  string
+		good int // число фич до ошибки
+	}{
+		{"не объект", `[` + point + `]`, 0},
+		{"не FeatureCollection", `{"type":"Feature","features":[]}`, 0},
+		{"features не массив", `{"type":"FeatureCollection","features":{}}`, 0},
+		{"не Feature", `{"type":"FeatureCollection","features":[` + point + `,{"type":"Point","coordinates":[0,0]}]}`, 1},
+		{"оборван", `{"type":"FeatureCollection","features":[` + point + `,{"type":"Feat`, 1},
+		{"мусор после массива", `{"type":"FeatureCollection","features":[` + point + `] 5}`, 1},
+		{"пустой файл", ``, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewGeoJSONReader(strings.NewReader(tt.doc))
+			for i := 0; ; i++ {
+				_, err := r.Next()
+				if err == nil {
+					continue
+				}
+				if err == io.EOF || !errors.Is(err, ErrInvalidFile) {
+					t.Fatalf("после %d фич: %v; ждали ErrInvalidFile", i, err)
+				}
+				if i != tt.good {
+					t.Errorf("ошибка после %d фич, ждали после %d: %v", i, tt.good, err)
+				}
+				return
+			}
+		})
+	}
+}
+
+// TestGeoJSONReaderStreaming проверяет, что фичи отдаются по мере
+// чтения: первая фича доступна, хотя продолжение файла ещё не прочитано.
+func TestGeoJSONReaderStreaming(t *testing.T) {
+	failed := errors.New("соединение оборвано")
+	head := `{"type":"FeatureCollection","features":[{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[0,0]}},`
+	r := NewGeoJSONReader(io.MultiReader(strings.NewReader(head), iotest.ErrReader(failed)))
+	if _, err := r.Next(); err != nil {
+		t.Fatalf("первая фича: %v", err)
+	}
+	if _, err := r.Next(); !errors.Is(err, ErrInvalidFile) || !strings.Contains(err.Error(), failed.Error()) {
+		t.Errorf("вторая фича: %v", err)
+	}
+}
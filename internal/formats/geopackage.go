@@ -0,0 +1,797 @@
+package formats
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	_ "github.com/mattn/go-sqlite3" // драйвер на C: сборка требует cgo, см. README
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// GeoPackage — файл OGC GeoPackage (база SQLite), открытый для чтения.
+type GeoPackage struct {
+	db *sql.DB
+}
+
+// GeoPackageLayer — векторная таблица GeoPackage.
+type GeoPackageLayer struct {
+	Table          string
+	Identifier     string
+	Description    string
+	GeometryColumn string
+	SRSID          int
+	srid           int
+	sridErr        error
+}
+
+// OpenGeoPackage открывает файл path только для чтения.
+func OpenGeoPackage(path string) (*GeoPackage, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&immutable=1")
+	if err != nil {
+		return nil, fmt.Errorf("%w: gpkg: %v", ErrInvalidFile, err)
+	}
+	var n int
+	// без gpkg_contents это не GeoPackage, даже если файл — база SQLite
+	err = db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type='table' AND name='gpkg_contents'`).Scan(&n)
+	if err != nil || n == 0 {
+		db.Close()
+		if err == nil {
+			err = errors.New("нет таблицы gpkg_contents")
+		}
+		return nil, fmt.Errorf("%w: gpkg: %v", ErrInvalidFile, err)
+	}
+	return &GeoPackage{db: db}, nil
+}
+
+// Close закрывает файл.
+func (g *GeoPackage) Close() error {
+	return g.db.Close()
+}
+
+// Layers возвращает векторные таблицы файла в порядке gpkg_contents.
+func (g *GeoPackage) Layers(ctx context.Context) ([]*GeoPackageLayer, error) {
+	rows, err := g.db.QueryContext(ctx, `
+	SELECT c.table_name, COALESCE(c.identifier, c.table_name), COALESCE(c.description, ''),
+	       gc.column_name, gc.srs_id,
+	       COALESCE(s.organization, ''), COALESCE(s.organization_coordsys_id, 0), COALESCE(s.definition, '')
+	  FROM gpkg_contents c
+	  JOIN gpkg_geometry_columns gc ON gc.table_name = c.table_name
+	  LEFT JOIN gpkg_spatial_ref_sys s ON s.srs_id = gc.srs_id
+	 WHERE c.data_type = 'features'
+	 ORDER BY c.rowid`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: gpkg: %v", ErrInvalidFile, err)
+	}
+	defer rows.Close()
+
+	var layers []*GeoPackageLayer
+	for rows.Next() {
+		var (
+			l          GeoPackageLayer
+			org, def   string
+			orgCoordID int
+		)
+		if err := rows.Scan(&l.Table, &l.Identifier, &l.Description, &l.GeometryColumn, &l.SRSID, &org, &orgCoordID, &def); err != nil {
+			return nil, fmt.Errorf("%w: gpkg: %v", ErrInvalidFile, err)
+		}
+		switch {
+		case strings.EqualFold(org, "EPSG"):
+			l.srid = orgCoordID
+		case l.SRSID == 0 || l.SRSID == -1 || strings.EqualFold(def, "undefined"):
+			// неопределённая система координат — решает source_srid
+		default:
+			l.srid, l.sridErr = PRJToSRID(def)
+		}
+		layers = append(layers, &l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: gpkg: %v", ErrInvalidFile, err)
+	}
+	return layers, nil
+}
+
+// Features возвращает источник фич слоя. Таблица читается при первом
+// вызове Next; первичный ключ в свойства не попадает.
+func (g *GeoPackage) Features(ctx context.Context, l *GeoPackageLayer) *GeoPackageFeatures {
+	return &GeoPackageFeatures{ctx: ctx, db: g.db, layer: l}
+}
+
+// GeoPackageFeatures читает фичи одного слоя GeoPackage.
+type GeoPackageFeatures struct {
+	ctx   context.Context
+	db    *sql.DB
+	layer *GeoPackageLayer
+	rows  *sql.Rows
+	cols  []string
+	geom  int // индекс столбца геометрии
+	pk    string
+	vals  []any
+	done  bool
+}
+
+// SRID возвращает систему координат слоя из gpkg_spatial_ref_sys.
+func (f *GeoPackageFeatures) SRID() (int, error) {
+	return f.layer.srid, f.layer.sridErr
+}
+
+// Close освобождает курсор, если слой прочитан не до конца.
+func (f *GeoPackageFeatures) Close() error {
+	f.done = true
+	if f.rows != nil {
+		return f.rows.Close()
+	}
+	return nil
+}
+
+func (f *GeoPackageFeatures) open() error {
+	var err error
+	if f.pk, err = f.primaryKey(); err != nil {
+		return err
+	}
+	f.rows, err = f.db.QueryContext(f.ctx, `SELECT * FROM `+quoteIdent(f.layer.Table))
+	if err != nil {
+		return err
+	}
+	if f.cols, err = f.rows.Columns(); err != nil {
+		return err
+	}
+	f.geom = -1
+	for i, c := range f.cols {
+		if strings.EqualFold(c, f.layer.GeometryColumn) {
+			f.geom = i
+		}
+	}
+	if f.geom < 0 {
+		return fmt.Errorf("нет столбца геометрии %q", f.layer.GeometryColumn)
+	}
+	f.vals = make([]any, len(f.cols))
+	return nil
+}
+
+func (f *GeoPackageFeatures) primaryKey() (string, error) {
+	rows, err := f.db.QueryContext(f.ctx, `SELECT name, pk FROM pragma_table_info(?)`, f.layer.Table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name string
+			pk   int
+		)
+		if err := rows.Scan(&name, &pk); err != nil {
+			return "", err
+		}
+		if pk == 1 {
+			return name, nil
+		}
+	}
+	return "", rows.Err()
+}
+
+// Next возвращает следующую фичу слоя; io.EOF — слой прочитан.
+func (f *GeoPackageFeatures) Next() (*models.GeoJSONFeature, error) {
+	if f.done {
+		return nil, io.EOF
+	}
+	if f.rows == nil {
+		if err := f.open(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%w: gpkg: слой %s: %v", ErrInvalidFile, f.layer.Table, err)
+		}
+	}
+	if !f.rows.Next() {
+		err := f.rows.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: gpkg: слой %s: %v", ErrInvalidFile, f.layer.Table, err)
+		}
+		return nil, io.EOF
+	}
+
+	ptrs := make([]any, len(f.vals))
+	for i := range f.vals {
+		ptrs[i] = &f.vals[i]
+	}
+	if err := f.rows.Scan(ptrs...); err != nil {
+		return nil, fmt.Errorf("%w: gpkg: слой %s: %v", ErrInvalidFile, f.layer.Table, err)
+	}
+
+	props := make(map[string]any, len(f.cols))
+	for i, c := range f.cols {
+		if i == f.geom || strings.EqualFold(c, f.pk) {
+			continue
+		}
+		props[c] = sqliteValue(f.vals[i])
+	}
+	propsJSON, err := json.Marshal(props)
+	if err != nil {
+		return nil, err
+	}
+
+	geomJSON := []byte("null")
+	if blob, ok := f.vals[f.geom].([]byte); ok && len(blob) > 0 {
+		g, err := ParseGeoPackageGeometry(blob)
+		if err != nil {
+			return nil, fmt.Errorf("%w: gpkg: слой %s: %v", ErrInvalidFile, f.layer.Table, err)
+		}
+		if g != nil {
+			if geomJSON, err = json.Marshal(g); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &models.GeoJSONFeature{
+		Type:       "Feature",
+		Properties: models.JSONData(propsJSON),
+		Geometry:   models.JSONData(geomJSON),
+	}, nil
+}
+
+// sqliteValue переводит значение SQLite в JSON-совместимое.
+func sqliteValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format(time.DateOnly)
+		}
+		return v.Format(time.RFC3339Nano)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+	}
+	return v // []byte кодируется в JSON как base64
+}
+
+// Флаги заголовка геометрии GeoPackage (GeoPackageBinary).
+const (
+	gpkgLittleEndian = 0x01
+	gpkgEmpty        = 0x10
+	gpkgExtended     = 0x20
+)
+
+// ParseGeoPackageGeometry разбирает значение столбца геометрии: заголовок
+// GeoPackageBinary и следующий за ним WKB. Пустая геометрия — nil.
+func ParseGeoPackageGeometry(b []byte) (*geo.Geometry, error) {
+	if len(b) < 8 || b[0] != 'G' || b[1] != 'P' {
+		return nil, errors.New("геометрия не в формате GeoPackageBinary")
+	}
+	flags := b[3]
+	if flags&gpkgExtended != 0 {
+		return nil, errors.New("расширенные геометрии GeoPackage не поддерживаются")
+	}
+	var envSize int
+	switch (flags >> 1) & 0x07 {
+	case 0:
+	case 1:
+		envSize = 32
+	case 2, 3:
+		envSize = 48
+	case 4:
+		envSize = 64
+	default:
+		return nil, errors.New("неверный тип охвата геометрии")
+	}
+	if len(b) < 8+envSize {
+		return nil, errors.New("геометрия обрывается")
+	}
+	if flags&gpkgEmpty != 0 {
+		return nil, nil
+	}
+	return geo.ParseWKB(b[8+envSize:])
+}
+
+// AppendGeoPackageGeometry дописывает геометрию в формате GeoPackageBinary
+// с охватом по x, y (и z для трёхмерной геометрии).
+func AppendGeoPackageGeometry(dst []byte, g *geo.Geometry, srsID int) ([]byte, error) {
+	env := geo.GeometryEnvelope(g)
+	flags := byte(gpkgLittleEndian)
+	switch {
+	case env.Empty:
+		flags |= gpkgEmpty
+	case env.HasZ:
+		flags |= 2 << 1
+	default:
+		flags |= 1 << 1
+	}
+	dst = append(dst, 'G', 'P', 0, flags)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(int32(srsID)))
+	if !env.Empty {
+		// порядок охвата по спецификации: minx, maxx, miny, maxy[, minz, maxz]
+		vals := []float64{env.MinX, env.MaxX, env.MinY, env.MaxY}
+		if env.HasZ {
+			vals = append(vals, env.MinZ, env.MaxZ)
+		}
+		for _, v := range vals {
+			dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(v))
+		}
+	}
+	return geo.AppendWKB(dst, g)
+}
+
+// GeoPackageWriter создаёт файл GeoPackage 1.4 с векторными слоями.
+// Все слои пишутся в одной транзакции SQLite. У каждого слоя есть
+// пространственный индекс — расширение gpkg_rtree_index.
+type GeoPackageWriter struct {
+	db     *sql.DB
+	tx     *sql.Tx
+	srs    map[int]bool
+	tables map[string]bool
+	idents map[string]bool
+}
+
+// wgs84Definition — WKT EPSG:4326, обязательной записи gpkg_spatial_ref_sys.
+const wgs84Definition = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AXIS["Latitude",NORTH],AXIS["Longitude",EAST],AUTHORITY["EPSG","4326"]]`
+
+// gpkgSchema — обязательные таблицы GeoPackage (раздел 1.1 спецификации).
+const gpkgSchema = `
+PRAGMA application_id = 1196444487;
+PRAGMA user_version = 10400;
+CREATE TABLE gpkg_spatial_ref_sys (
+    srs_name                 TEXT    NOT NULL,
+    srs_id                   INTEGER NOT NULL PRIMARY KEY,
+    organization             TEXT    NOT NULL,
+    organization_coordsys_id INTEGER NOT NULL,
+    definition               TEXT    NOT NULL,
+    description              TEXT
+);
+CREATE TABLE gpkg_contents (
+    table_name  TEXT     NOT NULL PRIMARY KEY,
+    data_type   TEXT     NOT NULL,
+    identifier  TEXT     UNIQUE,
+    description TEXT     DEFAULT '',
+    last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+    min_x       DOUBLE,
+    min_y       DOUBLE,
+    max_x       DOUBLE,
+    max_y       DOUBLE,
+    srs_id      INTEGER,
+    CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+);
+CREATE TABLE gpkg_geometry_columns (
+    table_name         TEXT    NOT NULL,
+    column_name        TEXT    NOT NULL,
+    geometry_type_name TEXT    NOT NULL,
+    srs_id             INTEGER NOT NULL,
+    z                  TINYINT NOT NULL,
+    m                  TINYINT NOT NULL,
+    CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+    CONSTRAINT uk_gc_table_name UNIQUE (table_name),
+    CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+    CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id)
+);
+CREATE TABLE gpkg_extensions (
+    table_name     TEXT,
+    column_name    TEXT,
+    extension_name TEXT NOT NULL,
+    definition     TEXT NOT NULL,
+    scope          TEXT NOT NULL,
+    CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name)
+);
+INSERT INTO gpkg_spatial_ref_sys VALUES
+    ('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+    ('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system');
+`
+
+// CreateGeoPackage создаёт новый файл GeoPackage по пути path.
+func CreateGeoPackage(path string) (*GeoPackageWriter, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=rwc&_journal_mode=OFF&_sync=OFF")
+	if err != nil {
+		return nil, err
+	}
+	// одна транзакция — одно соединение
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(gpkgSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	w := &GeoPackageWriter{db: db, tx: tx, srs: map[int]bool{}, tables: map[string]bool{}, idents: map[string]bool{}}
+	if err := w.AddSRS(geo.SRID4326, "EPSG", geo.SRID4326, "WGS 84 geodetic", wgs84Definition); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// AddSRS регистрирует систему координат в gpkg_spatial_ref_sys;
+// повторная регистрация ничего не делает.
+func (w *GeoPackageWriter) AddSRS(srsID int, org string, orgID int, name, definition string) error {
+	if w.srs[srsID] {
+		return nil
+	}
+	if definition == "" {
+		definition = "undefined"
+	}
+	_, err := w.tx.Exec(`INSERT INTO gpkg_spatial_ref_sys VALUES (?, ?, ?, ?, ?, NULL)`,
+		name, srsID, org, orgID, definition)
+	if err != nil {
+		return err
+	}
+	w.srs[srsID] = true
+	return nil
+}
+
+// HasSRS сообщает, зарегистрирована ли система координат.
+func (w *GeoPackageWriter) HasSRS(srsID int) bool {
+	return w.srs[srsID]
+}
+
+// Layer создаёт таблицу слоя и её R-дерево. Имя таблицы строится из
+// identifier; identifier остаётся человекочитаемым именем. Совпадающие
+// имена дополняются номером.
+func (w *GeoPackageWriter) Layer(identifier, description string, srsID int) (*GeoPackageLayerWriter, error) {
+	table := w.tableName(identifier)
+	base := identifier
+	for i := 2; w.idents[identifier]; i++ {
+		identifier = base + " (" + strconv.Itoa(i) + ")"
+	}
+	w.idents[identifier] = true
+	l := &GeoPackageLayerWriter{
+		w:           w,
+		table:       table,
+		identifier:  identifier,
+		description: description,
+		srsID:       srsID,
+		columns:     map[string]string{},
+		used:        map[string]bool{"fid": true, "geom": true},
+		env:         geo.EmptyEnvelope(),
+	}
+	w.tables[l.rtreeName()] = true
+	// тип геометрии известен только после записи всех фич: Finish
+	// пересоздаёт таблицу, если он у слоя один
+	if _, err := w.tx.Exec(l.createTable(table, "GEOMETRY")); err != nil {
+		return nil, err
+	}
+	rtree := quoteIdent(l.rtreeName())
+	if _, err := w.tx.Exec(`CREATE VIRTUAL TABLE ` + rtree + ` USING rtree(id, minx, maxx, miny, maxy)`); err != nil {
+		return nil, err
+	}
+	var err error
+	if l.insertRTree, err = w.tx.Prepare(`INSERT INTO ` + rtree + ` VALUES (?, ?, ?, ?, ?)`); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (w *GeoPackageWriter) tableName(identifier string) string {
+	base := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, identifier)
+	if base == "" || unicode.IsDigit([]rune(base)[0]) || strings.HasPrefix(base, "gpkg_") || strings.HasPrefix(base, "sqlite_") {
+		base = "layer_" + base
+	}
+	name := base
+	for i := 2; w.tables[name]; i++ {
+		name = base + "_" + strconv.Itoa(i)
+	}
+	w.tables[name] = true
+	return name
+}
+
+// Close фиксирует транзакцию и закрывает файл.
+func (w *GeoPackageWriter) Close() error {
+	if err := w.tx.Commit(); err != nil {
+		w.db.Close()
+		return err
+	}
+	return w.db.Close()
+}
+
+// Abort отменяет запись; файл остаётся неполным и подлежит удалению.
+func (w *GeoPackageWriter) Abort() {
+	w.tx.Rollback()
+	w.db.Close()
+}
+
+// GeoPackageLayerWriter пишет фичи в таблицу слоя. Столбцы свойств
+// добавляются по мере появления новых ключей, тип столбца — по первому
+// непустому значению; вложенные объекты и массивы пишутся как JSON.
+type GeoPackageLayerWriter struct {
+	w           *GeoPackageWriter
+	table       string
+	identifier  string
+	description string
+	srsID       int
+
+	keys     []string          // ключи свойств в порядке появления
+	columns  map[string]string // ключ свойства → столбец
+	colTypes []string          // типы столбцов свойств в порядке keys
+	used     map[string]bool   // занятые имена столбцов в нижнем регистре
+	insert   *sql.Stmt
+	width    int // число столбцов свойств в insert
+
+	insertRTree *sql.Stmt
+
+	env      geo.Envelope
+	geomType string
+	withZ    int // фичи с координатой z и без неё
+	withoutZ int
+	buf      []byte
+}
+
+// Write добавляет фичу; ID фичи сохраняется как fid.
+func (l *GeoPackageLayerWriter) Write(f *models.GeoJSONFeature) error {
+	var props map[string]any
+	if len(f.Properties) > 0 {
+		if err := json.Unmarshal(f.Properties, &props); err != nil {
+			return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+		}
+	}
+	// новые столбцы добавляются в порядке ключей, а не обхода map,
+	// чтобы одна и та же выгрузка давала одинаковые файлы
+	newKeys := make([]string, 0, len(props))
+	for k, v := range props {
+		if _, ok := l.columns[k]; !ok && v != nil {
+			newKeys = append(newKeys, k)
+		}
+	}
+	sort.Strings(newKeys)
+	for _, k := range newKeys {
+		if err := l.addColumn(k, props[k]); err != nil {
+			return err
+		}
+	}
+
+	var (
+		geom any
+		env  = geo.EmptyEnvelope()
+	)
+	if len(f.Geometry) > 0 && string(f.Geometry) != "null" {
+		var g geo.Geometry
+		if err := json.Unmarshal(f.Geometry, &g); err != nil {
+			return fmt.Errorf("фича %d: геометрия: %w", f.ID, err)
+		}
+		var err error
+		if l.buf, err = AppendGeoPackageGeometry(l.buf[:0], &g, l.srsID); err != nil {
+			return fmt.Errorf("фича %d: геометрия: %w", f.ID, err)
+		}
+		geom = l.buf
+		env = geo.GeometryEnvelope(&g)
+		l.env.Extend(env)
+		if geo.HasZ(&g) {
+			l.withZ++
+		} else {
+			l.withoutZ++
+		}
+		switch {
+		case l.geomType == "":
+			l.geomType = g.Type
+		case l.geomType != g.Type:
+			l.geomType = "GEOMETRY"
+		}
+	}
+
+	if l.insert == nil || l.width != len(l.keys) {
+		if err := l.prepare(); err != nil {
+			return err
+		}
+	}
+	args := make([]any, 0, 2+len(l.keys))
+	args = append(args, f.ID, geom)
+	for _, k := range l.keys {
+		args = append(args, columnValue(props[k]))
+	}
+	if _, err := l.insert.Exec(args...); err != nil {
+		return err
+	}
+	// пустые геометрии в R-дерево не попадают (ST_IsEmpty в триггерах)
+	if env.Empty {
+		return nil
+	}
+	_, err := l.insertRTree.Exec(f.ID, env.MinX, env.MaxX, env.MinY, env.MaxY)
+	return err
+}
+
+func (l *GeoPackageLayerWriter) addColumn(key string, sample any) error {
+	name := key
+	if name == "" {
+		name = "field"
+	}
+	base := name
+	for i := 2; l.used[strings.ToLower(name)]; i++ {
+		name = base + "_" + strconv.Itoa(i)
+	}
+	typ := "TEXT"
+	switch v := sample.(type) {
+	case bool:
+		typ = "BOOLEAN"
+	case float64:
+		typ = "REAL"
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			typ = "INTEGER"
+		}
+	}
+	_, err := l.w.tx.Exec(`ALTER TABLE ` + quoteIdent(l.table) + ` ADD COLUMN ` + quoteIdent(name) + ` ` + typ)
+	if err != nil {
+		return err
+	}
+	l.used[strings.ToLower(name)] = true
+	l.columns[key] = name
+	l.keys = append(l.keys, key)
+	l.colTypes = append(l.colTypes, typ)
+	return nil
+}
+
+// createTable возвращает CREATE TABLE таблицы слоя с текущими столбцами
+// и столбцом геометрии типа geomType.
+func (l *GeoPackageLayerWriter) createTable(table, geomType string) string {
+	cols := []string{"fid INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL", "geom " + geomType}
+	for i, k := range l.keys {
+		cols = append(cols, quoteIdent(l.columns[k])+" "+l.colTypes[i])
+	}
+	return `CREATE TABLE ` + quoteIdent(table) + ` (` + strings.Join(cols, ", ") + `)`
+}
+
+// rtreeName — имя R-дерева слоя по спецификации: rtree_<таблица>_<столбец>.
+func (l *GeoPackageLayerWriter) rtreeName() string {
+	return "rtree_" + l.table + "_geom"
+}
+
+func (l *GeoPackageLayerWriter) prepare() error {
+	if l.insert != nil {
+		l.insert.Close()
+	}
+	cols := []string{"fid", "geom"}
+	for _, k := range l.keys {
+		cols = append(cols, quoteIdent(l.columns[k]))
+	}
+	q := `INSERT INTO ` + quoteIdent(l.table) + ` (` + strings.Join(cols, ", ") +
+		`) VALUES (?` + strings.Repeat(", ?", len(cols)-1) + `)`
+	var err error
+	l.insert, err = l.w.tx.Prepare(q)
+	l.width = len(l.keys)
+	return err
+}
+
+// columnValue переводит значение свойства JSON в значение SQLite.
+func columnValue(v any) any {
+	switch v := v.(type) {
+	case nil, bool, string:
+		return v
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+		return v
+	default:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+}
+
+// Finish регистрирует слой в gpkg_contents и gpkg_geometry_columns
+// с охватом и типом геометрии по записанным фичам и подключает
+// R-дерево к таблице триггерами.
+func (l *GeoPackageLayerWriter) Finish() error {
+	if l.insert != nil {
+		l.insert.Close()
+	}
+	l.insertRTree.Close()
+
+	geomType := strings.ToUpper(l.geomType)
+	if geomType == "" {
+		geomType = "GEOMETRY"
+	}
+	// тип столбца геометрии должен совпадать с geometry_type_name
+	if geomType != "GEOMETRY" {
+		if err := l.retype(geomType); err != nil {
+			return err
+		}
+	}
+	if err := l.rtreeTriggers(); err != nil {
+		return err
+	}
+
+	var minX, minY, maxX, maxY any
+	if !l.env.Empty {
+		minX, minY, maxX, maxY = l.env.MinX, l.env.MinY, l.env.MaxX, l.env.MaxY
+	}
+	_, err := l.w.tx.Exec(`
+	INSERT INTO gpkg_contents (table_name, data_type, identifier, description, min_x, min_y, max_x, max_y, srs_id)
+	VALUES (?, 'features', ?, ?, ?, ?, ?, ?, ?)`,
+		l.table, l.identifier, l.description, minX, minY, maxX, maxY, l.srsID)
+	if err != nil {
+		return err
+	}
+	// z: 0 — запрещена, 1 — обязательна, 2 — необязательна
+	z := 0
+	switch {
+	case l.withZ > 0 && l.withoutZ > 0:
+		z = 2
+	case l.withZ > 0:
+		z = 1
+	}
+	_, err = l.w.tx.Exec(`INSERT INTO gpkg_geometry_columns VALUES (?, 'geom', ?, ?, ?, 0)`,
+		l.table, geomType, l.srsID, z)
+	if err != nil {
+		return err
+	}
+	_, err = l.w.tx.Exec(`INSERT INTO gpkg_extensions VALUES (?, 'geom', 'gpkg_rtree_index', ?, 'write-only')`,
+		l.table, "http://www.geopackage.org/spec120/#extension_rtree")
+	return err
+}
+
+// retype пересоздаёт таблицу слоя со столбцом геометрии типа geomType:
+// SQLite не меняет тип объявленного столбца.
+func (l *GeoPackageLayerWriter) retype(geomType string) error {
+	tmp := l.w.tableName(l.table + "_retype")
+	table := quoteIdent(l.table)
+	for _, q := range []string{
+		l.createTable(tmp, geomType),
+		`INSERT INTO ` + quoteIdent(tmp) + ` SELECT * FROM ` + table,
+		`DROP TABLE ` + table,
+		`ALTER TABLE ` + quoteIdent(tmp) + ` RENAME TO ` + table,
+	} {
+		if _, err := l.w.tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rtreeTriggers создаёт триггеры расширения gpkg_rtree_index
+// (GeoPackage 1.4, приложение F.3), которые поддерживают R-дерево при
+// изменении таблицы. Функции ST_* предоставляет читающее приложение
+// (GDAL, QGIS); записанные фичи уже занесены в R-дерево в Write.
+func (l *GeoPackageLayerWriter) rtreeTriggers() error {
+	r := l.rtreeName()
+	t, rt := quoteIdent(l.table), quoteIdent(r)
+	values := `VALUES (NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom))`
+	triggers := []struct{ name, on, when, body string }{
+		{"insert", "AFTER INSERT ON " + t,
+			"NEW.geom NOT NULL AND NOT ST_IsEmpty(NEW.geom)",
+			"INSERT OR REPLACE INTO " + rt + " " + values + ";"},
+		{"update6", "AFTER UPDATE OF geom ON " + t,
+			"OLD.fid = NEW.fid AND (NEW.geom NOTNULL AND NOT ST_IsEmpty(NEW.geom)) AND (OLD.geom NOTNULL AND NOT ST_IsEmpty(OLD.geom))",
+			"UPDATE " + rt + " SET minx = ST_MinX(NEW.geom), maxx = ST_MaxX(NEW.geom), miny = ST_MinY(NEW.geom), maxy = ST_MaxY(NEW.geom) WHERE id = NEW.fid;"},
+		{"update7", "AFTER UPDATE OF geom ON " + t,
+			"OLD.fid = NEW.fid AND (NEW.geom NOTNULL AND NOT ST_IsEmpty(NEW.geom)) AND (OLD.geom ISNULL OR ST_IsEmpty(OLD.geom))",
+			"INSERT INTO " + rt + " " + values + ";"},
+		{"update2", "AFTER UPDATE OF geom ON " + t,
+			"OLD.fid = NEW.fid AND (NEW.geom ISNULL OR ST_IsEmpty(NEW.geom))",
+			"DELETE FROM " + rt + " WHERE id = OLD.fid;"},
+		{"update5", "AFTER UPDATE ON " + t,
+			"OLD.fid != NEW.fid AND (NEW.geom NOTNULL AND NOT ST_IsEmpty(NEW.geom))",
+			"DELETE FROM " + rt + " WHERE id = OLD.fid; INSERT OR REPLACE INTO " + rt + " " + values + ";"},
+		{"update4", "AFTER UPDATE ON " + t,
+			"OLD.fid != NEW.fid AND (NEW.geom ISNULL OR ST_IsEmpty(NEW.geom))",
+			"DELETE FROM " + rt + " WHERE id IN (OLD.fid, NEW.fid);"},
+		{"delete", "AFTER DELETE ON " + t,
+			"OLD.geom NOT NULL",
+			"DELETE FROM " + rt + " WHERE id = OLD.fid;"},
+	}
+	for _, tr := range triggers {
+		q := `CREATE TRIGGER ` + quoteIdent(r+"_"+tr.name) + ` ` + tr.on + ` WHEN (` + tr.when + `) BEGIN ` + tr.body + ` END`
+		if _, err := l.w.tx.Exec(q); err != nil {
+			return fmt.Errorf("R-дерево %s: %w", r, err)
+		}
+	}
+	return nil
+}
+
+// quoteIdent заключает имя таблицы или столбца SQLite в кавычки.
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
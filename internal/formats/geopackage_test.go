@@ -0,0 +1,205 @@
+package formats
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"Datapolis/internal/models"
+)
+
+func feature(id int, props, geom string) *models.GeoJSONFeature {
+	return &models.GeoJSONFeature{
+		ID:         id,
+		Properties: models.JSONData(props),
+		Geometry:   models.JSONData(geom),
+	}
+}
+
+func TestGeoPackageRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.gpkg")
+	w, err := CreateGeoPackage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	polygons := []*models.GeoJSONFeature{
+		feature(10, `{"name":"a","area":1.5,"zip":"01234"}`, `{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[1,0],[0,0]]]}`),
+		feature(11, `{"zip":"99999","count":3,"name":"b","ok":true}`, `{"type":"Polygon","coordinates":[[[2,2],[2,3],[3,3],[3,2],[2,2]]]}`),
+	}
+	mixed := []*models.GeoJSONFeature{
+		feature(20, `{"z":1,"a":"x"}`, `{"type":"Point","coordinates":[30.5,59.9]}`),
+		feature(21, `{}`, `{"type":"LineString","coordinates":[[30,59],[31,60]]}`),
+		feature(22, `{"a":"no geometry"}`, `null`),
+	}
+	for _, layer := range []struct {
+		name  string
+		feats []*models.GeoJSONFeature
+	}{{"Кварталы", polygons}, {"mixed", mixed}} {
+		l, err := w.Layer(layer.name, "", 4326)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range layer.feats {
+			if err := l.Write(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := l.Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	t.Run("тип столбца геометрии", func(t *testing.T) {
+		for table, want := range map[string]string{"кварталы": "POLYGON", "mixed": "GEOMETRY"} {
+			var declared, registered string
+			if err := db.QueryRow(`SELECT type FROM pragma_table_info(?) WHERE name = 'geom'`, table).Scan(&declared); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.QueryRow(`SELECT geometry_type_name FROM gpkg_geometry_columns WHERE table_name = ?`, table).Scan(&registered); err != nil {
+				t.Fatal(err)
+			}
+			if declared != want || registered != want {
+				t.Errorf("%s: столбец %s, gpkg_geometry_columns %s, ждали %s", table, declared, registered, want)
+			}
+		}
+	})
+
+	t.Run("порядок столбцов", func(t *testing.T) {
+		rows, err := db.Query(`SELECT name FROM pragma_table_info('кварталы') ORDER BY cid`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var cols []string
+		for rows.Next() {
+			var c string
+			rows.Scan(&c)
+			cols = append(cols, c)
+		}
+		// ключи первой фичи по алфавиту, затем новые ключи второй
+		want := []string{"fid", "geom", "area", "name", "zip", "count", "ok"}
+		if !reflect.DeepEqual(cols, want) {
+			t.Errorf("столбцы %v, ждали %v", cols, want)
+		}
+	})
+
+	t.Run("R-дерево", func(t *testing.T) {
+		var ext string
+		err := db.QueryRow(`SELECT scope FROM gpkg_extensions
+		                     WHERE table_name = 'mixed' AND column_name = 'geom' AND extension_name = 'gpkg_rtree_index'`).Scan(&ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND tbl_name = 'mixed'`).Scan(&n)
+		if n != 7 {
+			t.Errorf("триггеров R-дерева %d, ждали 7", n)
+		}
+
+		rows, err := db.Query(`SELECT id, minx, maxx, miny, maxy FROM rtree_mixed_geom ORDER BY id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var got [][5]float64
+		for rows.Next() {
+			var r [5]float64
+			rows.Scan(&r[0], &r[1], &r[2], &r[3], &r[4])
+			got = append(got, r)
+		}
+		// фича без геометрии в индекс не попадает; float32 R-дерева
+		// округляет границы наружу
+		if len(got) != 2 || got[0][0] != 20 || got[1][0] != 21 {
+			t.Fatalf("R-дерево %v", got)
+		}
+		for _, r := range got {
+			if r[1] > r[2] || r[3] > r[4] {
+				t.Errorf("неверный прямоугольник %v", r)
+			}
+		}
+		if got[0][1] > 30.5 || got[0][2] < 30.5 || got[0][3] > 59.9 || got[0][4] < 59.9 {
+			t.Errorf("прямоугольник точки %v не содержит её", got[0])
+		}
+	})
+
+	t.Run("чтение", func(t *testing.T) {
+		gpkg, err := OpenGeoPackage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gpkg.Close()
+		layers, err := gpkg.Layers(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(layers) != 2 || layers[0].Identifier != "Кварталы" || layers[1].Table != "mixed" {
+			t.Fatalf("слои %+v", layers)
+		}
+		for i, want := range [][]*models.GeoJSONFeature{polygons, mixed} {
+			src := gpkg.Features(context.Background(), layers[i])
+			if srid, err := src.SRID(); err != nil || srid != 4326 {
+				t.Errorf("SRID %d, %v", srid, err)
+			}
+			for _, w := range want {
+				f, err := src.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				assertJSONEqual(t, "геометрия", f.Geometry, w.Geometry)
+				assertPropsSubset(t, f.Properties, w.Properties)
+			}
+			if _, err := src.Next(); !errors.Is(err, io.EOF) {
+				t.Errorf("ждали io.EOF, получили %v", err)
+			}
+		}
+	})
+}
+
+// assertJSONEqual сравнивает два JSON-документа по значению.
+func assertJSONEqual(t *testing.T, what string, got, want []byte) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("%s: %s, ждали %s", what, got, want)
+	}
+}
+
+// assertPropsSubset проверяет, что прочитанные свойства содержат все
+// записанные; столбцы, которых у фичи не было, читаются как null.
+func assertPropsSubset(t *testing.T, got, want []byte) {
+	t.Helper()
+	var g, w map[string]any
+	json.Unmarshal(got, &g)
+	json.Unmarshal(want, &w)
+	for k, v := range w {
+		if !reflect.DeepEqual(g[k], v) {
+			t.Errorf("свойство %s: %v, ждали %v (все свойства %s)", k, g[k], v, got)
+		}
+	}
+	for k, v := range g {
+		if _, ok := w[k]; !ok && v != nil {
+			t.Errorf("лишнее свойство %s=%v", k, v)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// GPXWriter пишет фичи в GPX 1.1: точки — путевыми точками (wpt), линии —
+// треками (trk), кольца полигонов — отрезками трека. GPX хранит только
+// имя, описание и время, их дают свойства name, description (или desc)
+// и time. Схема требует, чтобы все wpt шли раньше trk, поэтому фичи
+// передаются дважды: сначала в Waypoints, затем в Tracks.
+type GPXWriter struct {
+	w *bufio.Writer
+}
+
+func NewGPXWriter(w io.Writer) *GPXWriter {
+	g := &GPXWriter{w: bufio.NewWriter(w)}
+	g.w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<gpx version="1.1" creator="Datapolis" xmlns="http://www.topografix.com/GPX/1/1">` + "\n")
+	return g
+}
+
+// gpxMeta — свойства фичи, которые знает GPX.
+type gpxMeta struct {
+	name, desc, time string
+}
+
+func gpxFeature(f *models.GeoJSONFeature) (*geo.Geometry, gpxMeta, error) {
+	var meta gpxMeta
+	g, err := featureGeometry(f)
+	if err != nil || g == nil {
+		return nil, meta, err
+	}
+	props, err := FlattenProperties(f.Properties)
+	if err != nil {
+		return nil, meta, fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+	}
+	meta.name = propertyNamed(props, "name")
+	if meta.desc = propertyNamed(props, "description"); meta.desc == "" {
+		meta.desc = propertyNamed(props, "desc")
+	}
+	if t, err := time.Parse(time.RFC3339Nano, propertyNamed(props, "time")); err == nil {
+		meta.time = t.UTC().Format(time.RFC3339Nano)
+	}
+	return g, meta, nil
+}
+
+// Waypoints пишет точки фичи.
+func (g *GPXWriter) Waypoints(f *models.GeoJSONFeature) error {
+	geom, meta, err := gpxFeature(f)
+	if err != nil || geom == nil {
+		return err
+	}
+	var points []geo.Coord
+	collectPoints(geom, &points)
+	for _, p := range points {
+		g.point("wpt", p, &meta)
+		g.w.WriteString("\n")
+	}
+	return nil
+}
+
+// Tracks пишет линии и кольца полигонов фичи одним треком.
+func (g *GPXWriter) Tracks(f *models.GeoJSONFeature) error {
+	geom, meta, err := gpxFeature(f)
+	if err != nil || geom == nil {
+		return err
+	}
+	var segments [][]geo.Coord
+	collectLines(geom, &segments)
+	if len(segments) == 0 {
+		return nil
+	}
+	g.w.WriteString("<trk>")
+	g.text("name", meta.name)
+	g.text("desc", meta.desc)
+	for _, seg := range segments {
+		g.w.WriteString("<trkseg>")
+		for _, p := range seg {
+			g.point("trkpt", p, nil)
+		}
+		g.w.WriteString("</trkseg>")
+	}
+	_, err = g.w.WriteString("</trk>\n")
+	return err
+}
+
+// Close завершает документ.
+func (g *GPXWriter) Close() error {
+	g.w.WriteString("</gpx>\n")
+	return g.w.Flush()
+}
+
+func (g *GPXWriter) point(tag string, c geo.Coord, meta *gpxMeta) {
+	fmt.Fprintf(g.w, `<%s lat="%s" lon="%s">`, tag,
+		strconv.FormatFloat(c[1], 'f', -1, 64), strconv.FormatFloat(c[0], 'f', -1, 64))
+	if len(c) > 2 {
+		g.text("ele", strconv.FormatFloat(c[2], 'f', -1, 64))
+	}
+	if meta != nil {
+		// порядок элементов wpt задан схемой: ele, time, ..., name, cmt, desc
+		g.text("time", meta.time)
+		g.text("name", meta.name)
+		g.text("desc", meta.desc)
+	}
+	g.w.WriteString("</" + tag + ">")
+}
+
+func (g *GPXWriter) text(name, value string) {
+	if value == "" {
+		return
+	}
+	g.w.WriteString("<" + name + ">")
+	xml.EscapeText(g.w, []byte(value))
+	g.w.WriteString("</" + name + ">")
+}
+
+func collectPoints(g *geo.Geometry, out *[]geo.Coord) {
+	switch c := g.Coordinates.(type) {
+	case geo.Coord:
+		if len(c) >= 2 {
+			*out = append(*out, c)
+		}
+	case []geo.Coord:
+		if g.Type == "MultiPoint" {
+			*out = append(*out, c...)
+		}
+	}
+	for _, child := range g.Geometries {
+		if child != nil {
+			collectPoints(child, out)
+		}
+	}
+}
+
+func collectLines(g *geo.Geometry, out *[][]geo.Coord) {
+	switch c := g.Coordinates.(type) {
+	case []geo.Coord:
+		if g.Type == "LineString" {
+			*out = append(*out, c)
+		}
+	case [][]geo.Coord:
+		*out = append(*out, c...)
+	case [][][]geo.Coord:
+		for _, p := range c {
+			*out = append(*out, p...)
+		}
+	}
+	for _, child := range g.Geometries {
+		if child != nil {
+			collectLines(child, out)
+		}
+	}
+}
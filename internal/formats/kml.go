@@ -0,0 +1,167 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// KMLWriter пишет фичи в KML 2.2: по Placemark на фичу, свойства —
+// в ExtendedData. Координаты должны быть в WGS 84 (долгота, широта).
+type KMLWriter struct {
+	w *bufio.Writer
+}
+
+// NewKMLWriter пишет начало документа с именем name.
+func NewKMLWriter(w io.Writer, name string) *KMLWriter {
+	k := &KMLWriter{w: bufio.NewWriter(w)}
+	k.w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<kml xmlns="http://www.opengis.net/kml/2.2"><Document><name>`)
+	xml.EscapeText(k.w, []byte(name))
+	k.w.WriteString("</name>\n")
+	return k
+}
+
+func (k *KMLWriter) Write(f *models.GeoJSONFeature) error {
+	props, err := FlattenProperties(f.Properties)
+	if err != nil {
+		return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+	}
+	g, err := featureGeometry(f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(k.w, `<Placemark id="f%d">`, f.ID)
+	if name := propertyNamed(props, "name"); name != "" {
+		k.element("name", name)
+	}
+	if len(props) > 0 {
+		k.w.WriteString("<ExtendedData>")
+		for _, p := range props {
+			k.w.WriteString(`<Data name="`)
+			xml.EscapeText(k.w, []byte(p.Key))
+			k.w.WriteString(`">`)
+			k.element("value", PropertyText(p.Value))
+			k.w.WriteString("</Data>")
+		}
+		k.w.WriteString("</ExtendedData>")
+	}
+	if g != nil {
+		k.geometry(g)
+	}
+	_, err = k.w.WriteString("</Placemark>\n")
+	return err
+}
+
+// Close завершает документ.
+func (k *KMLWriter) Close() error {
+	k.w.WriteString("</Document></kml>\n")
+	return k.w.Flush()
+}
+
+func (k *KMLWriter) element(name, text string) {
+	k.w.WriteString("<" + name + ">")
+	xml.EscapeText(k.w, []byte(text))
+	k.w.WriteString("</" + name + ">")
+}
+
+func (k *KMLWriter) coordinates(cs ...geo.Coord) {
+	k.w.WriteString("<coordinates>")
+	for i, c := range cs {
+		if i > 0 {
+			k.w.WriteByte(' ')
+		}
+		for j, v := range c {
+			if j > 2 {
+				break
+			}
+			if j > 0 {
+				k.w.WriteByte(',')
+			}
+			k.w.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	k.w.WriteString("</coordinates>")
+}
+
+func (k *KMLWriter) polygon(rings [][]geo.Coord) {
+	k.w.WriteString("<Polygon>")
+	for i, r := range rings {
+		boundary := "innerBoundaryIs"
+		if i == 0 {
+			boundary = "outerBoundaryIs"
+		}
+		k.w.WriteString("<" + boundary + "><LinearRing>")
+		k.coordinates(r...)
+		k.w.WriteString("</LinearRing></" + boundary + ">")
+	}
+	k.w.WriteString("</Polygon>")
+}
+
+func (k *KMLWriter) geometry(g *geo.Geometry) {
+	switch c := g.Coordinates.(type) {
+	case geo.Coord:
+		k.w.WriteString("<Point>")
+		k.coordinates(c)
+		k.w.WriteString("</Point>")
+	case []geo.Coord:
+		if g.Type == "LineString" {
+			k.w.WriteString("<LineString>")
+			k.coordinates(c...)
+			k.w.WriteString("</LineString>")
+			return
+		}
+		k.w.WriteString("<MultiGeometry>")
+		for _, p := range c {
+			k.w.WriteString("<Point>")
+			k.coordinates(p)
+			k.w.WriteString("</Point>")
+		}
+		k.w.WriteString("</MultiGeometry>")
+	case [][]geo.Coord:
+		if g.Type == "Polygon" {
+			k.polygon(c)
+			return
+		}
+		k.w.WriteString("<MultiGeometry>")
+		for _, l := range c {
+			k.w.WriteString("<LineString>")
+			k.coordinates(l...)
+			k.w.WriteString("</LineString>")
+		}
+		k.w.WriteString("</MultiGeometry>")
+	case [][][]geo.Coord:
+		k.w.WriteString("<MultiGeometry>")
+		for _, p := range c {
+			k.polygon(p)
+		}
+		k.w.WriteString("</MultiGeometry>")
+	default:
+		if g.Type == "GeometryCollection" {
+			k.w.WriteString("<MultiGeometry>")
+			for _, child := range g.Geometries {
+				if child != nil {
+					k.geometry(child)
+				}
+			}
+			k.w.WriteString("</MultiGeometry>")
+		}
+	}
+}
+
+// propertyNamed возвращает текст свойства с именем name без учёта регистра.
+func propertyNamed(props []Property, name string) string {
+	for _, p := range props {
+		if strings.EqualFold(p.Key, name) {
+			return PropertyText(p.Value)
+		}
+	}
+	return ""
+}
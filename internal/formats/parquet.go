@@ -0,0 +1,394 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// Физические типы, кодеки и кодировки Parquet (parquet.thrift).
+const (
+	pqBoolean   = 0
+	pqInt64     = 2
+	pqDouble    = 5
+	pqByteArray = 6
+
+	pqRequired = 0
+	pqOptional = 1
+
+	pqPlain = 0
+	pqRLE   = 3
+	pqGzip  = 2
+)
+
+// Группа строк сбрасывается в поток, когда набирает столько строк или
+// байт: в памяти держится только текущая группа.
+const (
+	pqRowGroupRows  = 64 * 1024
+	pqRowGroupBytes = 64 << 20
+)
+
+// GeoParquetWriter выгружает коллекцию в GeoParquet 1.1: геометрия —
+// WKB в столбце geometry, id фичи и свойства — типизированные столбцы.
+// Координаты — долгота и широта WGS 84 (CRS по умолчанию в GeoParquet).
+// Запись в два прохода: Scan собирает схему столбцов, Write копит строки
+// и сбрасывает заполненные группы, Close пишет метаданные файла.
+type GeoParquetWriter struct {
+	w       *bufio.Writer
+	offset  int64
+	schema  *PropertySchema
+	columns []*pqColumn
+	index   map[string]int // ключ свойства → номер столбца
+	rows    int            // строк в текущей группе
+	numRows int64
+	groups  []pqRowGroup
+	types   map[string]bool
+	env     geo.Envelope
+	wkb     []byte
+	zbuf    bytes.Buffer
+}
+
+// pqColumn — столбец и значения текущей группы строк.
+type pqColumn struct {
+	name     string
+	kind     int
+	ptype    int32
+	optional bool
+	defs     []byte // уровни определения: 1 — значение есть, 0 — пусто
+	values   []byte // значения в кодировке PLAIN
+	bits     int    // записано булевых значений
+}
+
+type pqRowGroup struct {
+	chunks    []pqChunk
+	numRows   int64
+	totalSize int64
+}
+
+type pqChunk struct {
+	offset       int64
+	uncompressed int64
+	compressed   int64
+}
+
+func NewGeoParquetWriter(w io.Writer) *GeoParquetWriter {
+	return &GeoParquetWriter{
+		w:      bufio.NewWriter(w),
+		schema: NewPropertySchema(),
+		types:  map[string]bool{},
+		env:    geo.EmptyEnvelope(),
+	}
+}
+
+// Scan учитывает фичу при первом проходе.
+func (p *GeoParquetWriter) Scan(f *models.GeoJSONFeature) error {
+	props, err := FlattenProperties(f.Properties)
+	if err != nil {
+		return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+	}
+	p.schema.Add(props)
+	return nil
+}
+
+// start пишет сигнатуру файла и создаёт столбцы по собранной схеме.
+func (p *GeoParquetWriter) start() error {
+	p.columns = []*pqColumn{
+		{name: "id", kind: KindInt, ptype: pqInt64},
+		{name: "geometry", ptype: pqByteArray, optional: true},
+	}
+	p.index = map[string]int{}
+	for i, name := range columnNames([]string{"id", "geometry"}, p.schema.Keys()) {
+		f := p.schema.Fields[i]
+		col := &pqColumn{name: name, kind: f.Kind, ptype: pqByteArray, optional: true}
+		switch f.Kind {
+		case KindInt:
+			col.ptype = pqInt64
+		case KindFloat:
+			col.ptype = pqDouble
+		case KindBool:
+			col.ptype = pqBoolean
+		}
+		p.index[f.Key] = len(p.columns)
+		p.columns = append(p.columns, col)
+	}
+	return p.write([]byte("PAR1"))
+}
+
+func (p *GeoParquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// Write добавляет фичу при втором проходе.
+func (p *GeoParquetWriter) Write(f *models.GeoJSONFeature) error {
+	if p.columns == nil {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	props, err := FlattenProperties(f.Properties)
+	if err != nil {
+		return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+	}
+	g, err := featureGeometry(f)
+	if err != nil {
+		return err
+	}
+
+	p.columns[0].add(int64(f.ID))
+	if g == nil {
+		p.columns[1].add(nil)
+	} else {
+		if p.wkb, err = geo.AppendWKB(p.wkb[:0], g); err != nil {
+			return fmt.Errorf("фича %d: %w", f.ID, err)
+		}
+		p.columns[1].add(p.wkb)
+		name := g.Type
+		if geo.HasZ(g) {
+			name += " Z"
+		}
+		p.types[name] = true
+		p.env.Extend(geo.GeometryEnvelope(g))
+	}
+
+	values := make([]any, len(p.columns))
+	for _, prop := range props {
+		if i, ok := p.index[prop.Key]; ok {
+			values[i] = TypedValue(p.columns[i].kind, prop.Value)
+		}
+	}
+	for i := 2; i < len(p.columns); i++ {
+		p.columns[i].add(values[i])
+	}
+
+	p.rows++
+	size := 0
+	for _, col := range p.columns {
+		size += len(col.values)
+	}
+	if p.rows >= pqRowGroupRows || size >= pqRowGroupBytes {
+		return p.flush()
+	}
+	return nil
+}
+
+// add дописывает значение столбца; nil — пустое значение.
+func (c *pqColumn) add(v any) {
+	if c.optional {
+		if v == nil {
+			c.defs = append(c.defs, 0)
+			return
+		}
+		c.defs = append(c.defs, 1)
+	}
+	switch v := v.(type) {
+	case int64:
+		c.values = binary.LittleEndian.AppendUint64(c.values, uint64(v))
+	case float64:
+		c.values = binary.LittleEndian.AppendUint64(c.values, math.Float64bits(v))
+	case bool:
+		if c.bits%8 == 0 {
+			c.values = append(c.values, 0)
+		}
+		if v {
+			c.values[len(c.values)-1] |= 1 << (c.bits % 8)
+		}
+		c.bits++
+	case string:
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(len(v)))
+		c.values = append(c.values, v...)
+	case []byte:
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(len(v)))
+		c.values = append(c.values, v...)
+	}
+}
+
+// flush пишет текущую группу строк: по странице данных на столбец.
+func (p *GeoParquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	group := pqRowGroup{numRows: int64(p.rows)}
+	for _, col := range p.columns {
+		var page []byte
+		if col.optional {
+			page = appendDefLevels(page, col.defs)
+		}
+		page = append(page, col.values...)
+
+		p.zbuf.Reset()
+		zw := gzip.NewWriter(&p.zbuf)
+		zw.Write(page)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		t := newThriftWriter()
+		t.i32(1, 0) // DATA_PAGE
+		t.i32(2, int32(len(page)))
+		t.i32(3, int32(p.zbuf.Len()))
+		t.structBegin(5)
+		t.i32(1, int32(p.rows))
+		t.i32(2, pqPlain)
+		t.i32(3, pqRLE)
+		t.i32(4, pqRLE)
+		t.structEnd()
+		t.buf = append(t.buf, 0)
+
+		chunk := pqChunk{
+			offset:       p.offset,
+			uncompressed: int64(len(t.buf) + len(page)),
+			compressed:   int64(len(t.buf) + p.zbuf.Len()),
+		}
+		if err := p.write(t.buf); err != nil {
+			return err
+		}
+		if err := p.write(p.zbuf.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		group.totalSize += chunk.uncompressed
+
+		col.defs, col.values, col.bits = col.defs[:0], col.values[:0], 0
+	}
+	p.groups = append(p.groups, group)
+	p.numRows += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// appendDefLevels кодирует уровни определения (ширина 1 бит) сериями
+// RLE гибридной кодировки с префиксом длины.
+func appendDefLevels(dst, defs []byte) []byte {
+	start := len(dst)
+	dst = append(dst, 0, 0, 0, 0)
+	for i := 0; i < len(defs); {
+		j := i
+		for j < len(defs) && defs[j] == defs[i] {
+			j++
+		}
+		dst = binary.AppendUvarint(dst, uint64(j-i)<<1)
+		dst = append(dst, defs[i])
+		i = j
+	}
+	binary.LittleEndian.PutUint32(dst[start:], uint32(len(dst)-start-4))
+	return dst
+}
+
+// Close сбрасывает последнюю группу строк и пишет метаданные файла.
+func (p *GeoParquetWriter) Close() error {
+	if p.columns == nil {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	if err := p.flush(); err != nil {
+		return err
+	}
+	geoMeta, err := p.geoMetadata()
+	if err != nil {
+		return err
+	}
+
+	t := newThriftWriter()
+	t.i32(1, 1)
+	t.listBegin(2, thriftStruct, len(p.columns)+1)
+	t.elemBegin()
+	t.string(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.structEnd()
+	for _, col := range p.columns {
+		t.elemBegin()
+		t.i32(1, col.ptype)
+		rep := int32(pqRequired)
+		if col.optional {
+			rep = pqOptional
+		}
+		t.i32(3, rep)
+		t.string(4, col.name)
+		if col.ptype == pqByteArray && col.name != "geometry" {
+			t.i32(6, 0) // converted_type UTF8
+			t.structBegin(10)
+			t.structBegin(1) // logicalType STRING
+			t.structEnd()
+			t.structEnd()
+		}
+		t.structEnd()
+	}
+	t.i64(3, p.numRows)
+	t.listBegin(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		t.elemBegin()
+		t.listBegin(1, thriftStruct, len(g.chunks))
+		for i, ch := range g.chunks {
+			col := p.columns[i]
+			t.elemBegin()
+			t.i64(2, ch.offset)
+			t.structBegin(3)
+			t.i32(1, col.ptype)
+			t.listBegin(2, thriftI32, 2)
+			t.listI32(pqPlain)
+			t.listI32(pqRLE)
+			t.listBegin(3, thriftBinary, 1)
+			t.listString(col.name)
+			t.i32(4, pqGzip)
+			t.i64(5, g.numRows)
+			t.i64(6, ch.uncompressed)
+			t.i64(7, ch.compressed)
+			t.i64(9, ch.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64(2, g.totalSize)
+		t.i64(3, g.numRows)
+		t.structEnd()
+	}
+	t.listBegin(5, thriftStruct, 1)
+	t.elemBegin()
+	t.string(1, "geo")
+	t.string(2, string(geoMeta))
+	t.structEnd()
+	t.string(6, "Datapolis")
+	t.buf = append(t.buf, 0)
+
+	if err := p.write(t.buf); err != nil {
+		return err
+	}
+	tail := binary.LittleEndian.AppendUint32(nil, uint32(len(t.buf)))
+	if err := p.write(append(tail, "PAR1"...)); err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+// geoMetadata — метаданные GeoParquet в ключе "geo". CRS не указывается:
+// по умолчанию это OGC:CRS84.
+func (p *GeoParquetWriter) geoMetadata() ([]byte, error) {
+	types := make([]string, 0, len(p.types))
+	for t := range p.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	column := map[string]any{
+		"encoding":       "WKB",
+		"geometry_types": types,
+	}
+	if !p.env.Empty {
+		column["bbox"] = []float64{p.env.MinX, p.env.MinY, p.env.MaxX, p.env.MaxY}
+	}
+	return json.Marshal(map[string]any{
+		"version":        "1.1.0",
+		"primary_column": "geometry",
+		"columns":        map[string]any{"geometry": column},
+	})
+}
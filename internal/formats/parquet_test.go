@@ -0,0 +1,387 @@
+package formats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// thriftReader разбирает компактный протокол Thrift без схемы: структура —
+// map[номер поля]значение, список — []any, i32 и i64 — int64, binary — string.
+type thriftReader struct {
+	b   []byte
+	err error
+}
+
+func (r *thriftReader) byte() byte {
+	if len(r.b) == 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case 1, 2: // bool в заголовке поля
+		return typ == 1
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		if n > len(r.b) {
+			r.err = io.ErrUnexpectedEOF
+			return ""
+		}
+		s := string(r.b[:n])
+		r.b = r.b[n:]
+		return s
+	case thriftList:
+		h := r.byte()
+		n, elem := int(h>>4), h&0x0F
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	r.err = fmt.Errorf("тип Thrift %d", typ)
+	return nil
+}
+
+func (r *thriftReader) structure() map[int16]any {
+	s := map[int16]any{}
+	var last int16
+	for r.err == nil {
+		h := r.byte()
+		if h == 0 {
+			break
+		}
+		typ := h & 0x0F
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		s[id] = r.value(typ)
+		last = id
+	}
+	return s
+}
+
+func TestThriftWriter(t *testing.T) {
+	w := newThriftWriter()
+	w.i32(1, -5)
+	w.i64(2, math.MaxInt64)
+	w.string(20, "далёкое поле") // разница номеров больше 15
+	w.structBegin(21)
+	w.i32(3, 7)
+	w.structEnd()
+	w.listBegin(22, thriftI32, 20) // длинный список: размер отдельным varint
+	for i := range 20 {
+		w.listI32(int32(i - 10))
+	}
+	w.listBegin(23, thriftStruct, 2)
+	for i := range 2 {
+		w.elemBegin()
+		w.string(1, fmt.Sprint("элемент ", i))
+		w.structEnd()
+	}
+	w.i32(4, 1) // номер меньше предыдущего
+	w.buf = append(w.buf, 0)
+
+	r := &thriftReader{b: w.buf}
+	got := r.structure()
+	if r.err != nil || len(r.b) != 0 {
+		t.Fatalf("разбор: %v, осталось %d байт", r.err, len(r.b))
+	}
+	var long []any
+	for i := range 20 {
+		long = append(long, int64(i-10))
+	}
+	want := map[int16]any{
+		1:  int64(-5),
+		2:  int64(math.MaxInt64),
+		20: "далёкое поле",
+		21: map[int16]any{3: int64(7)},
+		22: long,
+		23: []any{map[int16]any{1: "элемент 0"}, map[int16]any{1: "элемент 1"}},
+		4:  int64(1),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("получили %v\nждали %v", got, want)
+	}
+}
+
+// pqFile — метаданные и строки файла Parquet.
+type pqFile struct {
+	meta    map[int16]any
+	columns []string
+	rows    []map[string]any
+}
+
+// readParquet разбирает файл, записанный GeoParquetWriter: страницы PLAIN
+// со сжатием gzip и уровнями определения RLE.
+func readParquet(t *testing.T, data []byte) *pqFile {
+	t.Helper()
+	if string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatal("нет сигнатуры PAR1")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := &thriftReader{b: data[len(data)-8-size : len(data)-8]}
+	f := &pqFile{meta: r.structure()}
+	if r.err != nil || len(r.b) != 0 {
+		t.Fatalf("метаданные: %v, осталось %d байт", r.err, len(r.b))
+	}
+
+	schema := f.meta[2].([]any)
+	types := map[string]int64{}
+	optional := map[string]bool{}
+	for _, el := range schema[1:] {
+		el := el.(map[int16]any)
+		name := el[4].(string)
+		f.columns = append(f.columns, name)
+		types[name] = el[1].(int64)
+		optional[name] = el[3].(int64) == pqOptional
+	}
+	if n := schema[0].(map[int16]any)[5].(int64); int(n) != len(f.columns) {
+		t.Fatalf("у корня схемы %d потомков, столбцов %d", n, len(f.columns))
+	}
+
+	for _, g := range f.meta[4].([]any) {
+		g := g.(map[int16]any)
+		numRows := int(g[3].(int64))
+		rows := make([]map[string]any, numRows)
+		for i := range rows {
+			rows[i] = map[string]any{}
+		}
+		for i, ch := range g[1].([]any) {
+			md := ch.(map[int16]any)[3].(map[int16]any)
+			name := f.columns[i]
+			if path := md[3].([]any); path[0] != name {
+				t.Fatalf("путь столбца %v, ждали %s", path, name)
+			}
+			if md[4].(int64) != pqGzip || md[5].(int64) != int64(numRows) {
+				t.Fatalf("столбец %s: кодек %v, значений %v", name, md[4], md[5])
+			}
+			off := md[9].(int64)
+			if chOff := ch.(map[int16]any)[2].(int64); chOff != off {
+				t.Fatalf("столбец %s: file_offset %d, data_page_offset %d", name, chOff, off)
+			}
+
+			pr := &thriftReader{b: data[off:]}
+			page := pr.structure()
+			header := len(data[off:]) - len(pr.b)
+			compressed := int(page[3].(int64))
+			if int64(header+compressed) != md[7].(int64) || int64(header)+page[2].(int64) != md[6].(int64) {
+				t.Fatalf("столбец %s: размеры в метаданных %v/%v, страница %d+%v/%d", name, md[6], md[7], header, page[2], compressed)
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(pr.b[:compressed]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(body) != int(page[2].(int64)) {
+				t.Fatalf("столбец %s: страница %d байт, в заголовке %v", name, len(body), page[2])
+			}
+
+			defs := make([]bool, numRows)
+			for j := range defs {
+				defs[j] = true
+			}
+			if optional[name] {
+				n := int(binary.LittleEndian.Uint32(body))
+				dr := &thriftReader{b: body[4 : 4+n]}
+				j := 0
+				for len(dr.b) > 0 {
+					h := dr.varint()
+					if h&1 != 0 {
+						t.Fatalf("столбец %s: ждали только серии RLE", name)
+					}
+					v := dr.byte()
+					for k := 0; k < int(h>>1); k++ {
+						defs[j] = v == 1
+						j++
+					}
+				}
+				if j != numRows {
+					t.Fatalf("столбец %s: уровней определения %d, строк %d", name, j, numRows)
+				}
+				body = body[4+n:]
+			}
+
+			bit := 0
+			for j := range rows {
+				if !defs[j] {
+					rows[j][name] = nil
+					continue
+				}
+				switch types[name] {
+				case pqInt64:
+					rows[j][name] = float64(int64(binary.LittleEndian.Uint64(body)))
+					body = body[8:]
+				case pqDouble:
+					rows[j][name] = math.Float64frombits(binary.LittleEndian.Uint64(body))
+					body = body[8:]
+				case pqBoolean:
+					rows[j][name] = body[bit/8]&(1<<(bit%8)) != 0
+					bit++
+				case pqByteArray:
+					n := binary.LittleEndian.Uint32(body)
+					v := body[4 : 4+n]
+					body = body[4+n:]
+					if name == "geometry" {
+						g, err := geo.ParseWKB(v)
+						if err != nil {
+							t.Fatal(err)
+						}
+						rows[j][name] = g
+					} else {
+						rows[j][name] = string(v)
+					}
+				}
+			}
+			if types[name] == pqBoolean {
+				body = body[(bit+7)/8:]
+			}
+			if len(body) != 0 {
+				t.Fatalf("столбец %s: лишние %d байт", name, len(body))
+			}
+		}
+		f.rows = append(f.rows, rows...)
+	}
+	if n := f.meta[3].(int64); int(n) != len(f.rows) {
+		t.Fatalf("num_rows %d, строк %d", n, len(f.rows))
+	}
+	return f
+}
+
+func TestGeoParquetRoundTrip(t *testing.T) {
+	feats := []*models.GeoJSONFeature{
+		feature(1, `{"name":"a","n":1,"x":1.5,"ok":true}`, `{"type":"Point","coordinates":[30.5,59.9]}`),
+		feature(2, `{"name":"б","n":-2,"ok":false}`, `{"type":"LineString","coordinates":[[0,0,1],[1,1,2]]}`),
+		feature(3, `{"x":2}`, `null`),
+		feature(4, `{"ok":true,"id":"свойство id"}`, `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`),
+	}
+	var buf bytes.Buffer
+	w := NewGeoParquetWriter(&buf)
+	for _, f := range feats {
+		if err := w.Scan(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range feats {
+		if err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readParquet(t, buf.Bytes())
+	if want := []string{"id", "geometry", "name", "n", "x", "ok", "id_2"}; !reflect.DeepEqual(f.columns, want) {
+		t.Errorf("столбцы %v, ждали %v", f.columns, want)
+	}
+	if len(f.rows) != len(feats) {
+		t.Fatalf("строк %d, ждали %d", len(f.rows), len(feats))
+	}
+	for i, w := range feats {
+		row := f.rows[i]
+		if row["id"] != float64(w.ID) {
+			t.Errorf("строка %d: id %v", i, row["id"])
+		}
+		geomJSON := []byte("null")
+		if g, _ := row["geometry"].(*geo.Geometry); g != nil {
+			geomJSON, _ = json.Marshal(g)
+		}
+		assertJSONEqual(t, fmt.Sprintf("строка %d: геометрия", i), geomJSON, w.Geometry)
+
+		props := map[string]any{}
+		for k, v := range row {
+			switch k {
+			case "id", "geometry":
+			case "id_2":
+				props["id"] = v
+			default:
+				props[k] = v
+			}
+		}
+		raw, _ := json.Marshal(props)
+		assertPropsSubset(t, raw, w.Properties)
+	}
+
+	var kv map[int16]any
+	for _, e := range f.meta[5].([]any) {
+		if e := e.(map[int16]any); e[1] == "geo" {
+			kv = e
+		}
+	}
+	if kv == nil {
+		t.Fatal("нет метаданных geo")
+	}
+	var meta struct {
+		Version       string `json:"version"`
+		PrimaryColumn string `json:"primary_column"`
+		Columns       map[string]struct {
+			Encoding      string    `json:"encoding"`
+			GeometryTypes []string  `json:"geometry_types"`
+			BBox          []float64 `json:"bbox"`
+		} `json:"columns"`
+	}
+	if err := json.Unmarshal([]byte(kv[2].(string)), &meta); err != nil {
+		t.Fatal(err)
+	}
+	col := meta.Columns["geometry"]
+	if meta.Version != "1.1.0" || meta.PrimaryColumn != "geometry" || col.Encoding != "WKB" {
+		t.Errorf("метаданные geo %+v", meta)
+	}
+	if want := []string{"LineString Z", "Point", "Polygon"}; !reflect.DeepEqual(col.GeometryTypes, want) {
+		t.Errorf("geometry_types %v, ждали %v", col.GeometryTypes, want)
+	}
+	if want := []float64{0, 0, 30.5, 59.9}; !reflect.DeepEqual(col.BBox, want) {
+		t.Errorf("bbox %v, ждали %v", col.BBox, want)
+	}
+}
+
+func TestAppendDefLevels(t *testing.T) {
+	got := appendDefLevels([]byte{0xEE}, []byte{1, 1, 1, 0, 0, 1})
+	// префикс длины, затем серии: 3×1, 2×0, 1×1
+	want := []byte{0xEE, 6, 0, 0, 0, 3 << 1, 1, 2 << 1, 0, 1 << 1, 1}
+	if !bytes.Equal(got, want) {
+		t.Errorf("получили % x, ждали % x", got, want)
+	}
+}
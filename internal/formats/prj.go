@@ -0,0 +1,108 @@
+package formats
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// authorityRe находит AUTHORITY["EPSG","32642"]; к системе координат
+	// целиком относится только вхождение на первом уровне вложенности.
+	authorityRe = regexp.MustCompile(`(?i)AUTHORITY\[\s*"EPSG"\s*,\s*"?(\d+)"?\s*\]`)
+	csNameRe    = regexp.MustCompile(`(?i)^\s*(PROJCS|GEOGCS)\[\s*"([^"]*)"`)
+	utmZoneRe   = regexp.MustCompile(`(?i)^WGS_1984_UTM_Zone_(\d{1,2})([NS])$`)
+	// Pulkovo_1942_GK_Zone_12 или Pulkovo_1942_GK_Zone_12N
+	pulkovoGKRe = regexp.MustCompile(`(?i)^Pulkovo_1942_GK_Zone_(\d{1,2})(N?)$`)
+)
+
+// esriNames — системы координат, которые ESRI записывает в .prj без
+// AUTHORITY, по имени PROJCS или GEOGCS.
+var esriNames = map[string]int{
+	"GCS_WGS_1984":                           4326,
+	"WGS_1984_Web_Mercator_Auxiliary_Sphere": 3857,
+	"WGS_84_Pseudo_Mercator":                 3857,
+	"WGS_1984_Web_Mercator":                  3857,
+	"GCS_Pulkovo_1942":                       4284,
+	"GCS_ETRS_1989":                          4258,
+	"GCS_North_American_1983":                4269,
+	"GCS_GSK_2011":                           7683,
+	"GCS_Pulkovo_1995":                       4200,
+	"ETRS_1989_LAEA":                         3035,
+	"World_Mercator":                         3395,
+	"WGS_1984_World_Mercator":                3395,
+	"NAD_1983_Contiguous_USA_Albers":         5070,
+	"USA_Contiguous_Albers_Equal_Area_Conic": 5070,
+}
+
+// PRJToSRID определяет код EPSG по содержимому файла .prj (ESRI WKT).
+func PRJToSRID(prj string) (int, error) {
+	m := csNameRe.FindStringSubmatch(prj)
+	if m == nil {
+		return 0, fmt.Errorf("%w: .prj не содержит PROJCS или GEOGCS", ErrInvalidFile)
+	}
+	if code, ok := rootAuthority(prj); ok {
+		return strconv.Atoi(code)
+	}
+
+	name := m[2]
+	if srid, ok := esriNames[name]; ok {
+		return srid, nil
+	}
+	if z := utmZoneRe.FindStringSubmatch(name); z != nil {
+		zone, _ := strconv.Atoi(z[1])
+		if zone >= 1 && zone <= 60 {
+			if strings.EqualFold(z[2], "N") {
+				return 32600 + zone, nil
+			}
+			return 32700 + zone, nil
+		}
+	}
+	if z := pulkovoGKRe.FindStringSubmatch(name); z != nil {
+		zone, _ := strconv.Atoi(z[1])
+		// EPSG:28402–28432 — зоны с номером в ложном восточном смещении,
+		// 28462–28492 — варианты "N" без номера зоны
+		if zone >= 2 && zone <= 32 {
+			if z[2] != "" {
+				return 28460 + zone, nil
+			}
+			return 28400 + zone, nil
+		}
+	}
+	return 0, fmt.Errorf("не удалось определить систему координат по .prj (%s): укажите source_srid", name)
+}
+
+// rootAuthority возвращает код EPSG из AUTHORITY корневого PROJCS или
+// GEOGCS. AUTHORITY вложенных узлов (GEOGCS внутри PROJCS, DATUM, UNIT)
+// описывают части системы координат и не подходят: без корневого
+// AUTHORITY система определяется по имени.
+func rootAuthority(prj string) (string, bool) {
+	depths := bracketDepths(prj)
+	for _, loc := range authorityRe.FindAllStringSubmatchIndex(prj, -1) {
+		if depths[loc[0]] == 1 {
+			return prj[loc[2]:loc[3]], true
+		}
+	}
+	return "", false
+}
+
+// bracketDepths считает для каждого байта WKT глубину вложенности
+// в скобки [ ] или ( ); скобки внутри строк в кавычках не учитываются.
+func bracketDepths(wkt string) []int {
+	depths := make([]int, len(wkt))
+	depth, quoted := 0, false
+	for i := 0; i < len(wkt); i++ {
+		depths[i] = depth
+		switch c := wkt[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		}
+	}
+	return depths
+}
@@ -0,0 +1,73 @@
+package formats
+
+import "testing"
+
+func TestPRJToSRID(t *testing.T) {
+	tests := []struct {
+		name    string
+		prj     string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "AUTHORITY корневого PROJCS",
+			prj: `PROJCS["WGS 84 / UTM zone 42N",GEOGCS["WGS 84",DATUM["WGS_1984",` +
+				`SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],` +
+				`PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433],AUTHORITY["EPSG","4326"]],` +
+				`PROJECTION["Transverse_Mercator"],UNIT["metre",1,AUTHORITY["EPSG","9001"]],` +
+				`AUTHORITY["EPSG","32642"]]`,
+			want: 32642,
+		},
+		{
+			name: "AUTHORITY корня не последний",
+			prj:  `GEOGCS["WGS 84",AUTHORITY["EPSG","4326"],DATUM["WGS_1984",AUTHORITY["EPSG","6326"]]]`,
+			want: 4326,
+		},
+		{
+			name: "только AUTHORITY вложенного GEOGCS, имя известно",
+			prj: `PROJCS["WGS_1984_UTM_Zone_42N",GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",` +
+				`SPHEROID["WGS_1984",6378137,298.257223563]],UNIT["Degree",0.0174532925199433],` +
+				`AUTHORITY["EPSG","4326"]],PROJECTION["Transverse_Mercator"],UNIT["Meter",1]]`,
+			want: 32642,
+		},
+		{
+			name: "только AUTHORITY вложенных узлов, имя неизвестно",
+			prj: `PROJCS["Local_TM",GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",` +
+				`SPHEROID["WGS_1984",6378137,298.257223563]],AUTHORITY["EPSG","4326"]],` +
+				`PROJECTION["Transverse_Mercator"],UNIT["Meter",1,AUTHORITY["EPSG","9001"]]]`,
+			wantErr: true,
+		},
+		{
+			name: "скобки в имени не сбивают глубину",
+			prj:  `GEOGCS["GCS [old]",DATUM["D",SPHEROID["S",6378137,298.257]],AUTHORITY["EPSG","4284"]]`,
+			want: 4284,
+		},
+		{
+			name: "Пулково, зона ГК по имени",
+			prj:  `PROJCS["Pulkovo_1942_GK_Zone_12N",GEOGCS["GCS_Pulkovo_1942"],UNIT["Meter",1]]`,
+			want: 28472,
+		},
+		{
+			name:    "не WKT",
+			prj:     `garbage`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PRJToSRID(tt.prj)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ждали ошибку, получили %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("PRJToSRID = %d, ждали %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Property — свойство фичи для табличных форматов.
+type Property struct {
+	Key   string
+	Value any // nil, bool, json.Number или string; массивы — строка JSON
+}
+
+// FlattenProperties разворачивает свойства фичи в плоский список
+// в порядке следования в JSON: вложенные объекты дают ключи через точку
+// ({"addr":{"city":"X"}} → addr.city), массивы записываются строкой JSON.
+func FlattenProperties(raw []byte) ([]Property, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, errors.New("свойства фичи не являются объектом")
+	}
+	var props []Property
+	if err := flattenObject(dec, raw, "", &props); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// flattenObject читает члены объекта после открывающей скобки.
+func flattenObject(dec *json.Decoder, raw []byte, prefix string, props *[]Property) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := prefix + tok.(string)
+
+		start := dec.InputOffset()
+		tok, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			before := len(*props)
+			if err := flattenObject(dec, raw, key+".", props); err != nil {
+				return err
+			}
+			if len(*props) == before {
+				// пустой объект остаётся пустым значением своего ключа
+				*props = append(*props, Property{Key: key})
+			}
+		case json.Delim('['):
+			if err := skipValue(dec); err != nil {
+				return err
+			}
+			text := strings.TrimLeft(string(raw[start:dec.InputOffset()]), " \t\r\n:")
+			*props = append(*props, Property{Key: key, Value: text})
+		default:
+			*props = append(*props, Property{Key: key, Value: tok})
+		}
+	}
+	_, err := dec.Token() // '}'
+	return err
+}
+
+// skipValue пропускает остаток массива или объекта после открывающей скобки.
+func skipValue(dec *json.Decoder) error {
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// PropertyText — значение свойства в виде текста; пустое — "".
+func PropertyText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return v.String()
+	case string:
+		return v
+	}
+	return ""
+}
+
+// Типы полей схемы свойств.
+const (
+	KindNone  = iota // встречались только пустые значения
+	KindInt          // целые числа
+	KindFloat        // числа с дробной частью или вне int64
+	KindBool
+	KindString
+)
+
+// SchemaField — столбец табличного формата, собранный по всем фичам.
+type SchemaField struct {
+	Key      string
+	Kind     int
+	Width    int // наибольшая длина текстового представления
+	Decimals int // наибольшее число знаков после точки
+}
+
+// PropertySchema собирает столбцы по свойствам всех фич коллекции:
+// ключи в порядке первого появления и общий тип значений каждого ключа.
+type PropertySchema struct {
+	Fields []*SchemaField
+	index  map[string]*SchemaField
+}
+
+func NewPropertySchema() *PropertySchema {
+	return &PropertySchema{index: map[string]*SchemaField{}}
+}
+
+// Add учитывает свойства очередной фичи.
+func (s *PropertySchema) Add(props []Property) {
+	for _, p := range props {
+		f, ok := s.index[p.Key]
+		if !ok {
+			f = &SchemaField{Key: p.Key}
+			s.index[p.Key] = f
+			s.Fields = append(s.Fields, f)
+		}
+		text := PropertyText(p.Value)
+		if len(text) > f.Width {
+			f.Width = len(text)
+		}
+		kind := valueKind(p.Value)
+		if kind == KindFloat {
+			if i := strings.IndexByte(text, '.'); i >= 0 && !strings.ContainsAny(text, "eE") && len(text)-i-1 > f.Decimals {
+				f.Decimals = len(text) - i - 1
+			}
+		}
+		f.Kind = mergeKinds(f.Kind, kind)
+	}
+}
+
+// Keys возвращает ключи столбцов.
+func (s *PropertySchema) Keys() []string {
+	keys := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		keys[i] = f.Key
+	}
+	return keys
+}
+
+func valueKind(v any) int {
+	switch v := v.(type) {
+	case nil:
+		return KindNone
+	case bool:
+		return KindBool
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return KindInt
+		}
+		return KindFloat
+	}
+	return KindString
+}
+
+func mergeKinds(a, b int) int {
+	switch {
+	case a == b || b == KindNone:
+		return a
+	case a == KindNone:
+		return b
+	case (a == KindInt && b == KindFloat) || (a == KindFloat && b == KindInt):
+		return KindFloat
+	}
+	return KindString
+}
+
+// TypedValue приводит значение свойства к типу столбца kind: int64,
+// float64, bool или string; nil — пустое значение.
+func TypedValue(kind int, v any) any {
+	if v == nil {
+		return nil
+	}
+	n, isNumber := v.(json.Number)
+	switch kind {
+	case KindInt:
+		if i, err := n.Int64(); isNumber && err == nil {
+			return i
+		}
+		return nil
+	case KindFloat:
+		if f, err := n.Float64(); isNumber && err == nil {
+			return f
+		}
+		return nil
+	case KindBool:
+		b, _ := v.(bool)
+		return b
+	}
+	return PropertyText(v)
+}
+
+// columnNames возвращает имена столбцов для ключей keys, не совпадающие
+// (без учёта регистра) с reserved и между собой: повтор получает суффикс _2, _3…
+func columnNames(reserved []string, keys []string) []string {
+	used := make(map[string]bool, len(reserved)+len(keys))
+	for _, r := range reserved {
+		used[strings.ToLower(r)] = true
+	}
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		name := k
+		for n := 2; used[strings.ToLower(name)]; n++ {
+			name = k + "_" + strconv.Itoa(n)
+		}
+		used[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
@@ -0,0 +1,435 @@
+package formats
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+
+	"golang.org/x/text/encoding"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// ShapefileReader читает слой ESRI Shapefile из zip-архива: геометрию —
+// из .shp, атрибуты — из .dbf, систему координат — из .prj. Оба файла
+// читаются последовательно, без распаковки архива целиком.
+type ShapefileReader struct {
+	zr     *zip.ReadCloser
+	files  []io.Closer
+	shp    *bufio.Reader
+	dbf    *dbfReader
+	prj    string
+	hasPRJ bool
+	buf    []byte
+}
+
+// OpenShapefileZip открывает архив path с одним слоем Shapefile.
+// encodingName задаёт кодировку .dbf; пустая строка — из .cpg или
+// заголовка .dbf.
+func OpenShapefileZip(path, encodingName string) (*ShapefileReader, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: zip: %v", ErrInvalidFile, err)
+	}
+	s := &ShapefileReader{zr: zr}
+	if err := s.open(encodingName); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ShapefileReader) open(encodingName string) error {
+	// файлы слоя: базовое имя без расширения → расширение → файл архива
+	layers := map[string]map[string]*zip.File{}
+	var shpBases []string
+	for _, f := range s.zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		ext := strings.ToLower(path.Ext(f.Name))
+		base := strings.ToLower(strings.TrimSuffix(f.Name, path.Ext(f.Name)))
+		if layers[base] == nil {
+			layers[base] = map[string]*zip.File{}
+		}
+		layers[base][ext] = f
+		if ext == ".shp" {
+			shpBases = append(shpBases, f.Name)
+		}
+	}
+	switch len(shpBases) {
+	case 0:
+		return fmt.Errorf("%w: в архиве нет файла .shp", ErrInvalidFile)
+	case 1:
+	default:
+		return fmt.Errorf("%w: в архиве несколько слоёв: %s", ErrInvalidFile, strings.Join(shpBases, ", "))
+	}
+	layer := layers[strings.ToLower(strings.TrimSuffix(shpBases[0], path.Ext(shpBases[0])))]
+	if layer[".dbf"] == nil {
+		return fmt.Errorf("%w: в архиве нет файла .dbf для %s", ErrInvalidFile, shpBases[0])
+	}
+
+	var enc encoding.Encoding
+	switch {
+	case encodingName != "":
+		var err error
+		if enc, err = ParseCodePage(encodingName); err != nil {
+			return err
+		}
+	case layer[".cpg"] != nil:
+		cpg, err := readZipText(layer[".cpg"])
+		if err != nil {
+			return err
+		}
+		// нераспознанный .cpg не мешает: остаётся кодировка из заголовка .dbf
+		enc, _ = ParseCodePage(cpg)
+	}
+	if f := layer[".prj"]; f != nil {
+		prj, err := readZipText(f)
+		if err != nil {
+			return err
+		}
+		s.prj, s.hasPRJ = prj, true
+	}
+
+	shp, err := s.openZip(layer[".shp"])
+	if err != nil {
+		return err
+	}
+	s.shp = bufio.NewReader(shp)
+	header := make([]byte, 100)
+	if _, err := io.ReadFull(s.shp, header); err != nil {
+		return fmt.Errorf("%w: shp: заголовок: %v", ErrInvalidFile, err)
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != 9994 {
+		return fmt.Errorf("%w: shp: неверная сигнатура файла", ErrInvalidFile)
+	}
+
+	dbf, err := s.openZip(layer[".dbf"])
+	if err != nil {
+		return err
+	}
+	s.dbf, err = newDBFReader(dbf, enc)
+	return err
+}
+
+func (s *ShapefileReader) openZip(f *zip.File) (io.Reader, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidFile, f.Name, err)
+	}
+	s.files = append(s.files, rc)
+	return rc, nil
+}
+
+func readZipText(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalidFile, f.Name, err)
+	}
+	defer rc.Close()
+	// .prj и .cpg — короткие текстовые файлы
+	b, err := io.ReadAll(io.LimitReader(rc, 64<<10))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalidFile, f.Name, err)
+	}
+	return string(b), nil
+}
+
+// Close закрывает файлы слоя и архив.
+func (s *ShapefileReader) Close() error {
+	for _, f := range s.files {
+		f.Close()
+	}
+	return s.zr.Close()
+}
+
+// SRID возвращает систему координат из .prj; без .prj — 0.
+func (s *ShapefileReader) SRID() (int, error) {
+	if !s.hasPRJ {
+		return 0, nil
+	}
+	return PRJToSRID(s.prj)
+}
+
+// Next возвращает следующую запись слоя; записи, удалённые в .dbf,
+// пропускаются. Пустая фигура (null shape или фигура без точек)
+// возвращается как *RecordError: PostGIS не построит из неё геометрию.
+func (s *ShapefileReader) Next() (*models.GeoJSONFeature, error) {
+	for {
+		head := make([]byte, 8)
+		if _, err := io.ReadFull(s.shp, head); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("%w: shp: заголовок записи: %v", ErrInvalidFile, err)
+		}
+		recNo := binary.BigEndian.Uint32(head[0:4])
+		size := int(binary.BigEndian.Uint32(head[4:8])) * 2 // длина в 16-битных словах
+		if cap(s.buf) < size {
+			s.buf = make([]byte, size)
+		}
+		content := s.buf[:size]
+		if _, err := io.ReadFull(s.shp, content); err != nil {
+			return nil, fmt.Errorf("%w: shp: запись %d: %v", ErrInvalidFile, recNo, err)
+		}
+
+		props, deleted, err := s.dbf.next()
+		if errors.Is(err, io.EOF) {
+			props = map[string]any{} // .dbf короче .shp — у записи нет атрибутов
+		} else if err != nil {
+			return nil, err
+		}
+		if deleted {
+			continue
+		}
+
+		g, err := shapeGeometry(content)
+		if errors.Is(err, errEmptyShape) {
+			return nil, &RecordError{Reason: fmt.Sprintf("запись %d: %v", recNo, err)}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: shp: запись %d: %v", ErrInvalidFile, recNo, err)
+		}
+		propsJSON, err := json.Marshal(props)
+		if err != nil {
+			return nil, err
+		}
+		geomJSON, err := json.Marshal(g)
+		if err != nil {
+			return nil, err
+		}
+		return &models.GeoJSONFeature{
+			Type:       "Feature",
+			Properties: models.JSONData(propsJSON),
+			Geometry:   models.JSONData(geomJSON),
+		}, nil
+	}
+}
+
+// Типы фигур Shapefile (ESRI Shapefile Technical Description, 1998).
+const (
+	shapeNull        = 0
+	shapePoint       = 1
+	shapePolyLine    = 3
+	shapePolygon     = 5
+	shapeMultiPoint  = 8
+	shapePointZ      = 11
+	shapePolyLineZ   = 13
+	shapePolygonZ    = 15
+	shapeMultiPointZ = 18
+	shapePointM      = 21
+	shapePolyLineM   = 23
+	shapePolygonM    = 25
+	shapeMultiPointM = 28
+)
+
+// shpCursor читает числа из содержимого записи с проверкой границ.
+type shpCursor struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (c *shpCursor) take(n int) []byte {
+	if c.err != nil {
+		return nil
+	}
+	if n < 0 || c.off+n > len(c.b) {
+		c.err = errors.New("запись обрывается")
+		return nil
+	}
+	b := c.b[c.off : c.off+n]
+	c.off += n
+	return b
+}
+
+func (c *shpCursor) int() int {
+	if b := c.take(4); b != nil {
+		return int(int32(binary.LittleEndian.Uint32(b)))
+	}
+	return 0
+}
+
+func (c *shpCursor) float() float64 {
+	if b := c.take(8); b != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+// points читает n пар x, y.
+func (c *shpCursor) points(n int) []geo.Coord {
+	if n < 0 || n > len(c.b)/16 {
+		c.err = errors.New("неверное число точек")
+		return nil
+	}
+	pts := make([]geo.Coord, n)
+	for i := range pts {
+		pts[i] = geo.Coord{c.float(), c.float()}
+	}
+	return pts
+}
+
+// zValues дописывает к точкам координату Z: диапазон, затем n значений.
+func (c *shpCursor) zValues(pts []geo.Coord) {
+	c.take(16)
+	for i := range pts {
+		pts[i] = append(pts[i], c.float())
+	}
+}
+
+// errEmptyShape — у записи нет геометрии: null shape или фигура без точек.
+var errEmptyShape = errors.New("пустая фигура")
+
+// shapeGeometry переводит содержимое записи .shp в геометрию GeoJSON;
+// для пустой фигуры возвращает errEmptyShape. Измерение M отбрасывается.
+func shapeGeometry(b []byte) (*geo.Geometry, error) {
+	c := &shpCursor{b: b}
+	typ := c.int()
+	hasZ := typ == shapePointZ || typ == shapePolyLineZ || typ == shapePolygonZ || typ == shapeMultiPointZ
+
+	var g *geo.Geometry
+	switch typ {
+	case shapeNull:
+		if c.err != nil {
+			return nil, c.err
+		}
+		return nil, errEmptyShape
+
+	case shapePoint, shapePointZ, shapePointM:
+		p := geo.Coord{c.float(), c.float()}
+		if hasZ {
+			p = append(p, c.float())
+		}
+		g = &geo.Geometry{Type: "Point", Coordinates: p}
+
+	case shapeMultiPoint, shapeMultiPointZ, shapeMultiPointM:
+		c.take(32) // bbox
+		pts := c.points(c.int())
+		if hasZ {
+			c.zValues(pts)
+		}
+		if c.err == nil && len(pts) == 0 {
+			return nil, errEmptyShape
+		}
+		g = &geo.Geometry{Type: "MultiPoint", Coordinates: pts}
+
+	case shapePolyLine, shapePolyLineZ, shapePolyLineM, shapePolygon, shapePolygonZ, shapePolygonM:
+		c.take(32) // bbox
+		numParts, numPoints := c.int(), c.int()
+		if numParts < 0 || numParts > len(b)/4 {
+			return nil, errors.New("неверное число частей")
+		}
+		starts := make([]int, numParts)
+		for i := range starts {
+			starts[i] = c.int()
+		}
+		pts := c.points(numPoints)
+		if hasZ {
+			c.zValues(pts)
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+		if numParts == 0 || numPoints == 0 {
+			return nil, errEmptyShape
+		}
+
+		parts := make([][]geo.Coord, numParts)
+		for i, start := range starts {
+			end := numPoints
+			if i+1 < numParts {
+				end = starts[i+1]
+			}
+			if start < 0 || start > end || end > numPoints {
+				return nil, errors.New("неверные границы частей")
+			}
+			parts[i] = pts[start:end:end]
+		}
+
+		polygon := typ == shapePolygon || typ == shapePolygonZ || typ == shapePolygonM
+		switch {
+		case polygon:
+			g = polygonGeometry(parts)
+		case len(parts) == 1:
+			g = &geo.Geometry{Type: "LineString", Coordinates: parts[0]}
+		default:
+			g = &geo.Geometry{Type: "MultiLineString", Coordinates: parts}
+		}
+
+	default:
+		return nil, fmt.Errorf("тип фигуры %d не поддерживается", typ)
+	}
+	return g, c.err
+}
+
+// polygonGeometry собирает полигоны из колец Shapefile: внешние кольца
+// идут по часовой стрелке, дыры — против; дыра относится к внешнему
+// кольцу, которое её содержит.
+func polygonGeometry(rings [][]geo.Coord) *geo.Geometry {
+	var polys [][][]geo.Coord
+	var holes [][]geo.Coord
+	for _, r := range rings {
+		if signedArea(r) <= 0 {
+			polys = append(polys, [][]geo.Coord{r})
+		} else {
+			holes = append(holes, r)
+		}
+	}
+	if len(polys) == 0 {
+		// ориентация не соблюдена — считаем все кольца внешними
+		for _, h := range holes {
+			polys = append(polys, [][]geo.Coord{h})
+		}
+		holes = nil
+	}
+	for _, h := range holes {
+		owner := len(polys) - 1
+		if len(h) > 0 {
+			for i, p := range polys {
+				if ringContains(p[0], h[0]) {
+					owner = i
+					break
+				}
+			}
+		}
+		polys[owner] = append(polys[owner], h)
+	}
+
+	if len(polys) == 1 {
+		return &geo.Geometry{Type: "Polygon", Coordinates: polys[0]}
+	}
+	return &geo.Geometry{Type: "MultiPolygon", Coordinates: polys}
+}
+
+// signedArea — удвоенная площадь кольца со знаком: положительная
+// для обхода против часовой стрелки.
+func signedArea(r []geo.Coord) float64 {
+	var a float64
+	for i := 0; i+1 < len(r); i++ {
+		a += r[i][0]*r[i+1][1] - r[i+1][0]*r[i][1]
+	}
+	return a
+}
+
+// ringContains проверяет попадание точки в кольцо лучом вдоль оси X.
+func ringContains(r []geo.Coord, p geo.Coord) bool {
+	in := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		a, b := r[i], r[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) &&
+			p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
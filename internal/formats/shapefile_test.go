@@ -0,0 +1,118 @@
+package formats
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// shpRecord кодирует содержимое записи .shp: тип фигуры и далее
+// числа int32 и float64 в порядке аргументов.
+func shpRecord(shapeType int32, values ...any) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(shapeType))
+	for _, v := range values {
+		switch v := v.(type) {
+		case int32:
+			b = binary.LittleEndian.AppendUint32(b, uint32(v))
+		case float64:
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		}
+	}
+	return b
+}
+
+// writeShapefileZip собирает архив слоя из записей .shp и пустой таблицы
+// атрибутов с тем же числом записей.
+func writeShapefileZip(t *testing.T, shapeType int32, records [][]byte) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	shp := make([]byte, 100)
+	binary.BigEndian.PutUint32(shp[0:], 9994)
+	binary.LittleEndian.PutUint32(shp[28:], 1000)
+	binary.LittleEndian.PutUint32(shp[32:], uint32(shapeType))
+	for i, rec := range records {
+		var head [8]byte
+		binary.BigEndian.PutUint32(head[0:], uint32(i+1))
+		binary.BigEndian.PutUint32(head[4:], uint32(len(rec)/2))
+		shp = append(shp, head[:]...)
+		shp = append(shp, rec...)
+	}
+	binary.BigEndian.PutUint32(shp[24:], uint32(len(shp)/2))
+
+	dbfPath := filepath.Join(dir, "layer.dbf")
+	dbf, err := newDBFWriter(dbfPath, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range records {
+		if err := dbf.write(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dbf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dbfData, err := os.ReadFile(dbfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zipPath := filepath.Join(dir, "layer.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, data := range map[string][]byte{"layer.shp": shp, "layer.dbf": dbfData} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return zipPath
+}
+
+func TestShapefileReaderEmptyShapes(t *testing.T) {
+	bbox := []any{0.0, 0.0, 1.0, 1.0}
+	square := shpRecord(shapePolygon, append(bbox, int32(1), int32(5), int32(0),
+		0.0, 0.0, 0.0, 1.0, 1.0, 1.0, 1.0, 0.0, 0.0, 0.0)...)
+	records := [][]byte{
+		shpRecord(shapeNull),
+		shpRecord(shapePolygon, append(bbox, int32(0), int32(0))...),
+		square,
+	}
+	r, err := OpenShapefileZip(writeShapefileZip(t, shapePolygon, records), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i, what := range []string{"null shape", "полигон без частей"} {
+		f, err := r.Next()
+		var recErr *RecordError
+		if !errors.As(err, &recErr) {
+			t.Fatalf("запись %d (%s): ждали *RecordError, получили %v, %v", i+1, what, f, err)
+		}
+	}
+	f, err := r.Next()
+	if err != nil {
+		t.Fatalf("запись 3: %v", err)
+	}
+	if got := string(f.Geometry); got != `{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[1,0],[0,0]]]}` {
+		t.Errorf("запись 3: геометрия %s", got)
+	}
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("после последней записи ждали io.EOF, получили %v", err)
+	}
+}
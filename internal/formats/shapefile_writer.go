@@ -0,0 +1,479 @@
+package formats
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// shpKinds — слои, на которые делится коллекция: Shapefile хранит
+// фигуры одного типа. Порядок задаёт порядок файлов в архиве.
+var shpKinds = []struct {
+	shapeType int
+	suffix    string
+}{
+	{shapePoint, "points"},
+	{shapeMultiPoint, "multipoints"},
+	{shapePolyLine, "lines"},
+	{shapePolygon, "polygons"},
+}
+
+// ShapefileWriter выгружает коллекцию в zip-архив Shapefile. Фичи
+// раскладываются по слоям по типу геометрии, элементы GeometryCollection —
+// каждый в свой слой с атрибутами фичи. Запись в два прохода: Scan
+// собирает схему атрибутов и типы геометрий, Write пишет фичи во временный
+// каталог, Close упаковывает слои в архив.
+type ShapefileWriter struct {
+	dir    string
+	name   string
+	prj    string
+	schema *PropertySchema
+	counts map[int]int  // фигур в слое по базовому типу
+	hasZ   map[int]bool // в слое есть координаты z
+	layers map[int]*shpLayer
+	keys   []string
+	fields []dbfField
+}
+
+// NewShapefileWriter создаёт запись во временный каталог dir; name — имя
+// слоёв в архиве, prj — WKT системы координат (пусто — без .prj).
+func NewShapefileWriter(dir, name, prj string) *ShapefileWriter {
+	return &ShapefileWriter{
+		dir:    dir,
+		name:   shpBaseName(name),
+		prj:    prj,
+		schema: NewPropertySchema(),
+		counts: map[int]int{},
+		hasZ:   map[int]bool{},
+		layers: map[int]*shpLayer{},
+	}
+}
+
+// Scan учитывает фичу при первом проходе.
+func (s *ShapefileWriter) Scan(f *models.GeoJSONFeature) error {
+	props, err := FlattenProperties(f.Properties)
+	if err != nil {
+		return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+	}
+	s.schema.Add(props)
+	g, err := featureGeometry(f)
+	if err != nil {
+		return err
+	}
+	for _, part := range shapeParts(g) {
+		s.counts[part.shapeType]++
+		s.hasZ[part.shapeType] = s.hasZ[part.shapeType] || geo.HasZ(part.g)
+	}
+	return nil
+}
+
+// shpBaseName делает из имени коллекции имя файла: разделители пути и
+// прочие недопустимые в именах файлов символы заменяются подчёркиванием.
+func shpBaseName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "layer"
+	}
+	return name
+}
+
+// nullKind — слой для фич без геометрии: самый многочисленный.
+func (s *ShapefileWriter) nullKind() int {
+	best := shapePoint
+	for _, k := range shpKinds {
+		if s.counts[k.shapeType] > s.counts[best] {
+			best = k.shapeType
+		}
+	}
+	return best
+}
+
+func (s *ShapefileWriter) layer(shapeType int) (*shpLayer, error) {
+	if l, ok := s.layers[shapeType]; ok {
+		return l, nil
+	}
+	if s.fields == nil {
+		var err error
+		if s.fields, err = dbfFields(s.schema); err != nil {
+			return nil, err
+		}
+		s.keys = s.schema.Keys()
+	}
+
+	base := s.name
+	kinds := 0
+	for _, k := range shpKinds {
+		if s.counts[k.shapeType] > 0 {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		for _, k := range shpKinds {
+			if k.shapeType == shapeType {
+				base += "_" + k.suffix
+			}
+		}
+	}
+	l, err := newShpLayer(filepath.Join(s.dir, base), shapeType, s.hasZ[shapeType], s.fields, s.keys)
+	if err != nil {
+		return nil, err
+	}
+	l.base = base
+	s.layers[shapeType] = l
+	return l, nil
+}
+
+// Write записывает фичу при втором проходе.
+func (s *ShapefileWriter) Write(f *models.GeoJSONFeature) error {
+	props, err := FlattenProperties(f.Properties)
+	if err != nil {
+		return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+	}
+	g, err := featureGeometry(f)
+	if err != nil {
+		return err
+	}
+	parts := shapeParts(g)
+	if len(parts) == 0 {
+		l, err := s.layer(s.nullKind())
+		if err != nil {
+			return err
+		}
+		return l.write(nil, props)
+	}
+	for _, part := range parts {
+		l, err := s.layer(part.shapeType)
+		if err != nil {
+			return err
+		}
+		if err := l.write(part.g, props); err != nil {
+			return fmt.Errorf("фича %d: %w", f.ID, err)
+		}
+	}
+	return nil
+}
+
+// Close завершает слои и пишет архив в w.
+func (s *ShapefileWriter) Close(w io.Writer) error {
+	if len(s.layers) == 0 {
+		// пустая коллекция — пустой слой точек, чтобы архив открывался
+		if _, err := s.layer(shapePoint); err != nil {
+			return err
+		}
+	}
+	var bases []string
+	for _, k := range shpKinds {
+		if l, ok := s.layers[k.shapeType]; ok {
+			if err := l.close(); err != nil {
+				return err
+			}
+			bases = append(bases, l.base)
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	for _, base := range bases {
+		for _, ext := range []string{".shp", ".shx", ".dbf"} {
+			if err := zipFile(zw, filepath.Join(s.dir, base+ext), base+ext); err != nil {
+				return err
+			}
+		}
+		extra := map[string]string{".cpg": "UTF-8"}
+		if s.prj != "" {
+			extra[".prj"] = s.prj
+		}
+		for _, ext := range []string{".cpg", ".prj"} {
+			if text, ok := extra[ext]; ok {
+				fw, err := zw.Create(base + ext)
+				if err != nil {
+					return err
+				}
+				if _, err := io.WriteString(fw, text); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// Discard закрывает файлы слоёв прерванной выгрузки; каталог удаляет
+// вызывающий.
+func (s *ShapefileWriter) Discard() {
+	for _, l := range s.layers {
+		l.shp.Close()
+		l.shx.Close()
+		l.dbf.f.Close()
+	}
+}
+
+func zipFile(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// shapePart — часть геометрии фичи, которая попадает в один слой.
+type shapePart struct {
+	shapeType int
+	g         *geo.Geometry
+}
+
+func shapeParts(g *geo.Geometry) []shapePart {
+	if g == nil {
+		return nil
+	}
+	switch g.Type {
+	case "Point":
+		if g.Coordinates == nil {
+			return nil
+		}
+		return []shapePart{{shapePoint, g}}
+	case "MultiPoint":
+		return []shapePart{{shapeMultiPoint, g}}
+	case "LineString", "MultiLineString":
+		return []shapePart{{shapePolyLine, g}}
+	case "Polygon", "MultiPolygon":
+		return []shapePart{{shapePolygon, g}}
+	case "GeometryCollection":
+		var parts []shapePart
+		for _, child := range g.Geometries {
+			parts = append(parts, shapeParts(child)...)
+		}
+		return parts
+	}
+	return nil
+}
+
+// shpLayer — файлы .shp, .shx и .dbf одного слоя.
+type shpLayer struct {
+	base      string
+	shapeType int // с учётом Z
+	shp, shx  *os.File
+	shpW      *bufio.Writer
+	shxW      *bufio.Writer
+	dbf       *dbfWriter
+	offset    int // текущая длина .shp в 16-битных словах
+	records   int
+	env       geo.Envelope
+	buf       []byte
+}
+
+func newShpLayer(base string, shapeType int, hasZ bool, fields []dbfField, keys []string) (*shpLayer, error) {
+	if hasZ {
+		shapeType += 10 // PointZ, PolyLineZ, PolygonZ, MultiPointZ
+	}
+	l := &shpLayer{shapeType: shapeType, offset: 50, env: geo.EmptyEnvelope()}
+	var err error
+	if l.shp, err = os.Create(base + ".shp"); err != nil {
+		return nil, err
+	}
+	if l.shx, err = os.Create(base + ".shx"); err != nil {
+		l.shp.Close()
+		return nil, err
+	}
+	if l.dbf, err = newDBFWriter(base+".dbf", fields, keys); err != nil {
+		l.shp.Close()
+		l.shx.Close()
+		return nil, err
+	}
+	l.shpW, l.shxW = bufio.NewWriter(l.shp), bufio.NewWriter(l.shx)
+	// заголовки перезаписываются в close, когда известны длина и охват
+	l.shpW.Write(make([]byte, 100))
+	l.shxW.Write(make([]byte, 100))
+	return l, nil
+}
+
+func (l *shpLayer) write(g *geo.Geometry, props []Property) error {
+	content, err := l.content(g)
+	if err != nil {
+		return err
+	}
+	l.records++
+	var head [8]byte
+	binary.BigEndian.PutUint32(head[0:], uint32(l.records))
+	binary.BigEndian.PutUint32(head[4:], uint32(len(content)/2))
+	l.shpW.Write(head[:])
+	l.shpW.Write(content)
+
+	binary.BigEndian.PutUint32(head[0:], uint32(l.offset))
+	l.shxW.Write(head[:])
+	l.offset += 4 + len(content)/2
+
+	return l.dbf.write(props)
+}
+
+// content кодирует геометрию в запись .shp. Внешние кольца полигонов
+// записываются по часовой стрелке, дыры — против, как требует формат.
+func (l *shpLayer) content(g *geo.Geometry) ([]byte, error) {
+	b := l.buf[:0]
+	le32 := func(v int) { b = binary.LittleEndian.AppendUint32(b, uint32(int32(v))) }
+	lef := func(v float64) { b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v)) }
+	hasZ := l.shapeType > 10
+
+	if g == nil {
+		le32(shapeNull)
+		l.buf = b
+		return b, nil
+	}
+	env := geo.GeometryEnvelope(g)
+	l.env.Extend(env)
+	le32(l.shapeType)
+
+	z := func(c geo.Coord) float64 {
+		if len(c) > 2 {
+			return c[2]
+		}
+		return 0
+	}
+	if l.shapeType == shapePoint || l.shapeType == shapePointZ {
+		c := g.Coordinates.(geo.Coord)
+		lef(c[0])
+		lef(c[1])
+		if hasZ {
+			lef(z(c))
+			lef(0) // M
+		}
+		l.buf = b
+		return b, nil
+	}
+
+	var parts [][]geo.Coord
+	switch c := g.Coordinates.(type) {
+	case []geo.Coord: // MultiPoint или LineString
+		parts = [][]geo.Coord{c}
+	case [][]geo.Coord:
+		if g.Type == "Polygon" {
+			parts = orientRings(c)
+		} else {
+			parts = c
+		}
+	case [][][]geo.Coord:
+		for _, p := range c {
+			parts = append(parts, orientRings(p)...)
+		}
+	default:
+		return nil, fmt.Errorf("геометрия %s без координат", g.Type)
+	}
+
+	lef(env.MinX)
+	lef(env.MinY)
+	lef(env.MaxX)
+	lef(env.MaxY)
+	numPoints := 0
+	for _, p := range parts {
+		numPoints += len(p)
+	}
+	multiPoint := l.shapeType == shapeMultiPoint || l.shapeType == shapeMultiPointZ
+	if !multiPoint {
+		le32(len(parts))
+	}
+	le32(numPoints)
+	if !multiPoint {
+		start := 0
+		for _, p := range parts {
+			le32(start)
+			start += len(p)
+		}
+	}
+	for _, p := range parts {
+		for _, c := range p {
+			lef(c[0])
+			lef(c[1])
+		}
+	}
+	if hasZ {
+		minZ, maxZ := env.MinZ, env.MaxZ
+		if !env.HasZ {
+			minZ, maxZ = 0, 0
+		}
+		lef(minZ)
+		lef(maxZ)
+		for _, p := range parts {
+			for _, c := range p {
+				lef(z(c))
+			}
+		}
+	}
+	l.buf = b
+	return b, nil
+}
+
+// orientRings возвращает кольца полигона в порядке обхода Shapefile.
+func orientRings(rings [][]geo.Coord) [][]geo.Coord {
+	out := make([][]geo.Coord, len(rings))
+	for i, r := range rings {
+		clockwise := signedArea(r) < 0
+		if (i == 0) != clockwise {
+			r = slices.Clone(r)
+			slices.Reverse(r)
+		}
+		out[i] = r
+	}
+	return out
+}
+
+func (l *shpLayer) close() error {
+	if err := l.shpW.Flush(); err != nil {
+		return err
+	}
+	if err := l.shxW.Flush(); err != nil {
+		return err
+	}
+	if _, err := l.shp.WriteAt(l.header(l.offset), 0); err != nil {
+		return err
+	}
+	if _, err := l.shx.WriteAt(l.header(50+4*l.records), 0); err != nil {
+		return err
+	}
+	for _, f := range []*os.File{l.shp, l.shx} {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return l.dbf.Close()
+}
+
+// header — заголовок .shp или .shx длиной words 16-битных слов.
+func (l *shpLayer) header(words int) []byte {
+	h := make([]byte, 100)
+	binary.BigEndian.PutUint32(h[0:], 9994)
+	binary.BigEndian.PutUint32(h[24:], uint32(words))
+	binary.LittleEndian.PutUint32(h[28:], 1000)
+	binary.LittleEndian.PutUint32(h[32:], uint32(l.shapeType))
+	env := l.env
+	if env.Empty {
+		env = geo.Envelope{}
+	}
+	minZ, maxZ := 0.0, 0.0
+	if env.HasZ {
+		minZ, maxZ = env.MinZ, env.MaxZ
+	}
+	for i, v := range []float64{env.MinX, env.MinY, env.MaxX, env.MaxY, minZ, maxZ} {
+		binary.LittleEndian.PutUint64(h[36+8*i:], math.Float64bits(v))
+	}
+	return h
+}
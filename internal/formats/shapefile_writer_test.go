@@ -0,0 +1,175 @@
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"Datapolis/internal/models"
+)
+
+// exportShapefile выгружает фичи в архив Shapefile и возвращает путь к нему.
+func exportShapefile(t *testing.T, name string, feats []*models.GeoJSONFeature) string {
+	t.Helper()
+	dir := t.TempDir()
+	w := NewShapefileWriter(dir, name, `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433],AUTHORITY["EPSG","4326"]]`)
+	for _, f := range feats {
+		if err := w.Scan(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range feats {
+		if err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "out.zip")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := w.Close(out); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// zipEntries читает все файлы архива.
+func zipEntries(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+	return files
+}
+
+func TestShapefileRoundTrip(t *testing.T) {
+	feats := []*models.GeoJSONFeature{
+		// внешнее кольцо против часовой стрелки, дыра — по часовой (RFC 7946)
+		feature(1, `{"name":"Квартал","area":12.5,"n":3,"ok":true}`,
+			`{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[2,2],[2,4],[4,4],[4,2],[2,2]]]}`),
+		feature(2, `{"name":"b","n":-7,"ok":false}`,
+			`{"type":"MultiPolygon","coordinates":[[[[20,20],[21,20],[21,21],[20,20]]],[[[30,30],[31,30],[31,31],[30,30]]]]}`),
+		feature(3, `{"name":"без геометрии"}`, `null`),
+	}
+	path := exportShapefile(t, "kv/a:rt", feats)
+	files := zipEntries(t, path)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if want := []string{"kv_a_rt.cpg", "kv_a_rt.dbf", "kv_a_rt.prj", "kv_a_rt.shp", "kv_a_rt.shx"}; !slices.Equal(names, want) {
+		t.Fatalf("файлы архива %v, ждали %v", names, want)
+	}
+
+	t.Run("shx", func(t *testing.T) {
+		shp, shx := files["kv_a_rt.shp"], files["kv_a_rt.shx"]
+		if got := int(binary.BigEndian.Uint32(shp[24:])) * 2; got != len(shp) {
+			t.Errorf("длина .shp в заголовке %d, файл %d", got, len(shp))
+		}
+		if got := int(binary.BigEndian.Uint32(shx[24:])) * 2; got != len(shx) || len(shx) != 100+8*len(feats) {
+			t.Errorf("длина .shx в заголовке %d, файл %d", got, len(shx))
+		}
+		if !bytes.Equal(shp[28:100], shx[28:100]) {
+			t.Error("заголовки .shp и .shx различаются охватом или типом")
+		}
+		if typ := binary.LittleEndian.Uint32(shp[32:]); typ != shapePolygon {
+			t.Errorf("тип слоя %d, ждали %d", typ, shapePolygon)
+		}
+		// каждая запись .shx указывает на заголовок записи .shp с тем же номером
+		for i := range feats {
+			off := int(binary.BigEndian.Uint32(shx[100+8*i:])) * 2
+			size := int(binary.BigEndian.Uint32(shx[104+8*i:])) * 2
+			if got := binary.BigEndian.Uint32(shp[off:]); int(got) != i+1 {
+				t.Errorf("запись %d: по смещению %d номер %d", i+1, off, got)
+			}
+			if got := int(binary.BigEndian.Uint32(shp[off+4:])) * 2; got != size {
+				t.Errorf("запись %d: длина в .shx %d, в .shp %d", i+1, size, got)
+			}
+		}
+	})
+
+	t.Run("чтение", func(t *testing.T) {
+		r, err := OpenShapefileZip(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if srid, err := r.SRID(); err != nil || srid != 4326 {
+			t.Errorf("SRID %d, %v", srid, err)
+		}
+		// кольца читаются в обходе Shapefile: внешние по часовой стрелке
+		geoms := []string{
+			`{"type":"Polygon","coordinates":[[[0,0],[0,10],[10,10],[10,0],[0,0]],[[2,2],[4,2],[4,4],[2,4],[2,2]]]}`,
+			`{"type":"MultiPolygon","coordinates":[[[[20,20],[21,21],[21,20],[20,20]]],[[[30,30],[31,31],[31,30],[30,30]]]]}`,
+		}
+		for i, w := range feats[:2] {
+			f, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSONEqual(t, "геометрия", f.Geometry, []byte(geoms[i]))
+			assertPropsSubset(t, f.Properties, w.Properties)
+		}
+		// фича без геометрии пишется пустой фигурой и при импорте отклоняется
+		var recErr *RecordError
+		if _, err := r.Next(); !errors.As(err, &recErr) {
+			t.Errorf("ждали *RecordError, получили %v", err)
+		}
+		if _, err := r.Next(); !errors.Is(err, io.EOF) {
+			t.Errorf("ждали io.EOF, получили %v", err)
+		}
+	})
+}
+
+func TestShapefileLayersByType(t *testing.T) {
+	feats := []*models.GeoJSONFeature{
+		feature(1, `{"a":1}`, `{"type":"Point","coordinates":[1,2,3]}`),
+		feature(2, `{"a":2}`, `{"type":"LineString","coordinates":[[0,0],[1,1]]}`),
+		feature(3, `{"a":3}`, `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[5,6,7]},{"type":"MultiPoint","coordinates":[[1,1],[2,2]]}]}`),
+	}
+	files := zipEntries(t, exportShapefile(t, "mixed", feats))
+	for base, want := range map[string]struct {
+		shapeType uint32
+		records   int
+	}{
+		"mixed_points":      {shapePointZ, 2},
+		"mixed_multipoints": {shapeMultiPoint, 1},
+		"mixed_lines":       {shapePolyLine, 1},
+	} {
+		shp, shx := files[base+".shp"], files[base+".shx"]
+		if shp == nil || files[base+".dbf"] == nil {
+			t.Errorf("нет слоя %s", base)
+			continue
+		}
+		if typ := binary.LittleEndian.Uint32(shp[32:]); typ != want.shapeType {
+			t.Errorf("%s: тип %d, ждали %d", base, typ, want.shapeType)
+		}
+		if n := (len(shx) - 100) / 8; n != want.records {
+			t.Errorf("%s: записей %d, ждали %d", base, n, want.records)
+		}
+	}
+	if len(files) != 3*5 {
+		t.Errorf("файлов в архиве %d, ждали 15", len(files))
+	}
+}
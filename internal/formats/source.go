@@ -0,0 +1,42 @@
+// Package formats читает и пишет файлы геоданных, которые поддерживает
+// импорт и экспорт коллекций.
+package formats
+
+import (
+	"errors"
+
+	"Datapolis/internal/models"
+)
+
+// ErrInvalidFile — файл повреждён или не соответствует формату.
+var ErrInvalidFile = errors.New("неверный формат файла")
+
+// RecordError — запись файла не удалось превратить в фичу (например,
+// координаты не разобрать), но чтение можно продолжить. Next возвращает
+// её вместо фичи; импорт считает запись отклонённой фичей.
+type RecordError struct {
+	Reason string
+}
+
+func (e *RecordError) Error() string {
+	return e.Reason
+}
+
+// FeatureSource — последовательный источник фич импортируемого файла.
+// Геометрия фичи — GeoJSON, свойства — JSON-объект.
+type FeatureSource interface {
+	// Next возвращает следующую фичу или io.EOF, когда фичи закончились;
+	// *RecordError — текущая запись отклонена, следующий вызов продолжит.
+	Next() (*models.GeoJSONFeature, error)
+	// SRID возвращает систему координат, объявленную в файле, или 0.
+	// Значение окончательно после того, как Next вернул io.EOF.
+	SRID() (int, error)
+}
+
+// Locator — источник, который может указать место записи в файле по её
+// позиции среди прочитанных (с нуля), например строку и столбцы геометрии
+// CSV. Импорт добавляет место к ошибке, которая его отменила.
+type Locator interface {
+	// Locate возвращает место записи или пустую строку.
+	Locate(index int) string
+}
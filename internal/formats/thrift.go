@@ -0,0 +1,96 @@
+package formats
+
+import "encoding/binary"
+
+// Типы полей компактного протокола Thrift.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter кодирует структуры в компактном протоколе Thrift, которым
+// Parquet записывает метаданные. Поля структуры пишутся по возрастанию
+// номеров; номер кодируется разницей с предыдущим полем.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // номер предыдущего поля для каждой вложенной структуры
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf = binary.AppendUvarint(t.buf, v)
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	top := &t.last[len(t.last)-1]
+	if delta := id - *top; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.zigzag(int64(id))
+	}
+	*top = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) string(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// structBegin открывает поле-структуру; structEnd его закрывает.
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) structEnd() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// listBegin открывает поле-список из n элементов типа elem. Элементы-
+// структуры открываются elemBegin и закрываются structEnd, элементы
+// простых типов пишутся методами list*.
+func (t *thriftWriter) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+		return
+	}
+	t.buf = append(t.buf, 0xF0|elem)
+	t.varint(uint64(n))
+}
+
+func (t *thriftWriter) elemBegin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) listString(s string) {
+	t.varint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
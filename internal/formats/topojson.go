@@ -0,0 +1,394 @@
+package formats
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+const (
+	// DefaultQuantization — размер сетки квантования TopoJSON по умолчанию.
+	DefaultQuantization = 100000
+	// TopologyObject — имя объекта с фичами в objects топологии.
+	TopologyObject = "features"
+)
+
+// Topology — объект TopoJSON (спецификация TopoJSON 1.0). Дуги
+// квантованы и закодированы разностями соседних точек.
+type Topology struct {
+	Type      string                   `json:"type"`
+	BBox      []float64                `json:"bbox,omitempty"`
+	Transform *TopoTransform           `json:"transform,omitempty"`
+	Objects   map[string]*TopoGeometry `json:"objects"`
+	Arcs      [][][2]int64             `json:"arcs"`
+}
+
+// TopoTransform переводит квантованные координаты в исходные:
+// x = qx*scale[0] + translate[0].
+type TopoTransform struct {
+	Scale     [2]float64 `json:"scale"`
+	Translate [2]float64 `json:"translate"`
+}
+
+// TopoGeometry — геометрический объект TopoJSON. У фичи без геометрии
+// Type равен null. Линии и полигоны ссылаются на дуги по номеру, ~i —
+// дуга i в обратном направлении.
+type TopoGeometry struct {
+	Type        any             `json:"type"`
+	ID          any             `json:"id,omitempty"`
+	Properties  json.RawMessage `json:"properties,omitempty"`
+	Arcs        any             `json:"arcs,omitempty"`
+	Coordinates any             `json:"coordinates,omitempty"`
+	Geometries  []*TopoGeometry `json:"geometries,omitempty"`
+}
+
+// topoPoint — точка на сетке квантования.
+type topoPoint struct{ x, y int64 }
+
+// TopologyBuilder собирает топологию из фич: общие границы соседних
+// полигонов становятся одной дугой, на которую ссылаются оба полигона.
+// Топология строится по всем фичам сразу, поэтому они держатся в памяти.
+type TopologyBuilder struct {
+	quantization int
+	features     []topoFeature
+	env          geo.Envelope
+}
+
+type topoFeature struct {
+	id    int
+	props json.RawMessage
+	g     *geo.Geometry
+}
+
+// NewTopologyBuilder создаёт построитель с сеткой quantization×quantization
+// (не меньше 2).
+func NewTopologyBuilder(quantization int) *TopologyBuilder {
+	return &TopologyBuilder{quantization: quantization, env: geo.EmptyEnvelope()}
+}
+
+// Add добавляет фичу.
+func (b *TopologyBuilder) Add(f *models.GeoJSONFeature) error {
+	g, err := featureGeometry(f)
+	if err != nil {
+		return err
+	}
+	props := json.RawMessage(f.Properties)
+	if string(props) == "null" {
+		props = nil
+	}
+	b.features = append(b.features, topoFeature{id: f.ID, props: props, g: g})
+	if g != nil {
+		b.env.Extend(geo.GeometryEnvelope(g))
+	}
+	return nil
+}
+
+// Topology строит топологию; фичи становятся элементами
+// GeometryCollection с именем object.
+func (b *TopologyBuilder) Topology(object string) *Topology {
+	t := &topologyBuild{
+		Topology: &Topology{Type: "Topology", Arcs: [][][2]int64{}},
+		visits:   map[topoPoint][2]topoPoint{},
+		junction: map[topoPoint]bool{},
+		arcIndex: map[string]int{},
+	}
+	t.Transform = &TopoTransform{Scale: [2]float64{1, 1}}
+	if !b.env.Empty {
+		t.BBox = []float64{b.env.MinX, b.env.MinY, b.env.MaxX, b.env.MaxY}
+		t.Transform.Translate = [2]float64{b.env.MinX, b.env.MinY}
+		n := float64(max(b.quantization, 2) - 1)
+		if w := b.env.MaxX - b.env.MinX; w > 0 {
+			t.Transform.Scale[0] = w / n
+		}
+		if h := b.env.MaxY - b.env.MinY; h > 0 {
+			t.Transform.Scale[1] = h / n
+		}
+	}
+
+	// квантование и поиск узлов — точек, где линии расходятся
+	quantized := make([]*topoShape, len(b.features))
+	for i, f := range b.features {
+		if f.g != nil {
+			quantized[i] = t.quantize(f.g)
+			t.join(quantized[i])
+		}
+	}
+
+	collection := &TopoGeometry{Type: "GeometryCollection", Geometries: []*TopoGeometry{}}
+	for i, f := range b.features {
+		var g *TopoGeometry
+		if f.g == nil {
+			g = &TopoGeometry{}
+		} else {
+			g = t.geometry(quantized[i])
+		}
+		g.ID = f.id
+		g.Properties = f.props
+		collection.Geometries = append(collection.Geometries, g)
+	}
+	t.Objects = map[string]*TopoGeometry{object: collection}
+	return t.Topology
+}
+
+// topoShape — квантованная геометрия: линии, кольца полигонов или точки.
+type topoShape struct {
+	typ    string
+	points []topoPoint     // Point, MultiPoint
+	lines  [][]topoPoint   // LineString, MultiLineString
+	polys  [][][]topoPoint // Polygon, MultiPolygon; кольца замкнуты
+	parts  []*topoShape    // GeometryCollection
+}
+
+type topologyBuild struct {
+	*Topology
+	visits   map[topoPoint][2]topoPoint // соседи точки при первом посещении
+	junction map[topoPoint]bool
+	arcIndex map[string]int
+}
+
+func (t *topologyBuild) point(c geo.Coord) topoPoint {
+	tr := t.Transform
+	return topoPoint{
+		x: int64(math.Round((c[0] - tr.Translate[0]) / tr.Scale[0])),
+		y: int64(math.Round((c[1] - tr.Translate[1]) / tr.Scale[1])),
+	}
+}
+
+// line квантует линию, убирая повторы соседних точек.
+func (t *topologyBuild) line(cs []geo.Coord) []topoPoint {
+	out := make([]topoPoint, 0, len(cs))
+	for _, c := range cs {
+		p := t.point(c)
+		if len(out) == 0 || out[len(out)-1] != p {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (t *topologyBuild) quantize(g *geo.Geometry) *topoShape {
+	s := &topoShape{typ: g.Type}
+	switch c := g.Coordinates.(type) {
+	case geo.Coord:
+		if len(c) >= 2 {
+			s.points = []topoPoint{t.point(c)}
+		}
+	case []geo.Coord:
+		if g.Type == "MultiPoint" {
+			for _, p := range c {
+				s.points = append(s.points, t.point(p))
+			}
+		} else {
+			s.lines = [][]topoPoint{t.line(c)}
+		}
+	case [][]geo.Coord:
+		if g.Type == "Polygon" {
+			s.polys = [][][]topoPoint{t.rings(c)}
+		} else {
+			for _, l := range c {
+				s.lines = append(s.lines, t.line(l))
+			}
+		}
+	case [][][]geo.Coord:
+		for _, p := range c {
+			s.polys = append(s.polys, t.rings(p))
+		}
+	}
+	for _, child := range g.Geometries {
+		if child != nil {
+			s.parts = append(s.parts, t.quantize(child))
+		}
+	}
+	return s
+}
+
+func (t *topologyBuild) rings(rs [][]geo.Coord) [][]topoPoint {
+	out := make([][]topoPoint, 0, len(rs))
+	for _, r := range rs {
+		q := t.line(r)
+		if len(q) > 0 && q[0] != q[len(q)-1] {
+			q = append(q, q[0])
+		}
+		out = append(out, q)
+	}
+	return out
+}
+
+// join отмечает узлы: концы линий и точки, которые разные линии проходят
+// с разными соседями.
+func (t *topologyBuild) join(s *topoShape) {
+	for _, l := range s.lines {
+		if len(l) == 0 {
+			continue
+		}
+		t.junction[l[0]] = true
+		t.junction[l[len(l)-1]] = true
+		for i := 1; i < len(l)-1; i++ {
+			t.visit(l[i], l[i-1], l[i+1])
+		}
+	}
+	for _, p := range s.polys {
+		for _, r := range p {
+			n := len(r) - 1 // последняя точка повторяет первую
+			if n < 1 {
+				continue
+			}
+			for i := 0; i < n; i++ {
+				t.visit(r[i], r[(i+n-1)%n], r[i+1])
+			}
+		}
+	}
+	for _, part := range s.parts {
+		t.join(part)
+	}
+}
+
+func (t *topologyBuild) visit(p, prev, next topoPoint) {
+	seen, ok := t.visits[p]
+	if !ok {
+		t.visits[p] = [2]topoPoint{prev, next}
+		return
+	}
+	if !(seen == [2]topoPoint{prev, next} || seen == [2]topoPoint{next, prev}) {
+		t.junction[p] = true
+	}
+}
+
+// geometry заменяет линии и кольца квантованной геометрии ссылками на дуги.
+func (t *topologyBuild) geometry(s *topoShape) *TopoGeometry {
+	g := &TopoGeometry{Type: s.typ}
+	switch s.typ {
+	case "Point":
+		if len(s.points) > 0 {
+			g.Coordinates = [2]int64{s.points[0].x, s.points[0].y}
+		} else {
+			g.Coordinates = []int64{}
+		}
+	case "MultiPoint":
+		cs := make([][2]int64, len(s.points))
+		for i, p := range s.points {
+			cs[i] = [2]int64{p.x, p.y}
+		}
+		g.Coordinates = cs
+	case "LineString":
+		g.Arcs = t.cutLine(s.lines[0])
+	case "MultiLineString":
+		arcs := make([][]int, len(s.lines))
+		for i, l := range s.lines {
+			arcs[i] = t.cutLine(l)
+		}
+		g.Arcs = arcs
+	case "Polygon":
+		g.Arcs = t.polygon(s.polys[0])
+	case "MultiPolygon":
+		arcs := make([][][]int, len(s.polys))
+		for i, p := range s.polys {
+			arcs[i] = t.polygon(p)
+		}
+		g.Arcs = arcs
+	case "GeometryCollection":
+		g.Geometries = make([]*TopoGeometry, len(s.parts))
+		for i, part := range s.parts {
+			g.Geometries[i] = t.geometry(part)
+		}
+	}
+	return g
+}
+
+func (t *topologyBuild) polygon(rings [][]topoPoint) [][]int {
+	out := make([][]int, 0, len(rings))
+	for _, r := range rings {
+		if len(r) > 0 {
+			out = append(out, t.cutRing(r))
+		}
+	}
+	return out
+}
+
+// cutLine режет линию в узлах на дуги.
+func (t *topologyBuild) cutLine(l []topoPoint) []int {
+	if len(l) < 2 {
+		// линия схлопнулась при квантовании в точку
+		return []int{t.arc(append(l, l...))}
+	}
+	var refs []int
+	start := 0
+	for i := 1; i < len(l); i++ {
+		if i == len(l)-1 || t.junction[l[i]] {
+			refs = append(refs, t.arc(l[start:i+1]))
+			start = i
+		}
+	}
+	return refs
+}
+
+// cutRing режет замкнутое кольцо: начинает его с узла, а кольцо без
+// узлов — с наименьшей точки, чтобы совпадающие кольца дали одну дугу.
+func (t *topologyBuild) cutRing(r []topoPoint) []int {
+	n := len(r) - 1
+	if n < 1 {
+		return []int{t.arc(append(r, r...))}
+	}
+	start := -1
+	for i := 0; i < n; i++ {
+		if t.junction[r[i]] {
+			start = i
+			break
+		}
+	}
+	noJunctions := start < 0
+	if noJunctions {
+		start = 0
+		for i := 1; i < n; i++ {
+			if r[i].x < r[start].x || (r[i].x == r[start].x && r[i].y < r[start].y) {
+				start = i
+			}
+		}
+	}
+	ring := make([]topoPoint, 0, n+1)
+	ring = append(ring, r[start:n]...)
+	ring = append(ring, r[:start+1]...)
+	if noJunctions {
+		return []int{t.arc(ring)}
+	}
+	return t.cutLine(ring)
+}
+
+// arc возвращает ссылку на дугу с точками l, добавляя её, если такой
+// дуги нет ни в прямом, ни в обратном направлении.
+func (t *topologyBuild) arc(l []topoPoint) int {
+	key := arcKey(l, false)
+	if i, ok := t.arcIndex[key]; ok {
+		return i
+	}
+	if i, ok := t.arcIndex[arcKey(l, true)]; ok {
+		return ^i
+	}
+	i := len(t.Arcs)
+	t.arcIndex[key] = i
+
+	arc := make([][2]int64, len(l))
+	var prev topoPoint
+	for j, p := range l {
+		arc[j] = [2]int64{p.x - prev.x, p.y - prev.y}
+		prev = p
+	}
+	t.Arcs = append(t.Arcs, arc)
+	return i
+}
+
+func arcKey(l []topoPoint, reverse bool) string {
+	b := make([]byte, 0, len(l)*16)
+	for j := range l {
+		p := l[j]
+		if reverse {
+			p = l[len(l)-1-j]
+		}
+		b = binary.AppendVarint(b, p.x)
+		b = binary.AppendVarint(b, p.y)
+	}
+	return string(b)
+}
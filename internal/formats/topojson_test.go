@@ -0,0 +1,250 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// topoDecoder восстанавливает геометрии GeoJSON из топологии, как это
+// делает клиент (topojson-client): дуги разворачиваются из разностей,
+// кольца и линии склеиваются из дуг без повтора общих точек.
+type topoDecoder struct {
+	t    *testing.T
+	topo *Topology
+}
+
+func (d *topoDecoder) arc(ref int) []geo.Coord {
+	i := ref
+	if ref < 0 {
+		i = ^ref
+	}
+	var x, y int64
+	tr := d.topo.Transform
+	out := make([]geo.Coord, len(d.topo.Arcs[i]))
+	for j, delta := range d.topo.Arcs[i] {
+		x, y = x+delta[0], y+delta[1]
+		out[j] = geo.Coord{float64(x)*tr.Scale[0] + tr.Translate[0], float64(y)*tr.Scale[1] + tr.Translate[1]}
+	}
+	if ref < 0 {
+		slices.Reverse(out)
+	}
+	return out
+}
+
+func (d *topoDecoder) line(refs []int) []geo.Coord {
+	var out []geo.Coord
+	for k, ref := range refs {
+		a := d.arc(ref)
+		if k > 0 {
+			if !reflect.DeepEqual(out[len(out)-1], a[0]) {
+				d.t.Fatalf("дуга %d не продолжает линию: %v после %v", ref, a[0], out[len(out)-1])
+			}
+			a = a[1:]
+		}
+		out = append(out, a...)
+	}
+	return out
+}
+
+func (d *topoDecoder) rings(refs [][]int) [][]geo.Coord {
+	out := make([][]geo.Coord, len(refs))
+	for i, r := range refs {
+		out[i] = d.line(r)
+	}
+	return out
+}
+
+func (d *topoDecoder) geometry(g *TopoGeometry) *geo.Geometry {
+	if g.Type == nil {
+		return nil
+	}
+	// ссылки на дуги разбираются через JSON, как их увидит клиент
+	var arcs json.RawMessage
+	if g.Arcs != nil {
+		arcs, _ = json.Marshal(g.Arcs)
+	}
+	out := &geo.Geometry{Type: g.Type.(string)}
+	tr := d.topo.Transform
+	point := func(p [2]int64) geo.Coord {
+		return geo.Coord{float64(p[0])*tr.Scale[0] + tr.Translate[0], float64(p[1])*tr.Scale[1] + tr.Translate[1]}
+	}
+	switch out.Type {
+	case "Point":
+		out.Coordinates = point(g.Coordinates.([2]int64))
+	case "MultiPoint":
+		var cs []geo.Coord
+		for _, p := range g.Coordinates.([][2]int64) {
+			cs = append(cs, point(p))
+		}
+		out.Coordinates = cs
+	case "LineString":
+		var refs []int
+		json.Unmarshal(arcs, &refs)
+		out.Coordinates = d.line(refs)
+	case "MultiLineString", "Polygon":
+		var refs [][]int
+		json.Unmarshal(arcs, &refs)
+		out.Coordinates = d.rings(refs)
+	case "MultiPolygon":
+		var refs [][][]int
+		json.Unmarshal(arcs, &refs)
+		var polys [][][]geo.Coord
+		for _, p := range refs {
+			polys = append(polys, d.rings(p))
+		}
+		out.Coordinates = polys
+	case "GeometryCollection":
+		for _, child := range g.Geometries {
+			out.Geometries = append(out.Geometries, d.geometry(child))
+		}
+	}
+	return out
+}
+
+// normalizeRings приводит кольца полигонов к началу с наименьшей точки:
+// топология может начать кольцо с любого узла.
+func normalizeRings(g *geo.Geometry) {
+	if g == nil {
+		return
+	}
+	norm := func(r []geo.Coord) []geo.Coord {
+		n := len(r) - 1
+		start := 0
+		for i := 1; i < n; i++ {
+			if r[i][0] < r[start][0] || (r[i][0] == r[start][0] && r[i][1] < r[start][1]) {
+				start = i
+			}
+		}
+		out := append(slices.Clone(r[start:n]), r[:start+1]...)
+		return out
+	}
+	switch c := g.Coordinates.(type) {
+	case [][]geo.Coord:
+		if g.Type == "Polygon" {
+			for i := range c {
+				c[i] = norm(c[i])
+			}
+		}
+	case [][][]geo.Coord:
+		for _, p := range c {
+			for i := range p {
+				p[i] = norm(p[i])
+			}
+		}
+	}
+	for _, child := range g.Geometries {
+		normalizeRings(child)
+	}
+}
+
+func TestTopologyRoundTrip(t *testing.T) {
+	// охват 0..10 и сетка 11×11: шаг квантования ровно 1
+	feats := []*models.GeoJSONFeature{
+		feature(1, `{"name":"A"}`, `{"type":"Polygon","coordinates":[[[0,0],[5,0],[5,5],[0,5],[0,0]]]}`),
+		feature(2, `{"name":"B"}`, `{"type":"Polygon","coordinates":[[[5,0],[10,0],[10,5],[5,5],[5,0]]]}`),
+		feature(3, `{"name":"дыра"}`, `{"type":"Polygon","coordinates":[[[0,6],[4,6],[4,10],[0,10],[0,6]],[[1,7],[1,8],[2,8],[2,7],[1,7]]]}`),
+		feature(4, `{}`, `{"type":"LineString","coordinates":[[5,5],[5,8],[9,8]]}`),
+		feature(5, `{"n":1}`, `{"type":"MultiPoint","coordinates":[[6,6],[7,7]]}`),
+		feature(6, `null`, `{"type":"Point","coordinates":[10,10]}`),
+		feature(7, `{"a":1}`, `null`),
+		feature(8, `{}`, `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[8,9]},{"type":"MultiLineString","coordinates":[[[6,9],[7,9]],[[6,10],[7,10]]]}]}`),
+		feature(9, `{}`, `{"type":"MultiPolygon","coordinates":[[[[6,6],[7,6],[7,7],[6,6]]],[[[8,6],[9,6],[9,7],[8,6]]]]}`),
+	}
+	b := NewTopologyBuilder(11)
+	for _, f := range feats {
+		if err := b.Add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	topo := b.Topology(TopologyObject)
+
+	// проверяем и JSON-представление: клиент получает именно его
+	raw, err := json.Marshal(topo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Type    string                     `json:"type"`
+		BBox    []float64                  `json:"bbox"`
+		Objects map[string]json.RawMessage `json:"objects"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Type != "Topology" || !reflect.DeepEqual(doc.BBox, []float64{0, 0, 10, 10}) || doc.Objects[TopologyObject] == nil {
+		t.Fatalf("топология %s", raw)
+	}
+	if topo.Transform.Scale != [2]float64{1, 1} || topo.Transform.Translate != [2]float64{0, 0} {
+		t.Fatalf("transform %+v", topo.Transform)
+	}
+
+	d := &topoDecoder{t: t, topo: topo}
+	objects := topo.Objects[TopologyObject].Geometries
+	if len(objects) != len(feats) {
+		t.Fatalf("объектов %d, ждали %d", len(objects), len(feats))
+	}
+	for i, f := range feats {
+		obj := objects[i]
+		if obj.ID != f.ID {
+			t.Errorf("объект %d: id %v", i, obj.ID)
+		}
+		if string(f.Properties) == "null" {
+			if obj.Properties != nil {
+				t.Errorf("объект %d: свойства %s, ждали без свойств", i, obj.Properties)
+			}
+		} else {
+			assertJSONEqual(t, fmt.Sprintf("объект %d: свойства", i), obj.Properties, f.Properties)
+		}
+
+		want, _ := featureGeometry(f)
+		got := d.geometry(obj)
+		normalizeRings(want)
+		normalizeRings(got)
+		if !reflect.DeepEqual(got, want) {
+			gotJSON, _ := json.Marshal(got)
+			t.Errorf("объект %d: геометрия %s, ждали %s", i, gotJSON, f.Geometry)
+		}
+	}
+
+	// общая граница A и B — одна дуга, которую B проходит в обратную сторону
+	arcsA := objects[0].Arcs.([][]int)[0]
+	arcsB := objects[1].Arcs.([][]int)[0]
+	shared := 0
+	for _, a := range arcsA {
+		for _, b := range arcsB {
+			if a == ^b {
+				shared++
+			}
+		}
+	}
+	if shared != 1 {
+		t.Errorf("общих дуг у A %v и B %v: %d, ждали 1", arcsA, arcsB, shared)
+	}
+}
+
+func TestTopologyQuantization(t *testing.T) {
+	b := NewTopologyBuilder(3)
+	// соседние точки линии сливаются в одну клетку сетки, а короткая
+	// линия схлопывается в точку, но остаётся дугой из двух точек
+	b.Add(feature(1, `{}`, `{"type":"LineString","coordinates":[[0,0],[0.1,0.1],[2,2]]}`))
+	b.Add(feature(2, `{}`, `{"type":"LineString","coordinates":[[1.9,1.9],[2,2]]}`))
+	topo := b.Topology("layer")
+	objects := topo.Objects["layer"].Geometries
+
+	if got := topo.Transform.Scale; got != [2]float64{1, 1} {
+		t.Fatalf("scale %v", got)
+	}
+	d := &topoDecoder{t: t, topo: topo}
+	if got := d.geometry(objects[0]).Coordinates; !reflect.DeepEqual(got, []geo.Coord{{0, 0}, {2, 2}}) {
+		t.Errorf("линия 1: %v", got)
+	}
+	if got := d.geometry(objects[1]).Coordinates; !reflect.DeepEqual(got, []geo.Coord{{2, 2}, {2, 2}}) {
+		t.Errorf("линия 2: %v", got)
+	}
+}
@@ -0,0 +1,84 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SRID4326 — WGS 84, система координат GeoJSON по умолчанию (RFC 7946).
+const SRID4326 = 4326
+
+// CRS84URI — WGS 84 с порядком осей долгота/широта, как в GeoJSON.
+const CRS84URI = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+
+// CRSURI возвращает URI системы координат для заголовка Content-Crs.
+// PostGIS отдаёт EPSG:4326 в порядке долгота/широта, поэтому это CRS84.
+func CRSURI(srid int) string {
+	if srid == SRID4326 {
+		return CRS84URI
+	}
+	return fmt.Sprintf("http://www.opengis.net/def/crs/EPSG/0/%d", srid)
+}
+
+// ParseSRID разбирает обозначение системы координат и возвращает код EPSG.
+// Поддерживаются формы "4326", "EPSG:4326", "urn:ogc:def:crs:EPSG::4326",
+// "http://www.opengis.net/def/crs/EPSG/0/4326" и варианты CRS84.
+func ParseSRID(s string) (int, error) {
+	v := strings.TrimSpace(s)
+	if v == "" {
+		return 0, fmt.Errorf("пустое обозначение системы координат")
+	}
+	upper := strings.ToUpper(v)
+	if strings.HasSuffix(upper, "CRS84") {
+		return SRID4326, nil
+	}
+
+	var code string
+	switch {
+	case strings.HasPrefix(upper, "EPSG:"):
+		code = v[len("EPSG:"):]
+	case strings.HasPrefix(upper, "URN:OGC:DEF:CRS:EPSG:"):
+		// urn:ogc:def:crs:EPSG:<версия>:<код>, версия может быть пустой
+		code = v[strings.LastIndex(v, ":")+1:]
+	case strings.Contains(upper, "OPENGIS.NET/DEF/CRS/EPSG/"):
+		code = v[strings.LastIndex(v, "/")+1:]
+	default:
+		code = v
+	}
+
+	srid, err := strconv.Atoi(code)
+	if err != nil || srid <= 0 {
+		return 0, fmt.Errorf("неподдерживаемая система координат %q", s)
+	}
+	return srid, nil
+}
+
+// ParseCRSMember разбирает устаревший член crs GeoJSON (GJ2008):
+// {"type":"name","properties":{"name":"urn:ogc:def:crs:EPSG::3857"}}
+// или {"type":"EPSG","properties":{"code":3857}}. Без члена crs возвращает 0.
+func ParseCRSMember(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	var crs struct {
+		Type       string `json:"type"`
+		Properties struct {
+			Name string `json:"name"`
+			Code int    `json:"code"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(raw, &crs); err != nil {
+		return 0, fmt.Errorf("неверный член crs: %w", err)
+	}
+	switch strings.ToLower(crs.Type) {
+	case "name":
+		return ParseSRID(crs.Properties.Name)
+	case "epsg":
+		if crs.Properties.Code > 0 {
+			return crs.Properties.Code, nil
+		}
+	}
+	return 0, fmt.Errorf("неподдерживаемый член crs типа %q", crs.Type)
+}
@@ -0,0 +1,89 @@
+package geo
+
+import "testing"
+
+func TestParseSRID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int // 0 — ждём ошибку
+	}{
+		{"4326", 4326},
+		{" 32642 ", 32642},
+		{"EPSG:3857", 3857},
+		{"epsg:3857", 3857},
+		{"urn:ogc:def:crs:EPSG::3857", 3857},
+		{"urn:ogc:def:crs:EPSG:9.9.1:2154", 2154},
+		{"http://www.opengis.net/def/crs/EPSG/0/32642", 32642},
+		{"https://www.opengis.net/def/crs/EPSG/0/28992", 28992},
+		{CRS84URI, 4326},
+		{"urn:ogc:def:crs:OGC:1.3:CRS84", 4326},
+		{"", 0},
+		{"EPSG:", 0},
+		{"EPSG:-1", 0},
+		{"EPSG:0", 0},
+		{"EPSG:abc", 0},
+		{"http://www.opengis.net/def/crs/OGC/1.3/CRS83", 0},
+	}
+	for _, tt := range tests {
+		got, err := ParseSRID(tt.in)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("ParseSRID(%q) = %d, ждали ошибку", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSRID(%q) = %d, %v; ждали %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestCRSURI(t *testing.T) {
+	for srid, want := range map[int]string{
+		4326:  CRS84URI,
+		3857:  "http://www.opengis.net/def/crs/EPSG/0/3857",
+		32642: "http://www.opengis.net/def/crs/EPSG/0/32642",
+	} {
+		uri := CRSURI(srid)
+		if uri != want {
+			t.Errorf("CRSURI(%d) = %s, ждали %s", srid, uri, want)
+		}
+		// URI из Content-Crs клиент может вернуть в параметре crs
+		if back, err := ParseSRID(uri); err != nil || back != srid {
+			t.Errorf("ParseSRID(CRSURI(%d)) = %d, %v", srid, back, err)
+		}
+	}
+}
+
+func TestParseCRSMember(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    int
+		wantErr bool
+	}{
+		{``, 0, false},
+		{`null`, 0, false},
+		{`{"type":"name","properties":{"name":"urn:ogc:def:crs:EPSG::3857"}}`, 3857, false},
+		{`{"type":"name","properties":{"name":"EPSG:32642"}}`, 32642, false},
+		{`{"type":"name","properties":{"name":"urn:ogc:def:crs:OGC:1.3:CRS84"}}`, 4326, false},
+		{`{"type":"EPSG","properties":{"code":2154}}`, 2154, false},
+		{`{"type":"epsg","properties":{"code":2154}}`, 2154, false},
+		{`{"type":"EPSG","properties":{"code":0}}`, 0, true},
+		{`{"type":"link","properties":{"href":"http://example.org/crs","type":"proj4"}}`, 0, true},
+		{`{"type":"name","properties":{"name":"local"}}`, 0, true},
+		{`{"type":"name","properties":{"name":3857}}`, 0, true},
+		{`"EPSG:3857"`, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCRSMember([]byte(tt.raw))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCRSMember(%s) = %d, ждали ошибку", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseCRSMember(%s) = %d, %v; ждали %d", tt.raw, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package geo
+
+import "math"
+
+// Envelope — ограничивающий прямоугольник геометрии.
+type Envelope struct {
+	MinX, MinY, MaxX, MaxY float64
+	MinZ, MaxZ             float64 // имеют смысл, если HasZ
+	HasZ                   bool
+	Empty                  bool
+}
+
+// EmptyEnvelope — прямоугольник без точек; Extend расширяет его.
+func EmptyEnvelope() Envelope {
+	return Envelope{
+		MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1),
+		MinZ: math.Inf(1), MaxZ: math.Inf(-1),
+		Empty: true,
+	}
+}
+
+// Extend расширяет прямоугольник до охвата o.
+func (e *Envelope) Extend(o Envelope) {
+	if o.Empty {
+		return
+	}
+	e.MinX, e.MinY = math.Min(e.MinX, o.MinX), math.Min(e.MinY, o.MinY)
+	e.MaxX, e.MaxY = math.Max(e.MaxX, o.MaxX), math.Max(e.MaxY, o.MaxY)
+	if o.HasZ {
+		e.MinZ, e.MaxZ = math.Min(e.MinZ, o.MinZ), math.Max(e.MaxZ, o.MaxZ)
+		e.HasZ = true
+	}
+	e.Empty = false
+}
+
+// GeometryEnvelope вычисляет прямоугольник, охватывающий все координаты g.
+func GeometryEnvelope(g *Geometry) Envelope {
+	e := EmptyEnvelope()
+	eachCoord(g, func(c Coord) {
+		if len(c) < 2 {
+			return
+		}
+		e.MinX, e.MinY = math.Min(e.MinX, c[0]), math.Min(e.MinY, c[1])
+		e.MaxX, e.MaxY = math.Max(e.MaxX, c[0]), math.Max(e.MaxY, c[1])
+		if len(c) > 2 {
+			e.MinZ, e.MaxZ = math.Min(e.MinZ, c[2]), math.Max(e.MaxZ, c[2])
+			e.HasZ = true
+		}
+		e.Empty = false
+	})
+	return e
+}
+
+// HasZ сообщает, есть ли у геометрии координаты z.
+func HasZ(g *Geometry) bool {
+	z := false
+	eachCoord(g, func(c Coord) { z = z || len(c) > 2 })
+	return z
+}
+
+// eachCoord вызывает fn для каждой координаты геометрии.
+func eachCoord(g *Geometry, fn func(Coord)) {
+	if g == nil {
+		return
+	}
+	switch g.Type {
+	case "Point":
+		if c := coords[Coord](g); c != nil {
+			fn(c)
+		}
+	case "MultiPoint", "LineString":
+		for _, c := range coords[[]Coord](g) {
+			fn(c)
+		}
+	case "MultiLineString", "Polygon":
+		for _, l := range coords[[][]Coord](g) {
+			for _, c := range l {
+				fn(c)
+			}
+		}
+	case "MultiPolygon":
+		for _, p := range coords[[][][]Coord](g) {
+			for _, l := range p {
+				for _, c := range l {
+					fn(c)
+				}
+			}
+		}
+	case "GeometryCollection":
+		for _, child := range g.Geometries {
+			eachCoord(child, fn)
+		}
+	}
+}
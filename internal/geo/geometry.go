@@ -0,0 +1,93 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// geometryTypes — типы геометрий GeoJSON (RFC 7946, раздел 3.1).
+var geometryTypes = map[string]bool{
+	"Point":              true,
+	"MultiPoint":         true,
+	"LineString":         true,
+	"MultiLineString":    true,
+	"Polygon":            true,
+	"MultiPolygon":       true,
+	"GeometryCollection": true,
+}
+
+// Coord — координата x, y и, при наличии, z.
+type Coord []float64
+
+// Geometry — геометрия в модели GeoJSON. Тип Coordinates зависит от Type:
+// Point — Coord, MultiPoint и LineString — []Coord,
+// MultiLineString и Polygon — [][]Coord, MultiPolygon — [][][]Coord.
+// У GeometryCollection заполнено только Geometries.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates any         `json:"coordinates,omitempty"`
+	Geometries  []*Geometry `json:"geometries,omitempty"`
+}
+
+// CheckGeoJSONGeometry проверяет, что raw — объект геометрии GeoJSON
+// с известным типом. Топологическую корректность проверяет PostGIS.
+func CheckGeoJSONGeometry(raw []byte) error {
+	var g struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return fmt.Errorf("геометрия не является объектом GeoJSON: %w", err)
+	}
+	if !geometryTypes[g.Type] {
+		return fmt.Errorf("неизвестный тип геометрии %q", g.Type)
+	}
+	return nil
+}
+
+// UnmarshalJSON разбирает геометрию GeoJSON в типизированные координаты.
+func (g *Geometry) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+		Geometries  []*Geometry     `json:"geometries"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if !geometryTypes[raw.Type] {
+		return fmt.Errorf("неизвестный тип геометрии %q", raw.Type)
+	}
+	g.Type = raw.Type
+	g.Geometries = nil
+	g.Coordinates = nil
+
+	if raw.Type == "GeometryCollection" {
+		g.Geometries = raw.Geometries
+		return nil
+	}
+	if len(raw.Coordinates) == 0 || string(raw.Coordinates) == "null" {
+		return errors.New("у геометрии нет координат")
+	}
+
+	var err error
+	switch raw.Type {
+	case "Point":
+		var c Coord
+		err = json.Unmarshal(raw.Coordinates, &c)
+		g.Coordinates = c
+	case "MultiPoint", "LineString":
+		var c []Coord
+		err = json.Unmarshal(raw.Coordinates, &c)
+		g.Coordinates = c
+	case "MultiLineString", "Polygon":
+		var c [][]Coord
+		err = json.Unmarshal(raw.Coordinates, &c)
+		g.Coordinates = c
+	case "MultiPolygon":
+		var c [][][]Coord
+		err = json.Unmarshal(raw.Coordinates, &c)
+		g.Coordinates = c
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CheckStructure проверяет то, без чего PostGIS не построит геометрию
+// из GeoJSON: число координат в точках, линиях и кольцах и замкнутость
+// колец. Топологическую корректность (самопересечения и т. п.) проверяет
+// ST_IsValid. При closeRings незамкнутые кольца замыкаются повтором первой
+// точки; changed сообщает, что геометрия была изменена.
+func CheckStructure(g *Geometry, closeRings bool) (changed bool, err error) {
+	switch g.Type {
+	case "Point":
+		err = checkCoord(coords[Coord](g))
+	case "MultiPoint":
+		for _, c := range coords[[]Coord](g) {
+			if err = checkCoord(c); err != nil {
+				break
+			}
+		}
+	case "LineString":
+		err = checkLine(coords[[]Coord](g))
+	case "MultiLineString":
+		for _, l := range coords[[][]Coord](g) {
+			if err = checkLine(l); err != nil {
+				break
+			}
+		}
+	case "Polygon":
+		changed, err = checkPolygon(coords[[][]Coord](g), closeRings)
+	case "MultiPolygon":
+		for _, p := range coords[[][][]Coord](g) {
+			var c bool
+			c, err = checkPolygon(p, closeRings)
+			changed = changed || c
+			if err != nil {
+				break
+			}
+		}
+	case "GeometryCollection":
+		for _, child := range g.Geometries {
+			if child == nil {
+				return changed, errors.New("пустой элемент GeometryCollection")
+			}
+			var c bool
+			c, err = CheckStructure(child, closeRings)
+			changed = changed || c
+			if err != nil {
+				break
+			}
+		}
+	}
+	return changed, err
+}
+
+// coords возвращает координаты нужного типа; у пустой геометрии — nil.
+func coords[T any](g *Geometry) T {
+	c, _ := g.Coordinates.(T)
+	return c
+}
+
+func checkCoord(c Coord) error {
+	if len(c) < 2 {
+		return errors.New("у координаты меньше двух чисел")
+	}
+	return nil
+}
+
+func checkLine(l []Coord) error {
+	if len(l) < 2 {
+		return errors.New("в линии меньше двух точек")
+	}
+	for _, c := range l {
+		if err := checkCoord(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPolygon замыкает кольца в самом срезе rings, поэтому изменения
+// видны в исходной геометрии.
+func checkPolygon(rings [][]Coord, closeRings bool) (changed bool, err error) {
+	if len(rings) == 0 {
+		return false, errors.New("у полигона нет колец")
+	}
+	for i, r := range rings {
+		if err := checkLine(r); err != nil {
+			return changed, err
+		}
+		if !sameCoord(r[0], r[len(r)-1]) {
+			if !closeRings {
+				return changed, fmt.Errorf("кольцо %d не замкнуто", i)
+			}
+			r = append(r, r[0])
+			rings[i] = r
+			changed = true
+		}
+		if len(r) < 4 {
+			return changed, fmt.Errorf("в кольце %d меньше четырёх точек", i)
+		}
+	}
+	return changed, nil
+}
+
+func sameCoord(a, b Coord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
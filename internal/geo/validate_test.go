@@ -0,0 +1,107 @@
+package geo
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCheckStructure(t *testing.T) {
+	tests := []struct {
+		name    string
+		geom    string
+		close   bool
+		changed bool
+		want    string // геометрия после проверки; пусто — без изменений
+		wantErr bool
+	}{
+		{name: "точка", geom: `{"type":"Point","coordinates":[30,60]}`},
+		{name: "точка Z", geom: `{"type":"Point","coordinates":[30,60,100]}`},
+		{name: "точка без y", geom: `{"type":"Point","coordinates":[30]}`, wantErr: true},
+		{name: "мультиточка", geom: `{"type":"MultiPoint","coordinates":[[1,2],[3]]}`, wantErr: true},
+		{name: "линия", geom: `{"type":"LineString","coordinates":[[0,0],[1,1]]}`},
+		{name: "линия из одной точки", geom: `{"type":"LineString","coordinates":[[0,0]]}`, wantErr: true},
+		{name: "мультилиния", geom: `{"type":"MultiLineString","coordinates":[[[0,0],[1,1]],[[2,2]]]}`, wantErr: true},
+		{name: "полигон", geom: `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`},
+		{name: "полигон без колец", geom: `{"type":"Polygon","coordinates":[]}`, wantErr: true},
+		{
+			name: "незамкнутое кольцо", geom: `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`,
+			wantErr: true,
+		},
+		{
+			name: "замыкание кольца", geom: `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`,
+			close: true, changed: true,
+			want: `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}`,
+		},
+		{
+			// после замыкания в кольце всё равно три точки
+			name: "короткое кольцо", geom: `{"type":"Polygon","coordinates":[[[0,0],[1,0]]]}`,
+			close: true, wantErr: true,
+		},
+		{
+			name:  "замыкание дырки мультиполигона",
+			geom:  `{"type":"MultiPolygon","coordinates":[[[[0,0],[4,0],[4,4],[0,0]]],[[[5,5],[9,5],[9,9],[5,5]],[[6,6],[7,6],[7,7]]]]}`,
+			close: true, changed: true,
+			want: `{"type":"MultiPolygon","coordinates":[[[[0,0],[4,0],[4,4],[0,0]]],[[[5,5],[9,5],[9,9],[5,5]],[[6,6],[7,6],[7,7],[6,6]]]]}`,
+		},
+		{
+			name:  "кольцо Z замыкается с z",
+			geom:  `{"type":"Polygon","coordinates":[[[0,0,1],[1,0,1],[1,1,1],[0,0,2]]]}`,
+			close: true, changed: true,
+			want: `{"type":"Polygon","coordinates":[[[0,0,1],[1,0,1],[1,1,1],[0,0,2],[0,0,1]]]}`,
+		},
+		{
+			name:  "коллекция",
+			geom:  `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]},{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}]}`,
+			close: true, changed: true,
+			want: `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]},{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}]}`,
+		},
+		{name: "пустой элемент коллекции", geom: `{"type":"GeometryCollection","geometries":[null]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var g Geometry
+			if err := json.Unmarshal([]byte(tt.geom), &g); err != nil {
+				t.Fatal(err)
+			}
+			changed, err := CheckStructure(&g, tt.close)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ждали ошибку")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed = %v", changed)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.geom
+			}
+			raw, err := json.Marshal(&g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw) != want {
+				t.Errorf("получили %s\nждали %s", raw, want)
+			}
+		})
+	}
+}
+
+func TestCheckGeoJSONGeometry(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		`{"type":"Point","coordinates":[1,2]}`:          true,
+		`{"type":"GeometryCollection","geometries":[]}`: true,
+		`{"type":"Feature"}`:                            false,
+		`{"type":"point","coordinates":[1,2]}`:          false,
+		`[1,2]`:                                         false,
+		`null`:                                          false,
+	} {
+		if err := CheckGeoJSONGeometry([]byte(raw)); (err == nil) != ok {
+			t.Errorf("CheckGeoJSONGeometry(%s) = %v", raw, err)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package geo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Коды типов WKB (OGC Simple Features, часть 1).
+var wkbTypes = map[string]uint32{
+	"Point":              1,
+	"LineString":         2,
+	"Polygon":            3,
+	"MultiPoint":         4,
+	"MultiLineString":    5,
+	"MultiPolygon":       6,
+	"GeometryCollection": 7,
+}
+
+var wkbNames = map[uint32]string{
+	1: "Point", 2: "LineString", 3: "Polygon",
+	4: "MultiPoint", 5: "MultiLineString", 6: "MultiPolygon",
+	7: "GeometryCollection",
+}
+
+// Флаги измерений и SRID расширенного WKB (EWKB) PostGIS.
+const (
+	ewkbZ    = 0x80000000
+	ewkbM    = 0x40000000
+	ewkbSRID = 0x20000000
+)
+
+// ParseWKB разбирает геометрию в WKB: ISO (коды типов +1000 для Z,
+// +2000 для M, +3000 для ZM) или EWKB PostGIS. Координата M
+// отбрасывается, пустая точка (NaN) даёт геометрию без координат.
+func ParseWKB(b []byte) (*Geometry, error) {
+	r := &wkbReader{b: b}
+	g := r.geometry(0)
+	if r.err != nil {
+		return nil, fmt.Errorf("WKB: %w", r.err)
+	}
+	return g, nil
+}
+
+type wkbReader struct {
+	b     []byte
+	off   int
+	order binary.ByteOrder
+	err   error
+}
+
+func (r *wkbReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.off+n > len(r.b) {
+		r.err = errors.New("данные обрываются")
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *wkbReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return r.order.Uint32(b)
+	}
+	return 0
+}
+
+// count читает число элементов, каждый из которых занимает не меньше
+// size байт, — чтобы испорченная длина не привела к огромной аллокации.
+func (r *wkbReader) count(size int) int {
+	n := int(r.uint32())
+	if r.err == nil && n > (len(r.b)-r.off)/size {
+		r.err = errors.New("неверное число элементов")
+		return 0
+	}
+	return n
+}
+
+func (r *wkbReader) coord(hasZ, hasM bool) Coord {
+	n := 2
+	if hasZ {
+		n++
+	}
+	if hasM {
+		n++
+	}
+	b := r.take(8 * n)
+	if b == nil {
+		return nil
+	}
+	c := make(Coord, 0, 3)
+	for i := 0; i < n; i++ {
+		if hasM && i == n-1 {
+			break
+		}
+		c = append(c, math.Float64frombits(r.order.Uint64(b[8*i:])))
+	}
+	return c
+}
+
+func (r *wkbReader) coords(hasZ, hasM bool) []Coord {
+	size := 16
+	if hasZ {
+		size += 8
+	}
+	if hasM {
+		size += 8
+	}
+	n := r.count(size)
+	cs := make([]Coord, n)
+	for i := range cs {
+		cs[i] = r.coord(hasZ, hasM)
+	}
+	return cs
+}
+
+func (r *wkbReader) rings(hasZ, hasM bool) [][]Coord {
+	n := r.count(4)
+	rings := make([][]Coord, n)
+	for i := range rings {
+		rings[i] = r.coords(hasZ, hasM)
+	}
+	return rings
+}
+
+func (r *wkbReader) geometry(depth int) *Geometry {
+	if depth > 32 {
+		r.err = errors.New("слишком глубокая вложенность")
+		return nil
+	}
+	switch bo := r.take(1); {
+	case bo == nil:
+		return nil
+	case bo[0] == 0:
+		r.order = binary.BigEndian
+	case bo[0] == 1:
+		r.order = binary.LittleEndian
+	default:
+		r.err = fmt.Errorf("неверный порядок байт %d", bo[0])
+		return nil
+	}
+
+	code := r.uint32()
+	hasZ, hasM := code&ewkbZ != 0, code&ewkbM != 0
+	if code&ewkbSRID != 0 {
+		r.uint32()
+	}
+	code &^= ewkbZ | ewkbM | ewkbSRID
+	switch code / 1000 {
+	case 1:
+		hasZ = true
+	case 2:
+		hasM = true
+	case 3:
+		hasZ, hasM = true, true
+	}
+	name, ok := wkbNames[code%1000]
+	if !ok {
+		if r.err == nil {
+			r.err = fmt.Errorf("тип геометрии %d не поддерживается", code)
+		}
+		return nil
+	}
+
+	g := &Geometry{Type: name}
+	switch name {
+	case "Point":
+		c := r.coord(hasZ, hasM)
+		if c != nil && !(math.IsNaN(c[0]) && math.IsNaN(c[1])) {
+			g.Coordinates = c
+		}
+	case "LineString":
+		g.Coordinates = r.coords(hasZ, hasM)
+	case "Polygon":
+		g.Coordinates = r.rings(hasZ, hasM)
+	default:
+		n := r.count(5)
+		parts := make([]*Geometry, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			part := r.geometry(depth + 1)
+			if r.err == nil && name != "GeometryCollection" && part.Type != name[len("Multi"):] {
+				r.err = fmt.Errorf("%s содержит %s", name, part.Type)
+			}
+			parts = append(parts, part)
+		}
+		if r.err != nil {
+			return nil
+		}
+		switch name {
+		case "MultiPoint":
+			cs := make([]Coord, 0, len(parts))
+			for _, p := range parts {
+				if c := coords[Coord](p); c != nil {
+					cs = append(cs, c)
+				}
+			}
+			g.Coordinates = cs
+		case "MultiLineString":
+			cs := make([][]Coord, len(parts))
+			for i, p := range parts {
+				cs[i] = coords[[]Coord](p)
+			}
+			g.Coordinates = cs
+		case "MultiPolygon":
+			cs := make([][][]Coord, len(parts))
+			for i, p := range parts {
+				cs[i] = coords[[][]Coord](p)
+			}
+			g.Coordinates = cs
+		case "GeometryCollection":
+			g.Geometries = parts
+		}
+	}
+	if r.err != nil {
+		return nil
+	}
+	return g
+}
+
+// AppendWKB дописывает геометрию в ISO WKB с порядком байт little-endian.
+// Если хоть одна координата содержит z, геометрия записывается
+// трёхмерной, недостающие z равны 0.
+func AppendWKB(dst []byte, g *Geometry) ([]byte, error) {
+	return appendWKB(dst, g, HasZ(g))
+}
+
+func appendWKB(dst []byte, g *Geometry, hasZ bool) ([]byte, error) {
+	code, ok := wkbTypes[g.Type]
+	if !ok {
+		return nil, fmt.Errorf("неизвестный тип геометрии %q", g.Type)
+	}
+	if hasZ {
+		code += 1000
+	}
+	dst = append(dst, 1)
+	dst = binary.LittleEndian.AppendUint32(dst, code)
+
+	coord := func(dst []byte, c Coord) []byte {
+		for i := 0; i < 2; i++ {
+			v := math.NaN()
+			if i < len(c) {
+				v = c[i]
+			}
+			dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(v))
+		}
+		if hasZ {
+			z := 0.0
+			if len(c) > 2 {
+				z = c[2]
+			}
+			dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(z))
+		}
+		return dst
+	}
+	line := func(dst []byte, cs []Coord) []byte {
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(cs)))
+		for _, c := range cs {
+			dst = coord(dst, c)
+		}
+		return dst
+	}
+	polygon := func(dst []byte, rings [][]Coord) []byte {
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(rings)))
+		for _, r := range rings {
+			dst = line(dst, r)
+		}
+		return dst
+	}
+	// элемент мультигеометрии — самостоятельная геометрия WKB
+	part := func(dst []byte, typ uint32) []byte {
+		if hasZ {
+			typ += 1000
+		}
+		dst = append(dst, 1)
+		return binary.LittleEndian.AppendUint32(dst, typ)
+	}
+
+	switch g.Type {
+	case "Point":
+		dst = coord(dst, coords[Coord](g)) // пустая точка — NaN, NaN
+	case "LineString":
+		dst = line(dst, coords[[]Coord](g))
+	case "Polygon":
+		dst = polygon(dst, coords[[][]Coord](g))
+	case "MultiPoint":
+		cs := coords[[]Coord](g)
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(cs)))
+		for _, c := range cs {
+			dst = coord(part(dst, 1), c)
+		}
+	case "MultiLineString":
+		ls := coords[[][]Coord](g)
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(ls)))
+		for _, l := range ls {
+			dst = line(part(dst, 2), l)
+		}
+	case "MultiPolygon":
+		ps := coords[[][][]Coord](g)
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(ps)))
+		for _, p := range ps {
+			dst = polygon(part(dst, 3), p)
+		}
+	case "GeometryCollection":
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(g.Geometries)))
+		for _, child := range g.Geometries {
+			if child == nil {
+				return nil, errors.New("пустой элемент GeometryCollection")
+			}
+			var err error
+			if dst, err = appendWKB(dst, child, hasZ); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,153 @@
+package geo
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWKBRoundTrip(t *testing.T) {
+	for _, wkt := range canonicalWKT {
+		t.Run(wkt, func(t *testing.T) {
+			g, err := ParseWKT(wkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := AppendWKB(nil, g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseWKB(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// пустые геометрии WKB читаются пустыми срезами, а не nil,
+			// поэтому сравниваем записи WKT
+			if s := FormatWKT(got); s != wkt {
+				t.Errorf("ParseWKB(AppendWKB) = %s", s)
+			}
+			if strings.HasSuffix(wkt, "EMPTY") {
+				return // в GeoJSON у пустой геометрии нет координат
+			}
+
+			// и через GeoJSON: так геометрия приходит из API и уходит в ответ
+			raw, err := json.Marshal(g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fromJSON Geometry
+			if err := json.Unmarshal(raw, &fromJSON); err != nil {
+				t.Fatalf("%s: %v", raw, err)
+			}
+			if !reflect.DeepEqual(&fromJSON, g) {
+				t.Errorf("через GeoJSON %s: %s", raw, FormatWKT(&fromJSON))
+			}
+		})
+	}
+}
+
+// wkbBuilder собирает WKB вручную, чтобы проверить чтение EWKB,
+// big-endian и координат M.
+type wkbBuilder struct {
+	b     []byte
+	order binary.AppendByteOrder
+}
+
+func (w *wkbBuilder) header(code uint32) *wkbBuilder {
+	if w.order == binary.BigEndian {
+		w.b = append(w.b, 0)
+	} else {
+		w.b = append(w.b, 1)
+	}
+	w.b = w.order.AppendUint32(w.b, code)
+	return w
+}
+
+func (w *wkbBuilder) u32(v uint32) *wkbBuilder {
+	w.b = w.order.AppendUint32(w.b, v)
+	return w
+}
+
+func (w *wkbBuilder) f(vs ...float64) *wkbBuilder {
+	for _, v := range vs {
+		w.b = w.order.AppendUint64(w.b, math.Float64bits(v))
+	}
+	return w
+}
+
+func TestParseWKB(t *testing.T) {
+	var le, be binary.AppendByteOrder = binary.LittleEndian, binary.BigEndian
+	tests := []struct {
+		name string
+		wkb  []byte
+		want string // FormatWKT, "" — ждём ошибку
+	}{
+		{"big-endian", (&wkbBuilder{order: be}).header(1).f(1, 2).b, "POINT (1 2)"},
+		{"EWKB с SRID и Z", (&wkbBuilder{order: le}).header(ewkbZ|ewkbSRID|1).u32(4326).f(1, 2, 3).b, "POINT Z (1 2 3)"},
+		{"EWKB с M", (&wkbBuilder{order: le}).header(ewkbM|2).u32(2).f(0, 0, 9, 1, 1, 9).b, "LINESTRING (0 0, 1 1)"},
+		{"ISO ZM", (&wkbBuilder{order: le}).header(3001).f(1, 2, 3, 4).b, "POINT Z (1 2 3)"},
+		{"ISO M", (&wkbBuilder{order: be}).header(2001).f(1, 2, 4).b, "POINT (1 2)"},
+		{"пустая точка", (&wkbBuilder{order: le}).header(1).f(math.NaN(), math.NaN()).b, "POINT EMPTY"},
+		{
+			"мультиточка EWKB с порядком байт у каждой части",
+			append((&wkbBuilder{order: le}).header(ewkbSRID|4).u32(3857).u32(2).header(1).f(1, 2).b,
+				(&wkbBuilder{order: be}).header(1).f(3, 4).b...),
+			"MULTIPOINT ((1 2), (3 4))",
+		},
+		{"неизвестный тип", (&wkbBuilder{order: le}).header(8).u32(0).b, ""},
+		{"неверный порядок байт", []byte{2, 1, 0, 0, 0}, ""},
+		{"обрезанная координата", (&wkbBuilder{order: le}).header(1).f(1).b, ""},
+		{"огромное число точек", (&wkbBuilder{order: le}).header(2).u32(1 << 30).b, ""},
+		{"пусто", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := ParseWKB(tt.wkb)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("ждали ошибку, получили %s", FormatWKT(g))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FormatWKT(g); got != tt.want {
+				t.Errorf("получили %s, ждали %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWKBNesting(t *testing.T) {
+	w := &wkbBuilder{order: binary.LittleEndian}
+	for range 40 {
+		w.header(7).u32(1)
+	}
+	w.header(1).f(0, 0)
+	if _, err := ParseWKB(w.b); err == nil {
+		t.Error("ждали ошибку вложенности")
+	}
+}
+
+func TestAppendWKBMixedZ(t *testing.T) {
+	// z хотя бы у одной координаты делает трёхмерной всю геометрию
+	g := &Geometry{Type: "LineString", Coordinates: []Coord{{0, 0}, {1, 1, 5}}}
+	b, err := AppendWKB(nil, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := binary.LittleEndian.Uint32(b[1:]); code != 1002 {
+		t.Errorf("код типа %d, ждали 1002", code)
+	}
+	got, err := ParseWKB(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := FormatWKT(got); s != "LINESTRING Z (0 0 0, 1 1 5)" {
+		t.Errorf("получили %s", s)
+	}
+}
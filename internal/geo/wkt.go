@@ -0,0 +1,366 @@
+package geo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// wktTypes сопоставляет ключевые слова WKT типам GeoJSON.
+var wktTypes = map[string]string{
+	"POINT":              "Point",
+	"MULTIPOINT":         "MultiPoint",
+	"LINESTRING":         "LineString",
+	"MULTILINESTRING":    "MultiLineString",
+	"POLYGON":            "Polygon",
+	"MULTIPOLYGON":       "MultiPolygon",
+	"GEOMETRYCOLLECTION": "GeometryCollection",
+}
+
+// IsWKTKeyword сообщает, начинается ли с этого слова геометрия WKT.
+func IsWKTKeyword(word string) bool {
+	_, ok := wktTypes[strings.ToUpper(word)]
+	return ok
+}
+
+// ParseWKT разбирает геометрию в формате Well-Known Text (OGC 06-103r4).
+// Измерение M отбрасывается, Z сохраняется третьей координатой.
+func ParseWKT(s string) (*Geometry, error) {
+	p := &wktParser{src: s}
+	g, err := p.geometry()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return nil, p.errorf("лишние символы после геометрии")
+	}
+	return g, nil
+}
+
+type wktParser struct {
+	src  string
+	pos  int
+	dims int // число координат в точке с учётом Z и M
+	hasM bool
+}
+
+func (p *wktParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("WKT, позиция %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *wktParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || p.src[p.pos] == '_') {
+		p.pos++
+	}
+	return strings.ToUpper(p.src[start:p.pos])
+}
+
+func (p *wktParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *wktParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("ожидался символ %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *wktParser) geometry() (*Geometry, error) {
+	kw := p.word()
+	typ, ok := wktTypes[kw]
+	if !ok {
+		return nil, p.errorf("неизвестный тип геометрии %q", kw)
+	}
+
+	p.dims, p.hasM = 2, false
+	save := p.pos
+	switch p.word() {
+	case "Z":
+		p.dims = 3
+	case "M":
+		p.dims, p.hasM = 3, true
+	case "ZM":
+		p.dims, p.hasM = 4, true
+	case "EMPTY":
+		return &Geometry{Type: typ}, nil
+	default:
+		p.pos = save
+	}
+	save = p.pos
+	if p.word() == "EMPTY" {
+		return &Geometry{Type: typ}, nil
+	}
+	p.pos = save
+
+	g := &Geometry{Type: typ}
+	var err error
+	switch typ {
+	case "Point":
+		var pts []Coord
+		pts, err = p.coordList()
+		if err == nil && len(pts) != 1 {
+			err = p.errorf("точка должна иметь одну координату")
+		}
+		if err == nil {
+			g.Coordinates = pts[0]
+		}
+	case "LineString":
+		g.Coordinates, err = p.coordList()
+	case "MultiPoint":
+		g.Coordinates, err = p.multiPoint()
+	case "Polygon", "MultiLineString":
+		g.Coordinates, err = p.coordLists()
+	case "MultiPolygon":
+		var polys [][][]Coord
+		err = p.list(func() error {
+			rings, err := p.coordLists()
+			polys = append(polys, rings)
+			return err
+		})
+		g.Coordinates = polys
+	case "GeometryCollection":
+		err = p.list(func() error {
+			child, err := p.geometry()
+			g.Geometries = append(g.Geometries, child)
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// list разбирает "(" элемент {"," элемент} ")".
+func (p *wktParser) list(item func() error) error {
+	if err := p.expect('('); err != nil {
+		return err
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	return p.expect(')')
+}
+
+func (p *wktParser) coordList() ([]Coord, error) {
+	var pts []Coord
+	err := p.list(func() error {
+		c, err := p.coord()
+		pts = append(pts, c)
+		return err
+	})
+	return pts, err
+}
+
+func (p *wktParser) coordLists() ([][]Coord, error) {
+	var lists [][]Coord
+	err := p.list(func() error {
+		pts, err := p.coordList()
+		lists = append(lists, pts)
+		return err
+	})
+	return lists, err
+}
+
+// multiPoint принимает обе записи: MULTIPOINT ((1 2), (3 4)) и MULTIPOINT (1 2, 3 4).
+func (p *wktParser) multiPoint() ([]Coord, error) {
+	var pts []Coord
+	err := p.list(func() error {
+		if p.peek() == '(' {
+			inner, err := p.coordList()
+			if err == nil && len(inner) != 1 {
+				err = p.errorf("точка должна иметь одну координату")
+			}
+			if err != nil {
+				return err
+			}
+			pts = append(pts, inner[0])
+			return nil
+		}
+		c, err := p.coord()
+		pts = append(pts, c)
+		return err
+	})
+	return pts, err
+}
+
+func (p *wktParser) coord() (Coord, error) {
+	var c Coord
+	for {
+		p.skipSpace()
+		start := p.pos
+		for p.pos < len(p.src) && strings.IndexByte("+-.0123456789eE", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		if start == p.pos {
+			break
+		}
+		text := p.src[start:p.pos]
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("неверное число %q", text)
+		}
+		c = append(c, v)
+	}
+	if len(c) < 2 {
+		return nil, p.errorf("у координаты меньше двух чисел")
+	}
+	if p.dims > 2 && len(c) != p.dims {
+		return nil, p.errorf("ожидалось %d числа в координате", p.dims)
+	}
+	if p.hasM {
+		// измерение M в GeoJSON не передаётся
+		if p.dims == 4 {
+			c = c[:3]
+		} else {
+			c = c[:2]
+		}
+	}
+	if len(c) > 3 {
+		c = c[:3]
+	}
+	return c, nil
+}
+
+// FormatWKT записывает геометрию в WKT. Геометрия с координатами z
+// записывается с ключевым словом Z; пустая — как, например, POINT EMPTY.
+func FormatWKT(g *Geometry) string {
+	var b strings.Builder
+	appendWKT(&b, g, HasZ(g))
+	return b.String()
+}
+
+func appendWKT(b *strings.Builder, g *Geometry, hasZ bool) {
+	b.WriteString(strings.ToUpper(g.Type))
+	if hasZ {
+		b.WriteString(" Z")
+	}
+
+	coord := func(c Coord) {
+		for i := 0; i < 2 || (hasZ && i < 3); i++ {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			v := 0.0
+			if i < len(c) {
+				v = c[i]
+			}
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	line := func(cs []Coord) {
+		b.WriteByte('(')
+		for i, c := range cs {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			coord(c)
+		}
+		b.WriteByte(')')
+	}
+	lines := func(ls [][]Coord) {
+		b.WriteByte('(')
+		for i, l := range ls {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			line(l)
+		}
+		b.WriteByte(')')
+	}
+
+	switch g.Type {
+	case "Point":
+		c := coords[Coord](g)
+		if c == nil {
+			b.WriteString(" EMPTY")
+			return
+		}
+		b.WriteString(" (")
+		coord(c)
+		b.WriteByte(')')
+		return
+	case "GeometryCollection":
+		if len(g.Geometries) == 0 {
+			b.WriteString(" EMPTY")
+			return
+		}
+		b.WriteString(" (")
+		for i, child := range g.Geometries {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if child != nil {
+				appendWKT(b, child, hasZ)
+			}
+		}
+		b.WriteByte(')')
+		return
+	}
+
+	empty := false
+	switch c := g.Coordinates.(type) {
+	case []Coord:
+		empty = len(c) == 0
+	case [][]Coord:
+		empty = len(c) == 0
+	case [][][]Coord:
+		empty = len(c) == 0
+	default:
+		empty = true
+	}
+	if empty {
+		b.WriteString(" EMPTY")
+		return
+	}
+	b.WriteByte(' ')
+	switch g.Type {
+	case "LineString":
+		line(coords[[]Coord](g))
+	case "MultiPoint":
+		// точки в скобках: MULTIPOINT ((1 2), (3 4))
+		lines(splitPoints(coords[[]Coord](g)))
+	case "Polygon", "MultiLineString":
+		lines(coords[[][]Coord](g))
+	case "MultiPolygon":
+		b.WriteByte('(')
+		for i, p := range coords[[][][]Coord](g) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			lines(p)
+		}
+		b.WriteByte(')')
+	}
+}
+
+func splitPoints(cs []Coord) [][]Coord {
+	out := make([][]Coord, len(cs))
+	for i, c := range cs {
+		out[i] = []Coord{c}
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package geo
+
+import (
+	"reflect"
+	"testing"
+)
+
+// canonicalWKT — геометрии в записи FormatWKT: разбор и запись обратно
+// должны давать ту же строку.
+var canonicalWKT = []string{
+	"POINT (30.5 59.9)",
+	"POINT Z (1 2 3)",
+	"POINT EMPTY",
+	"LINESTRING (0 0, 1 1, 2 0.000001)",
+	"LINESTRING EMPTY",
+	"POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0), (2 2, 2 4, 4 4, 4 2, 2 2))",
+	"MULTIPOINT ((1 2), (3 4))",
+	"MULTILINESTRING ((0 0, 1 1), (2 2, 3 3))",
+	"MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)), ((5 5, 6 5, 6 6, 5 5), (5.2 5.1, 5.3 5.1, 5.3 5.2, 5.2 5.1)))",
+	"GEOMETRYCOLLECTION (POINT (1 2), LINESTRING (0 0, 1 1))",
+	"GEOMETRYCOLLECTION Z (POINT Z (1 2 3), LINESTRING Z (0 0 0, 1 1 0))",
+	"GEOMETRYCOLLECTION EMPTY",
+	"POINT (-179.99999999999997 0.30000000000000004)",
+}
+
+func TestWKTRoundTrip(t *testing.T) {
+	for _, wkt := range canonicalWKT {
+		t.Run(wkt, func(t *testing.T) {
+			g, err := ParseWKT(wkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FormatWKT(g); got != wkt {
+				t.Errorf("FormatWKT = %q", got)
+			}
+			again, err := ParseWKT(FormatWKT(g))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again, g) {
+				t.Errorf("после записи и разбора %#v, ждали %#v", again, g)
+			}
+		})
+	}
+}
+
+func TestParseWKT(t *testing.T) {
+	tests := []struct {
+		wkt  string
+		want string // FormatWKT результата, "" — ждём ошибку
+	}{
+		{"point(1 2)", "POINT (1 2)"},
+		{"  Point ( 1   2 )  ", "POINT (1 2)"},
+		{"MULTIPOINT (1 2, 3 4)", "MULTIPOINT ((1 2), (3 4))"},
+		{"POINT M (1 2 5)", "POINT (1 2)"},
+		{"POINT ZM (1 2 3 4)", "POINT Z (1 2 3)"},
+		{"LINESTRING Z (0 0 1, 1 1 2)", "LINESTRING Z (0 0 1, 1 1 2)"},
+		{"POLYGON EMPTY", "POLYGON EMPTY"},
+		{"POINT Z EMPTY", "POINT EMPTY"},
+		{"POINT (+1 -2.5e1)", "POINT (1 -25)"},
+		{"CIRCULARSTRING (0 0, 1 1, 2 0)", ""},
+		{"POINT (1)", ""},
+		{"POINT (1 2, 3 4)", ""},
+		{"POINT Z (1 2)", ""},
+		{"POINT (1 2", ""},
+		{"POINT (1 2) x", ""},
+		{"POINT (1 2 3 4 5)", "POINT Z (1 2 3)"},
+		{"POINT (1 --2)", ""},
+		{"MULTIPOINT ((1 2, 3 4))", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wkt, func(t *testing.T) {
+			g, err := ParseWKT(tt.wkt)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("ждали ошибку, получили %s", FormatWKT(g))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FormatWKT(g); got != tt.want {
+				t.Errorf("получили %s, ждали %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Datapolis/internal/models"
+)
+
+// featureETag — сильный ETag версии фичи: время её последнего изменения
+// в микросекундах (точность timestamptz). Система координат в ответе
+// задаётся параметром запроса, то есть URL, поэтому в ETag не входит.
+func featureETag(f *models.GeoJSONFeature) string {
+	return `"` + strconv.FormatInt(f.UpdatedAt.UnixMicro(), 10) + `"`
+}
+
+// setFeatureETag добавляет к ответу ETag фичи.
+func setFeatureETag(c *gin.Context, f *models.GeoJSONFeature) {
+	c.Header("ETag", featureETag(f))
+}
+
+// etagList разбирает список ETag из If-Match / If-None-Match; "*"
+// возвращается как есть.
+func etagList(header string) []string {
+	var tags []string
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// ifMatch разбирает обязательный заголовок If-Match изменяющего запроса
+// и возвращает версии фичи (updated_at), с которыми допустимо изменение;
+// nil — подходит любая ("*"). Без заголовка отвечает 428 и возвращает
+// ok=false. Слабые и чужие ETag не совпадают ни с одной версией.
+func ifMatch(c *gin.Context) (versions []time.Time, ok bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "нужен заголовок If-Match с ETag фичи"})
+		return nil, false
+	}
+	versions = []time.Time{}
+	for _, tag := range etagList(header) {
+		if tag == "*" {
+			return nil, true
+		}
+		// If-Match сравнивает ETag строго: слабые не подходят
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		micros, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, time.UnixMicro(micros))
+	}
+	return versions, true
+}
+
+// notModified отвечает 304, если ETag фичи указан в If-None-Match.
+func notModified(c *gin.Context, f *models.GeoJSONFeature) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	etag := featureETag(f)
+	for _, tag := range etagList(header) {
+		// If-None-Match сравнивает слабо: префикс W/ не учитывается
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			setFeatureETag(c, f)
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
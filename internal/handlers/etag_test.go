@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"Datapolis/internal/models"
+)
+
+// testContext создаёт контекст gin для запроса с заголовками headers.
+func testContext(headers map[string]string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/geojson/features/1", nil)
+	for k, v := range headers {
+		c.Request.Header.Set(k, v)
+	}
+	return c, w
+}
+
+func TestIfMatch(t *testing.T) {
+	v1 := time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.UTC)
+	v2 := v1.Add(time.Microsecond)
+	f1 := &models.GeoJSONFeature{UpdatedAt: v1}
+	f2 := &models.GeoJSONFeature{UpdatedAt: v2}
+
+	tests := []struct {
+		name   string
+		header string
+		want   []time.Time // nil — подходит любая версия
+	}{
+		{"ETag фичи", featureETag(f1), []time.Time{v1}},
+		{"список", featureETag(f1) + ", " + featureETag(f2), []time.Time{v1, v2}},
+		{"звёздочка", "*", nil},
+		// такие ETag не совпадают ни с одной версией: обновление получит 412
+		{"слабый", "W/" + featureETag(f1), []time.Time{}},
+		{"без кавычек", "1709287200123456", []time.Time{}},
+		{"чужой", `"abc"`, []time.Time{}},
+		{"слабый и сильный", "W/" + featureETag(f1) + "," + featureETag(f2), []time.Time{v2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := testContext(map[string]string{"If-Match": tt.header})
+			versions, ok := ifMatch(c)
+			if !ok || w.Code != http.StatusOK || c.Writer.Written() {
+				t.Fatalf("ok = %v, ответ %d", ok, w.Code)
+			}
+			if (versions == nil) != (tt.want == nil) || len(versions) != len(tt.want) {
+				t.Fatalf("версии %v, ждали %v", versions, tt.want)
+			}
+			for i := range versions {
+				if !versions[i].Equal(tt.want[i]) {
+					t.Errorf("версия %d: %v, ждали %v", i, versions[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIfMatchRequired(t *testing.T) {
+	c, w := testContext(nil)
+	if versions, ok := ifMatch(c); ok || versions != nil {
+		t.Fatalf("без If-Match: %v, %v", versions, ok)
+	}
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("ответ %d, ждали 428", w.Code)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	f := &models.GeoJSONFeature{UpdatedAt: time.Date(2024, 3, 1, 10, 0, 0, 1000, time.UTC)}
+	other := &models.GeoJSONFeature{UpdatedAt: f.UpdatedAt.Add(time.Second)}
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{featureETag(f), true},
+		{"W/" + featureETag(f), true},
+		{featureETag(other) + ", " + featureETag(f), true},
+		{"*", true},
+		{featureETag(other), false},
+	}
+	for _, tt := range tests {
+		c, w := testContext(map[string]string{"If-None-Match": tt.header})
+		got := notModified(c, f)
+		c.Writer.WriteHeaderNow()
+		if got != tt.want {
+			t.Errorf("If-None-Match %q: %v, ждали %v", tt.header, got, tt.want)
+		}
+		if got && (w.Code != http.StatusNotModified || w.Header().Get("ETag") != featureETag(f)) {
+			t.Errorf("If-None-Match %q: ответ %d, ETag %q", tt.header, w.Code, w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestEtagList(t *testing.T) {
+	got := etagList(` "a" ,W/"b",, * `)
+	if want := []string{`"a"`, `W/"b"`, "*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("etagList = %q, ждали %q", got, want)
+	}
+}
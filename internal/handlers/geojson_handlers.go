@@ -1,20 +1,94 @@
 package handlers
 
 import (
+	"Datapolis/internal/formats"
+	"Datapolis/internal/geo"
 	"Datapolis/internal/models"
 	service "Datapolis/internal/services"
+	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"log"
+	"mime"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 type GeoJSONHandler struct {
 	geoJSONService *service.GeoService
+	importJobs     *service.ImportJobs
 }
 
 // конструктор
-func NewGeoJSONHandler(geoJSONService *service.GeoService) *GeoJSONHandler {
-	return &GeoJSONHandler{geoJSONService: geoJSONService}
+func NewGeoJSONHandler(geoJSONService *service.GeoService, importJobs *service.ImportJobs) *GeoJSONHandler {
+	return &GeoJSONHandler{geoJSONService: geoJSONService, importJobs: importJobs}
+}
+
+// FeatureCollectionResponse — страница фич в виде GeoJSON FeatureCollection.
+type FeatureCollectionResponse struct {
+	Type           string                   `json:"type"`
+	Features       []*models.GeoJSONFeature `json:"features"`
+	NumberMatched  int                      `json:"numberMatched"`
+	NumberReturned int                      `json:"numberReturned"`
+	Links          []models.Link            `json:"links"`
+}
+
+// TopologyResponse — страница фич в виде TopoJSON Topology; фичи лежат
+// в objects.features.
+type TopologyResponse struct {
+	*formats.Topology
+	NumberMatched  int           `json:"numberMatched"`
+	NumberReturned int           `json:"numberReturned"`
+	Links          []models.Link `json:"links"`
+}
+
+// sendFeaturePage отдаёт страницу фич в GeoJSON или, если quantization
+// больше нуля, в TopoJSON с такой сеткой квантования.
+func sendFeaturePage(c *gin.Context, page *models.Page[*models.GeoJSONFeature], quantization int) {
+	if quantization == 0 {
+		c.JSON(http.StatusOK, newFeatureCollectionResponse(c, page))
+		return
+	}
+	tb := formats.NewTopologyBuilder(quantization)
+	for _, f := range page.Items {
+		if err := tb.Add(f); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка построения TopoJSON: " + err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, TopologyResponse{
+		Topology:       tb.Topology(formats.TopologyObject),
+		NumberMatched:  page.NumberMatched,
+		NumberReturned: len(page.Items),
+		Links:          pageLinks(c, page, "application/json"),
+	})
+}
+
+// CollectionListResponse — страница списка коллекций.
+type CollectionListResponse struct {
+	Collections    []*models.GeoJSONCollection `json:"collections"`
+	NumberMatched  int                         `json:"numberMatched"`
+	NumberReturned int                         `json:"numberReturned"`
+	Links          []models.Link               `json:"links"`
+}
+
+func newFeatureCollectionResponse(
+	c *gin.Context, page *models.Page[*models.GeoJSONFeature],
+) FeatureCollectionResponse {
+	features := page.Items
+	if features == nil {
+		features = []*models.GeoJSONFeature{}
+	}
+	return FeatureCollectionResponse{
+		Type:           "FeatureCollection",
+		Features:       features,
+		NumberMatched:  page.NumberMatched,
+		NumberReturned: len(features),
+		Links:          pageLinks(c, page, "application/geo+json"),
+	}
 }
 
 // GetCollection получает коллекцию по ID
@@ -39,7 +113,210 @@ func (h *GeoJSONHandler) GetCollection(c *gin.Context) {
 	c.JSON(http.StatusOK, collection)
 }
 
-// DeleteCollection удаляет коллекцию
+// ExportCollection отдаёт коллекцию файлом для скачивания; формат задаёт
+// параметр format: geojson (по умолчанию), gpkg, csv, kml, gpx, wkt, shp,
+// fgb (FlatGeobuf с пространственным индексом), parquet (GeoParquet) или
+// topojson (параметр quantization — размер сетки квантования).
+// KML, GPX и GeoParquet выгружаются в CRS84: первые два хранят только
+// координаты WGS 84, а GeoParquet без PROJJSON описывает лишь CRS84.
+func (h *GeoJSONHandler) ExportCollection(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "geojson")
+	ef, ok := service.ExportFormats[format]
+	if !ok {
+		names := make([]string, 0, len(service.ExportFormats))
+		for name := range service.ExportFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format: поддерживаются " + strings.Join(names, ", ")})
+		return
+	}
+
+	collection := h.featuresCollection(c, id)
+	if collection == nil {
+		return
+	}
+	srid, ok := h.outputSRID(c, collection)
+	if !ok {
+		return
+	}
+	if ef.WGS84 {
+		if c.Query("crs") != "" && srid != geo.SRID4326 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "crs: формат " + format + " хранит только координаты WGS 84"})
+			return
+		}
+		srid = geo.SRID4326
+		c.Header("Content-Crs", "<"+geo.CRS84URI+">")
+	}
+
+	opts := service.ExportOptions{}
+	if format == "topojson" {
+		if opts.Quantization, err = parseQuantization(c.Query("quantization")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	out := &exportResponse{c: c, contentType: ef.ContentType, disposition: attachment(collection.Name, ef.Ext)}
+	err = h.geoJSONService.Export(c.Request.Context(), collection, srid, format, opts, out)
+	if err == nil {
+		return
+	}
+	if out.started {
+		// заголовки уже отправлены — ошибку посреди потока можно только залогировать
+		log.Printf("Ошибка экспорта коллекции %d: %v", id, err)
+		return
+	}
+	if errors.Is(err, service.ErrUnknownCRS) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка экспорта коллекции: " + err.Error()})
+}
+
+// exportResponse откладывает заголовки ответа до первых данных выгрузки:
+// ошибку, случившуюся раньше (например, в первом проходе по фичам), ещё
+// можно вернуть обычным JSON-ответом.
+type exportResponse struct {
+	c           *gin.Context
+	contentType string
+	disposition string
+	started     bool
+}
+
+func (r *exportResponse) Write(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		r.c.Header("Content-Type", r.contentType)
+		r.c.Header("Content-Disposition", r.disposition)
+		r.c.Status(http.StatusOK)
+	}
+	return r.c.Writer.Write(p)
+}
+
+// ExportCollections выгружает несколько коллекций одним файлом GeoPackage:
+// GET /geojson/export?collections=1,2,3[&crs=...]. Без crs каждый слой
+// остаётся в системе координат своей коллекции.
+func (h *GeoJSONHandler) ExportCollections(c *gin.Context) {
+	if format := c.DefaultQuery("format", "gpkg"); format != "gpkg" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format: несколько коллекций выгружаются только в gpkg"})
+		return
+	}
+	raw := c.Query("collections")
+	if raw == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "collections: укажите ID коллекций через запятую"})
+		return
+	}
+
+	var cols []*models.GeoJSONCollection
+	seen := map[int]bool{}
+	for _, part := range strings.Split(raw, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "collections: неверный ID " + strconv.Quote(part)})
+			return
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		col := h.featuresCollection(c, id)
+		if col == nil {
+			return
+		}
+		cols = append(cols, col)
+	}
+
+	srid := 0
+	if crs := c.Query("crs"); crs != "" {
+		var err error
+		srid, err = h.geoJSONService.ResolveSRID(c.Request.Context(), crs)
+		if errors.Is(err, service.ErrUnknownCRS) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки системы координат: " + err.Error()})
+			return
+		}
+	}
+
+	name := "collections"
+	if len(cols) == 1 {
+		name = cols[0].Name
+	}
+	h.sendGeoPackage(c, cols, srid, name)
+}
+
+// sendGeoPackage собирает GeoPackage во временном файле и отдаёт его.
+func (h *GeoJSONHandler) sendGeoPackage(c *gin.Context, cols []*models.GeoJSONCollection, srid int, name string) {
+	path, err := h.geoJSONService.ExportGeoPackage(c.Request.Context(), cols, srid)
+	if errors.Is(err, service.ErrUnknownCRS) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка экспорта в GeoPackage: " + err.Error()})
+		return
+	}
+	defer os.Remove(path)
+
+	c.Header("Content-Type", "application/geopackage+sqlite3")
+	c.Header("Content-Disposition", attachment(name, ".gpkg"))
+	c.File(path)
+}
+
+// attachment формирует Content-Disposition для скачивания файла name+ext.
+func attachment(name, ext string) string {
+	if name == "" {
+		name = "collection"
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name + ext})
+}
+
+// outputSRID выбирает систему координат ответа по параметру crs
+// (по умолчанию — SRID хранения коллекции) и объявляет её в заголовке
+// Content-Crs. При ошибке ответ уже отправлен и возвращается false.
+func (h *GeoJSONHandler) outputSRID(c *gin.Context, col *models.GeoJSONCollection) (int, bool) {
+	srid := col.SRID
+	if raw := c.Query("crs"); raw != "" {
+		var err error
+		srid, err = h.geoJSONService.ResolveSRID(c.Request.Context(), raw)
+		if errors.Is(err, service.ErrUnknownCRS) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return 0, false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки системы координат: " + err.Error()})
+			return 0, false
+		}
+	}
+	c.Header("Content-Crs", "<"+geo.CRSURI(srid)+">")
+	return srid, true
+}
+
+// featuresCollection получает коллекцию для выдачи её фич; при ошибке
+// ответ уже отправлен и возвращается nil.
+func (h *GeoJSONHandler) featuresCollection(c *gin.Context, id int) *models.GeoJSONCollection {
+	collection, err := h.geoJSONService.GetCollection(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении коллекции: " + err.Error()})
+		return nil
+	}
+	if collection == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Коллекция не найдена"})
+		return nil
+	}
+	return collection
+}
+
+// DeleteCollection переносит коллекцию с её фичами в корзину
 func (h *GeoJSONHandler) DeleteCollection(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -57,7 +334,9 @@ func (h *GeoJSONHandler) DeleteCollection(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// GetFeatures получает все фичи коллекции
+// GetFeatures получает фичи коллекции, отобранные по параметрам запроса;
+// crs задаёт систему координат геометрий в ответе, format=topojson —
+// ответ в TopoJSON (quantization — размер сетки квантования)
 func (h *GeoJSONHandler) GetFeatures(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -65,13 +344,225 @@ func (h *GeoJSONHandler) GetFeatures(c *gin.Context) {
 		return
 	}
 
-	features, err := h.geoJSONService.GetFeatures(c.Request.Context(), id)
+	query, err := parseFeatureQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	quantization, err := parsePageFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	collection := h.featuresCollection(c, id)
+	if collection == nil {
+		return
+	}
+	srid, ok := h.outputSRID(c, collection)
+	if !ok {
+		return
+	}
+	if srid != collection.SRID {
+		query.OutputSRID = srid
+	}
+
+	page, err := h.geoJSONService.GetFeatures(c.Request.Context(), id, query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении фич: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, features)
+	sendFeaturePage(c, page, quantization)
+}
+
+// SpatialQueryRequest — тело запроса фич по пространственному предикату.
+type SpatialQueryRequest struct {
+	Predicate string          `json:"predicate" binding:"required,oneof=intersects within contains touches dwithin"`
+	Geometry  models.JSONData `json:"geometry" binding:"required"`
+	Distance  float64         `json:"distance"` // метры, только для dwithin
+	CRS       string          `json:"crs"`      // по умолчанию EPSG:4326
+}
+
+// QueryFeatures отбирает фичи коллекции по отношению к переданной геометрии
+func (h *GeoJSONHandler) QueryFeatures(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return
+	}
+
+	var req SpatialQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных: " + err.Error()})
+		return
+	}
+	spatial, err := parseSpatialQuery(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query, err := parseFeatureQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	quantization, err := parsePageFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	collection := h.featuresCollection(c, id)
+	if collection == nil {
+		return
+	}
+	// crs запроса задаёт систему координат ответа, crs тела — геометрии фильтра
+	outSRID, ok := h.outputSRID(c, collection)
+	if !ok {
+		return
+	}
+	if outSRID != collection.SRID {
+		query.OutputSRID = outSRID
+	}
+	query.Spatial = spatial
+
+	page, err := h.geoJSONService.GetFeatures(c.Request.Context(), id, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении фич: " + err.Error()})
+		return
+	}
+
+	sendFeaturePage(c, page, quantization)
+}
+
+// GetTile отдаёт векторный тайл коллекции в формате Mapbox Vector Tile.
+// Параметры: properties — список свойств через запятую (по умолчанию все),
+// simplify — допуск упрощения в пикселях тайла (по умолчанию 1).
+func (h *GeoJSONHandler) GetTile(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return
+	}
+	z, x, y, err := parseTileCoords(c.Param("z"), c.Param("x"), strings.TrimSuffix(c.Param("y"), ".mvt"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := models.TileOptions{Simplify: 1}
+	if raw, ok := c.GetQuery("properties"); ok {
+		opts.Properties = []string{}
+		for _, p := range strings.Split(raw, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				opts.Properties = append(opts.Properties, p)
+			}
+		}
+	}
+	if raw := c.Query("simplify"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "simplify должен быть неотрицательным числом"})
+			return
+		}
+		opts.Simplify = v
+	}
+
+	tile, err := h.geoJSONService.GetTile(c.Request.Context(), id, z, x, y, opts)
+	if err != nil {
+		if errors.Is(err, service.ErrCollectionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Коллекция не найдена"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при построении тайла: " + err.Error()})
+		return
+	}
+	if len(tile) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/vnd.mapbox-vector-tile", tile)
+}
+
+// GetFeature отдаёт фичу по ID; crs задаёт систему координат геометрии
+func (h *GeoJSONHandler) GetFeature(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return
+	}
+
+	feature, err := h.geoJSONService.GetFeatureByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка поиска фичи: " + err.Error()})
+		return
+	}
+	if feature == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Фича не найдена"})
+		return
+	}
+	collection := h.featuresCollection(c, feature.CollectionID)
+	if collection == nil {
+		return
+	}
+	srid, ok := h.outputSRID(c, collection)
+	if !ok {
+		return
+	}
+	if notModified(c, feature) {
+		return
+	}
+	if srid != collection.SRID {
+		if feature, err = h.geoJSONService.GetFeatureInSRID(c.Request.Context(), id, srid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка поиска фичи: " + err.Error()})
+			return
+		}
+		// фичу могли удалить между двумя чтениями
+		if feature == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Фича не найдена"})
+			return
+		}
+	}
+
+	setFeatureETag(c, feature)
+	c.JSON(http.StatusOK, feature)
+}
+
+// GetFeatureHistory отдаёт версии фичи от создания до последнего изменения
+// или удаления; crs задаёт систему координат геометрии
+func (h *GeoJSONHandler) GetFeatureHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return
+	}
+
+	srid := 0
+	if raw := c.Query("crs"); raw != "" {
+		srid, err = h.geoJSONService.ResolveSRID(c.Request.Context(), raw)
+		if errors.Is(err, service.ErrUnknownCRS) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки системы координат: " + err.Error()})
+			return
+		}
+		c.Header("Content-Crs", "<"+geo.CRSURI(srid)+">")
+	}
+
+	versions, err := h.geoJSONService.GetFeatureHistory(c.Request.Context(), id, srid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения истории фичи: " + err.Error()})
+		return
+	}
+	if len(versions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Фича не найдена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"feature_id": id, "versions": versions})
 }
 
 // AddFeature добавляет новую фичу в коллекцию
@@ -90,14 +581,35 @@ func (h *GeoJSONHandler) AddSingleFeature(c *gin.Context) {
 	}
 	feature.CollectionID = cid
 
-	if err := h.geoJSONService.AddSingleFeature(c.Request.Context(), &feature); err != nil {
+	repair, err := parseRepairMode(c.Query("repair"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	err = h.geoJSONService.AddSingleFeature(c.Request.Context(), &feature, repair, userID)
+	if errors.Is(err, service.ErrInvalidGeometry) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrCollectionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Коллекция не найдена"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при добавлении фичи: " + err.Error()})
 		return
 	}
+	setFeatureETag(c, &feature)
 	c.JSON(http.StatusCreated, feature)
 }
 
-// UpdateFeature обновляет фичу
+// UpdateFeature обновляет фичу; If-Match с ETag фичи обязателен и защищает
+// от перезаписи чужих правок (412, если фича изменилась)
 func (h *GeoJSONHandler) UpdateFeature(c *gin.Context) {
 	// 1) Парсим ID фичи из URL
 	id, err := strconv.Atoi(c.Param("id"))
@@ -113,6 +625,20 @@ func (h *GeoJSONHandler) UpdateFeature(c *gin.Context) {
 	}
 	input.ID = id
 
+	repair, err := parseRepairMode(c.Query("repair"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	versions, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
 	existing, err := h.geoJSONService.GetFeatureByID(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка поиска фичи: " + err.Error()})
@@ -125,25 +651,120 @@ func (h *GeoJSONHandler) UpdateFeature(c *gin.Context) {
 	input.CollectionID = existing.CollectionID
 
 	// 4) Выполняем обновление
-	if err := h.geoJSONService.UpdateFeature(c.Request.Context(), &input); err != nil {
+	err = h.geoJSONService.UpdateFeature(c.Request.Context(), &input, repair, userID, versions)
+	switch {
+	case errors.Is(err, service.ErrInvalidGeometry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrFeatureNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Фича не найдена"})
+		return
+	case errors.Is(err, service.ErrPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении фичи: " + err.Error()})
 		return
 	}
 
 	// 5) Отдаём обновлённую фичу (можно вернуть input или снова подгрузить из БД)
+	setFeatureETag(c, &input)
 	c.JSON(http.StatusOK, input)
 }
 
-// DeleteFeature удаляет фичу
+// patchMediaTypes — поддерживаемые типы тела PATCH и форматы патча.
+var patchMediaTypes = map[string]string{
+	"application/merge-patch+json": models.PatchMerge,
+	"application/json-patch+json":  models.PatchJSON,
+}
+
+// PatchFeature частично изменяет фичу: тело — JSON Merge Patch (RFC 7396)
+// или JSON Patch (RFC 6902) к документу {"properties": ..., "geometry": ...},
+// формат задаёт Content-Type. If-Match обязателен, как в UpdateFeature
+func (h *GeoJSONHandler) PatchFeature(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID фичи"})
+		return
+	}
+	kind, ok := patchMediaTypes[c.ContentType()]
+	if !ok {
+		c.Header("Accept-Patch", "application/merge-patch+json, application/json-patch+json")
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "Content-Type должен быть application/merge-patch+json или application/json-patch+json",
+		})
+		return
+	}
+	patch, err := c.GetRawData()
+	if err != nil || !json.Valid(patch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Тело запроса должно быть JSON"})
+		return
+	}
+
+	repair, err := parseRepairMode(c.Query("repair"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	versions, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	feature, err := h.geoJSONService.PatchFeature(c.Request.Context(), id, kind,
+		models.JSONData(patch), repair, userID, versions)
+	var patchErr *models.PatchError
+	switch {
+	case errors.As(err, &patchErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": patchErr.Error()})
+		return
+	case errors.Is(err, service.ErrInvalidGeometry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrFeatureNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Фича не найдена"})
+		return
+	case errors.Is(err, service.ErrPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при изменении фичи: " + err.Error()})
+		return
+	}
+	setFeatureETag(c, feature)
+	c.JSON(http.StatusOK, feature)
+}
+
+// DeleteFeature переносит фичу в корзину; If-Match — как в UpdateFeature
 func (h *GeoJSONHandler) DeleteFeature(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
 		return
 	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
 
-	err = h.geoJSONService.DeleteFeature(c.Request.Context(), id)
-	if err != nil {
+	versions, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+
+	err = h.geoJSONService.DeleteFeature(c.Request.Context(), id, userID, versions)
+	switch {
+	case errors.Is(err, service.ErrFeatureNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Фича не найдена"})
+		return
+	case errors.Is(err, service.ErrPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при удалении фичи: " + err.Error()})
 		return
 	}
@@ -151,53 +772,178 @@ func (h *GeoJSONHandler) DeleteFeature(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// GetAllCollections получает все коллекции
+// RevertFeature возвращает фичу к версии из её истории (?version=N).
+// Отдаёт фичу после возврата или 204, если версия — удаление
+func (h *GeoJSONHandler) RevertFeature(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return
+	}
+	version, err := strconv.Atoi(c.Query("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version должен быть положительным целым числом"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	feature, err := h.geoJSONService.RevertFeature(c.Request.Context(), id, version, userID)
+	switch {
+	case errors.Is(err, service.ErrVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrCollectionNotFound):
+		c.JSON(http.StatusConflict, gin.H{"error": "Коллекция фичи удалена"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при возврате фичи: " + err.Error()})
+		return
+	}
+	if feature == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	setFeatureETag(c, feature)
+	c.JSON(http.StatusOK, feature)
+}
+
+// RevertCollection возвращает фичи коллекции к состоянию на момент as_of
+func (h *GeoJSONHandler) RevertCollection(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return
+	}
+	asOf, err := parseTimestamp(c.Query("as_of"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of: ожидается дата RFC 3339"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.geoJSONService.RevertCollection(c.Request.Context(), id, asOf, userID)
+	if errors.Is(err, service.ErrCollectionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Коллекция не найдена"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при возврате коллекции: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GetAllCollections получает страницу коллекций
 func (h *GeoJSONHandler) GetAllCollections(c *gin.Context) {
-	collections, err := h.geoJSONService.GetAllCollections(c.Request.Context())
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.geoJSONService.GetAllCollections(c.Request.Context(), pageReq)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении коллекций: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, collections)
+	collections := page.Items
+	if collections == nil {
+		collections = []*models.GeoJSONCollection{}
+	}
+	c.JSON(http.StatusOK, CollectionListResponse{
+		Collections:    collections,
+		NumberMatched:  page.NumberMatched,
+		NumberReturned: len(collections),
+		Links:          pageLinks(c, page, "application/json"),
+	})
 }
 
+// UploadGeoJSONBulk ставит импорт загруженного файла в очередь и сразу
+// отвечает 202 с задачей; ход импорта — GET /admin/geojson/imports/:jobId
 func (h *GeoJSONHandler) UploadGeoJSONBulk(c *gin.Context) {
-	file, _, err := c.Request.FormFile("file")
+	file, header, err := c.Request.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Файл не найден: " + err.Error()})
 		return
 	}
 	defer file.Close()
 
+	format, err := uploadFormat(c.PostForm("format"), header.Filename)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// коллекции из GeoPackage без имени называются по слоям
 	name := c.PostForm("name")
-	if name == "" {
+	if name == "" && format != models.FormatGeoPackage {
 		name = "unnamed_collection"
 	}
 	description := c.PostForm("description")
 
-	uidIfc, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неавторизованный запрос"})
+	// source_srid — система координат файла, если в нём нет члена crs;
+	// target_srid — SRID хранения коллекции
+	var opts models.ImportOptions
+	for field, dst := range map[string]*int{"source_srid": &opts.SourceSRID, "target_srid": &opts.TargetSRID} {
+		if raw := c.PostForm(field); raw != "" {
+			srid, err := geo.ParseSRID(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": field + ": " + err.Error()})
+				return
+			}
+			*dst = srid
+		}
+	}
+	if opts.Repair, err = parseRepairMode(c.PostForm("repair")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// encoding — кодировка атрибутов Shapefile (если .cpg нет или он неверен) или CSV
+	if opts.Encoding = c.PostForm("encoding"); opts.Encoding != "" {
+		if _, err := formats.ParseCodePage(opts.Encoding); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "encoding: " + err.Error()})
+			return
+		}
+	}
+	// layer — импортировать только эту таблицу GeoPackage
+	opts.Layer = c.PostForm("layer")
+	// CSV: разделитель и столбцы координат или WKT
+	if opts.Delimiter, err = parseDelimiter(c.PostForm("delimiter")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	userID, ok := uidIfc.(int)
+	opts.LatColumn = c.PostForm("lat_column")
+	opts.LonColumn = c.PostForm("lon_column")
+	opts.WKTColumn = c.PostForm("wkt_column")
+	if opts.WKTColumn != "" && (opts.LatColumn != "" || opts.LonColumn != "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "укажите либо lat_column и lon_column, либо wkt_column"})
+		return
+	}
+
+	userID, ok := currentUserID(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Неверный формат user_id"})
 		return
 	}
 
-	col, err := h.geoJSONService.ImportGeoJSONBulk(
-		c.Request.Context(),
-		file,
-		name,
-		description,
-		userID,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка bulk‑импорта: " + err.Error()})
+	job := &models.ImportJob{
+		Format:      format,
+		Name:        name,
+		Description: description,
+		UserID:      userID,
+		Options:     opts,
+	}
+	if err := h.importJobs.Submit(c.Request.Context(), file, job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка постановки импорта в очередь: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, col)
+	c.Header("Location", "/admin/geojson/imports/"+strconv.Itoa(job.ID))
+	c.JSON(http.StatusAccepted, job)
 }
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"testing"
+)
+
+func TestAttachment(t *testing.T) {
+	tests := []struct {
+		name, ext, want string
+	}{
+		{"roads", ".geojson", "roads.geojson"},
+		{"Дороги Москвы", ".gpkg", "Дороги Москвы.gpkg"},
+		{`a "b";c`, ".csv", `a "b";c.csv`},
+		{"", ".zip", "collection.zip"},
+	}
+	for _, tt := range tests {
+		header := attachment(tt.name, tt.ext)
+		disp, params, err := mime.ParseMediaType(header)
+		if err != nil {
+			t.Fatalf("%q: %v", header, err)
+		}
+		if disp != "attachment" || params["filename"] != tt.want {
+			t.Errorf("attachment(%q) = %s: %s %q, ждали %q", tt.name, header, disp, params["filename"], tt.want)
+		}
+	}
+}
+
+// TestExportResponse проверяет, что заголовки выгрузки отправляются
+// только вместе с первыми данными.
+func TestExportResponse(t *testing.T) {
+	c, w := testContext(nil)
+	out := &exportResponse{c: c, contentType: "application/geo+json", disposition: attachment("roads", ".geojson")}
+
+	// до первых данных ответ ещё можно заменить ошибкой
+	if out.started || c.Writer.Written() {
+		t.Fatal("заголовки отправлены до данных")
+	}
+	if _, err := out.Write([]byte(`{"type":`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := out.Write([]byte(`"FeatureCollection","features":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !out.started {
+		t.Error("started не выставлен")
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("код %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/geo+json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename=roads.geojson` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if got := w.Body.String(); got != `{"type":"FeatureCollection","features":[]}` {
+		t.Errorf("тело %s", got)
+	}
+}
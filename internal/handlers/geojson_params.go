@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"Datapolis/internal/cql2"
+	"Datapolis/internal/formats"
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// parseFeatureQuery разбирает параметры фильтрации фич из строки запроса.
+func parseFeatureQuery(c *gin.Context) (*models.FeatureQuery, error) {
+	page, err := parsePageRequest(c)
+	if err != nil {
+		return nil, err
+	}
+	q := &models.FeatureQuery{PageRequest: page}
+
+	if raw := c.Query("bbox"); raw != "" {
+		bbox, err := parseBBox(raw, c.Query("bbox-crs"))
+		if err != nil {
+			return nil, err
+		}
+		q.BBox = bbox
+	}
+
+	if raw := c.Query("datetime"); raw != "" {
+		dt, err := parseDateTime(raw)
+		if err != nil {
+			return nil, err
+		}
+		q.DateTime = dt
+	}
+
+	if raw := c.Query("as_of"); raw != "" {
+		t, err := parseTimestamp(raw)
+		if err != nil {
+			return nil, errors.New("as_of: неверная дата " + strconv.Quote(raw))
+		}
+		q.AsOf = &t
+	}
+
+	props, err := parsePropertyFilters(c.Request.URL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	q.Properties = props
+
+	if raw := c.Query("filter"); raw != "" {
+		filter, srid, err := parseFilter(raw, c.Query("filter-lang"), c.Query("filter-crs"))
+		if err != nil {
+			return nil, err
+		}
+		q.Filter, q.FilterSRID = filter, srid
+	}
+
+	return q, nil
+}
+
+// currentUserID возвращает ID пользователя, установленный middleware
+// авторизации; при ошибке ответ уже отправлен.
+func currentUserID(c *gin.Context) (int, bool) {
+	uidIfc, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неавторизованный запрос"})
+		return 0, false
+	}
+	userID, ok := uidIfc.(int)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Неверный формат user_id"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// parseFilter разбирает фильтр CQL2 (OGC API – Features, Part 3).
+// По умолчанию filter-lang=cql2-text, геометрии фильтра — в EPSG:4326.
+func parseFilter(raw, lang, crs string) (cql2.Expr, int, error) {
+	srid := geo.SRID4326
+	if crs != "" {
+		var err error
+		if srid, err = geo.ParseSRID(crs); err != nil {
+			return nil, 0, fmt.Errorf("filter-crs: %w", err)
+		}
+	}
+
+	var (
+		filter cql2.Expr
+		err    error
+	)
+	switch lang {
+	case "", "cql2-text":
+		filter, err = cql2.ParseText(raw)
+	case "cql2-json":
+		filter, err = cql2.ParseJSON([]byte(raw))
+	default:
+		return nil, 0, errors.New("filter-lang: поддерживаются cql2-text и cql2-json")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return filter, srid, nil
+}
+
+// parseDateTime разбирает datetime в форме OGC API: момент времени
+// "2024-03-01T00:00:00Z" или интервал "начало/конец", где ".." — открытая граница.
+// Дата без времени означает весь день: "2024-03-01" — интервал
+// [2024-03-01, 2024-03-02), а в конце интервала день входит целиком.
+func parseDateTime(raw string) (*models.TimeInterval, error) {
+	parts := strings.Split(raw, "/")
+	if len(parts) > 2 {
+		return nil, errors.New("datetime: ожидается момент или интервал начало/конец")
+	}
+
+	bounds := make([]*time.Time, len(parts))
+	var lastDay bool // последняя граница — дата без времени
+	for i, p := range parts {
+		if p == "" || p == ".." {
+			continue
+		}
+		t, err := parseTimestamp(p)
+		if err != nil {
+			return nil, errors.New("datetime: неверная дата " + strconv.Quote(p))
+		}
+		bounds[i] = &t
+		lastDay = isDateOnly(p)
+	}
+
+	if len(bounds) == 1 {
+		if bounds[0] == nil {
+			return nil, errors.New("datetime: не указан момент времени")
+		}
+		if lastDay {
+			end := bounds[0].AddDate(0, 0, 1)
+			return &models.TimeInterval{Start: bounds[0], End: &end, EndExclusive: true}, nil
+		}
+		return &models.TimeInterval{Start: bounds[0], End: bounds[0]}, nil
+	}
+	if bounds[0] != nil && bounds[1] != nil && bounds[1].Before(*bounds[0]) {
+		return nil, errors.New("datetime: конец интервала раньше начала")
+	}
+	dt := &models.TimeInterval{Start: bounds[0], End: bounds[1]}
+	if dt.End != nil && lastDay {
+		end := dt.End.AddDate(0, 0, 1)
+		dt.End, dt.EndExclusive = &end, true
+	}
+	return dt, nil
+}
+
+// isDateOnly сообщает, что значение — дата без времени.
+func isDateOnly(s string) bool {
+	_, err := time.Parse(time.DateOnly, s)
+	return err == nil
+}
+
+// parseTimestamp принимает RFC 3339 или дату без времени (полночь UTC).
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// parseRepairMode проверяет режим обработки некорректных геометрий.
+func parseRepairMode(raw string) (string, error) {
+	switch raw {
+	case models.RepairNone, models.RepairSkip, models.RepairMakeValid:
+		return raw, nil
+	}
+	return "", errors.New("repair: поддерживаются skip и make_valid")
+}
+
+// uploadFormat определяет формат загруженного файла: по полю format,
+// а без него — по расширению имени (.zip — Shapefile, .gpkg — GeoPackage,
+// .csv, .tsv и .txt — CSV, иначе GeoJSON).
+func uploadFormat(raw, filename string) (string, error) {
+	switch strings.ToLower(raw) {
+	case "":
+		switch strings.ToLower(path.Ext(filename)) {
+		case ".zip":
+			return models.FormatShapefile, nil
+		case ".gpkg":
+			return models.FormatGeoPackage, nil
+		case ".csv", ".tsv", ".txt":
+			return models.FormatCSV, nil
+		}
+		return models.FormatGeoJSON, nil
+	case models.FormatGeoJSON, models.FormatShapefile, models.FormatGeoPackage, models.FormatCSV:
+		return strings.ToLower(raw), nil
+	}
+	return "", errors.New("format: поддерживаются geojson, shapefile, gpkg и csv")
+}
+
+// parseQuantization разбирает параметр quantization TopoJSON — размер
+// сетки квантования координат.
+func parseQuantization(raw string) (int, error) {
+	if raw == "" {
+		return formats.DefaultQuantization, nil
+	}
+	q, err := strconv.Atoi(raw)
+	if err != nil || q < 2 || q > 1_000_000_000 {
+		return 0, errors.New("quantization: ожидается целое от 2 до 1000000000")
+	}
+	return q, nil
+}
+
+// parsePageFormat разбирает параметр format страницы фич: geojson
+// (по умолчанию) или topojson. Для TopoJSON возвращает размер сетки
+// квантования, для GeoJSON — 0.
+func parsePageFormat(c *gin.Context) (int, error) {
+	switch c.DefaultQuery("format", "geojson") {
+	case "geojson":
+		return 0, nil
+	case "topojson":
+		return parseQuantization(c.Query("quantization"))
+	}
+	return 0, errors.New("format: поддерживаются geojson и topojson")
+}
+
+// parseDelimiter проверяет разделитель CSV: один символ или "tab".
+func parseDelimiter(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", nil
+	case strings.EqualFold(raw, "tab") || raw == `\t`:
+		return "\t", nil
+	case utf8.RuneCountInString(raw) == 1 && raw != "\"" && raw != "\r" && raw != "\n":
+		return raw, nil
+	}
+	return "", errors.New("delimiter: укажите один символ или tab")
+}
+
+// parsePageRequest разбирает параметры limit и cursor. Размер страницы
+// сверх models.MaxPageLimit не ошибка — он урезается в репозитории.
+func parsePageRequest(c *gin.Context) (models.PageRequest, error) {
+	var p models.PageRequest
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return p, errors.New("limit должен быть положительным целым числом")
+		}
+		p.Limit = limit
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := models.DecodeCursor(raw)
+		if err != nil {
+			return p, err
+		}
+		p.Cursor = cur
+	}
+	return p, nil
+}
+
+// maxTileZoom — наибольший поддерживаемый уровень масштаба тайлов
+const maxTileZoom = 24
+
+// parseTileCoords разбирает и проверяет координаты тайла z/x/y.
+func parseTileCoords(zs, xs, ys string) (z, x, y int, err error) {
+	z, errZ := strconv.Atoi(zs)
+	x, errX := strconv.Atoi(xs)
+	y, errY := strconv.Atoi(ys)
+	if errZ != nil || errX != nil || errY != nil {
+		return 0, 0, 0, errors.New("неверные координаты тайла")
+	}
+	if z < 0 || z > maxTileZoom {
+		return 0, 0, 0, fmt.Errorf("уровень масштаба должен быть от 0 до %d", maxTileZoom)
+	}
+	if n := 1 << z; x < 0 || x >= n || y < 0 || y >= n {
+		return 0, 0, 0, errors.New("координаты тайла вне сетки для данного масштаба")
+	}
+	return z, x, y, nil
+}
+
+// requestURL восстанавливает абсолютный URL текущего запроса.
+func requestURL(c *gin.Context) *url.URL {
+	u := *c.Request.URL
+	u.Host = c.Request.Host
+	u.Scheme = "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		u.Scheme = "https"
+	}
+	return &u
+}
+
+// withCursor возвращает URL запроса с заменённым параметром cursor.
+// Строка запроса правится как есть: перекодирование через url.Values
+// испортило бы фильтры вида properties.district in (A,B).
+func withCursor(u *url.URL, cur *models.Cursor) string {
+	var parts []string
+	for _, part := range strings.Split(u.RawQuery, "&") {
+		if part != "" && !strings.HasPrefix(part, "cursor=") {
+			parts = append(parts, part)
+		}
+	}
+	if cur != nil {
+		parts = append(parts, "cursor="+cur.Encode())
+	}
+	next := *u
+	next.RawQuery = strings.Join(parts, "&")
+	return next.String()
+}
+
+// pageLinks формирует ссылки self/next/prev для страницы результатов.
+func pageLinks[T any](c *gin.Context, page *models.Page[T], mediaType string) []models.Link {
+	u := requestURL(c)
+	links := []models.Link{{Href: u.String(), Rel: "self", Type: mediaType}}
+	if page.Next != nil {
+		links = append(links, models.Link{Href: withCursor(u, page.Next), Rel: "next", Type: mediaType})
+	}
+	if page.Prev != nil {
+		links = append(links, models.Link{Href: withCursor(u, page.Prev), Rel: "prev", Type: mediaType})
+	}
+	return links
+}
+
+const propertiesPrefix = "properties."
+
+// parsePropertyFilters разбирает условия вида properties.status=active,
+// properties.floors>=5 и properties.district in (A,B). Строку запроса
+// разбираем вручную: для url.Values "floors>=5" — это ключ "floors>" и значение "5".
+func parsePropertyFilters(rawQuery string) ([]models.PropertyFilter, error) {
+	var filters []models.PropertyFilter
+	for _, part := range strings.Split(rawQuery, "&") {
+		expr, err := url.QueryUnescape(part)
+		if err != nil || !strings.HasPrefix(expr, propertiesPrefix) {
+			continue
+		}
+		f, err := parsePropertyFilter(expr[len(propertiesPrefix):])
+		if err != nil {
+			return nil, fmt.Errorf("фильтр %q: %w", expr, err)
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func parsePropertyFilter(expr string) (models.PropertyFilter, error) {
+	var f models.PropertyFilter
+
+	var key, value string
+	if m := propertyInRe.FindStringSubmatch(expr); m != nil {
+		key = m[1]
+		f.Op = models.PropertyIn
+		for _, v := range strings.Split(m[2], ",") {
+			f.Values = append(f.Values, unquote(strings.TrimSpace(v)))
+		}
+	} else {
+		i := strings.IndexAny(expr, "=<>!")
+		if i <= 0 {
+			return f, errors.New("не найден оператор сравнения")
+		}
+		key = expr[:i]
+		op := expr[i : i+1]
+		if i+1 < len(expr) && expr[i+1] == '=' {
+			op = expr[i : i+2]
+		}
+		value = unquote(strings.TrimSpace(expr[i+len(op):]))
+		switch op {
+		case models.PropertyEq, models.PropertyNe, models.PropertyGt,
+			models.PropertyGte, models.PropertyLt, models.PropertyLte:
+			f.Op = op
+		default:
+			return f, fmt.Errorf("неподдерживаемый оператор %q", op)
+		}
+		f.Values = []string{value}
+	}
+
+	for _, k := range strings.Split(strings.TrimSpace(key), ".") {
+		if k == "" {
+			return f, errors.New("пустое имя свойства")
+		}
+		f.Path = append(f.Path, k)
+	}
+	return f, nil
+}
+
+var propertyInRe = regexp.MustCompile(`(?i)^([^=<>!\s]+)\s+in\s*\((.*)\)$`)
+
+// unquote снимает необязательные кавычки вокруг значения.
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// parseSpatialQuery проверяет тело пространственного запроса и собирает
+// фильтр. SRID фильтра берётся из crs тела (по умолчанию EPSG:4326) и не
+// зависит от системы координат ответа.
+func parseSpatialQuery(req SpatialQueryRequest) (*models.SpatialFilter, error) {
+	if err := geo.CheckGeoJSONGeometry(req.Geometry); err != nil {
+		return nil, err
+	}
+	if req.Predicate == models.PredicateDWithin && req.Distance <= 0 {
+		return nil, errors.New("для dwithin требуется положительное расстояние distance")
+	}
+
+	geomSRID := geo.SRID4326
+	if req.CRS != "" {
+		srid, err := geo.ParseSRID(req.CRS)
+		if err != nil {
+			return nil, err
+		}
+		geomSRID = srid
+	}
+	return &models.SpatialFilter{
+		Predicate: req.Predicate,
+		Geometry:  req.Geometry,
+		SRID:      geomSRID,
+		Distance:  req.Distance,
+	}, nil
+}
+
+// parseBBox разбирает bbox=minx,miny,maxx,maxy (или 6 чисел с высотой).
+// Без bbox-crs координаты считаются заданными в WGS 84 (CRS84); в нём
+// minx > maxx — прямоугольник через антимеридиан.
+func parseBBox(raw, crs string) (*models.BBox, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 && len(parts) != 6 {
+		return nil, errors.New("bbox должен содержать 4 или 6 чисел")
+	}
+	nums := make([]float64, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, errors.New("bbox: неверное число " + strconv.Quote(p))
+		}
+		nums[i] = v
+	}
+
+	bbox := &models.BBox{SRID: geo.SRID4326}
+	if len(nums) == 4 {
+		bbox.MinX, bbox.MinY, bbox.MaxX, bbox.MaxY = nums[0], nums[1], nums[2], nums[3]
+	} else {
+		// minx,miny,minz,maxx,maxy,maxz — высота не учитывается
+		bbox.MinX, bbox.MinY, bbox.MaxX, bbox.MaxY = nums[0], nums[1], nums[3], nums[4]
+	}
+	if crs != "" {
+		srid, err := geo.ParseSRID(crs)
+		if err != nil {
+			return nil, errors.New("bbox-crs: " + err.Error())
+		}
+		bbox.SRID = srid
+	}
+
+	if bbox.MinY > bbox.MaxY {
+		return nil, errors.New("bbox: минимальные координаты больше максимальных")
+	}
+	if bbox.MinX > bbox.MaxX {
+		if bbox.SRID != geo.SRID4326 {
+			return nil, errors.New("bbox: минимальные координаты больше максимальных")
+		}
+		if bbox.MinX > 180 || bbox.MaxX < -180 {
+			return nil, errors.New("bbox: долгота через антимеридиан должна быть в пределах [-180, 180]")
+		}
+	}
+	return bbox, nil
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"Datapolis/internal/models"
+)
+
+func TestParseDateTime(t *testing.T) {
+	day := func(s string) *time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return &t
+	}
+	tests := []struct {
+		raw       string
+		start     *time.Time
+		end       *time.Time
+		exclusive bool
+		wantErr   bool
+	}{
+		{raw: "2024-03-01T10:00:00Z", start: day("2024-03-01T10:00:00Z"), end: day("2024-03-01T10:00:00Z")},
+		{raw: "2024-03-01", start: day("2024-03-01T00:00:00Z"), end: day("2024-03-02T00:00:00Z"), exclusive: true},
+		{raw: "2024-02-29/2024-03-01", start: day("2024-02-29T00:00:00Z"), end: day("2024-03-02T00:00:00Z"), exclusive: true},
+		{raw: "2024-03-01/2024-03-01", start: day("2024-03-01T00:00:00Z"), end: day("2024-03-02T00:00:00Z"), exclusive: true},
+		{raw: "2024-03-01/2024-03-05T12:00:00Z", start: day("2024-03-01T00:00:00Z"), end: day("2024-03-05T12:00:00Z")},
+		{raw: "../2024-03-01", end: day("2024-03-02T00:00:00Z"), exclusive: true},
+		{raw: "2024-03-01/..", start: day("2024-03-01T00:00:00Z")},
+		{raw: "2024-03-01T00:00:00+03:00/", start: day("2024-02-29T21:00:00Z")},
+		{raw: "..", wantErr: true},
+		{raw: "2024-03-05/2024-03-01", wantErr: true},
+		{raw: "2024-13-01", wantErr: true},
+		{raw: "2024-03-01/2024-03-02/2024-03-03", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			dt, err := parseDateTime(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ждали ошибку, получили %+v", dt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameTime(dt.Start, tt.start) || !sameTime(dt.End, tt.end) || dt.EndExclusive != tt.exclusive {
+				t.Errorf("parseDateTime(%q) = [%v, %v] exclusive=%v, ждали [%v, %v] exclusive=%v",
+					tt.raw, dt.Start, dt.End, dt.EndExclusive, tt.start, tt.end, tt.exclusive)
+			}
+		})
+	}
+}
+
+func TestParseBBox(t *testing.T) {
+	tests := []struct {
+		raw, crs string
+		want     []models.BBox // Envelopes()
+		wantErr  bool
+	}{
+		{raw: "30,59,31,60", want: []models.BBox{{MinX: 30, MinY: 59, MaxX: 31, MaxY: 60, SRID: 4326}}},
+		{raw: " 30 , 59 , 31 , 60 ", want: []models.BBox{{MinX: 30, MinY: 59, MaxX: 31, MaxY: 60, SRID: 4326}}},
+		{raw: "30,59,0,31,60,100", want: []models.BBox{{MinX: 30, MinY: 59, MaxX: 31, MaxY: 60, SRID: 4326}}},
+		{raw: "3000,6000,4000,7000", crs: "EPSG:3857", want: []models.BBox{{MinX: 3000, MinY: 6000, MaxX: 4000, MaxY: 7000, SRID: 3857}}},
+		{raw: "1,2,3,4", crs: "http://www.opengis.net/def/crs/OGC/1.3/CRS84", want: []models.BBox{{MinX: 1, MinY: 2, MaxX: 3, MaxY: 4, SRID: 4326}}},
+		{
+			raw: "177,-20,-178,-10",
+			want: []models.BBox{
+				{MinX: 177, MinY: -20, MaxX: 180, MaxY: -10, SRID: 4326},
+				{MinX: -180, MinY: -20, MaxX: -178, MaxY: -10, SRID: 4326},
+			},
+		},
+		{raw: "30,60,31,59", wantErr: true},
+		{raw: "4000,6000,3000,7000", crs: "EPSG:3857", wantErr: true},
+		{raw: "190,0,-170,1", wantErr: true},
+		{raw: "30,59,31", wantErr: true},
+		{raw: "30,59,31,60,1", wantErr: true},
+		{raw: "30,59,x,60", wantErr: true},
+		{raw: "30,59,31,60", crs: "EPSG:abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw+" "+tt.crs, func(t *testing.T) {
+			b, err := parseBBox(tt.raw, tt.crs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ждали ошибку, получили %+v", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.Envelopes(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBBox(%q, %q) = %+v, ждали %+v", tt.raw, tt.crs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSpatialQuery(t *testing.T) {
+	point := models.JSONData(`{"type":"Point","coordinates":[69.2,41.3]}`)
+	tests := []struct {
+		name     string
+		req      SpatialQueryRequest
+		wantSRID int
+		wantErr  bool
+	}{
+		{name: "без crs — WGS 84", req: SpatialQueryRequest{Predicate: "intersects", Geometry: point}, wantSRID: 4326},
+		{name: "crs тела", req: SpatialQueryRequest{Predicate: "intersects", Geometry: point, CRS: "EPSG:32642"}, wantSRID: 32642},
+		{name: "CRS84", req: SpatialQueryRequest{Predicate: "dwithin", Geometry: point, Distance: 100, CRS: "http://www.opengis.net/def/crs/OGC/1.3/CRS84"}, wantSRID: 4326},
+		{name: "dwithin без расстояния", req: SpatialQueryRequest{Predicate: "dwithin", Geometry: point}, wantErr: true},
+		{name: "неверный crs", req: SpatialQueryRequest{Predicate: "intersects", Geometry: point, CRS: "EPSG:x"}, wantErr: true},
+		{name: "не геометрия", req: SpatialQueryRequest{Predicate: "intersects", Geometry: models.JSONData(`{"type":"Feature"}`)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf, err := parseSpatialQuery(tt.req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ждали ошибку, получили %+v", sf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sf.SRID != tt.wantSRID || sf.Predicate != tt.req.Predicate || sf.Distance != tt.req.Distance {
+				t.Errorf("фильтр %+v, ждали SRID %d", sf, tt.wantSRID)
+			}
+		})
+	}
+}
+
+func TestWithCursor(t *testing.T) {
+	next := &models.Cursor{ID: 7}
+	tests := []struct {
+		raw  string
+		cur  *models.Cursor
+		want string
+	}{
+		{"limit=2", next, "limit=2&cursor=" + next.Encode()},
+		{"cursor=abc&limit=2", next, "limit=2&cursor=" + next.Encode()},
+		// фильтр остаётся как есть, без перекодирования
+		{"properties.district%20in%20(A,B)&cursor=abc", next, "properties.district%20in%20(A,B)&cursor=" + next.Encode()},
+		{"limit=2&cursor=abc", nil, "limit=2"},
+		{"", nil, ""},
+	}
+	for _, tt := range tests {
+		u := &url.URL{Scheme: "http", Host: "example.org", Path: "/geojson/collections/1/features", RawQuery: tt.raw}
+		want := "http://example.org/geojson/collections/1/features"
+		if tt.want != "" {
+			want += "?" + tt.want
+		}
+		if got := withCursor(u, tt.cur); got != want {
+			t.Errorf("withCursor(%q) = %s, ждали %s", tt.raw, got, want)
+		}
+	}
+}
+
+func TestParsePropertyFilters(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []models.PropertyFilter
+		wantErr bool
+	}{
+		{raw: "properties.status=active", want: []models.PropertyFilter{{Path: []string{"status"}, Op: "=", Values: []string{"active"}}}},
+		{raw: "properties.floors>=5&limit=10", want: []models.PropertyFilter{{Path: []string{"floors"}, Op: ">=", Values: []string{"5"}}}},
+		{raw: "properties.floors%3C3", want: []models.PropertyFilter{{Path: []string{"floors"}, Op: "<", Values: []string{"3"}}}},
+		{raw: "properties.address.city!='Санкт-Петербург'", want: []models.PropertyFilter{{Path: []string{"address", "city"}, Op: "!=", Values: []string{"Санкт-Петербург"}}}},
+		{raw: "properties.district%20in%20(A,%20'B,C'%20)", want: []models.PropertyFilter{{Path: []string{"district"}, Op: "in", Values: []string{"A", "'B", "C'"}}}},
+		{raw: "properties.district+IN+(A,B)", want: []models.PropertyFilter{{Path: []string{"district"}, Op: "in", Values: []string{"A", "B"}}}},
+		{
+			raw: "properties.a=1&bbox=1,2,3,4&properties.b>2",
+			want: []models.PropertyFilter{
+				{Path: []string{"a"}, Op: "=", Values: []string{"1"}},
+				{Path: []string{"b"}, Op: ">", Values: []string{"2"}},
+			},
+		},
+		{raw: "limit=10&cursor=abc"},
+		{raw: "properties.status", wantErr: true},
+		{raw: "properties.=1", wantErr: true},
+		{raw: "properties.a..b=1", wantErr: true},
+		{raw: "properties.a!1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parsePropertyFilters(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ждали ошибку, получили %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("получили %+v, ждали %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func TestParseTileCoords(t *testing.T) {
+	tests := []struct {
+		z, x, y string
+		want    [3]int
+		wantErr bool
+	}{
+		{z: "0", x: "0", y: "0", want: [3]int{0, 0, 0}},
+		{z: "3", x: "7", y: "5", want: [3]int{3, 7, 5}},
+		{z: "24", x: "16777215", y: "0", want: [3]int{24, 16777215, 0}},
+		{z: "0", x: "1", y: "0", wantErr: true},
+		{z: "3", x: "8", y: "0", wantErr: true},
+		{z: "3", x: "0", y: "-1", wantErr: true},
+		{z: "25", x: "0", y: "0", wantErr: true},
+		{z: "-1", x: "0", y: "0", wantErr: true},
+		{z: "a", x: "0", y: "0", wantErr: true},
+		{z: "1", x: "0", y: "0.mvt", wantErr: true},
+	}
+	for _, tt := range tests {
+		z, x, y, err := parseTileCoords(tt.z, tt.x, tt.y)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s/%s/%s: ждали ошибку", tt.z, tt.x, tt.y)
+			}
+			continue
+		}
+		if err != nil || [3]int{z, x, y} != tt.want {
+			t.Errorf("%s/%s/%s = %d/%d/%d, %v", tt.z, tt.x, tt.y, z, x, y, err)
+		}
+	}
+}
+
+func TestParseRepairMode(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"":           true,
+		"skip":       true,
+		"make_valid": true,
+		"MAKE_VALID": false,
+		"fix":        false,
+	} {
+		got, err := parseRepairMode(raw)
+		if (err == nil) != ok || (ok && got != raw) {
+			t.Errorf("parseRepairMode(%q) = %q, %v", raw, got, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	service "Datapolis/internal/services"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// GetImportJob отдаёт состояние задачи импорта: прогресс, отчёт и ошибку
+func (h *GeoJSONHandler) GetImportJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID задачи"})
+		return
+	}
+
+	job, err := h.importJobs.Get(c.Request.Context(), id)
+	if errors.Is(err, service.ErrImportJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении задачи: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelImportJob отменяет задачу импорта из очереди или выполняющуюся;
+// частично загруженные данные откатываются
+func (h *GeoJSONHandler) CancelImportJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID задачи"})
+		return
+	}
+
+	job, err := h.importJobs.Cancel(c.Request.Context(), id)
+	switch {
+	case errors.Is(err, service.ErrImportJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrImportJobFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "job": job})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при отмене задачи: " + err.Error()})
+	default:
+		c.JSON(http.StatusAccepted, job)
+	}
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+	service "Datapolis/internal/services"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OGCHandler реализует OGC API – Features, Part 1: Core поверх GeoService,
+// чтобы коллекции открывались в QGIS, ArcGIS и OpenLayers без доработок.
+type OGCHandler struct {
+	geoJSONService *service.GeoService
+}
+
+func NewOGCHandler(geoJSONService *service.GeoService) *OGCHandler {
+	return &OGCHandler{geoJSONService: geoJSONService}
+}
+
+const (
+	gregorianTRS    = "http://www.opengis.net/def/uom/ISO-8601/0/Gregorian"
+	ogcDefaultLimit = 10
+
+	mediaJSON    = "application/json"
+	mediaGeoJSON = "application/geo+json"
+	mediaHTML    = "text/html"
+)
+
+var ogcConformance = []string{
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/html",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/filter",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/features-filter",
+	"http://www.opengis.net/spec/cql2/1.0/conf/cql2-text",
+	"http://www.opengis.net/spec/cql2/1.0/conf/cql2-json",
+	"http://www.opengis.net/spec/cql2/1.0/conf/basic-cql2",
+	"http://www.opengis.net/spec/cql2/1.0/conf/advanced-comparison-operators",
+	"http://www.opengis.net/spec/cql2/1.0/conf/basic-spatial-functions",
+	"http://www.opengis.net/spec/cql2/1.0/conf/spatial-functions",
+}
+
+// ogcItemsParams — параметры запроса /items; прочие, кроме properties.*, отклоняются.
+var ogcItemsParams = map[string]bool{
+	"f": true, "bbox": true, "bbox-crs": true, "limit": true, "cursor": true, "datetime": true,
+	"filter": true, "filter-lang": true, "filter-crs": true,
+}
+
+type ogcCollection struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Extent      *ogcExtent    `json:"extent,omitempty"`
+	ItemType    string        `json:"itemType"`
+	CRS         []string      `json:"crs"`
+	Links       []models.Link `json:"links"`
+}
+
+type ogcExtent struct {
+	Spatial struct {
+		BBox [][4]float64 `json:"bbox"`
+		CRS  string       `json:"crs"`
+	} `json:"spatial"`
+	Temporal struct {
+		Interval [][2]string `json:"interval"`
+		TRS      string      `json:"trs"`
+	} `json:"temporal"`
+}
+
+type ogcFeature struct {
+	Type       string          `json:"type"`
+	ID         int             `json:"id"`
+	Geometry   models.JSONData `json:"geometry"`
+	Properties models.JSONData `json:"properties"`
+	Links      []models.Link   `json:"links,omitempty"`
+}
+
+type ogcFeatureCollection struct {
+	Type           string        `json:"type"`
+	Features       []ogcFeature  `json:"features"`
+	Links          []models.Link `json:"links"`
+	TimeStamp      string        `json:"timeStamp"`
+	NumberMatched  int           `json:"numberMatched"`
+	NumberReturned int           `json:"numberReturned"`
+}
+
+// Landing — корневая страница API со ссылками на остальные ресурсы.
+func (h *OGCHandler) Landing(c *gin.Context) {
+	base := ogcBaseURL(c)
+	links := append(selfLinks(c, base, mediaJSON),
+		models.Link{Href: base + "?f=html", Rel: "service-doc", Type: mediaHTML, Title: "Описание API"},
+		models.Link{Href: base + "/conformance", Rel: "conformance", Type: mediaJSON, Title: "Классы соответствия"},
+		models.Link{Href: base + "/collections", Rel: "data", Type: mediaJSON, Title: "Коллекции"},
+	)
+	body := gin.H{
+		"title":       "Datapolis",
+		"description": "Пространственные данные Datapolis (OGC API – Features)",
+		"links":       links,
+	}
+	h.respond(c, mediaJSON, body, ogcPage{Title: "Datapolis", Description: "OGC API – Features", Links: links})
+}
+
+// Conformance перечисляет поддерживаемые классы соответствия.
+func (h *OGCHandler) Conformance(c *gin.Context) {
+	page := ogcPage{Title: "Классы соответствия", Headers: []string{"URI"}}
+	for _, uri := range ogcConformance {
+		page.Rows = append(page.Rows, []string{uri})
+	}
+	h.respond(c, mediaJSON, gin.H{"conformsTo": ogcConformance}, page)
+}
+
+// Collections перечисляет все коллекции с их охватом.
+func (h *OGCHandler) Collections(c *gin.Context) {
+	ctx := c.Request.Context()
+	cols, err := h.geoJSONService.ListAllCollections(ctx)
+	if err != nil {
+		ogcError(c, http.StatusInternalServerError, "Ошибка при получении коллекций: "+err.Error())
+		return
+	}
+	ids := make([]int, len(cols))
+	for i, col := range cols {
+		ids[i] = col.ID
+	}
+	extents, err := h.geoJSONService.GetCollectionExtents(ctx, ids)
+	if err != nil {
+		ogcError(c, http.StatusInternalServerError, "Ошибка при расчёте охвата: "+err.Error())
+		return
+	}
+
+	base := ogcBaseURL(c)
+	list := make([]ogcCollection, len(cols))
+	page := ogcPage{Title: "Коллекции", Headers: []string{"id", "Название", "Описание"}}
+	for i, col := range cols {
+		list[i] = newOGCCollection(base, col, extents[col.ID])
+		page.Rows = append(page.Rows, []string{list[i].ID, col.Name, col.Description})
+		page.RowLinks = append(page.RowLinks, base+"/collections/"+list[i].ID+"?f=html")
+	}
+	links := selfLinks(c, base+"/collections", mediaJSON)
+	page.Links = links
+
+	h.respond(c, mediaJSON, gin.H{"links": links, "collections": list}, page)
+}
+
+// Collection описывает одну коллекцию.
+func (h *OGCHandler) Collection(c *gin.Context) {
+	col, ok := h.collection(c)
+	if !ok {
+		return
+	}
+	extents, err := h.geoJSONService.GetCollectionExtents(c.Request.Context(), []int{col.ID})
+	if err != nil {
+		ogcError(c, http.StatusInternalServerError, "Ошибка при расчёте охвата: "+err.Error())
+		return
+	}
+
+	oc := newOGCCollection(ogcBaseURL(c), col, extents[col.ID])
+	oc.Links = append(selfLinks(c, ogcBaseURL(c)+"/collections/"+oc.ID, mediaJSON), oc.Links...)
+	h.respond(c, mediaJSON, oc, ogcPage{Title: col.Name, Description: col.Description, Links: oc.Links})
+}
+
+// Items возвращает страницу фич коллекции (bbox, datetime, limit, cursor).
+func (h *OGCHandler) Items(c *gin.Context) {
+	for key := range c.Request.URL.Query() {
+		if !ogcItemsParams[key] && !strings.HasPrefix(key, propertiesPrefix) {
+			ogcError(c, http.StatusBadRequest, "Неизвестный параметр запроса "+strconv.Quote(key))
+			return
+		}
+	}
+
+	col, ok := h.collection(c)
+	if !ok {
+		return
+	}
+	query, err := parseFeatureQuery(c)
+	if err != nil {
+		ogcError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if c.Query("limit") == "" {
+		query.Limit = ogcDefaultLimit
+	}
+	query.OutputSRID = geo.SRID4326 // OGC API отдаёт геометрию в CRS84
+
+	page, err := h.geoJSONService.GetFeatures(c.Request.Context(), col.ID, query)
+	if err != nil {
+		ogcError(c, http.StatusInternalServerError, "Ошибка при получении фич: "+err.Error())
+		return
+	}
+
+	base := ogcBaseURL(c)
+	collectionURL := base + "/collections/" + strconv.Itoa(col.ID)
+	links := pageLinks(c, page, mediaGeoJSON)
+	alternate := models.Link{Href: withFormat(requestURL(c).String(), "html"), Rel: "alternate", Type: mediaHTML}
+	if wantsHTML(c) {
+		alternate = models.Link{Href: withFormat(requestURL(c).String(), "json"), Rel: "alternate", Type: mediaGeoJSON}
+	}
+	links = append(links, alternate,
+		models.Link{Href: collectionURL, Rel: "collection", Type: mediaJSON, Title: col.Name},
+	)
+
+	fc := ogcFeatureCollection{
+		Type:           "FeatureCollection",
+		Features:       make([]ogcFeature, len(page.Items)),
+		Links:          links,
+		TimeStamp:      time.Now().UTC().Format(time.RFC3339),
+		NumberMatched:  page.NumberMatched,
+		NumberReturned: len(page.Items),
+	}
+	for i, f := range page.Items {
+		fc.Features[i] = newOGCFeature(f)
+	}
+
+	h.respond(c, mediaGeoJSON, fc, featuresPage(col.Name, collectionURL, links, page.Items))
+}
+
+// Item возвращает одну фичу коллекции.
+func (h *OGCHandler) Item(c *gin.Context) {
+	col, ok := h.collection(c)
+	if !ok {
+		return
+	}
+	fid, err := strconv.Atoi(c.Param("fid"))
+	if err != nil {
+		ogcError(c, http.StatusNotFound, "Фича не найдена")
+		return
+	}
+
+	f, err := h.geoJSONService.GetFeatureInSRID(c.Request.Context(), fid, geo.SRID4326)
+	if err != nil {
+		ogcError(c, http.StatusInternalServerError, "Ошибка поиска фичи: "+err.Error())
+		return
+	}
+	if f == nil || f.CollectionID != col.ID {
+		ogcError(c, http.StatusNotFound, "Фича не найдена")
+		return
+	}
+	// представление выбирается и по Accept; ETag версии фичи — только
+	// у GeoJSON, HTML-страница кэшируется без проверки
+	c.Header("Vary", "Accept")
+	if !wantsHTML(c) {
+		if notModified(c, f) {
+			return
+		}
+		setFeatureETag(c, f)
+	}
+
+	collectionURL := ogcBaseURL(c) + "/collections/" + strconv.Itoa(col.ID)
+	of := newOGCFeature(f)
+	of.Links = append(selfLinks(c, collectionURL+"/items/"+strconv.Itoa(f.ID), mediaGeoJSON),
+		models.Link{Href: collectionURL, Rel: "collection", Type: mediaJSON, Title: col.Name})
+
+	h.respond(c, mediaGeoJSON, of, featuresPage("Фича "+strconv.Itoa(f.ID), collectionURL, of.Links, []*models.GeoJSONFeature{f}))
+}
+
+// collection находит коллекцию из параметра :id, отвечая 404 при её отсутствии.
+func (h *OGCHandler) collection(c *gin.Context) (*models.GeoJSONCollection, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		ogcError(c, http.StatusNotFound, "Коллекция не найдена")
+		return nil, false
+	}
+	col, err := h.geoJSONService.GetCollection(c.Request.Context(), id)
+	if err != nil {
+		ogcError(c, http.StatusInternalServerError, "Ошибка при получении коллекции: "+err.Error())
+		return nil, false
+	}
+	if col == nil {
+		ogcError(c, http.StatusNotFound, "Коллекция не найдена")
+		return nil, false
+	}
+	return col, true
+}
+
+// respond отдаёт JSON-представление или, если клиент просит HTML, страницу.
+func (h *OGCHandler) respond(c *gin.Context, mediaType string, body any, page ogcPage) {
+	if wantsHTML(c) {
+		renderOGCPage(c, page)
+		return
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		ogcError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, mediaType, raw)
+}
+
+func newOGCCollection(base string, col *models.GeoJSONCollection, extent *models.Extent) ogcCollection {
+	id := strconv.Itoa(col.ID)
+	oc := ogcCollection{
+		ID:          id,
+		Title:       col.Name,
+		Description: col.Description,
+		ItemType:    "feature",
+		CRS:         []string{geo.CRS84URI},
+		Links: []models.Link{
+			{Href: base + "/collections/" + id + "/items", Rel: "items", Type: mediaGeoJSON, Title: col.Name},
+			{Href: base + "/collections/" + id + "/items?f=html", Rel: "items", Type: mediaHTML, Title: col.Name},
+		},
+	}
+	if extent != nil {
+		oc.Extent = new(ogcExtent)
+		oc.Extent.Spatial.BBox = [][4]float64{{extent.MinX, extent.MinY, extent.MaxX, extent.MaxY}}
+		oc.Extent.Spatial.CRS = geo.CRS84URI
+		oc.Extent.Temporal.Interval = [][2]string{{
+			extent.Start.UTC().Format(time.RFC3339), extent.End.UTC().Format(time.RFC3339),
+		}}
+		oc.Extent.Temporal.TRS = gregorianTRS
+	}
+	return oc
+}
+
+func newOGCFeature(f *models.GeoJSONFeature) ogcFeature {
+	return ogcFeature{Type: "Feature", ID: f.ID, Geometry: f.Geometry, Properties: f.Properties}
+}
+
+// featuresPage строит HTML-таблицу фич: столбцы — объединение ключей свойств.
+func featuresPage(title, collectionURL string, links []models.Link, feats []*models.GeoJSONFeature) ogcPage {
+	page := ogcPage{Title: title, Links: links}
+
+	props := make([]map[string]any, len(feats))
+	keys := map[string]bool{}
+	for i, f := range feats {
+		_ = json.Unmarshal(f.Properties, &props[i])
+		for k := range props[i] {
+			keys[k] = true
+		}
+	}
+	columns := make([]string, 0, len(keys))
+	for k := range keys {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+
+	page.Headers = append([]string{"id"}, columns...)
+	for i, f := range feats {
+		row := []string{strconv.Itoa(f.ID)}
+		for _, k := range columns {
+			row = append(row, formatValue(props[i][k]))
+		}
+		page.Rows = append(page.Rows, row)
+		page.RowLinks = append(page.RowLinks, collectionURL+"/items/"+strconv.Itoa(f.ID)+"?f=html")
+	}
+	return page
+}
+
+func formatValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	default:
+		raw, _ := json.Marshal(val)
+		return string(raw)
+	}
+}
+
+// wantsHTML решает, какое представление отдать: параметр f важнее заголовка Accept.
+func wantsHTML(c *gin.Context) bool {
+	switch c.Query("f") {
+	case "html":
+		return true
+	case "json", "geojson":
+		return false
+	}
+	return strings.Contains(c.GetHeader("Accept"), mediaHTML)
+}
+
+// ogcBaseURL — абсолютный адрес корня OGC API.
+func ogcBaseURL(c *gin.Context) string {
+	u := requestURL(c)
+	return u.Scheme + "://" + u.Host + "/ogc"
+}
+
+// selfLinks возвращает ссылку self и альтернативное HTML-представление.
+func selfLinks(c *gin.Context, href, mediaType string) []models.Link {
+	rel, altRel := "self", "alternate"
+	if wantsHTML(c) {
+		rel, altRel = altRel, rel
+	}
+	return []models.Link{
+		{Href: href, Rel: rel, Type: mediaType},
+		{Href: withFormat(href, "html"), Rel: altRel, Type: mediaHTML},
+	}
+}
+
+// withFormat возвращает адрес с параметром f, заменяя прежнее значение.
+func withFormat(href, f string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	parts := []string{"f=" + f}
+	for _, part := range strings.Split(u.RawQuery, "&") {
+		if part != "" && !strings.HasPrefix(part, "f=") {
+			parts = append(parts, part)
+		}
+	}
+	u.RawQuery = strings.Join(parts, "&")
+	return u.String()
+}
+
+// ogcError отвечает ошибкой в формате исключений OGC API.
+func ogcError(c *gin.Context, status int, description string) {
+	c.JSON(status, gin.H{"code": http.StatusText(status), "description": description})
+}
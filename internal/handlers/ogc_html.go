@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"Datapolis/internal/models"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// ogcPage — данные HTML-представления ресурса OGC API.
+type ogcPage struct {
+	Title       string
+	Description string
+	Links       []models.Link
+	Headers     []string
+	Rows        [][]string
+	RowLinks    []string // ссылка для первой ячейки строки, если есть
+}
+
+var ogcTemplate = template.Must(template.New("ogc").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Description}}<p>{{.}}</p>{{end}}
+{{with .Links}}<ul>
+{{range .}}<li><a href="{{.Href}}">{{if .Title}}{{.Title}}{{else}}{{.Rel}}{{end}}</a> ({{.Rel}}{{with .Type}}, {{.}}{{end}})</li>
+{{end}}</ul>{{end}}
+{{if .Headers}}<table>
+<tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr>
+{{range $i, $row := .Rows}}<tr>{{range $j, $cell := $row}}<td>{{if and (eq $j 0) (lt $i (len $.RowLinks))}}<a href="{{index $.RowLinks $i}}">{{$cell}}</a>{{else}}{{$cell}}{{end}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+// renderOGCPage отдаёт HTML-представление ресурса.
+func renderOGCPage(c *gin.Context, page ogcPage) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := ogcTemplate.Execute(c.Writer, page); err != nil {
+		log.Printf("Ошибка отрисовки HTML: %v", err)
+	}
+}
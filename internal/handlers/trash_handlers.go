@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"Datapolis/internal/models"
+	service "Datapolis/internal/services"
+)
+
+// GetTrashedCollections отдаёт страницу коллекций в корзине
+func (h *GeoJSONHandler) GetTrashedCollections(c *gin.Context) {
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.geoJSONService.GetTrashedCollections(c.Request.Context(), pageReq)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении корзины: " + err.Error()})
+		return
+	}
+
+	collections := page.Items
+	if collections == nil {
+		collections = []*models.GeoJSONCollection{}
+	}
+	c.JSON(http.StatusOK, CollectionListResponse{
+		Collections:    collections,
+		NumberMatched:  page.NumberMatched,
+		NumberReturned: len(collections),
+		Links:          pageLinks(c, page, "application/json"),
+	})
+}
+
+// GetTrashedFeatures отдаёт страницу фич в корзине; геометрия — в SRID
+// хранения коллекции
+func (h *GeoJSONHandler) GetTrashedFeatures(c *gin.Context) {
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.geoJSONService.GetTrashedFeatures(c.Request.Context(), pageReq)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении корзины: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newFeatureCollectionResponse(c, page))
+}
+
+// RestoreCollection возвращает коллекцию из корзины вместе с её фичами
+func (h *GeoJSONHandler) RestoreCollection(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	col, err := h.geoJSONService.RestoreCollection(c.Request.Context(), id, userID)
+	if errors.Is(err, service.ErrNotInTrash) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Коллекция не найдена в корзине"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при восстановлении коллекции: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, col)
+}
+
+// RestoreFeature возвращает фичу из корзины; фичу удалённой коллекции
+// можно вернуть только вместе с коллекцией
+func (h *GeoJSONHandler) RestoreFeature(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	feature, err := h.geoJSONService.RestoreFeature(c.Request.Context(), id, userID)
+	switch {
+	case errors.Is(err, service.ErrNotInTrash):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Фича не найдена в корзине"})
+		return
+	case errors.Is(err, service.ErrCollectionNotFound):
+		c.JSON(http.StatusConflict, gin.H{"error": "Коллекция фичи в корзине: восстановите её"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при восстановлении фичи: " + err.Error()})
+		return
+	}
+	setFeatureETag(c, feature)
+	c.JSON(http.StatusOK, feature)
+}
@@ -4,28 +4,133 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 )
 
 // GeoJSONCollection holds high‑level metadata only. Geometry lives in geo_features.
 type GeoJSONCollection struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	SRID        int       `json:"srid"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	UserID      int       `json:"user_id"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	SRID        int        `json:"srid"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	UserID      int        `json:"user_id"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"` // задано только у коллекций в корзине
+}
+
+// Extent — пространственный охват коллекции в WGS 84 (lon/lat)
+// и временной — по времени последнего изменения фич.
+type Extent struct {
+	MinX  float64
+	MinY  float64
+	MaxX  float64
+	MaxY  float64
+	Start time.Time
+	End   time.Time
+}
+
+// Режимы обработки некорректных геометрий.
+const (
+	RepairNone      = ""           // некорректная геометрия отклоняется, импорт прерывается
+	RepairSkip      = "skip"       // фича с некорректной геометрией пропускается
+	RepairMakeValid = "make_valid" // исправляется через ST_MakeValid
+)
+
+// Форматы патча фичи (PATCH).
+const (
+	PatchMerge = "merge" // JSON Merge Patch, RFC 7396
+	PatchJSON  = "json"  // JSON Patch, RFC 6902
+)
+
+// PatchError — патч неприменим к фиче: неверный путь, операция
+// или не прошла проверка test.
+type PatchError struct {
+	Reason string
+}
+
+func (e *PatchError) Error() string {
+	return e.Reason
+}
+
+// ImportOptions — параметры импорта коллекции.
+type ImportOptions struct {
+	SourceSRID int    `json:"source_srid,omitempty"` // 0 — из crs или .prj файла, без них EPSG:4326
+	TargetSRID int    `json:"target_srid,omitempty"` // SRID хранения, 0 — совпадает с исходным
+	Repair     string `json:"repair,omitempty"`      // RepairNone, RepairSkip или RepairMakeValid
+	Encoding   string `json:"encoding,omitempty"`    // кодировка .dbf или CSV, пусто — из .cpg, заголовка .dbf или UTF-8
+	Layer      string `json:"layer,omitempty"`       // таблица GeoPackage, пусто — все векторные таблицы
+
+	// CSV: разделитель и столбцы геометрии; пустые — определяются по заголовку
+	Delimiter string `json:"delimiter,omitempty"`
+	LatColumn string `json:"lat_column,omitempty"`
+	LonColumn string `json:"lon_column,omitempty"`
+	WKTColumn string `json:"wkt_column,omitempty"`
+}
+
+// FeatureIssue — проблема с фичей импортируемого файла;
+// Index — позиция фичи в массиве features, с нуля.
+type FeatureIssue struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// FeatureError — фича импортируемого файла, из-за которой импорт отменён.
+type FeatureError struct {
+	Index  int // позиция в массиве features, с нуля
+	Reason string
+}
+
+func (e *FeatureError) Error() string {
+	return fmt.Sprintf("фича %d: %s", e.Index, e.Reason)
+}
+
+// ImportReport — итог импорта: сколько фич загружено, какие отклонены
+// и какие исправлены.
+type ImportReport struct {
+	Imported int            `json:"imported"`
+	Rejected []FeatureIssue `json:"rejected"`
+	Repaired []FeatureIssue `json:"repaired"`
 }
 
 type GeoJSONFeature struct {
-	ID           int       `json:"id"`
-	Type         string    `json:"type"`
+	ID           int        `json:"id"`
+	Type         string     `json:"type"`
+	Properties   JSONData   `json:"properties"`
+	Geometry     JSONData   `json:"geometry"`
+	CollectionID int        `json:"collection_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"` // задано только у фич в корзине
+}
+
+// Операции в истории изменений фичи.
+const (
+	OperationInsert  = "insert"
+	OperationUpdate  = "update"
+	OperationDelete  = "delete"  // перенос в корзину
+	OperationRestore = "restore" // возврат из корзины
+)
+
+// FeatureVersion — состояние фичи после одной операции из истории изменений;
+// для удаления — состояние на момент удаления.
+type FeatureVersion struct {
+	FeatureID    int       `json:"feature_id"`
+	CollectionID int       `json:"collection_id"`
+	Version      int       `json:"version"`
+	Operation    string    `json:"operation"`
 	Properties   JSONData  `json:"properties"`
 	Geometry     JSONData  `json:"geometry"`
-	CollectionID int       `json:"collection_id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	UserID       *int      `json:"user_id"` // nil — автор неизвестен
+	ChangedAt    time.Time `json:"changed_at"`
+}
+
+// RevertReport — итог возврата коллекции к прошлому состоянию.
+type RevertReport struct {
+	Deleted  int `json:"deleted"`  // фичи, созданные после момента возврата: перенесены в корзину
+	Updated  int `json:"updated"`  // фичи, изменённые после него и не удалённые
+	Restored int `json:"restored"` // фичи, удалённые после него: возвращены из корзины или созданы заново
 }
 
 type JSONData json.RawMessage
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// Состояния задачи импорта.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Форматы импортируемых файлов.
+const (
+	FormatGeoJSON    = "geojson"
+	FormatShapefile  = "shapefile" // zip-архив с .shp, .dbf и .prj
+	FormatGeoPackage = "gpkg"
+	FormatCSV        = "csv"
+)
+
+// ImportJob — задача фонового импорта коллекции из загруженного файла.
+type ImportJob struct {
+	ID          int           `json:"id"`
+	State       string        `json:"state"`
+	Format      string        `json:"format"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	UserID      int           `json:"user_id"`
+	Options     ImportOptions `json:"options"`
+	FilePath    string        `json:"-"`
+
+	Processed    int             `json:"processed"` // прочитано фич из файла
+	Rejected     int             `json:"rejected"`
+	Report       *ImportReport   `json:"report,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	ErrorIndex   *int            `json:"error_index,omitempty"` // фича, из-за которой импорт отменён
+	CollectionID *int            `json:"collection_id,omitempty"`
+	Layers       []ImportedLayer `json:"layers,omitempty"` // коллекции многослойного файла
+
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ImportedLayer — коллекция, созданная из слоя многослойного файла.
+type ImportedLayer struct {
+	Layer        string        `json:"layer"`
+	CollectionID int           `json:"collection_id"`
+	Report       *ImportReport `json:"report"`
+}
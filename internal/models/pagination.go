@@ -0,0 +1,62 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("неверный курсор")
+
+// Cursor — позиция постраничной выборки по id (keyset-пагинация).
+// Backward означает, что запрошена страница перед ID.
+type Cursor struct {
+	ID       int  `json:"id"`
+	Backward bool `json:"b,omitempty"`
+}
+
+// Encode кодирует курсор в непрозрачную для клиента строку.
+func (c *Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor восстанавливает курсор из строки, полученной от Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := new(Cursor)
+	if err := json.Unmarshal(raw, c); err != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// PageRequest — размер страницы и позиция, с которой её читать.
+type PageRequest struct {
+	Limit  int
+	Cursor *Cursor
+}
+
+// Page — страница результатов и курсоры соседних страниц (nil, если их нет).
+type Page[T any] struct {
+	Items         []T
+	NumberMatched int
+	Next          *Cursor
+	Prev          *Cursor
+}
+
+// Link — ссылка в ответе API (next, prev, self и т. п.).
+type Link struct {
+	Href  string `json:"href"`
+	Rel   string `json:"rel"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []Cursor{{ID: 1}, {ID: 42, Backward: true}, {ID: 1<<31 - 1}} {
+		s := c.Encode()
+		got, err := DecodeCursor(s)
+		if err != nil {
+			t.Fatalf("%+v → %q: %v", c, s, err)
+		}
+		if *got != c {
+			t.Errorf("%+v → %q → %+v", c, s, *got)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, s := range []string{
+		"",
+		"не base64",
+		enc(`{"id":`),
+		enc(`{"id":0}`),
+		enc(`{"id":-5}`),
+		enc(`{"id":"7"}`),
+		enc(`[]`),
+		enc(`{"id":7}`) + "==", // в RawURLEncoding дополнения нет
+	} {
+		if c, err := DecodeCursor(s); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) = %+v, %v; ждали ErrInvalidCursor", s, c, err)
+		}
+	}
+}
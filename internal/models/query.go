@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"Datapolis/internal/cql2"
+)
+
+// Пространственные предикаты для запроса фич по геометрии.
+const (
+	PredicateIntersects = "intersects"
+	PredicateWithin     = "within"
+	PredicateContains   = "contains"
+	PredicateTouches    = "touches"
+	PredicateDWithin    = "dwithin"
+)
+
+// Операторы фильтра по свойствам фичи.
+const (
+	PropertyEq  = "="
+	PropertyNe  = "!="
+	PropertyGt  = ">"
+	PropertyGte = ">="
+	PropertyLt  = "<"
+	PropertyLte = "<="
+	PropertyIn  = "in"
+)
+
+// BBox — прямоугольник охвата в системе координат SRID. В WGS 84
+// MinX > MaxX означает прямоугольник через антимеридиан (RFC 7946, 5.2).
+type BBox struct {
+	MinX float64
+	MinY float64
+	MaxX float64
+	MaxY float64
+	SRID int
+}
+
+// Envelopes возвращает обычные прямоугольники, из которых состоит BBox:
+// пересекающий антимеридиан делится на [MinX, 180] и [-180, MaxX].
+func (b BBox) Envelopes() []BBox {
+	if b.MinX <= b.MaxX {
+		return []BBox{b}
+	}
+	east, west := b, b
+	east.MaxX = 180
+	west.MinX = -180
+	return []BBox{east, west}
+}
+
+// SpatialFilter отбирает фичи по отношению их геометрии к заданной.
+// Для within/contains фича является первым аргументом: within — фича
+// лежит внутри Geometry, contains — фича содержит Geometry.
+type SpatialFilter struct {
+	Predicate string
+	Geometry  JSONData
+	SRID      int
+	Distance  float64 // только для dwithin, в метрах
+}
+
+// PropertyFilter — условие на значение свойства фичи (поле properties).
+// Path задаёт путь во вложенных объектах: properties.address.city → ["address", "city"].
+type PropertyFilter struct {
+	Path   []string
+	Op     string
+	Values []string // для in — несколько значений, иначе одно
+}
+
+// TimeInterval — момент (Start == End) или интервал времени;
+// nil-граница означает открытый конец.
+type TimeInterval struct {
+	Start        *time.Time
+	End          *time.Time
+	EndExclusive bool // End не входит в интервал: конец дня, заданного датой
+}
+
+// FeatureQuery описывает условия выборки фич коллекции.
+type FeatureQuery struct {
+	PageRequest
+	BBox       *BBox
+	Spatial    *SpatialFilter
+	Properties []PropertyFilter
+	DateTime   *TimeInterval // по времени последнего изменения фичи
+	OutputSRID int           // 0 — геометрия в SRID хранения
+	Filter     cql2.Expr     // фильтр CQL2, nil — без фильтра
+	FilterSRID int           // SRID геометрий в Filter
+	AsOf       *time.Time    // состояние коллекции на этот момент, nil — текущее; история ведётся с миграции 005
+}
+
+// TileOptions — параметры построения векторного тайла.
+type TileOptions struct {
+	Properties []string // nil — все свойства, пустой срез — без свойств
+	Simplify   float64  // допуск упрощения в пикселях тайла, 0 — без упрощения
+}
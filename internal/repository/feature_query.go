@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"Datapolis/internal/cql2"
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+)
+
+// queryArgs накапливает параметры запроса и выдаёт плейсхолдеры $n.
+type queryArgs []any
+
+func (a *queryArgs) add(v any) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// geoJSONColumn возвращает выражение геометрии в GeoJSON, при outputSRID > 0
+// перепроецированной через ST_Transform.
+func geoJSONColumn(outputSRID int, args *queryArgs) string {
+	if outputSRID > 0 {
+		return "ST_AsGeoJSON(ST_Transform(geometry, " + args.add(outputSRID) + "))"
+	}
+	return "ST_AsGeoJSON(geometry)"
+}
+
+// featureSource возвращает источник строк фич для FROM: geo_features или,
+// при q.AsOf, состояние коллекции на этот момент (historyState). Столбцы
+// названы одинаково, так что featureConditions применимы к обоим.
+func featureSource(collectionID int, q *models.FeatureQuery, args *queryArgs) string {
+	if q == nil || q.AsOf == nil {
+		return "geo_features"
+	}
+	return historyState(collectionID, *q.AsOf, args) + " AS geo_features"
+}
+
+// historyState — подзапрос состояния коллекции на момент asOf,
+// восстановленного по истории: последняя версия каждой фичи не позже
+// asOf, кроме удалённых. Столбцы — как у geo_features.
+func historyState(collectionID int, asOf time.Time, args *queryArgs) string {
+	return `(SELECT id, collection_id, properties, geometry, created_at, updated_at,
+	                NULL::timestamptz AS deleted_at
+	           FROM (SELECT DISTINCT ON (feature_id)
+	                        feature_id AS id, collection_id, properties, geometry, operation,
+	                        min(changed_at) OVER (PARTITION BY feature_id) AS created_at,
+	                        changed_at AS updated_at
+	                   FROM geo_features_history
+	                  WHERE collection_id = ` + args.add(collectionID) + `
+	                    AND changed_at <= ` + args.add(asOf) + `
+	                  ORDER BY feature_id, version DESC) h
+	          WHERE operation <> '` + models.OperationDelete + `')`
+}
+
+// featureConditions собирает условия WHERE для выборки фич коллекции.
+// Все пользовательские значения передаются только через параметры.
+// crs нужна только фильтру dwithin.
+func featureConditions(collectionID int, q *models.FeatureQuery, crs storageCRS, args *queryArgs) ([]string, error) {
+	cid := args.add(collectionID)
+	conds := []string{"collection_id = " + cid, "deleted_at IS NULL"}
+	if q == nil {
+		return conds, nil
+	}
+
+	// SRID хранения берём подзапросом: он вычисляется один раз,
+	// поэтому планировщик может использовать GIST-индекс по geometry.
+	storageSRID := "(SELECT srid FROM geo_collections WHERE id = " + cid + ")"
+
+	if q.BBox != nil {
+		// ST_Intersects неявно делает проверку && по индексу geo_features_geom_gix;
+		// bbox через антимеридиан — две половины, подходит любая
+		var boxes []string
+		for _, b := range q.BBox.Envelopes() {
+			boxes = append(boxes, fmt.Sprintf(
+				"ST_Intersects(geometry, ST_Transform(ST_MakeEnvelope(%s, %s, %s, %s, %s), %s))",
+				args.add(b.MinX), args.add(b.MinY), args.add(b.MaxX), args.add(b.MaxY),
+				args.add(b.SRID), storageSRID,
+			))
+		}
+		conds = append(conds, "("+strings.Join(boxes, " OR ")+")")
+	}
+
+	if sf := q.Spatial; sf != nil {
+		filterGeom := fmt.Sprintf("ST_SetSRID(ST_GeomFromGeoJSON(%s), %s)", args.add(sf.Geometry), args.add(sf.SRID))
+
+		switch fn, ok := spatialPredicates[sf.Predicate]; {
+		case sf.Predicate == models.PredicateDWithin:
+			conds = append(conds, dwithinCondition(filterGeom, sf.Distance, crs, args))
+		case ok:
+			conds = append(conds, fmt.Sprintf("%s(geometry, ST_Transform(%s, %s))", fn, filterGeom, storageSRID))
+		default:
+			return nil, fmt.Errorf("unsupported spatial predicate %q", sf.Predicate)
+		}
+	}
+
+	if dt := q.DateTime; dt != nil {
+		switch {
+		case dt.Start != nil && dt.End != nil && dt.Start.Equal(*dt.End):
+			conds = append(conds, "updated_at = "+args.add(*dt.Start))
+		default:
+			if dt.Start != nil {
+				conds = append(conds, "updated_at >= "+args.add(*dt.Start))
+			}
+			if dt.End != nil && dt.EndExclusive {
+				conds = append(conds, "updated_at < "+args.add(*dt.End))
+			} else if dt.End != nil {
+				conds = append(conds, "updated_at <= "+args.add(*dt.End))
+			}
+		}
+	}
+
+	for _, pf := range q.Properties {
+		cond, err := propertyCondition(pf, args)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+
+	if q.Filter != nil {
+		where := cql2.SQL{Bind: args.add, StorageSRID: storageSRID, FilterSRID: q.FilterSRID}
+		cond, err := where.Where(q.Filter)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	return conds, nil
+}
+
+// storageCRS — система координат хранения коллекции: от неё зависит,
+// как фильтр dwithin сравнивает расстояние в метрах.
+type storageCRS struct {
+	SRID   int
+	Metric bool // проекция в метрах с малыми искажениями длин (UTM, Гаусс–Крюгер)
+}
+
+// newStorageCRS определяет вид системы координат по её proj4-описанию.
+// Web Mercator хоть и в метрах, но растягивает расстояния в 1/cos(широты)
+// раз, поэтому метрической не считается.
+func newStorageCRS(srid int, proj4 string) storageCRS {
+	params := strings.Fields(proj4)
+	metric := slices.Contains(params, "+units=m") && !slices.Contains(params, "+proj=merc")
+	return storageCRS{SRID: srid, Metric: metric}
+}
+
+// dwithinCondition строит условие «не дальше distance метров от filterGeom»
+// так, чтобы его можно было отобрать по индексу:
+//   - в метрической проекции — ST_DWithin по geometry в SRID хранения,
+//     индекс geo_features_geom_gix;
+//   - в WGS 84 — по geography без перепроецирования, частичный индекс
+//     geo_features_geog_gix (миграция 012);
+//   - в остальных системах — по geography в WGS 84 без индекса.
+func dwithinCondition(filterGeom string, distance float64, crs storageCRS, args *queryArgs) string {
+	d := args.add(distance)
+	switch {
+	case crs.Metric:
+		return fmt.Sprintf("ST_DWithin(geometry, ST_Transform(%s, %s), %s)", filterGeom, args.add(crs.SRID), d)
+	case crs.SRID == geo.SRID4326:
+		// условие ST_SRID совпадает с условием частичного индекса
+		return fmt.Sprintf("(ST_SRID(geometry) = 4326 AND ST_DWithin(geometry::geography, ST_Transform(%s, 4326)::geography, %s))",
+			filterGeom, d)
+	}
+	return fmt.Sprintf("ST_DWithin(ST_Transform(geometry, 4326)::geography, ST_Transform(%s, 4326)::geography, %s)", filterGeom, d)
+}
+
+// propertyCondition строит условие на JSONB-поле properties.
+// Равенство и in выражаются через @>, чтобы работал GIN-индекс
+// geo_features_properties_gin; сравнения — через извлечение значения по пути.
+func propertyCondition(pf models.PropertyFilter, args *queryArgs) (string, error) {
+	if len(pf.Path) == 0 || len(pf.Values) == 0 {
+		return "", errors.New("empty property filter")
+	}
+
+	switch pf.Op {
+	case models.PropertyEq, models.PropertyIn:
+		return containsAny(pf.Path, pf.Values, args)
+	case models.PropertyNe:
+		cond, err := containsAny(pf.Path, pf.Values, args)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + cond, nil
+	case models.PropertyGt, models.PropertyGte, models.PropertyLt, models.PropertyLte:
+		path := args.add(pf.Path)
+		if n, ok := jsonScalar(pf.Values[0]).(float64); ok {
+			// CASE гарантирует, что к числу приводятся только числовые значения
+			return fmt.Sprintf(
+				"CASE WHEN jsonb_typeof(properties #> %s) = 'number' THEN (properties #>> %s)::float8 END %s %s",
+				path, path, pf.Op, args.add(n),
+			), nil
+		}
+		return fmt.Sprintf("properties #>> %s %s %s", path, pf.Op, args.add(pf.Values[0])), nil
+	}
+	return "", fmt.Errorf("unsupported property operator %q", pf.Op)
+}
+
+// containsAny строит (properties @> $a OR properties @> $b ...) для всех
+// возможных JSON-представлений значений: "5" ищется и как строка, и как число.
+func containsAny(path []string, values []string, args *queryArgs) (string, error) {
+	var alts []string
+	for _, v := range values {
+		candidates := []any{v}
+		if lit := jsonScalar(v); lit != nil {
+			candidates = append(candidates, lit)
+		} else if v == "null" {
+			candidates = append(candidates, nil)
+		}
+
+		for _, cand := range candidates {
+			doc := cand
+			for i := len(path) - 1; i >= 0; i-- {
+				doc = map[string]any{path[i]: doc}
+			}
+			raw, err := json.Marshal(doc)
+			if err != nil {
+				return "", err
+			}
+			alts = append(alts, "properties @> "+args.add(string(raw))+"::jsonb")
+		}
+	}
+	return "(" + strings.Join(alts, " OR ") + ")", nil
+}
+
+// jsonScalar возвращает число или логическое значение, если v — такой JSON-литерал.
+func jsonScalar(v string) any {
+	var lit any
+	if err := json.Unmarshal([]byte(v), &lit); err != nil {
+		return nil
+	}
+	switch lit.(type) {
+	case float64, bool:
+		return lit
+	}
+	return nil
+}
+
+// spatialPredicates сопоставляет предикаты запроса функциям PostGIS.
+var spatialPredicates = map[string]string{
+	models.PredicateIntersects: "ST_Intersects",
+	models.PredicateWithin:     "ST_Within",
+	models.PredicateContains:   "ST_Contains",
+	models.PredicateTouches:    "ST_Touches",
+}
@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+
+	"Datapolis/internal/models"
+)
+
+// testCollection создаёт коллекцию с SRID хранения srid и точками,
+// заданными в WGS 84, и возвращает её ID и ID точек.
+func testCollection(t *testing.T, tx pgx.Tx, srid int, points ...[2]float64) (int, []int) {
+	t.Helper()
+	ctx := context.Background()
+	var colID int
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO geo_collections (name, description, srid, user_id) VALUES ('test', '', $1, 1) RETURNING id`, srid,
+	).Scan(&colID); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, len(points))
+	for i, p := range points {
+		if err := tx.QueryRow(ctx, `
+		INSERT INTO geo_features (collection_id, properties, geometry)
+		VALUES ($1, '{}', ST_Transform(ST_SetSRID(ST_MakePoint($2, $3), 4326), $4))
+		RETURNING id`, colID, p[0], p[1], srid,
+		).Scan(&ids[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return colID, ids
+}
+
+// TestSpatialFilterProjectedCollection проверяет, что геометрия фильтра
+// читается в своей системе координат, а не в SRID хранения коллекции.
+func TestSpatialFilterProjectedCollection(t *testing.T) {
+	tx := testTx(t)
+	// UTM 42N: координаты хранения — метры
+	colID, ids := testCollection(t, tx, 32642, [2]float64{69.2, 41.3})
+	repo := &GeoRepository{db: tx}
+
+	square := models.JSONData(`{"type":"Polygon","coordinates":[[[69.1,41.2],[69.3,41.2],[69.3,41.4],[69.1,41.4],[69.1,41.2]]]}`)
+	near := models.JSONData(`{"type":"Point","coordinates":[69.201,41.3]}`) // ≈ 84 м от фичи
+	tests := []struct {
+		name   string
+		filter models.SpatialFilter
+		want   int // число найденных фич
+	}{
+		{"intersects в WGS 84", models.SpatialFilter{Predicate: models.PredicateIntersects, Geometry: square, SRID: 4326}, 1},
+		{"те же числа как метры UTM", models.SpatialFilter{Predicate: models.PredicateIntersects, Geometry: square, SRID: 32642}, 0},
+		{"within в WGS 84", models.SpatialFilter{Predicate: models.PredicateWithin, Geometry: square, SRID: 4326}, 1},
+		{"dwithin 200 м", models.SpatialFilter{Predicate: models.PredicateDWithin, Geometry: near, SRID: 4326, Distance: 200}, 1},
+		{"dwithin 50 м", models.SpatialFilter{Predicate: models.PredicateDWithin, Geometry: near, SRID: 4326, Distance: 50}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.GetFeaturesByCollectionID(context.Background(), colID,
+				&models.FeatureQuery{Spatial: &tt.filter, OutputSRID: 4326})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Items) != tt.want || page.NumberMatched != tt.want {
+				t.Fatalf("найдено %d (numberMatched %d), ждали %d", len(page.Items), page.NumberMatched, tt.want)
+			}
+			if tt.want > 0 && page.Items[0].ID != ids[0] {
+				t.Errorf("найдена фича %d, ждали %d", page.Items[0].ID, ids[0])
+			}
+		})
+	}
+}
+
+func TestNewStorageCRS(t *testing.T) {
+	tests := []struct {
+		proj4  string
+		metric bool
+	}{
+		{"+proj=longlat +datum=WGS84 +no_defs", false},
+		{"+proj=utm +zone=42 +datum=WGS84 +units=m +no_defs", true},
+		{"+proj=tmerc +lat_0=0 +lon_0=39 +k=1 +x_0=7500000 +y_0=0 +ellps=krass +units=m +no_defs", true},
+		{"+proj=merc +a=6378137 +b=6378137 +lat_ts=0 +lon_0=0 +x_0=0 +y_0=0 +k=1 +units=m +nadgrids=@null +wktext +no_defs", false},
+		{"+proj=lcc +lat_1=33 +lat_2=45 +datum=NAD83 +units=us-ft +no_defs", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := newStorageCRS(1, tt.proj4); got.Metric != tt.metric {
+			t.Errorf("%q: metric = %v, ждали %v", tt.proj4, got.Metric, tt.metric)
+		}
+	}
+}
+
+func TestDWithinCondition(t *testing.T) {
+	tests := []struct {
+		name string
+		crs  storageCRS
+		want string // фрагмент условия
+	}{
+		{"метрическая проекция", storageCRS{SRID: 32642, Metric: true}, "ST_DWithin(geometry, ST_Transform(g, $2), $1)"},
+		{"WGS 84", storageCRS{SRID: 4326}, "(ST_SRID(geometry) = 4326 AND ST_DWithin(geometry::geography,"},
+		{"Web Mercator", storageCRS{SRID: 3857}, "ST_DWithin(ST_Transform(geometry, 4326)::geography,"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args queryArgs
+			got := dwithinCondition("g", 100, tt.crs, &args)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("получили %s, ждали фрагмент %s", got, tt.want)
+			}
+			if args[0] != 100.0 {
+				t.Errorf("параметры %v", args)
+			}
+		})
+	}
+}
+
+// TestDWithinStorageCRS проверяет dwithin в метрах для коллекций в WGS 84,
+// в UTM и в Web Mercator.
+func TestDWithinStorageCRS(t *testing.T) {
+	tx := testTx(t)
+	repo := &GeoRepository{db: tx}
+	// точки в 100 и 300 м к северу от (30, 60)
+	near := models.JSONData(`{"type":"Point","coordinates":[30,60]}`)
+	for _, srid := range []int{4326, 32636, 3857} {
+		colID, ids := testCollection(t, tx, srid, [2]float64{30, 60.0009}, [2]float64{30, 60.0027})
+		page, err := repo.GetFeaturesByCollectionID(context.Background(), colID, &models.FeatureQuery{
+			Spatial: &models.SpatialFilter{Predicate: models.PredicateDWithin, Geometry: near, SRID: 4326, Distance: 200},
+		})
+		if err != nil {
+			t.Fatalf("SRID %d: %v", srid, err)
+		}
+		if len(page.Items) != 1 || page.Items[0].ID != ids[0] {
+			t.Errorf("SRID %d: найдено %d фич, ждали только ближнюю", srid, len(page.Items))
+		}
+	}
+}
+
+func TestPropertyCondition(t *testing.T) {
+	tests := []struct {
+		name string
+		pf   models.PropertyFilter
+		want string
+		args []any
+	}{
+		{
+			name: "строка ищется как строка",
+			pf:   models.PropertyFilter{Path: []string{"status"}, Op: models.PropertyEq, Values: []string{"active"}},
+			want: "(properties @> $1::jsonb)",
+			args: []any{`{"status":"active"}`},
+		},
+		{
+			name: "число — и как строка, и как число",
+			pf:   models.PropertyFilter{Path: []string{"address", "house"}, Op: models.PropertyEq, Values: []string{"5"}},
+			want: "(properties @> $1::jsonb OR properties @> $2::jsonb)",
+			args: []any{`{"address":{"house":"5"}}`, `{"address":{"house":5}}`},
+		},
+		{
+			name: "null",
+			pf:   models.PropertyFilter{Path: []string{"a"}, Op: models.PropertyNe, Values: []string{"null"}},
+			want: "NOT (properties @> $1::jsonb OR properties @> $2::jsonb)",
+			args: []any{`{"a":"null"}`, `{"a":null}`},
+		},
+		{
+			name: "in",
+			pf:   models.PropertyFilter{Path: []string{"d"}, Op: models.PropertyIn, Values: []string{"A", "true"}},
+			want: "(properties @> $1::jsonb OR properties @> $2::jsonb OR properties @> $3::jsonb)",
+			args: []any{`{"d":"A"}`, `{"d":"true"}`, `{"d":true}`},
+		},
+		{
+			name: "сравнение с числом",
+			pf:   models.PropertyFilter{Path: []string{"floors"}, Op: models.PropertyGte, Values: []string{"5"}},
+			want: "CASE WHEN jsonb_typeof(properties #> $1) = 'number' THEN (properties #>> $1)::float8 END >= $2",
+			args: []any{[]string{"floors"}, 5.0},
+		},
+		{
+			name: "сравнение со строкой",
+			pf:   models.PropertyFilter{Path: []string{"name"}, Op: models.PropertyLt, Values: []string{"M"}},
+			want: "properties #>> $1 < $2",
+			args: []any{[]string{"name"}, "M"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args queryArgs
+			got, err := propertyCondition(tt.pf, &args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("получили %s\nждали %s", got, tt.want)
+			}
+			if !reflect.DeepEqual([]any(args), tt.args) {
+				t.Errorf("параметры %#v, ждали %#v", args, tt.args)
+			}
+		})
+	}
+
+	for _, pf := range []models.PropertyFilter{
+		{Op: models.PropertyEq, Values: []string{"1"}},
+		{Path: []string{"a"}, Op: models.PropertyEq},
+		{Path: []string{"a"}, Op: "~", Values: []string{"1"}},
+	} {
+		var args queryArgs
+		if cond, err := propertyCondition(pf, &args); err == nil {
+			t.Errorf("%+v: ждали ошибку, получили %s", pf, cond)
+		}
+	}
+}
+
+// TestPropertyFilters проверяет фильтры свойств на данных: значения
+// разных JSON-типов и отсутствующие свойства.
+func TestPropertyFilters(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	colID, _ := testCollection(t, tx, 4326)
+	props := []string{
+		`{"name":"a","floors":5,"status":"active","address":{"city":"Москва"}}`,
+		`{"name":"b","floors":"5","status":"closed"}`,
+		`{"name":"c","floors":12,"address":{"city":"Казань"}}`,
+		`{"name":"d","floors":"много","status":null}`,
+	}
+	for _, p := range props {
+		if _, err := tx.Exec(ctx, `
+		INSERT INTO geo_features (collection_id, properties, geometry)
+		VALUES ($1, $2, ST_SetSRID(ST_MakePoint(30, 60), 4326))`, colID, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := &GeoRepository{db: tx}
+
+	tests := []struct {
+		name string
+		pf   models.PropertyFilter
+		want []string // значения name в порядке id
+	}{
+		{"равно числу и строке", models.PropertyFilter{Path: []string{"floors"}, Op: models.PropertyEq, Values: []string{"5"}}, []string{"a", "b"}},
+		{"вложенное", models.PropertyFilter{Path: []string{"address", "city"}, Op: models.PropertyEq, Values: []string{"Казань"}}, []string{"c"}},
+		{"больше — только числа", models.PropertyFilter{Path: []string{"floors"}, Op: models.PropertyGt, Values: []string{"4"}}, []string{"a", "c"}},
+		{"не равно — и без свойства", models.PropertyFilter{Path: []string{"status"}, Op: models.PropertyNe, Values: []string{"active"}}, []string{"b", "c", "d"}},
+		{"null", models.PropertyFilter{Path: []string{"status"}, Op: models.PropertyEq, Values: []string{"null"}}, []string{"d"}},
+		{"in", models.PropertyFilter{Path: []string{"name"}, Op: models.PropertyIn, Values: []string{"b", "d", "z"}}, []string{"b", "d"}},
+		{"строки сравниваются как строки", models.PropertyFilter{Path: []string{"name"}, Op: models.PropertyGte, Values: []string{"c"}}, []string{"c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.GetFeaturesByCollectionID(ctx, colID,
+				&models.FeatureQuery{Properties: []models.PropertyFilter{tt.pf}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range page.Items {
+				var p struct{ Name string }
+				if err := json.Unmarshal(f.Properties, &p); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, p.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("найдены %v, ждали %v", got, tt.want)
+			}
+		})
+	}
+}
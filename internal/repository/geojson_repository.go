@@ -4,22 +4,88 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"Datapolis/internal/geo"
 	"Datapolis/internal/models"
 )
 
-const srid4326 = 4326
+// dbtx — общие методы пула соединений и транзакции, чтобы одни и те же
+// методы репозитория работали и вне транзакции, и внутри InTx.
+type dbtx interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error)
+}
 
 type GeoRepository struct {
-	db *pgxpool.Pool
+	db dbtx
 }
 
 func NewGeoRepository(db *pgxpool.Pool) *GeoRepository {
 	return &GeoRepository{db: db}
 }
 
+// InTx выполняет fn в транзакции: репозиторий tx работает через неё.
+// Ошибка fn откатывает транзакцию, иначе она фиксируется.
+func (r *GeoRepository) InTx(ctx context.Context, fn func(tx *GeoRepository) error) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// после Commit откат ничего не делает
+	defer tx.Rollback(ctx)
+
+	if err := fn(&GeoRepository{db: tx}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// InSnapshot выполняет fn в транзакции REPEATABLE READ READ ONLY: все
+// запросы fn видят один и тот же снимок базы. Внутри InTx fn выполняется
+// в уже открытой транзакции.
+func (r *GeoRepository) InSnapshot(ctx context.Context, fn func(tx *GeoRepository) error) error {
+	pool, ok := r.db.(*pgxpool.Pool)
+	if !ok {
+		return fn(r)
+	}
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(&GeoRepository{db: tx}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// AsUser выполняет fn в транзакции от имени пользователя userID:
+// триггер истории geo_features записывает его автором изменений.
+func (r *GeoRepository) AsUser(ctx context.Context, userID int, fn func(tx *GeoRepository) error) error {
+	return r.InTx(ctx, func(tx *GeoRepository) error {
+		if _, err := tx.db.Exec(ctx,
+			`SELECT set_config('datapolis.user_id', $1, true)`, strconv.Itoa(userID),
+		); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
 // CreateCollection создает новую коллекцию GeoJSON
 func (r *GeoRepository) CreateCollection(ctx context.Context, c *models.GeoJSONCollection) error {
 	return r.db.QueryRow(ctx,
@@ -29,80 +95,181 @@ func (r *GeoRepository) CreateCollection(ctx context.Context, c *models.GeoJSONC
 	).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
 }
 
+// GetCollections возвращает страницу коллекций, новые — первыми.
 func (r *GeoRepository) GetCollections(
-	ctx context.Context,
-) ([]*models.GeoJSONCollection, error) {
+	ctx context.Context, p models.PageRequest,
+) (*models.Page[*models.GeoJSONCollection], error) {
 
-	const q = `
+	var matched int
+	if err := r.db.QueryRow(ctx,
+		`SELECT count(*) FROM geo_collections WHERE deleted_at IS NULL`,
+	).Scan(&matched); err != nil {
+		return nil, err
+	}
+
+	var args queryArgs
+	limit := pageLimit(p.Limit)
+	where, order := keysetClause(p.Cursor, true, &args)
+	if where != "" {
+		where = "AND " + where
+	}
+
+	q := `
 	SELECT id, name, description, srid,
 	       user_id, created_at, updated_at
 	FROM   geo_collections
-	ORDER BY created_at DESC;`
+	WHERE  deleted_at IS NULL
+	` + where + `
+	ORDER BY ` + order + `
+	LIMIT ` + args.add(limit+1)
+
+	list, err := r.scanCollections(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
 
-	return r.scanCollections(ctx, q)
+	page := buildPage(list, func(c *models.GeoJSONCollection) int { return c.ID }, limit, p.Cursor)
+	page.NumberMatched = matched
+	return page, nil
 }
 
-// DeleteCollection удаляет коллекцию
+// DeleteCollection переносит коллекцию в корзину вместе с её фичами.
+// Фичи получают то же время удаления, что и коллекция: по нему
+// RestoreCollection отличает их от удалённых раньше по отдельности.
+// Вызывается внутри транзакции.
 func (r *GeoRepository) DeleteCollection(ctx context.Context, id, userID int) error {
-	cmd, err := r.db.Exec(ctx, `DELETE FROM geo_collections WHERE id=$1 AND user_id=$2`, id, userID)
+	cmd, err := r.db.Exec(ctx, `
+	UPDATE geo_collections SET deleted_at = NOW()
+	 WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL`, id, userID)
 	if err != nil {
 		return err
 	}
 	if cmd.RowsAffected() == 0 {
 		return errors.New("collection not found or not owned by user")
 	}
-	return nil
+	_, err = r.db.Exec(ctx, `
+	UPDATE geo_features SET deleted_at = NOW()
+	 WHERE collection_id=$1 AND deleted_at IS NULL`, id)
+	return err
 }
 
-func (r *GeoRepository) FeaturesByCollection(ctx context.Context, collectionID int) ([]*models.GeoJSONFeature, error) {
-	rows, err := r.db.Query(ctx,
-		`SELECT id, properties, ST_AsGeoJSON(geometry)::jsonb AS geometry, collection_id, created_at, updated_at
-           FROM geo_features WHERE collection_id=$1`, collectionID)
+// StreamFeatures вызывает fn для каждой фичи коллекции в порядке id.
+// pgx читает строки из соединения по мере итерации, поэтому в памяти
+// одновременно находится одна фича независимо от размера коллекции.
+// При outputSRID > 0 геометрия перепроецируется в outputSRID.
+func (r *GeoRepository) StreamFeatures(
+	ctx context.Context,
+	collectionID, outputSRID int,
+	fn func(*models.GeoJSONFeature) error,
+) error {
+	var args queryArgs
+	q := `SELECT id, properties, ` + geoJSONColumn(outputSRID, &args) + `::jsonb AS geometry, collection_id, created_at, updated_at
+           FROM geo_features WHERE collection_id=` + args.add(collectionID) + ` AND deleted_at IS NULL ORDER BY id`
+	rows, err := r.db.Query(ctx, q, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var res []*models.GeoJSONFeature
 	for rows.Next() {
 		f := &models.GeoJSONFeature{}
 		var props, geom []byte
 		if err := rows.Scan(&f.ID, &props, &geom, &f.CollectionID, &f.CreatedAt, &f.UpdatedAt); err != nil {
-			return nil, err
+			return err
 		}
 		f.Properties = models.JSONData(props)
 		f.Geometry = models.JSONData(geom)
-		res = append(res, f)
+		if err := fn(f); err != nil {
+			return err
+		}
 	}
-	return res, rows.Err()
+	return rows.Err()
 }
 
-// DeleteFeature удаляет фичу
-func (r *GeoRepository) DeleteFeature(ctx context.Context, id int) error {
-	cmd, err := r.db.Exec(ctx, `DELETE FROM geo_features WHERE id=$1`, id)
-	if err != nil {
-		return err
+// SRIDExists проверяет, что система координат есть в spatial_ref_sys
+// и, значит, ST_Transform сможет в неё перепроецировать.
+func (r *GeoRepository) SRIDExists(ctx context.Context, srid int) (bool, error) {
+	var ok bool
+	err := r.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM spatial_ref_sys WHERE srid=$1)`, srid,
+	).Scan(&ok)
+	return ok, err
+}
+
+// SpatialRefSys возвращает описание системы координат из spatial_ref_sys:
+// организацию, код в ней и WKT; ok=false — SRID неизвестен.
+func (r *GeoRepository) SpatialRefSys(ctx context.Context, srid int) (authName string, authSRID int, srtext string, ok bool, err error) {
+	err = r.db.QueryRow(ctx,
+		`SELECT COALESCE(auth_name, ''), COALESCE(auth_srid, 0), COALESCE(srtext, '')
+           FROM spatial_ref_sys WHERE srid=$1`, srid,
+	).Scan(&authName, &authSRID, &srtext)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", 0, "", false, nil
 	}
-	if cmd.RowsAffected() == 0 {
-		return errors.New("feature not found")
+	return authName, authSRID, srtext, err == nil, err
+}
+
+// DeleteFeature переносит фичу в корзину; ifMatch — как в UpdateFeature.
+// false — фича не найдена или изменена с тех пор.
+func (r *GeoRepository) DeleteFeature(ctx context.Context, id int, ifMatch []time.Time) (bool, error) {
+	args := queryArgs{id}
+	cmd, err := r.db.Exec(ctx,
+		`UPDATE geo_features SET deleted_at = NOW() WHERE id=$1 AND deleted_at IS NULL`+
+			ifMatchCondition(ifMatch, &args), args...)
+	if err != nil {
+		return false, err
 	}
-	return nil
+	return cmd.RowsAffected() > 0, nil
 }
 
+// GetFeaturesByCollectionID возвращает страницу фич коллекции,
+// удовлетворяющих условиям q, и общее число подходящих фич.
 func (r *GeoRepository) GetFeaturesByCollectionID(
-	ctx context.Context, collectionID int,
-) ([]*models.GeoJSONFeature, error) {
+	ctx context.Context, collectionID int, q *models.FeatureQuery,
+) (*models.Page[*models.GeoJSONFeature], error) {
+
+	if q == nil {
+		q = &models.FeatureQuery{}
+	}
+	var crs storageCRS
+	if q.Spatial != nil && q.Spatial.Predicate == models.PredicateDWithin {
+		var err error
+		if crs, err = r.storageCRS(ctx, collectionID); err != nil {
+			return nil, err
+		}
+	}
+	var args queryArgs
+	conds, err := featureConditions(collectionID, q, crs, &args)
+	if err != nil {
+		return nil, err
+	}
+	source := featureSource(collectionID, q, &args)
+
+	var matched int
+	if err := r.db.QueryRow(ctx,
+		`SELECT count(*) FROM `+source+` WHERE `+strings.Join(conds, " AND "), args...,
+	).Scan(&matched); err != nil {
+		return nil, err
+	}
+
+	limit := pageLimit(q.Limit)
+	keyCond, order := keysetClause(q.Cursor, false, &args)
+	if keyCond != "" {
+		conds = append(conds, keyCond)
+	}
+	geom := geoJSONColumn(q.OutputSRID, &args)
 
 	rows, err := r.db.Query(ctx, `
         SELECT id,
                properties,
-               ST_AsGeoJSON(geometry)::jsonb,
+               `+geom+`::jsonb,
                collection_id,
                created_at,
                updated_at
-        FROM   geo_features
-        WHERE  collection_id = $1
-        ORDER  BY id`, collectionID)
+        FROM   `+source+`
+        WHERE  `+strings.Join(conds, " AND ")+`
+        ORDER  BY `+order+`
+        LIMIT  `+args.add(limit+1), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +287,32 @@ func (r *GeoRepository) GetFeaturesByCollectionID(
 		f.Geometry = models.JSONData(geom)
 		feats = append(feats, f)
 	}
-	return feats, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := buildPage(feats, func(f *models.GeoJSONFeature) int { return f.ID }, limit, q.Cursor)
+	page.NumberMatched = matched
+	return page, nil
+}
+
+// storageCRS возвращает систему координат хранения коллекции; для
+// несуществующей коллекции — пустую.
+func (r *GeoRepository) storageCRS(ctx context.Context, collectionID int) (storageCRS, error) {
+	var srid int
+	var proj4 string
+	err := r.db.QueryRow(ctx, `
+	SELECT c.srid, coalesce(s.proj4text, '')
+	FROM   geo_collections c
+	LEFT   JOIN spatial_ref_sys s ON s.srid = c.srid
+	WHERE  c.id = $1`, collectionID).Scan(&srid, &proj4)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storageCRS{}, nil
+	}
+	if err != nil {
+		return storageCRS{}, err
+	}
+	return newStorageCRS(srid, proj4), nil
 }
 
 func (r *GeoRepository) GetCollectionByID(
@@ -131,7 +323,7 @@ func (r *GeoRepository) GetCollectionByID(
 	SELECT id, name, description, srid,
 	       user_id, created_at, updated_at
 	FROM   geo_collections
-	WHERE  id = $1;`
+	WHERE  id = $1 AND deleted_at IS NULL;`
 
 	col := new(models.GeoJSONCollection)
 	err := r.db.QueryRow(ctx, q, id).Scan(
@@ -153,47 +345,147 @@ func (r *GeoRepository) GetCollectionByID(
 	return col, nil
 }
 
+// UpdateFeature записывает свойства и геометрию фичи и обновляет в f
+// время изменения. При ifMatch != nil фича изменяется, только если её
+// updated_at совпадает с одним из ifMatch: проверка и запись атомарны.
+// false — фича не найдена или изменена с тех пор.
 func (r *GeoRepository) UpdateFeature(
 	ctx context.Context,
 	f *models.GeoJSONFeature,
-	srid int, // обычно defaultSRID
-) error {
-	props := f.Properties
-	geom := f.Geometry
-
-	res, err := r.db.Exec(ctx, `
+	srid int, // SRID хранения коллекции
+	makeValid bool,
+	ifMatch []time.Time,
+) (bool, error) {
+	args := queryArgs{f.Properties, f.Geometry, srid, f.ID, f.CollectionID}
+	err := r.db.QueryRow(ctx, `
         UPDATE geo_features
            SET properties = $1,
-               geometry   = ST_SetSRID(ST_GeomFromGeoJSON($2), $3),
+               geometry   = `+geometryInput("$2", "$3", makeValid)+`,
                updated_at = NOW()
          WHERE id = $4
-           AND collection_id = $5;
-    `,
-		props,
-		geom,
-		srid,
-		f.ID,
-		f.CollectionID,
-	)
+           AND collection_id = $5
+           AND deleted_at IS NULL`+ifMatchCondition(ifMatch, &args)+`
+     RETURNING created_at, updated_at;
+    `, args...).Scan(&f.CreatedAt, &f.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UpdateFeatureProperties записывает только свойства фичи, не трогая
+// геометрию, и обновляет в f время изменения.
+func (r *GeoRepository) UpdateFeatureProperties(ctx context.Context, f *models.GeoJSONFeature) error {
+	return r.db.QueryRow(ctx, `
+        UPDATE geo_features
+           SET properties = $1,
+               updated_at = NOW()
+         WHERE id = $2
+           AND deleted_at IS NULL
+     RETURNING created_at, updated_at`, f.Properties, f.ID,
+	).Scan(&f.CreatedAt, &f.UpdatedAt)
+}
+
+// patchFunctions — функции миграции 007, применяющие патч к JSONB.
+var patchFunctions = map[string]string{
+	models.PatchMerge: "jsonb_merge_patch",
+	models.PatchJSON:  "jsonb_json_patch",
+}
+
+// PatchedFeature применяет к фиче патч формата kind и возвращает
+// результат, не записывая его. Патчится документ {"properties": ...,
+// "geometry": ...} с геометрией в SRID хранения; geometryChanged=false —
+// патч не затронул геометрию. Строка фичи блокируется до конца транзакции,
+// ifMatch — как в UpdateFeature; nil — фича не найдена или изменена
+// с тех пор. Неприменимый патч — ошибка *models.PatchError.
+func (r *GeoRepository) PatchedFeature(
+	ctx context.Context,
+	id int,
+	kind string,
+	patch models.JSONData,
+	ifMatch []time.Time,
+) (f *models.GeoJSONFeature, geometryChanged bool, err error) {
+	fn, ok := patchFunctions[kind]
+	if !ok {
+		return nil, false, fmt.Errorf("unknown patch kind %q", kind)
+	}
+
+	f = new(models.GeoJSONFeature)
+	var props, wkb []byte
+	args := queryArgs{id}
+	err = r.db.QueryRow(ctx, `
+	SELECT id, collection_id, properties, ST_AsEWKB(geometry), created_at, updated_at
+	  FROM geo_features
+	 WHERE id = $1
+	   AND deleted_at IS NULL`+ifMatchCondition(ifMatch, &args)+`
+	   FOR UPDATE`, args...).Scan(&f.ID, &f.CollectionID, &props, &wkb, &f.CreatedAt, &f.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
 	if err != nil {
-		return err
+		return nil, false, err
 	}
-	if res.RowsAffected() == 0 {
-		return errors.New("feature not found")
+
+	// GeoJSON геометрии собирается здесь, а не ST_AsGeoJSON: тот округляет
+	// координаты до 15 знаков, и патч одной вершины сдвигал бы остальные
+	g, err := geo.ParseWKB(wkb)
+	if err != nil {
+		return nil, false, err
+	}
+	geom, err := json.Marshal(g)
+	if err != nil {
+		return nil, false, err
+	}
+	orig, err := json.Marshal(struct {
+		Properties json.RawMessage `json:"properties"`
+		Geometry   json.RawMessage `json:"geometry"`
+	}{props, geom})
+	if err != nil {
+		return nil, false, err
 	}
-	return nil
+
+	err = r.db.QueryRow(ctx, `
+	SELECT doc -> 'properties', doc -> 'geometry',
+	       doc -> 'geometry' IS DISTINCT FROM $1::jsonb -> 'geometry'
+	  FROM (SELECT `+fn+`($1::jsonb, $2::jsonb) AS doc) p`,
+		orig, patch).Scan(&props, &geom, &geometryChanged)
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr) && pgErr.Code == invalidParameterValue:
+		return nil, false, &models.PatchError{Reason: pgErr.Message}
+	case err != nil:
+		return nil, false, err
+	}
+	f.Properties = models.JSONData(props)
+	f.Geometry = models.JSONData(geom)
+	return f, geometryChanged, nil
 }
 
-func (r *GeoRepository) GetFeatureByID(ctx context.Context, id int) (*models.GeoJSONFeature, error) {
-	const q = `
+// invalidParameterValue — SQLSTATE, с которым функции патча сообщают
+// о неприменимом патче.
+const invalidParameterValue = "22023"
+
+// ifMatchCondition — условие на updated_at для If-Match; nil — без условия.
+func ifMatchCondition(ifMatch []time.Time, args *queryArgs) string {
+	if ifMatch == nil {
+		return ""
+	}
+	return " AND updated_at = ANY(" + args.add(ifMatch) + ")"
+}
+
+// GetFeatureByID возвращает фичу; при outputSRID > 0 геометрия
+// перепроецируется в эту систему координат, иначе отдаётся в SRID хранения.
+func (r *GeoRepository) GetFeatureByID(ctx context.Context, id, outputSRID int) (*models.GeoJSONFeature, error) {
+	var args queryArgs
+	q := `
 	SELECT id,
 	       collection_id,
 	       properties,
-	       ST_AsGeoJSON(geometry) AS geom,          -- конвертируем в GeoJSON
+	       ` + geoJSONColumn(outputSRID, &args) + ` AS geom,          -- конвертируем в GeoJSON
 	       created_at,
 	       updated_at
 	FROM   geo_features
-	WHERE  id = $1;
+	WHERE  id = ` + args.add(id) + ` AND deleted_at IS NULL;
 	`
 
 	var (
@@ -202,7 +494,7 @@ func (r *GeoRepository) GetFeatureByID(ctx context.Context, id int) (*models.Geo
 		f         models.GeoJSONFeature
 	)
 
-	err := r.db.QueryRow(ctx, q, id).Scan(
+	err := r.db.QueryRow(ctx, q, args...).Scan(
 		&f.ID,
 		&f.CollectionID,
 		&propsData,
@@ -257,48 +549,130 @@ func (r *GeoRepository) scanCollections(
 	return list, nil
 }
 
-// AddFeaturesBulk выполняет серию INSERT в одном батче.
-func (r *GeoRepository) AddFeaturesBulk(
+// CreateImportStaging создаёт пустую временную таблицу для потокового
+// импорта. Таблица удаляется при завершении транзакции, поэтому вызывать
+// её нужно внутри InTx; повторный вызов в той же транзакции (следующий
+// слой многослойного файла) очищает таблицу.
+func (r *GeoRepository) CreateImportStaging(ctx context.Context) error {
+	_, err := r.db.Exec(ctx, `
+	CREATE TEMP TABLE IF NOT EXISTS geo_import_staging (
+	    idx        int PRIMARY KEY,
+	    properties jsonb NOT NULL,
+	    geojson    text  NOT NULL
+	) ON COMMIT DROP`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(ctx, `TRUNCATE geo_import_staging`)
+	return err
+}
+
+// CopyToStaging загружает фичи в geo_import_staging через COPY. next
+// возвращает позицию фичи в файле и саму фичу, io.EOF — конец данных;
+// строки уходят на сервер по мере чтения, не накапливаясь в памяти.
+func (r *GeoRepository) CopyToStaging(
 	ctx context.Context,
-	features []*models.GeoJSONFeature,
-	srid int,
-) error {
-	batch := &pgx.Batch{}
-	for _, f := range features {
-		props := json.RawMessage(`{}`)
-		if len(f.Properties) > 0 {
-			props = json.RawMessage(f.Properties)
+	next func() (int, *models.GeoJSONFeature, error),
+) (int64, error) {
+	src := &stagingSource{next: next}
+	n, err := r.db.CopyFrom(ctx,
+		pgx.Identifier{"geo_import_staging"},
+		[]string{"idx", "properties", "geojson"},
+		src,
+	)
+	if src.err != nil {
+		// сервер сообщает лишь, что COPY прерван; причина — ошибка источника
+		return n, src.err
+	}
+	return n, err
+}
+
+// stagingSource приводит функцию next к pgx.CopyFromSource.
+type stagingSource struct {
+	next func() (int, *models.GeoJSONFeature, error)
+	row  []any
+	err  error
+}
+
+func (s *stagingSource) Next() bool {
+	idx, f, err := s.next()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.err = err
 		}
-		batch.Queue(
-			`INSERT INTO geo_features
-                (properties, geometry, collection_id)
-             VALUES
-                ($1, ST_SetSRID(ST_GeomFromGeoJSON($2), $3), $4)
-             RETURNING id, created_at, updated_at;`,
-			props,
-			f.Geometry,
-			srid,
-			f.CollectionID,
-		)
+		return false
+	}
+	props := json.RawMessage(`{}`)
+	if len(f.Properties) > 0 && string(f.Properties) != "null" {
+		props = json.RawMessage(f.Properties)
 	}
+	s.row = []any{idx, props, string(f.Geometry)}
+	return true
+}
 
-	br := r.db.SendBatch(ctx, batch)
-	defer br.Close()
+func (s *stagingSource) Values() ([]any, error) { return s.row, nil }
 
-	for _, f := range features {
-		if err := br.QueryRow().Scan(&f.ID, &f.CreatedAt, &f.UpdatedAt); err != nil {
-			return err
+func (s *stagingSource) Err() error { return s.err }
+
+// InvalidStaged возвращает фичи geo_import_staging с некорректной
+// геометрией и причину некорректности (ST_IsValidReason) в порядке idx.
+func (r *GeoRepository) InvalidStaged(ctx context.Context) ([]models.FeatureIssue, error) {
+	rows, err := r.db.Query(ctx, `
+	SELECT idx, ST_IsValidReason(g)
+	FROM   geo_import_staging, ST_GeomFromGeoJSON(geojson) AS g
+	WHERE  NOT ST_IsValid(g)
+	ORDER BY idx`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issues []models.FeatureIssue
+	for rows.Next() {
+		var it models.FeatureIssue
+		if err := rows.Scan(&it.Index, &it.Reason); err != nil {
+			return nil, err
 		}
+		issues = append(issues, it)
 	}
+	return issues, rows.Err()
+}
 
-	return nil
+// DeleteStaged удаляет из geo_import_staging фичи с позициями idxs.
+func (r *GeoRepository) DeleteStaged(ctx context.Context, idxs []int) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM geo_import_staging WHERE idx = ANY($1)`, idxs)
+	return err
 }
 
+// InsertStaged переносит фичи из geo_import_staging в коллекцию.
+// Геометрия строится и перепроецируется из sourceSRID в targetSRID
+// на стороне сервера; при makeValid исправляется ST_MakeValid.
+func (r *GeoRepository) InsertStaged(
+	ctx context.Context,
+	collectionID, sourceSRID, targetSRID int,
+	makeValid bool,
+) (int64, error) {
+	cmd, err := r.db.Exec(ctx, `
+	INSERT INTO geo_features (properties, geometry, collection_id)
+	SELECT properties, ST_Transform(`+geometryInput("geojson", "$1", makeValid)+`, $2), $3
+	FROM   geo_import_staging
+	ORDER BY idx`,
+		sourceSRID, targetSRID, collectionID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return cmd.RowsAffected(), nil
+}
+
+// AddSingleFeature добавляет фичу в коллекцию; false — коллекции
+// f.CollectionID нет (удалена после проверки вызывающим).
 func (r *GeoRepository) AddSingleFeature(
 	ctx context.Context,
 	f *models.GeoJSONFeature,
 	srid int,
-) error {
+	makeValid bool,
+) (bool, error) {
 	props := f.Properties
 	geom := f.Geometry
 
@@ -308,7 +682,7 @@ func (r *GeoRepository) AddSingleFeature(
             (properties, geometry, collection_id)
         VALUES
             ($1,
-             ST_SetSRID(ST_GeomFromGeoJSON($2), $3),
+             `+geometryInput("$2", "$3", makeValid)+`,
              $4)
         RETURNING id, created_at, updated_at;
     `,
@@ -317,5 +691,222 @@ func (r *GeoRepository) AddSingleFeature(
 		srid,
 		f.CollectionID,
 	).Scan(&f.ID, &f.CreatedAt, &f.UpdatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// foreignKeyViolation — SQLSTATE нарушения внешнего ключа.
+const foreignKeyViolation = "23503"
+
+// geometryInput строит выражение геометрии из GeoJSON-параметра geom
+// с системой координат srid; при makeValid добавляется ST_MakeValid.
+func geometryInput(geom, srid string, makeValid bool) string {
+	expr := "ST_SetSRID(ST_GeomFromGeoJSON(" + geom + "), " + srid + ")"
+	if makeValid {
+		expr = "ST_MakeValid(" + expr + ")"
+	}
+	return expr
+}
+
+// GeometryValidity проверяет геометрию GeoJSON средствами PostGIS и
+// возвращает причину некорректности (ST_IsValidReason) или пустую строку.
+func (r *GeoRepository) GeometryValidity(ctx context.Context, geom models.JSONData) (string, error) {
+	var reason string
+	err := r.db.QueryRow(ctx, `
+	SELECT CASE WHEN ST_IsValid(g) THEN '' ELSE ST_IsValidReason(g) END
+	FROM   ST_GeomFromGeoJSON($1::text) AS g`, string(geom),
+	).Scan(&reason)
+	return reason, err
+}
+
+// GetCollectionExtents возвращает охваты коллекций из ids.
+// Пустые коллекции в результат не попадают.
+func (r *GeoRepository) GetCollectionExtents(
+	ctx context.Context, ids []int,
+) (map[int]*models.Extent, error) {
+
+	const q = `
+	SELECT collection_id,
+	       ST_XMin(e), ST_YMin(e), ST_XMax(e), ST_YMax(e),
+	       t_min, t_max
+	FROM (
+	    SELECT f.collection_id,
+	           ST_Transform(ST_SetSRID(ST_Extent(f.geometry)::geometry, c.srid), 4326) AS e,
+	           min(f.updated_at) AS t_min,
+	           max(f.updated_at) AS t_max
+	    FROM   geo_features f
+	    JOIN   geo_collections c ON c.id = f.collection_id
+	    WHERE  f.collection_id = ANY($1) AND f.deleted_at IS NULL
+	    GROUP  BY f.collection_id, c.srid
+	) s;`
+
+	rows, err := r.db.Query(ctx, q, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[int]*models.Extent, len(ids))
+	for rows.Next() {
+		var id int
+		e := new(models.Extent)
+		if err := rows.Scan(&id, &e.MinX, &e.MinY, &e.MaxX, &e.MaxY, &e.Start, &e.End); err != nil {
+			return nil, err
+		}
+		res[id] = e
+	}
+	return res, rows.Err()
+}
+
+// FeatureHistory возвращает все версии фичи по возрастанию номера,
+// включая удаление; при outputSRID > 0 геометрия перепроецируется.
+func (r *GeoRepository) FeatureHistory(ctx context.Context, featureID, outputSRID int) ([]*models.FeatureVersion, error) {
+	var args queryArgs
+	return r.featureVersions(ctx, outputSRID, `feature_id = `+args.add(featureID), &args)
+}
+
+// FeatureVersion возвращает версию фичи из истории или nil, если её нет.
+func (r *GeoRepository) FeatureVersion(ctx context.Context, featureID, version int) (*models.FeatureVersion, error) {
+	var args queryArgs
+	versions, err := r.featureVersions(ctx, 0,
+		`feature_id = `+args.add(featureID)+` AND version = `+args.add(version), &args)
+	if err != nil || len(versions) == 0 {
+		return nil, err
+	}
+	return versions[0], nil
+}
+
+func (r *GeoRepository) featureVersions(ctx context.Context, outputSRID int, where string, args *queryArgs) ([]*models.FeatureVersion, error) {
+	q := `
+	SELECT feature_id, collection_id, version, operation, properties,
+	       ` + geoJSONColumn(outputSRID, args) + `::jsonb,
+	       user_id, changed_at
+	FROM   geo_features_history
+	WHERE  ` + where + `
+	ORDER  BY version`
+
+	rows, err := r.db.Query(ctx, q, *args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*models.FeatureVersion
+	for rows.Next() {
+		v := new(models.FeatureVersion)
+		var props, geom []byte
+		if err := rows.Scan(&v.FeatureID, &v.CollectionID, &v.Version, &v.Operation,
+			&props, &geom, &v.UserID, &v.ChangedAt); err != nil {
+			return nil, err
+		}
+		v.Properties = models.JSONData(props)
+		v.Geometry = models.JSONData(geom)
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// RevertFeature возвращает фичу к версии v из истории: её свойства
+// и геометрия записываются в geo_features, фича из корзины
+// восстанавливается, окончательно удалённая создаётся заново с прежним id,
+// а возврат к версии-удалению переносит фичу в корзину.
+// Коллекция фичи должна существовать.
+func (r *GeoRepository) RevertFeature(ctx context.Context, v *models.FeatureVersion) error {
+	if v.Operation == models.OperationDelete {
+		_, err := r.db.Exec(ctx,
+			`UPDATE geo_features SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, v.FeatureID)
+		return err
+	}
+
+	cmd, err := r.db.Exec(ctx, `
+	UPDATE geo_features f
+	   SET properties = h.properties,
+	       geometry   = h.geometry,
+	       updated_at = NOW(),
+	       deleted_at = NULL
+	  FROM geo_features_history h
+	 WHERE h.feature_id = $1 AND h.version = $2
+	   AND f.id = h.feature_id`, v.FeatureID, v.Version)
+	if err != nil || cmd.RowsAffected() > 0 {
+		return err
+	}
+	_, err = r.db.Exec(ctx, `
+	INSERT INTO geo_features (id, collection_id, properties, geometry, created_at)
+	SELECT feature_id, collection_id, properties, geometry,
+	       (SELECT min(changed_at) FROM geo_features_history WHERE feature_id = $1)
+	  FROM geo_features_history
+	 WHERE feature_id = $1 AND version = $2`, v.FeatureID, v.Version)
 	return err
 }
+
+// RevertCollection возвращает фичи коллекции к состоянию на момент asOf:
+// созданные позже переносятся в корзину, изменённые получают прежние
+// свойства и геометрию, удалённые восстанавливаются из корзины или
+// создаются заново с прежними id. Фичи, не
+// менявшиеся после asOf, не трогаются, чтобы не засорять историю.
+// Вызывается внутри транзакции.
+func (r *GeoRepository) RevertCollection(ctx context.Context, collectionID int, asOf time.Time) (*models.RevertReport, error) {
+	// состояние фиксируется до изменений: они сами пишут историю
+	var args queryArgs
+	if _, err := r.db.Exec(ctx, `
+	CREATE TEMP TABLE geo_revert_state ON COMMIT DROP AS
+	SELECT * FROM `+historyState(collectionID, asOf, &args)+` s`, args...); err != nil {
+		return nil, err
+	}
+
+	report := &models.RevertReport{}
+	cmd, err := r.db.Exec(ctx, `
+	UPDATE geo_features f SET deleted_at = NOW()
+	 WHERE f.collection_id = $1
+	   AND f.deleted_at IS NULL
+	   AND NOT EXISTS (SELECT 1 FROM geo_revert_state s WHERE s.id = f.id)`, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	report.Deleted = int(cmd.RowsAffected())
+
+	cmd, err = r.db.Exec(ctx, `
+	UPDATE geo_features f
+	   SET properties = s.properties,
+	       geometry   = s.geometry,
+	       updated_at = NOW()
+	  FROM geo_revert_state s
+	 WHERE f.id = s.id
+	   AND f.deleted_at IS NULL
+	   AND (f.properties IS DISTINCT FROM s.properties
+	        OR ST_AsEWKB(f.geometry) IS DISTINCT FROM ST_AsEWKB(s.geometry))`)
+	if err != nil {
+		return nil, err
+	}
+	report.Updated = int(cmd.RowsAffected())
+
+	// удалённые после asOf: из корзины…
+	cmd, err = r.db.Exec(ctx, `
+	UPDATE geo_features f
+	   SET properties = s.properties,
+	       geometry   = s.geometry,
+	       updated_at = NOW(),
+	       deleted_at = NULL
+	  FROM geo_revert_state s
+	 WHERE f.id = s.id
+	   AND f.deleted_at IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	report.Restored = int(cmd.RowsAffected())
+
+	// …и уже удалённые из неё окончательно
+	cmd, err = r.db.Exec(ctx, `
+	INSERT INTO geo_features (id, collection_id, properties, geometry, created_at)
+	SELECT s.id, s.collection_id, s.properties, s.geometry, s.created_at
+	  FROM geo_revert_state s
+	 WHERE NOT EXISTS (SELECT 1 FROM geo_features f WHERE f.id = s.id)`)
+	if err != nil {
+		return nil, err
+	}
+	report.Restored += int(cmd.RowsAffected())
+	return report, nil
+}
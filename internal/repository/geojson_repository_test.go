@@ -0,0 +1,306 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"Datapolis/internal/models"
+)
+
+func TestAddSingleFeatureMissingCollection(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	var missing int
+	if err := tx.QueryRow(ctx, `SELECT coalesce(max(id), 0) + 1000 FROM geo_collections`).Scan(&missing); err != nil {
+		t.Fatal(err)
+	}
+	f := &models.GeoJSONFeature{
+		CollectionID: missing,
+		Properties:   models.JSONData(`{}`),
+		Geometry:     models.JSONData(`{"type":"Point","coordinates":[30,60]}`),
+	}
+	// нарушение внешнего ключа прерывает транзакцию: пробуем в точке сохранения
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := (&GeoRepository{db: sp}).AddSingleFeature(ctx, f, 4326, false)
+	sp.Rollback(ctx)
+	if err != nil || ok {
+		t.Fatalf("AddSingleFeature в несуществующую коллекцию = %v, %v; ждали false, nil", ok, err)
+	}
+
+	colID, _ := testCollection(t, tx, 4326)
+	f.CollectionID = colID
+	if ok, err := (&GeoRepository{db: tx}).AddSingleFeature(ctx, f, 4326, false); err != nil || !ok || f.ID == 0 {
+		t.Fatalf("AddSingleFeature = %v, %v, id %d", ok, err, f.ID)
+	}
+}
+
+// TestIfMatchVersions проверяет условие If-Match в UpdateFeature и
+// DeleteFeature: устаревшая версия не даёт изменить фичу.
+func TestIfMatchVersions(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	colID, ids := testCollection(t, tx, 4326, [2]float64{30, 60})
+	f, err := repo.GetFeatureByID(ctx, ids[0], 0)
+	if err != nil || f == nil {
+		t.Fatalf("фича %d: %v, %v", ids[0], f, err)
+	}
+	current := f.UpdatedAt
+	stale := current.Add(-time.Second)
+
+	update := func(ifMatch []time.Time) bool {
+		t.Helper()
+		g := &models.GeoJSONFeature{ID: f.ID, CollectionID: colID, Properties: models.JSONData(`{"v":1}`), Geometry: f.Geometry}
+		ok, err := repo.UpdateFeature(ctx, g, 4326, false, ifMatch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	if update([]time.Time{stale}) {
+		t.Error("обновление с устаревшей версией прошло")
+	}
+	if update([]time.Time{}) {
+		t.Error("обновление без подходящих версий прошло")
+	}
+	if !update([]time.Time{stale, current}) {
+		t.Error("обновление с текущей версией не прошло")
+	}
+	if !update(nil) {
+		t.Error("обновление с If-Match: * не прошло")
+	}
+
+	// NOW() в транзакции постоянно, так что версия после обновлений та же
+	if ok, err := repo.DeleteFeature(ctx, f.ID, []time.Time{stale}); err != nil || ok {
+		t.Fatalf("удаление с устаревшей версией: %v, %v", ok, err)
+	}
+	if ok, err := repo.DeleteFeature(ctx, f.ID, []time.Time{current}); err != nil || !ok {
+		t.Fatalf("удаление с текущей версией: %v, %v", ok, err)
+	}
+	if ok, err := repo.DeleteFeature(ctx, f.ID, nil); err != nil || ok {
+		t.Fatalf("повторное удаление: %v, %v", ok, err)
+	}
+}
+
+// TestOutputSRID проверяет перепроецирование геометрии в ответе.
+func TestOutputSRID(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	colID, ids := testCollection(t, tx, 32636, [2]float64{33, 60})
+
+	point := func(raw models.JSONData) []float64 {
+		t.Helper()
+		var g struct{ Coordinates []float64 }
+		if err := json.Unmarshal(raw, &g); err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		return g.Coordinates
+	}
+	near := func(got []float64, x, y, eps float64) bool {
+		return len(got) == 2 && math.Abs(got[0]-x) < eps && math.Abs(got[1]-y) < eps
+	}
+
+	// 33° в.д. — осевой меридиан зоны 36: x = 500 км
+	f, err := repo.GetFeatureByID(ctx, ids[0], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := point(f.Geometry); !near(c, 500000, 6651411, 1) {
+		t.Errorf("в SRID хранения: %v", c)
+	}
+	if f, err = repo.GetFeatureByID(ctx, ids[0], 4326); err != nil {
+		t.Fatal(err)
+	}
+	if c := point(f.Geometry); !near(c, 33, 60, 1e-9) {
+		t.Errorf("в EPSG:4326: %v", c)
+	}
+
+	page, err := repo.GetFeaturesByCollectionID(ctx, colID, &models.FeatureQuery{OutputSRID: 4326})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || !near(point(page.Items[0].Geometry), 33, 60, 1e-9) {
+		t.Errorf("страница в EPSG:4326: %+v", page.Items)
+	}
+
+	for srid, want := range map[int]bool{4326: true, 32636: true, 999999: false} {
+		if ok, err := repo.SRIDExists(ctx, srid); err != nil || ok != want {
+			t.Errorf("SRIDExists(%d) = %v, %v", srid, ok, err)
+		}
+	}
+}
+
+// TestStreamFeatures проверяет потоковое чтение коллекции для выгрузки:
+// порядок id, пропуск удалённых фич, перепроецирование и остановку по
+// ошибке fn.
+func TestStreamFeatures(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	colID, ids := testCollection(t, tx, 32636, [2]float64{33, 60}, [2]float64{33.1, 60}, [2]float64{33.2, 60})
+	if _, err := tx.Exec(ctx, `UPDATE geo_features SET deleted_at = NOW() WHERE id = $1`, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	err := repo.StreamFeatures(ctx, colID, 4326, func(f *models.GeoJSONFeature) error {
+		var g struct{ Coordinates []float64 }
+		if err := json.Unmarshal(f.Geometry, &g); err != nil {
+			t.Fatalf("%s: %v", f.Geometry, err)
+		}
+		if len(g.Coordinates) != 2 || math.Abs(g.Coordinates[1]-60) > 1e-9 {
+			t.Errorf("фича %d не перепроецирована: %s", f.ID, f.Geometry)
+		}
+		got = append(got, f.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{ids[0], ids[2]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("прочитаны %v, ждали %v", got, want)
+	}
+
+	stop := errors.New("стоп")
+	calls := 0
+	err = repo.StreamFeatures(ctx, colID, 0, func(*models.GeoJSONFeature) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("ошибка fn: %v после %d вызовов", err, calls)
+	}
+}
+
+// TestGeometryValidity проверяет причину некорректности геометрии и
+// исправление через ST_MakeValid при записи.
+func TestGeometryValidity(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	bowtie := models.JSONData(`{"type":"Polygon","coordinates":[[[0,0],[1,1],[1,0],[0,1],[0,0]]]}`)
+
+	reason, err := repo.GeometryValidity(ctx, models.JSONData(`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`))
+	if err != nil || reason != "" {
+		t.Errorf("корректный полигон: %q, %v", reason, err)
+	}
+	reason, err = repo.GeometryValidity(ctx, bowtie)
+	if err != nil || !strings.Contains(reason, "Self-intersection") {
+		t.Errorf("«бабочка»: %q, %v", reason, err)
+	}
+
+	colID, _ := testCollection(t, tx, 4326)
+	f := &models.GeoJSONFeature{CollectionID: colID, Properties: models.JSONData(`{}`), Geometry: bowtie}
+	if ok, err := repo.AddSingleFeature(ctx, f, 4326, true); err != nil || !ok {
+		t.Fatalf("AddSingleFeature с make_valid: %v, %v", ok, err)
+	}
+	var valid bool
+	var kind string
+	if err := tx.QueryRow(ctx,
+		`SELECT ST_IsValid(geometry), GeometryType(geometry) FROM geo_features WHERE id = $1`, f.ID,
+	).Scan(&valid, &kind); err != nil {
+		t.Fatal(err)
+	}
+	if !valid || kind != "MULTIPOLYGON" {
+		t.Errorf("после ST_MakeValid: valid=%v, тип %s", valid, kind)
+	}
+}
+
+// TestImportStaging проверяет путь потокового импорта: COPY во
+// временную таблицу, проверку геометрий и перенос в коллекцию.
+func TestImportStaging(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	if err := repo.CreateImportStaging(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// позиции идут с пропуском, как после отклонённой записи
+	feats := map[int]*models.GeoJSONFeature{
+		0: {Properties: models.JSONData(`{"n":0}`), Geometry: models.JSONData(`{"type":"Point","coordinates":[33,60]}`)},
+		2: {Properties: models.JSONData(`null`), Geometry: models.JSONData(`{"type":"Polygon","coordinates":[[[0,0],[1,1],[1,0],[0,1],[0,0]]]}`)},
+		3: {Geometry: models.JSONData(`{"type":"Point","coordinates":[33.1,60]}`)},
+	}
+	order := []int{0, 2, 3}
+	next := func() func() (int, *models.GeoJSONFeature, error) {
+		i := 0
+		return func() (int, *models.GeoJSONFeature, error) {
+			if i == len(order) {
+				return 0, nil, io.EOF
+			}
+			idx := order[i]
+			i++
+			return idx, feats[idx], nil
+		}
+	}
+	n, err := repo.CopyToStaging(ctx, next())
+	if err != nil || n != 3 {
+		t.Fatalf("CopyToStaging = %d, %v", n, err)
+	}
+
+	invalid, err := repo.InvalidStaged(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid) != 1 || invalid[0].Index != 2 || !strings.Contains(invalid[0].Reason, "Self-intersection") {
+		t.Fatalf("InvalidStaged = %+v", invalid)
+	}
+	if err := repo.DeleteStaged(ctx, []int{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	colID, _ := testCollection(t, tx, 32636)
+	if n, err = repo.InsertStaged(ctx, colID, 4326, 32636, false); err != nil || n != 2 {
+		t.Fatalf("InsertStaged = %d, %v", n, err)
+	}
+	var props []string
+	var x0 float64
+	if err := tx.QueryRow(ctx, `
+	SELECT array_agg(properties::text ORDER BY id), min(ST_X(geometry))
+	FROM   geo_features WHERE collection_id = $1`, colID,
+	).Scan(&props, &x0); err != nil {
+		t.Fatal(err)
+	}
+	// пустые свойства записываются как {}, геометрия перепроецирована
+	if !reflect.DeepEqual(props, []string{`{"n": 0}`, `{}`}) || math.Abs(x0-500000) > 1 {
+		t.Errorf("в коллекции: %v, x = %v", props, x0)
+	}
+
+	// повторный вызов в той же транзакции очищает таблицу
+	if err := repo.CreateImportStaging(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if invalid, err := repo.InvalidStaged(ctx); err != nil || len(invalid) != 0 {
+		t.Errorf("после очистки: %+v, %v", invalid, err)
+	}
+
+	// ошибка источника прерывает COPY и возвращается как есть
+	failed := errors.New("ошибка чтения")
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Rollback(ctx)
+	calls := 0
+	_, err = (&GeoRepository{db: sp}).CopyToStaging(ctx, func() (int, *models.GeoJSONFeature, error) {
+		if calls++; calls == 2 {
+			return 0, nil, failed
+		}
+		return calls, feats[0], nil
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("ошибка источника: %v", err)
+	}
+}
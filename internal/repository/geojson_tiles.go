@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"math"
+
+	"Datapolis/internal/models"
+)
+
+const (
+	// tileExtent — размер сетки координат внутри тайла MVT
+	tileExtent = 4096
+	// tileBuffer — запас вокруг тайла, чтобы не было швов на стыках
+	tileBuffer = 64
+	// webMercatorWorld — ширина мира в метрах EPSG:3857
+	webMercatorWorld = 2 * math.Pi * 6378137
+)
+
+// GetTile строит тайл Mapbox Vector Tile z/x/y для коллекции через ST_AsMVT.
+// Слой тайла называется layer; фичи без геометрии в тайле отбрасываются.
+func (r *GeoRepository) GetTile(
+	ctx context.Context,
+	collectionID, z, x, y int,
+	layer string,
+	opts models.TileOptions,
+) ([]byte, error) {
+
+	var args queryArgs
+	cid := args.add(collectionID)
+	bounds := "ST_TileEnvelope(" + args.add(z) + ", " + args.add(x) + ", " + args.add(y) + ")"
+
+	// допуск упрощения переводим из пикселей тайла в метры на текущем зуме
+	tolerance := opts.Simplify * webMercatorWorld / math.Exp2(float64(z)) / tileExtent
+
+	props := "f.properties"
+	if opts.Properties != nil {
+		props = `(SELECT coalesce(jsonb_object_agg(key, value), '{}'::jsonb)
+                    FROM jsonb_each(f.properties)
+                   WHERE key = ANY(` + args.add(opts.Properties) + `))`
+	}
+
+	q := `
+    WITH bounds AS (
+        SELECT ` + bounds + ` AS geom
+    ),
+    mvtgeom AS (
+        SELECT f.id,
+               ` + props + ` AS properties,
+               ST_AsMVTGeom(
+                   ST_SimplifyPreserveTopology(ST_Transform(f.geometry, 3857), ` + args.add(tolerance) + `),
+                   bounds.geom, ` + args.add(tileExtent) + `, ` + args.add(tileBuffer) + `, true
+               ) AS geom
+        FROM   geo_features f, bounds
+        WHERE  f.collection_id = ` + cid + `
+          AND  f.deleted_at IS NULL
+          AND  f.geometry && ST_Transform(bounds.geom, (SELECT srid FROM geo_collections WHERE id = ` + cid + `))
+    )
+    SELECT ST_AsMVT(mvtgeom.*, ` + args.add(layer) + `, ` + args.add(tileExtent) + `, 'geom', 'id')
+    FROM   mvtgeom
+    WHERE  geom IS NOT NULL;`
+
+	var tile []byte
+	if err := r.db.QueryRow(ctx, q, args...).Scan(&tile); err != nil {
+		return nil, err
+	}
+	return tile, nil
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"Datapolis/internal/models"
+)
+
+// TestGetTile проверяет отбор фич в тайл и фильтр свойств. Ключи
+// свойств в MVT хранятся строками, так что их можно искать в байтах.
+func TestGetTile(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	// коллекция в UTM: тайл сравнивается с геометрией в её SRID
+	colID, ids := testCollection(t, tx, 32636, [2]float64{33, 60})
+	if _, err := tx.Exec(ctx,
+		`UPDATE geo_features SET properties = '{"name":"a","secret":"b"}' WHERE id = $1`, ids[0],
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// 33° в.д., 60° с.ш. на зуме 4 — тайл 9/4
+	tile, err := repo.GetTile(ctx, colID, 4, 9, 4, "features", models.TileOptions{Simplify: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tile) == 0 || !bytes.Contains(tile, []byte("features")) || !bytes.Contains(tile, []byte("secret")) {
+		t.Errorf("тайл с фичей: % x", tile)
+	}
+
+	tile, err = repo.GetTile(ctx, colID, 4, 9, 4, "features", models.TileOptions{Properties: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(tile, []byte("name")) || bytes.Contains(tile, []byte("secret")) {
+		t.Errorf("фильтр свойств не применён: % x", tile)
+	}
+
+	// соседний тайл пуст
+	if tile, err = repo.GetTile(ctx, colID, 4, 10, 4, "features", models.TileOptions{}); err != nil || len(tile) != 0 {
+		t.Errorf("пустой тайл: % x, %v", tile, err)
+	}
+
+	// удалённые фичи в тайл не попадают
+	if _, err := tx.Exec(ctx, `UPDATE geo_features SET deleted_at = NOW() WHERE id = $1`, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if tile, err = repo.GetTile(ctx, colID, 4, 9, 4, "features", models.TileOptions{}); err != nil || len(tile) != 0 {
+		t.Errorf("тайл без фич: % x, %v", tile, err)
+	}
+}
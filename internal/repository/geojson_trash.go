@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"Datapolis/internal/models"
+)
+
+// TrashedCollections возвращает страницу коллекций в корзине, новые — первыми.
+func (r *GeoRepository) TrashedCollections(
+	ctx context.Context, p models.PageRequest,
+) (*models.Page[*models.GeoJSONCollection], error) {
+
+	var matched int
+	if err := r.db.QueryRow(ctx,
+		`SELECT count(*) FROM geo_collections WHERE deleted_at IS NOT NULL`,
+	).Scan(&matched); err != nil {
+		return nil, err
+	}
+
+	var args queryArgs
+	limit := pageLimit(p.Limit)
+	where, order := keysetClause(p.Cursor, true, &args)
+	if where != "" {
+		where = "AND " + where
+	}
+
+	rows, err := r.db.Query(ctx, `
+	SELECT id, name, description, srid,
+	       user_id, created_at, updated_at, deleted_at
+	FROM   geo_collections
+	WHERE  deleted_at IS NOT NULL
+	`+where+`
+	ORDER BY `+order+`
+	LIMIT `+args.add(limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*models.GeoJSONCollection
+	for rows.Next() {
+		c := new(models.GeoJSONCollection)
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.SRID,
+			&c.UserID, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := buildPage(list, func(c *models.GeoJSONCollection) int { return c.ID }, limit, p.Cursor)
+	page.NumberMatched = matched
+	return page, nil
+}
+
+// trashedFeaturesCond отбирает фичи в корзине, удалённые по отдельности:
+// фичи удалённых коллекций восстанавливаются вместе с коллекцией.
+const trashedFeaturesCond = `deleted_at IS NOT NULL
+	AND collection_id IN (SELECT id FROM geo_collections WHERE deleted_at IS NULL)`
+
+// TrashedFeatures возвращает страницу фич в корзине, новые — первыми.
+func (r *GeoRepository) TrashedFeatures(
+	ctx context.Context, p models.PageRequest,
+) (*models.Page[*models.GeoJSONFeature], error) {
+
+	var matched int
+	if err := r.db.QueryRow(ctx,
+		`SELECT count(*) FROM geo_features WHERE `+trashedFeaturesCond,
+	).Scan(&matched); err != nil {
+		return nil, err
+	}
+
+	var args queryArgs
+	limit := pageLimit(p.Limit)
+	where, order := keysetClause(p.Cursor, true, &args)
+	if where != "" {
+		where = "AND " + where
+	}
+
+	rows, err := r.db.Query(ctx, `
+	SELECT id, properties, ST_AsGeoJSON(geometry)::jsonb, collection_id,
+	       created_at, updated_at, deleted_at
+	FROM   geo_features
+	WHERE  `+trashedFeaturesCond+`
+	`+where+`
+	ORDER BY `+order+`
+	LIMIT `+args.add(limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feats []*models.GeoJSONFeature
+	for rows.Next() {
+		f := new(models.GeoJSONFeature)
+		var props, geom []byte
+		if err := rows.Scan(&f.ID, &props, &geom, &f.CollectionID,
+			&f.CreatedAt, &f.UpdatedAt, &f.DeletedAt); err != nil {
+			return nil, err
+		}
+		f.Properties = models.JSONData(props)
+		f.Geometry = models.JSONData(geom)
+		feats = append(feats, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := buildPage(feats, func(f *models.GeoJSONFeature) int { return f.ID }, limit, p.Cursor)
+	page.NumberMatched = matched
+	return page, nil
+}
+
+// TrashedFeatureCollection возвращает ID коллекции фичи из корзины;
+// ok=false — фичи в корзине нет.
+func (r *GeoRepository) TrashedFeatureCollection(ctx context.Context, id int) (collectionID int, ok bool, err error) {
+	err = r.db.QueryRow(ctx,
+		`SELECT collection_id FROM geo_features WHERE id = $1 AND deleted_at IS NOT NULL`, id,
+	).Scan(&collectionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	return collectionID, err == nil, err
+}
+
+// RestoreFeature возвращает фичу из корзины.
+func (r *GeoRepository) RestoreFeature(ctx context.Context, id int) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE geo_features SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+	return err
+}
+
+// RestoreCollection возвращает коллекцию из корзины вместе с фичами,
+// удалёнными вместе с ней; удалённые раньше остаются в корзине.
+// Вызывается внутри транзакции; ok=false — коллекции в корзине нет.
+func (r *GeoRepository) RestoreCollection(ctx context.Context, id int) (ok bool, err error) {
+	if _, err := r.db.Exec(ctx, `
+	UPDATE geo_features f SET deleted_at = NULL
+	  FROM geo_collections c
+	 WHERE c.id = $1 AND c.deleted_at IS NOT NULL
+	   AND f.collection_id = c.id AND f.deleted_at = c.deleted_at`, id); err != nil {
+		return false, err
+	}
+	cmd, err := r.db.Exec(ctx,
+		`UPDATE geo_collections SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	return cmd.RowsAffected() > 0, nil
+}
+
+// PurgeTrash окончательно удаляет фичи и коллекции, попавшие в корзину
+// раньше before; фичи удаляемых коллекций удаляются каскадно.
+func (r *GeoRepository) PurgeTrash(ctx context.Context, before time.Time) (collections, features int64, err error) {
+	cmd, err := r.db.Exec(ctx, `DELETE FROM geo_features WHERE deleted_at < $1`, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	features = cmd.RowsAffected()
+	cmd, err = r.db.Exec(ctx, `DELETE FROM geo_collections WHERE deleted_at < $1`, before)
+	if err != nil {
+		return 0, features, err
+	}
+	return cmd.RowsAffected(), features, nil
+}
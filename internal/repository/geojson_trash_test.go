@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"Datapolis/internal/models"
+)
+
+// featureIDs возвращает ID фич страницы.
+func featureIDs(page *models.Page[*models.GeoJSONFeature]) []int {
+	var ids []int
+	for _, f := range page.Items {
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+// TestTrash проверяет корзину: удалённое не видно при чтении, фичи
+// возвращаются по одной и вместе с коллекцией, а удалённые раньше
+// коллекции остаются в корзине.
+func TestTrash(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	colID, ids := testCollection(t, tx, 4326, [2]float64{30, 60}, [2]float64{31, 60}, [2]float64{32, 60})
+	single := ids[0]
+
+	// NOW() в транзакции постоянно: отдельное удаление отодвигаем на час
+	// назад, иначе его не отличить от удаления вместе с коллекцией
+	trash := func() {
+		t.Helper()
+		if _, err := tx.Exec(ctx,
+			`UPDATE geo_features SET deleted_at = NOW() - interval '1 hour' WHERE id = $1`, single,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trash()
+
+	if f, err := repo.GetFeatureByID(ctx, single, 0); err != nil || f != nil {
+		t.Errorf("фича из корзины читается: %+v, %v", f, err)
+	}
+	page, err := repo.GetFeaturesByCollectionID(ctx, colID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := featureIDs(page); !slices.Equal(got, ids[1:]) || page.NumberMatched != 2 {
+		t.Errorf("фичи коллекции: %v (%d)", got, page.NumberMatched)
+	}
+	trashed, err := repo.TrashedFeatures(ctx, models.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := featureIDs(trashed); !slices.Contains(got, single) || trashed.Items[0].DeletedAt == nil {
+		t.Errorf("в корзине фич: %v", got)
+	}
+	if cid, ok, err := repo.TrashedFeatureCollection(ctx, single); err != nil || !ok || cid != colID {
+		t.Errorf("TrashedFeatureCollection = %d, %v, %v", cid, ok, err)
+	}
+	if _, ok, err := repo.TrashedFeatureCollection(ctx, ids[1]); err != nil || ok {
+		t.Errorf("TrashedFeatureCollection живой фичи: %v, %v", ok, err)
+	}
+
+	if err := repo.RestoreFeature(ctx, single); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := repo.GetFeatureByID(ctx, single, 0); err != nil || f == nil {
+		t.Fatalf("фича не восстановлена: %v", err)
+	}
+
+	// удаление коллекции — только владельцем
+	if err := repo.DeleteCollection(ctx, colID, 2); err == nil {
+		t.Error("коллекцию удалил не владелец")
+	}
+	trash()
+	if err := repo.DeleteCollection(ctx, colID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if col, err := repo.GetCollectionByID(ctx, colID); err != nil || col != nil {
+		t.Errorf("коллекция из корзины читается: %+v, %v", col, err)
+	}
+	cols, err := repo.TrashedCollections(ctx, models.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(cols.Items, func(c *models.GeoJSONCollection) bool { return c.ID == colID && c.DeletedAt != nil }) {
+		t.Errorf("коллекции %d нет в корзине", colID)
+	}
+	// фичи удалённой коллекции по отдельности не показываются
+	if trashed, err = repo.TrashedFeatures(ctx, models.PageRequest{Limit: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if got := featureIDs(trashed); slices.Contains(got, single) || slices.Contains(got, ids[1]) {
+		t.Errorf("в корзине фич: %v", got)
+	}
+
+	if ok, err := repo.RestoreCollection(ctx, colID); err != nil || !ok {
+		t.Fatalf("RestoreCollection = %v, %v", ok, err)
+	}
+	if page, err = repo.GetFeaturesByCollectionID(ctx, colID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := featureIDs(page); !slices.Equal(got, ids[1:]) {
+		t.Errorf("после восстановления коллекции: %v; удалённая раньше фича должна остаться в корзине", got)
+	}
+	if ok, err := repo.RestoreCollection(ctx, colID); err != nil || ok {
+		t.Errorf("повторный RestoreCollection = %v, %v", ok, err)
+	}
+}
+
+// TestPurgeTrash проверяет окончательное удаление по сроку хранения.
+func TestPurgeTrash(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	colID, ids := testCollection(t, tx, 4326, [2]float64{30, 60}, [2]float64{31, 60})
+	var now time.Time
+	if err := tx.QueryRow(ctx, `SELECT NOW()`).Scan(&now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE geo_features SET deleted_at = NOW() - interval '1 hour' WHERE id = $1`, ids[0],
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteCollection(ctx, colID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// старше получаса — только отдельно удалённая фича
+	cols, feats, err := repo.PurgeTrash(ctx, now.Add(-30*time.Minute))
+	if err != nil || cols != 0 || feats != 1 {
+		t.Fatalf("PurgeTrash = %d, %d, %v", cols, feats, err)
+	}
+	if _, ok, err := repo.TrashedFeatureCollection(ctx, ids[0]); err != nil || ok {
+		t.Errorf("фича осталась в корзине: %v, %v", ok, err)
+	}
+
+	if cols, feats, err = repo.PurgeTrash(ctx, now.Add(time.Second)); err != nil || cols != 1 || feats != 1 {
+		t.Fatalf("PurgeTrash = %d, %d, %v", cols, feats, err)
+	}
+	var left int
+	if err := tx.QueryRow(ctx,
+		`SELECT (SELECT count(*) FROM geo_collections WHERE id = $1) + (SELECT count(*) FROM geo_features WHERE collection_id = $1)`, colID,
+	).Scan(&left); err != nil || left != 0 {
+		t.Errorf("после очистки осталось строк: %d, %v", left, err)
+	}
+
+	// окончательное удаление из корзины в историю повторно не пишется
+	for _, id := range ids {
+		if v := lastVersion(t, repo, id); v.Operation != models.OperationDelete {
+			t.Errorf("фича %d: последняя версия %+v", id, v)
+		}
+	}
+}
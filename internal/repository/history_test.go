@@ -0,0 +1,322 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"Datapolis/internal/models"
+)
+
+// asUser выполняет sql от имени пользователя userID, как это делают
+// изменения через сервис.
+func asUser(t *testing.T, repo *GeoRepository, userID int, sql string, args ...any) {
+	t.Helper()
+	err := repo.AsUser(context.Background(), userID, func(tx *GeoRepository) error {
+		_, err := tx.db.Exec(context.Background(), sql, args...)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// backdate переносит версию фичи в истории на момент at. NOW() в
+// транзакции постоянно, поэтому разные моменты изменений задаются так.
+func backdate(t *testing.T, tx pgx.Tx, featureID, version int, at time.Time) {
+	t.Helper()
+	cmd, err := tx.Exec(context.Background(),
+		`UPDATE geo_features_history SET changed_at = $3 WHERE feature_id = $1 AND version = $2`,
+		featureID, version, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.RowsAffected() != 1 {
+		t.Fatalf("версии %d фичи %d нет в истории", version, featureID)
+	}
+}
+
+// propsOf возвращает свойства фич страницы в порядке id.
+func propsOf(t *testing.T, page *models.Page[*models.GeoJSONFeature]) []string {
+	t.Helper()
+	props := []string{}
+	for _, f := range page.Items {
+		props = append(props, string(f.Properties))
+	}
+	return props
+}
+
+func TestFeatureHistory(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	colID, ids := testCollection(t, tx, 4326, [2]float64{30, 60})
+	id := ids[0]
+
+	asUser(t, repo, 7, `UPDATE geo_features SET properties = '{"v": 1}' WHERE id = $1`, id)
+	asUser(t, repo, 8, `UPDATE geo_features SET deleted_at = NOW() WHERE id = $1`, id)
+
+	versions, err := repo.FeatureHistory(ctx, id, 3857)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		op    string
+		props string
+		user  int // 0 — автор неизвестен
+	}{
+		{models.OperationInsert, `{}`, 0},
+		{models.OperationUpdate, `{"v": 1}`, 7},
+		{models.OperationDelete, `{"v": 1}`, 8},
+	}
+	if len(versions) != len(want) {
+		t.Fatalf("версий %d, ждали %d", len(versions), len(want))
+	}
+	for i, w := range want {
+		v := versions[i]
+		user := 0
+		if v.UserID != nil {
+			user = *v.UserID
+		}
+		if v.Version != i+1 || v.Operation != w.op || string(v.Properties) != w.props || user != w.user || v.CollectionID != colID {
+			t.Errorf("версия %d: %+v (автор %d)", i+1, v, user)
+		}
+	}
+	// геометрия истории перепроецируется: 30° в.д. в Web Mercator
+	var g struct{ Coordinates []float64 }
+	if err := json.Unmarshal(versions[0].Geometry, &g); err != nil || len(g.Coordinates) != 2 || math.Abs(g.Coordinates[0]-3339584.72) > 0.01 {
+		t.Errorf("в EPSG:3857: %s", versions[0].Geometry)
+	}
+
+	v, err := repo.FeatureVersion(ctx, id, 2)
+	if err != nil || v == nil || string(v.Properties) != `{"v": 1}` {
+		t.Errorf("FeatureVersion(2) = %+v, %v", v, err)
+	}
+	if v, err = repo.FeatureVersion(ctx, id, 99); err != nil || v != nil {
+		t.Errorf("FeatureVersion(99) = %+v, %v", v, err)
+	}
+}
+
+// TestHistoryAsOf проверяет восстановление состояния коллекции на момент
+// времени (параметр as_of).
+func TestHistoryAsOf(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	colID, ids := testCollection(t, tx, 4326, [2]float64{30, 60}, [2]float64{31, 61})
+	a, b := ids[0], ids[1]
+
+	asUser(t, repo, 1, `UPDATE geo_features SET properties = '{"v": 1}' WHERE id = $1`, a)
+	asUser(t, repo, 1, `UPDATE geo_features SET deleted_at = NOW() WHERE id = $1`, b)
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	backdate(t, tx, a, 1, day(1))
+	backdate(t, tx, b, 1, day(2))
+	backdate(t, tx, a, 2, day(10))
+	backdate(t, tx, b, 2, day(20))
+
+	tests := []struct {
+		asOf  time.Time
+		props []string
+	}{
+		{day(1).Add(-time.Second), []string{}},
+		{day(1), []string{`{}`}},
+		{day(5), []string{`{}`, `{}`}},
+		{day(10), []string{`{"v": 1}`, `{}`}},
+		{day(25), []string{`{"v": 1}`}},
+	}
+	for _, tt := range tests {
+		asOf := tt.asOf
+		page, err := repo.GetFeaturesByCollectionID(ctx, colID, &models.FeatureQuery{AsOf: &asOf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := propsOf(t, page); !slices.Equal(got, tt.props) {
+			t.Errorf("на %s: %v, ждали %v", asOf.Format(time.RFC3339), got, tt.props)
+		}
+	}
+
+	// время создания — первая версия, изменения — последняя до as_of
+	asOf := day(15)
+	page, err := repo.GetFeaturesByCollectionID(ctx, colID, &models.FeatureQuery{AsOf: &asOf})
+	if err != nil || len(page.Items) == 0 {
+		t.Fatalf("на %s: %v", asOf.Format(time.RFC3339), err)
+	}
+	if f := page.Items[0]; f.ID != a || !f.CreatedAt.Equal(day(1)) || !f.UpdatedAt.Equal(day(10)) {
+		t.Errorf("фича %d: создана %s, изменена %s", f.ID, f.CreatedAt, f.UpdatedAt)
+	}
+}
+
+// revertTo возвращает фичу к версии version от имени userID.
+func revertTo(t *testing.T, repo *GeoRepository, id, version, userID int) {
+	t.Helper()
+	ctx := context.Background()
+	err := repo.AsUser(ctx, userID, func(tx *GeoRepository) error {
+		v, err := tx.FeatureVersion(ctx, id, version)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			t.Fatalf("версии %d фичи %d нет", version, id)
+		}
+		return tx.RevertFeature(ctx, v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lastVersion возвращает последнюю версию фичи из истории.
+func lastVersion(t *testing.T, repo *GeoRepository, id int) *models.FeatureVersion {
+	t.Helper()
+	versions, err := repo.FeatureHistory(context.Background(), id, 0)
+	if err != nil || len(versions) == 0 {
+		t.Fatalf("история фичи %d: %v", id, err)
+	}
+	return versions[len(versions)-1]
+}
+
+func TestRevertFeature(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	_, ids := testCollection(t, tx, 4326, [2]float64{30, 60})
+	id := ids[0]
+	asUser(t, repo, 5, `UPDATE geo_features SET properties = '{"v": 1}' WHERE id = $1`, id)
+	asUser(t, repo, 5, `UPDATE geo_features SET properties = '{"v": 2}', geometry = ST_SetSRID(ST_MakePoint(31, 61), 4326) WHERE id = $1`, id)
+
+	current := func() string {
+		t.Helper()
+		f, err := repo.GetFeatureByID(ctx, id, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f == nil {
+			return ""
+		}
+		return string(f.Properties) + " " + string(f.Geometry)
+	}
+
+	// возврат записывается новой версией от имени того, кто его сделал
+	revertTo(t, repo, id, 2, 9)
+	if got := current(); got != `{"v": 1} {"type":"Point","coordinates":[30,60]}` {
+		t.Errorf("после возврата к версии 2: %s", got)
+	}
+	if v := lastVersion(t, repo, id); v.Version != 4 || v.Operation != models.OperationUpdate || v.UserID == nil || *v.UserID != 9 {
+		t.Errorf("версия возврата: %+v", v)
+	}
+
+	// фича из корзины восстанавливается
+	asUser(t, repo, 5, `UPDATE geo_features SET deleted_at = NOW() WHERE id = $1`, id)
+	revertTo(t, repo, id, 3, 9)
+	if got := current(); got != `{"v": 2} {"type":"Point","coordinates":[31,61]}` {
+		t.Errorf("после возврата из корзины: %s", got)
+	}
+	if v := lastVersion(t, repo, id); v.Operation != models.OperationRestore {
+		t.Errorf("возврат из корзины записан как %s", v.Operation)
+	}
+
+	// возврат к версии-удалению переносит фичу в корзину
+	revertTo(t, repo, id, 5, 9)
+	if got := current(); got != "" {
+		t.Errorf("после возврата к удалению фича видна: %s", got)
+	}
+
+	// окончательно удалённая фича создаётся заново с прежним id
+	if _, err := tx.Exec(ctx, `DELETE FROM geo_features WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+	revertTo(t, repo, id, 1, 9)
+	if got := current(); got != `{} {"type":"Point","coordinates":[30,60]}` {
+		t.Errorf("после пересоздания: %s", got)
+	}
+	if v := lastVersion(t, repo, id); v.Operation != models.OperationInsert || v.UserID == nil || *v.UserID != 9 {
+		t.Errorf("пересоздание записано как %+v", v)
+	}
+}
+
+// TestRevertCollection проверяет возврат коллекции к прошлому состоянию:
+// новые фичи уходят в корзину, изменённые получают прежние значения,
+// удалённые возвращаются из корзины или создаются заново.
+func TestRevertCollection(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	repo := &GeoRepository{db: tx}
+	colID, ids := testCollection(t, tx, 4326, [2]float64{30, 60}, [2]float64{31, 60}, [2]float64{32, 60}, [2]float64{33, 60})
+	changed, trashed, purged, untouched := ids[0], ids[1], ids[2], ids[3]
+
+	// исходное состояние — на 1 января, изменения — позже (NOW() транзакции)
+	asOf := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, id := range ids {
+		backdate(t, tx, id, 1, asOf)
+	}
+	asUser(t, repo, 5, `UPDATE geo_features SET properties = '{"v": 1}' WHERE id = $1`, changed)
+	asUser(t, repo, 5, `UPDATE geo_features SET deleted_at = NOW() WHERE id = ANY($1)`, []int{trashed, purged})
+	if _, err := tx.Exec(ctx, `DELETE FROM geo_features WHERE id = $1`, purged); err != nil {
+		t.Fatal(err)
+	}
+	var added int
+	if err := tx.QueryRow(ctx, `
+	INSERT INTO geo_features (collection_id, properties, geometry)
+	VALUES ($1, '{"new": true}', ST_SetSRID(ST_MakePoint(34, 60), 4326)) RETURNING id`, colID,
+	).Scan(&added); err != nil {
+		t.Fatal(err)
+	}
+
+	revert := func() *models.RevertReport {
+		t.Helper()
+		var report *models.RevertReport
+		err := repo.AsUser(ctx, 9, func(tx *GeoRepository) error {
+			var err error
+			report, err = tx.RevertCollection(ctx, colID, asOf)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// временная таблица живёт до конца внешней транзакции теста
+		if _, err := tx.Exec(ctx, `DROP TABLE geo_revert_state`); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	report := revert()
+	if *report != (models.RevertReport{Deleted: 1, Updated: 1, Restored: 2}) {
+		t.Errorf("отчёт %+v", *report)
+	}
+	page, err := repo.GetFeaturesByCollectionID(ctx, colID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, f := range page.Items {
+		if string(f.Properties) != `{}` {
+			t.Errorf("фича %d: %s", f.ID, f.Properties)
+		}
+		got = append(got, f.ID)
+	}
+	if want := []int{changed, trashed, purged, untouched}; !slices.Equal(got, want) {
+		t.Errorf("после возврата фичи %v, ждали %v", got, want)
+	}
+
+	// изменения возврата подписаны его автором, нетронутая фича не менялась
+	for _, id := range []int{changed, trashed, purged, added} {
+		if v := lastVersion(t, repo, id); v.UserID == nil || *v.UserID != 9 {
+			t.Errorf("фича %d: последняя версия %+v", id, v)
+		}
+	}
+	if v := lastVersion(t, repo, untouched); v.Version != 1 {
+		t.Errorf("нетронутая фича получила версию %d", v.Version)
+	}
+
+	// повторный возврат к тому же моменту ничего не меняет
+	if report := revert(); *report != (models.RevertReport{}) {
+		t.Errorf("повторный возврат: %+v", *report)
+	}
+}
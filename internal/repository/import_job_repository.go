@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"Datapolis/internal/models"
+)
+
+type ImportJobRepository struct {
+	db dbtx
+}
+
+func NewImportJobRepository(db *pgxpool.Pool) *ImportJobRepository {
+	return &ImportJobRepository{db: db}
+}
+
+const importJobColumns = `
+	id, state, format, name, COALESCE(description, ''), user_id, options, file_path,
+	processed, rejected, report, COALESCE(error, ''), error_index, collection_id, layers,
+	created_at, updated_at, started_at, finished_at`
+
+// Create ставит задачу в очередь
+func (r *ImportJobRepository) Create(ctx context.Context, job *models.ImportJob) error {
+	opts, err := json.Marshal(job.Options)
+	if err != nil {
+		return err
+	}
+	job.State = models.JobQueued
+	return r.db.QueryRow(ctx,
+		`INSERT INTO geo_import_jobs (state, format, name, description, user_id, options, file_path)
+         VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id, created_at, updated_at`,
+		job.State, job.Format, job.Name, job.Description, job.UserID, opts, job.FilePath,
+	).Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt)
+}
+
+// GetByID возвращает задачу или nil, если её нет
+func (r *ImportJobRepository) GetByID(ctx context.Context, id int) (*models.ImportJob, error) {
+	job, err := scanImportJob(r.db.QueryRow(ctx,
+		`SELECT `+importJobColumns+` FROM geo_import_jobs WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// ClaimNext переводит старейшую задачу из очереди в состояние running,
+// записывает её за обработчиком owner и возвращает её; nil — очередь
+// пуста. SKIP LOCKED не даёт двум обработчикам взять одну задачу.
+func (r *ImportJobRepository) ClaimNext(ctx context.Context, owner string) (*models.ImportJob, error) {
+	job, err := scanImportJob(r.db.QueryRow(ctx, `
+	UPDATE geo_import_jobs
+	   SET state = 'running', started_at = NOW(), updated_at = NOW(),
+	       locked_by = $1, heartbeat_at = NOW()
+	 WHERE id = (
+	       SELECT id FROM geo_import_jobs
+	        WHERE state = 'queued'
+	        ORDER BY id
+	        LIMIT 1
+	        FOR UPDATE SKIP LOCKED)
+	RETURNING `+importJobColumns, owner))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Requeue возвращает в очередь выполняющиеся задачи, обработчик которых
+// не отзывался дольше lease: сервер остановился или упал. Задачи живых
+// обработчиков, в том числе других экземпляров сервера, не трогаются.
+// Задачи, отмену которых успели запросить, помечаются отменёнными;
+// их файлы больше не нужны, поэтому такие задачи возвращаются.
+func (r *ImportJobRepository) Requeue(ctx context.Context, lease time.Duration) ([]*models.ImportJob, error) {
+	rows, err := r.db.Query(ctx, `
+	UPDATE geo_import_jobs
+	   SET state        = CASE WHEN cancel_requested THEN 'cancelled' ELSE 'queued' END,
+	       processed    = 0,
+	       started_at   = NULL,
+	       finished_at  = CASE WHEN cancel_requested THEN NOW() END,
+	       locked_by    = NULL,
+	       heartbeat_at = NULL,
+	       updated_at   = NOW()
+	 WHERE state = 'running'
+	   AND (heartbeat_at IS NULL OR heartbeat_at < NOW() - make_interval(secs => $1))
+	RETURNING `+importJobColumns, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cancelled []*models.ImportJob
+	for rows.Next() {
+		job, err := scanImportJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		if job.State == models.JobCancelled {
+			cancelled = append(cancelled, job)
+		}
+	}
+	return cancelled, rows.Err()
+}
+
+// Heartbeat продлевает аренду задачи обработчиком owner и сообщает,
+// запрошена ли отмена. ok=false — задача больше не за owner: аренда
+// истекла и задачу вернули в очередь.
+func (r *ImportJobRepository) Heartbeat(ctx context.Context, id int, owner string) (cancel, ok bool, err error) {
+	err = r.db.QueryRow(ctx,
+		`UPDATE geo_import_jobs SET heartbeat_at=NOW()
+          WHERE id=$1 AND state='running' AND locked_by=$2 RETURNING cancel_requested`,
+		id, owner,
+	).Scan(&cancel)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, false, nil
+	}
+	return cancel, err == nil, err
+}
+
+// UpdateProgress сохраняет число прочитанных фич, продлевает аренду
+// и сообщает, запрошена ли отмена задачи. ok=false — задача больше
+// не за owner.
+func (r *ImportJobRepository) UpdateProgress(ctx context.Context, id int, owner string, processed int) (cancel, ok bool, err error) {
+	err = r.db.QueryRow(ctx,
+		`UPDATE geo_import_jobs SET processed=$3, heartbeat_at=NOW(), updated_at=NOW()
+          WHERE id=$1 AND state='running' AND locked_by=$2 RETURNING cancel_requested`,
+		id, owner, processed,
+	).Scan(&cancel)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, false, nil
+	}
+	return cancel, err == nil, err
+}
+
+// Finish записывает итог задачи: состояние, отчёт, ошибку и коллекцию.
+// ok=false — задача больше не за owner, итог не записан.
+func (r *ImportJobRepository) Finish(ctx context.Context, job *models.ImportJob, owner string) (ok bool, err error) {
+	var report, layers []byte
+	if job.Report != nil {
+		if report, err = json.Marshal(job.Report); err != nil {
+			return false, err
+		}
+	}
+	if job.Layers != nil {
+		if layers, err = json.Marshal(job.Layers); err != nil {
+			return false, err
+		}
+	}
+	err = r.db.QueryRow(ctx, `
+	UPDATE geo_import_jobs
+	   SET state = $2, processed = $3, rejected = $4, report = $5,
+	       error = NULLIF($6, ''), error_index = $7, collection_id = $8, layers = $9,
+	       finished_at = NOW(), updated_at = NOW()
+	 WHERE id = $1 AND state = 'running' AND locked_by = $10
+	RETURNING updated_at, finished_at`,
+		job.ID, job.State, job.Processed, job.Rejected, report,
+		job.Error, job.ErrorIndex, job.CollectionID, layers, owner,
+	).Scan(&job.UpdatedAt, &job.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// RequestCancel запрашивает отмену задачи. Задача из очереди отменяется
+// сразу, у выполняющейся выставляется флаг, который проверяет обработчик.
+// Возвращает состояние задачи после запроса; nil — задачи нет.
+func (r *ImportJobRepository) RequestCancel(ctx context.Context, id int) (*models.ImportJob, error) {
+	job, err := scanImportJob(r.db.QueryRow(ctx, `
+	UPDATE geo_import_jobs
+	   SET cancel_requested = state IN ('queued', 'running'),
+	       state       = CASE WHEN state = 'queued' THEN 'cancelled' ELSE state END,
+	       finished_at = CASE WHEN state = 'queued' THEN NOW() ELSE finished_at END,
+	       updated_at  = NOW()
+	 WHERE id = $1
+	RETURNING `+importJobColumns, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+func scanImportJob(row pgx.Row) (*models.ImportJob, error) {
+	var (
+		job                  models.ImportJob
+		opts, report, layers []byte
+	)
+	err := row.Scan(
+		&job.ID, &job.State, &job.Format, &job.Name, &job.Description, &job.UserID, &opts, &job.FilePath,
+		&job.Processed, &job.Rejected, &report, &job.Error, &job.ErrorIndex, &job.CollectionID, &layers,
+		&job.CreatedAt, &job.UpdatedAt, &job.StartedAt, &job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(opts, &job.Options); err != nil {
+		return nil, err
+	}
+	if report != nil {
+		job.Report = new(models.ImportReport)
+		if err := json.Unmarshal(report, job.Report); err != nil {
+			return nil, err
+		}
+	}
+	if layers != nil {
+		if err := json.Unmarshal(layers, &job.Layers); err != nil {
+			return nil, err
+		}
+	}
+	return &job, nil
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"Datapolis/internal/models"
+)
+
+// testJobs возвращает репозиторий задач в тестовой транзакции, где
+// очередь пуста: задачи, поставленные вне теста, отменены до отката.
+func testJobs(t *testing.T) *ImportJobRepository {
+	t.Helper()
+	tx := testTx(t)
+	if _, err := tx.Exec(context.Background(),
+		`UPDATE geo_import_jobs SET state = 'cancelled' WHERE state IN ('queued', 'running')`,
+	); err != nil {
+		t.Fatal(err)
+	}
+	return &ImportJobRepository{db: tx}
+}
+
+func newJob(t *testing.T, repo *ImportJobRepository, name string) *models.ImportJob {
+	t.Helper()
+	job := &models.ImportJob{
+		Format: models.FormatGeoJSON, Name: name, UserID: 1, FilePath: "/tmp/" + name,
+		Options: models.ImportOptions{Repair: models.RepairSkip},
+	}
+	if err := repo.Create(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestImportJobLifecycle(t *testing.T) {
+	repo := testJobs(t)
+	ctx := context.Background()
+	first := newJob(t, repo, "first")
+	second := newJob(t, repo, "second")
+
+	// задачи берутся по порядку постановки
+	job, err := repo.ClaimNext(ctx, "w1")
+	if err != nil || job == nil || job.ID != first.ID || job.State != models.JobRunning || job.StartedAt == nil {
+		t.Fatalf("ClaimNext = %+v, %v", job, err)
+	}
+	if job.Options.Repair != models.RepairSkip {
+		t.Errorf("параметры не сохранились: %+v", job.Options)
+	}
+	if job, err = repo.ClaimNext(ctx, "w2"); err != nil || job == nil || job.ID != second.ID {
+		t.Fatalf("вторая задача: %+v, %v", job, err)
+	}
+	if job, err = repo.ClaimNext(ctx, "w1"); err != nil || job != nil {
+		t.Fatalf("из пустой очереди: %+v, %v", job, err)
+	}
+
+	// прогресс и аренда — только у своего обработчика
+	if cancel, ok, err := repo.UpdateProgress(ctx, first.ID, "w1", 10); err != nil || !ok || cancel {
+		t.Errorf("UpdateProgress: %v, %v, %v", cancel, ok, err)
+	}
+	if _, ok, err := repo.UpdateProgress(ctx, first.ID, "w2", 20); err != nil || ok {
+		t.Errorf("UpdateProgress чужой задачи: %v, %v", ok, err)
+	}
+	if _, ok, err := repo.Heartbeat(ctx, second.ID, "w1"); err != nil || ok {
+		t.Errorf("Heartbeat чужой задачи: %v, %v", ok, err)
+	}
+
+	colID := 42
+	done := &models.ImportJob{
+		ID: first.ID, State: models.JobDone, Processed: 12, Rejected: 1, CollectionID: &colID,
+		Report: &models.ImportReport{Imported: 11, Rejected: []models.FeatureIssue{{Index: 3, Reason: "нет геометрии"}}, Repaired: []models.FeatureIssue{}},
+	}
+	if ok, err := repo.Finish(ctx, done, "w2"); err != nil || ok {
+		t.Errorf("Finish чужим обработчиком: %v, %v", ok, err)
+	}
+	if ok, err := repo.Finish(ctx, done, "w1"); err != nil || !ok || done.FinishedAt == nil {
+		t.Fatalf("Finish: %v, %v", ok, err)
+	}
+	got, err := repo.GetByID(ctx, first.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetByID: %+v, %v", got, err)
+	}
+	if got.State != models.JobDone || got.Processed != 12 || got.Rejected != 1 ||
+		got.CollectionID == nil || *got.CollectionID != colID ||
+		got.Report == nil || got.Report.Imported != 11 || len(got.Report.Rejected) != 1 || got.ErrorIndex != nil || got.Error != "" {
+		t.Errorf("после Finish: %+v", got)
+	}
+	// повторный итог не записывается: задача уже не выполняется
+	if ok, err := repo.Finish(ctx, done, "w1"); err != nil || ok {
+		t.Errorf("повторный Finish: %v, %v", ok, err)
+	}
+
+	if got, err := repo.GetByID(ctx, -1); err != nil || got != nil {
+		t.Errorf("несуществующая задача: %+v, %v", got, err)
+	}
+}
+
+func TestImportJobCancel(t *testing.T) {
+	repo := testJobs(t)
+	ctx := context.Background()
+	queued := newJob(t, repo, "queued")
+	running := newJob(t, repo, "running")
+	if _, err := repo.ClaimNext(ctx, "w1"); err != nil { // берёт queued
+		t.Fatal(err)
+	}
+	if _, err := repo.ClaimNext(ctx, "w1"); err != nil { // берёт running
+		t.Fatal(err)
+	}
+	third := newJob(t, repo, "third")
+
+	// задача из очереди отменяется сразу
+	job, err := repo.RequestCancel(ctx, third.ID)
+	if err != nil || job.State != models.JobCancelled || job.FinishedAt == nil {
+		t.Fatalf("отмена задачи в очереди: %+v, %v", job, err)
+	}
+	if job, err = repo.ClaimNext(ctx, "w1"); err != nil || job != nil {
+		t.Errorf("отменённая задача взята в работу: %+v, %v", job, err)
+	}
+
+	// выполняющаяся узнаёт об отмене из Heartbeat и UpdateProgress
+	if job, err = repo.RequestCancel(ctx, running.ID); err != nil || job.State != models.JobRunning {
+		t.Fatalf("отмена выполняющейся задачи: %+v, %v", job, err)
+	}
+	if cancel, ok, err := repo.Heartbeat(ctx, running.ID, "w1"); err != nil || !ok || !cancel {
+		t.Errorf("Heartbeat: %v, %v, %v", cancel, ok, err)
+	}
+	if cancel, ok, err := repo.UpdateProgress(ctx, running.ID, "w1", 5); err != nil || !ok || !cancel {
+		t.Errorf("UpdateProgress: %v, %v, %v", cancel, ok, err)
+	}
+
+	// завершённую задачу отменить нельзя
+	done := &models.ImportJob{ID: queued.ID, State: models.JobDone}
+	if ok, err := repo.Finish(ctx, done, "w1"); err != nil || !ok {
+		t.Fatalf("Finish: %v, %v", ok, err)
+	}
+	if job, err = repo.RequestCancel(ctx, queued.ID); err != nil || job.State != models.JobDone {
+		t.Errorf("отмена завершённой задачи: %+v, %v", job, err)
+	}
+	if job, err = repo.RequestCancel(ctx, -1); err != nil || job != nil {
+		t.Errorf("отмена несуществующей задачи: %+v, %v", job, err)
+	}
+}
+
+// TestImportJobRequeue проверяет возврат в очередь задач, обработчик
+// которых перестал продлевать аренду. NOW() в транзакции постоянно,
+// поэтому просроченную аренду задаём сдвигом heartbeat_at.
+func TestImportJobRequeue(t *testing.T) {
+	repo := testJobs(t)
+	ctx := context.Background()
+	alive := newJob(t, repo, "alive")
+	stale := newJob(t, repo, "stale")
+	cancelled := newJob(t, repo, "cancelled")
+	for range 3 {
+		if _, err := repo.ClaimNext(ctx, "w1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.RequestCancel(ctx, cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.UpdateProgress(ctx, stale.ID, "w1", 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.db.Exec(ctx,
+		`UPDATE geo_import_jobs SET heartbeat_at = NOW() - interval '1 hour' WHERE id = ANY($1)`,
+		[]int{stale.ID, cancelled.ID},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// отменённые при возврате задачи возвращаются, чтобы удалить их файлы
+	dropped, err := repo.Requeue(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 1 || dropped[0].ID != cancelled.ID || dropped[0].FilePath != "/tmp/cancelled" {
+		t.Errorf("Requeue вернул %+v", dropped)
+	}
+
+	for id, want := range map[int]string{alive.ID: models.JobRunning, stale.ID: models.JobQueued, cancelled.ID: models.JobCancelled} {
+		job, err := repo.GetByID(ctx, id)
+		if err != nil || job.State != want {
+			t.Errorf("задача %d: %+v, %v; ждали %s", id, job, err, want)
+		}
+	}
+	job, _ := repo.GetByID(ctx, stale.ID)
+	if job.Processed != 0 || job.StartedAt != nil {
+		t.Errorf("возвращённая задача не сброшена: %+v", job)
+	}
+	// прежний обработчик потерял задачу
+	if _, ok, err := repo.Heartbeat(ctx, stale.ID, "w1"); err != nil || ok {
+		t.Errorf("Heartbeat после возврата: %v, %v", ok, err)
+	}
+	if job, err := repo.ClaimNext(ctx, "w2"); err != nil || job == nil || job.ID != stale.ID {
+		t.Errorf("возвращённая задача не взята снова: %+v, %v", job, err)
+	}
+}
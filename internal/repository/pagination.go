@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"Datapolis/internal/models"
+)
+
+// keysetClause возвращает условие по курсору (пустое, если курсора нет)
+// и направление сортировки по id. desc задаёт порядок выдачи списка;
+// страница «назад» читается в обратном порядке и затем разворачивается.
+func keysetClause(cur *models.Cursor, desc bool, args *queryArgs) (cond, order string) {
+	backward := cur != nil && cur.Backward
+	cmp, order := "<", "id DESC"
+	if desc == backward {
+		cmp, order = ">", "id ASC"
+	}
+	if cur != nil {
+		cond = "id " + cmp + " " + args.add(cur.ID)
+	}
+	return cond, order
+}
+
+// pageLimit приводит запрошенный размер страницы к допустимому диапазону.
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return models.DefaultPageLimit
+	}
+	if limit > models.MaxPageLimit {
+		return models.MaxPageLimit
+	}
+	return limit
+}
+
+// buildPage собирает страницу из limit+1 прочитанных строк:
+// лишняя строка лишь говорит о том, что дальше есть ещё данные.
+func buildPage[T any](items []T, idOf func(T) int, limit int, cur *models.Cursor) *models.Page[T] {
+	backward := cur != nil && cur.Backward
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	page := &models.Page[T]{Items: items}
+	if len(items) == 0 {
+		return page
+	}
+	first, last := idOf(items[0]), idOf(items[len(items)-1])
+
+	if backward {
+		page.Next = &models.Cursor{ID: last}
+		if hasMore {
+			page.Prev = &models.Cursor{ID: first, Backward: true}
+		}
+	} else {
+		if hasMore {
+			page.Next = &models.Cursor{ID: last}
+		}
+		if cur != nil {
+			page.Prev = &models.Cursor{ID: first, Backward: true}
+		}
+	}
+	return page
+}
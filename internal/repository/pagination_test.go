@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"Datapolis/internal/models"
+)
+
+// fetchPage читает страницу из ids так, как её прочитал бы запрос
+// с условием и порядком из keysetClause и LIMIT limit+1.
+func fetchPage(t *testing.T, ids []int, desc bool, limit int, cur *models.Cursor) *models.Page[int] {
+	t.Helper()
+	var args queryArgs
+	cond, order := keysetClause(cur, desc, &args)
+
+	var rows []int
+	for _, id := range ids {
+		switch {
+		case cond == "":
+		case strings.HasPrefix(cond, "id < $1"):
+			if id >= args[0].(int) {
+				continue
+			}
+		case strings.HasPrefix(cond, "id > $1"):
+			if id <= args[0].(int) {
+				continue
+			}
+		default:
+			t.Fatalf("неожиданное условие %q", cond)
+		}
+		rows = append(rows, id)
+	}
+	slices.Sort(rows)
+	if order == "id DESC" {
+		slices.Reverse(rows)
+	}
+	if len(rows) > limit+1 {
+		rows = rows[:limit+1]
+	}
+	return buildPage(rows, func(id int) int { return id }, limit, cur)
+}
+
+func TestKeysetPagination(t *testing.T) {
+	// id с пропусками, как после удалений
+	ids := []int{2, 3, 5, 8, 13, 21, 34}
+	for _, desc := range []bool{false, true} {
+		want := slices.Clone(ids)
+		if desc {
+			slices.Reverse(want)
+		}
+		for _, limit := range []int{1, 2, 3, 7, 10} {
+			// вперёд по next до конца
+			var pages []*models.Page[int]
+			var got []int
+			for page := fetchPage(t, ids, desc, limit, nil); ; page = fetchPage(t, ids, desc, limit, page.Next) {
+				pages = append(pages, page)
+				got = append(got, page.Items...)
+				if page.Next == nil {
+					break
+				}
+				if len(pages) > len(ids) {
+					t.Fatal("пагинация не заканчивается")
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("desc=%v limit=%d: вперёд %v, ждали %v", desc, limit, got, want)
+			}
+			if pages[0].Prev != nil {
+				t.Errorf("desc=%v limit=%d: у первой страницы есть prev", desc, limit)
+			}
+
+			// назад по prev: те же страницы в обратном порядке
+			for i := len(pages) - 1; i > 0; i-- {
+				prev := fetchPage(t, ids, desc, limit, pages[i].Prev)
+				if !reflect.DeepEqual(prev.Items, pages[i-1].Items) {
+					t.Fatalf("desc=%v limit=%d: prev страницы %d = %v, ждали %v", desc, limit, i, prev.Items, pages[i-1].Items)
+				}
+				if (prev.Prev == nil) != (i == 1) {
+					t.Errorf("desc=%v limit=%d: prev страницы %d: prev = %+v", desc, limit, i-1, prev.Prev)
+				}
+				if prev.Next == nil {
+					t.Errorf("desc=%v limit=%d: у страницы %d, прочитанной назад, нет next", desc, limit, i-1)
+				}
+			}
+		}
+	}
+}
+
+func TestKeysetPaginationEmpty(t *testing.T) {
+	page := fetchPage(t, nil, false, 10, nil)
+	if len(page.Items) != 0 || page.Next != nil || page.Prev != nil {
+		t.Errorf("пустая выборка: %+v", page)
+	}
+	// курсор за последним элементом: страница пуста, ссылок нет
+	page = fetchPage(t, []int{1, 2}, false, 10, &models.Cursor{ID: 2})
+	if len(page.Items) != 0 || page.Next != nil || page.Prev != nil {
+		t.Errorf("за концом: %+v", page)
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	for _, tt := range []struct{ in, want int }{
+		{0, models.DefaultPageLimit},
+		{-1, models.DefaultPageLimit},
+		{5, 5},
+		{models.MaxPageLimit + 1, models.MaxPageLimit},
+	} {
+		if got := pageLimit(tt.in); got != tt.want {
+			t.Errorf("pageLimit(%d) = %d, ждали %d", tt.in, got, tt.want)
+		}
+	}
+}
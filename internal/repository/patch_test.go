@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"Datapolis/internal/models"
+)
+
+// testTx открывает транзакцию в базе DATABASE_URL с применёнными
+// миграциями и откатывает её в конце теста. Без DATABASE_URL тест
+// пропускается.
+func testTx(t *testing.T) pgx.Tx {
+	t.Helper()
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL не задан")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tx.Rollback(ctx) })
+	return tx
+}
+
+// jsonEqual сравнивает два JSON-документа по значению.
+func jsonEqual(t *testing.T, got, want string) bool {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal([]byte(got), &g); err != nil {
+		t.Fatalf("%s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("%s: %v", want, err)
+	}
+	return reflect.DeepEqual(g, w)
+}
+
+// TestJSONMergePatch — примеры приложения A RFC 7396.
+func TestJSONMergePatch(t *testing.T) {
+	tx := testTx(t)
+	tests := []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		var got string
+		err := tx.QueryRow(context.Background(),
+			`SELECT jsonb_merge_patch($1::jsonb, $2::jsonb)::text`, tt.target, tt.patch).Scan(&got)
+		if err != nil {
+			t.Fatalf("%s + %s: %v", tt.target, tt.patch, err)
+		}
+		if !jsonEqual(t, got, tt.want) {
+			t.Errorf("%s + %s = %s, ждали %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+// TestJSONPatch — примеры приложения A RFC 6902 и ошибки патча.
+func TestJSONPatch(t *testing.T) {
+	tx := testTx(t)
+	tests := []struct {
+		name, doc, patch string
+		want             string // "" — ждём ошибку патча 22023
+	}{
+		{"A.1", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"A.2", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"A.3", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"A.4", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"A.5", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"A.6", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"A.7", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{"A.8", `{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{"A.9", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ""},
+		{"A.10", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`},
+		{"A.11", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{"A.12", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ""},
+		{"A.14", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{"A.15", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ""},
+		{"A.16", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{"индекс за концом массива", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":1}]`, ""},
+		{"индекс больше int", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/99999999999","value":1}]`, ""},
+		{"удаление по индексу больше int", `{"foo":["bar"]}`, `[{"op":"remove","path":"/foo/99999999999"}]`, ""},
+		{"индекс с ведущим нулём", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/01","value":1}]`, ""},
+		{"неизвестная операция", `{}`, `[{"op":"merge","path":"/a","value":1}]`, ""},
+		{"не массив", `{}`, `{"op":"add","path":"/a","value":1}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// ошибка прерывает транзакцию: каждый случай — в своей точке сохранения
+			sp, err := tx.Begin(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sp.Rollback(context.Background())
+
+			var got string
+			err = sp.QueryRow(context.Background(),
+				`SELECT jsonb_json_patch($1::jsonb, $2::jsonb)::text`, tt.doc, tt.patch).Scan(&got)
+			if tt.want == "" {
+				var pgErr *pgconn.PgError
+				if !errors.As(err, &pgErr) || pgErr.Code != invalidParameterValue {
+					t.Fatalf("ждали ошибку патча 22023, получили %q, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jsonEqual(t, got, tt.want) {
+				t.Errorf("получили %s, ждали %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPatchedFeaturePrecision проверяет, что патч не округляет
+// координаты, которых не касается.
+func TestPatchedFeaturePrecision(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	var colID, id int
+	if err := tx.QueryRow(ctx, `INSERT INTO geo_collections (name, srid, user_id) VALUES ('patch', 4326, 1) RETURNING id`).Scan(&colID); err != nil {
+		t.Fatal(err)
+	}
+	err := tx.QueryRow(ctx, `
+	INSERT INTO geo_features (collection_id, properties, geometry)
+	VALUES ($1, '{"a":1}', ST_GeomFromText('LINESTRING(0.000012345678901234567 59.12345678901234, 30.1 60.2)', 4326))
+	RETURNING id`, colID).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &GeoRepository{db: tx}
+
+	f, changed, err := repo.PatchedFeature(ctx, id, models.PatchMerge, models.JSONData(`{"properties":{"b":2}}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("патч свойств изменил геометрию")
+	}
+	if !jsonEqual(t, string(f.Properties), `{"a":1,"b":2}`) {
+		t.Errorf("свойства %s", f.Properties)
+	}
+
+	f, changed, err = repo.PatchedFeature(ctx, id, models.PatchJSON,
+		models.JSONData(`[{"op":"replace","path":"/geometry/coordinates/1","value":[31,61]}]`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("патч вершины не изменил геометрию")
+	}
+	var g struct{ Coordinates [][]float64 }
+	if err := json.Unmarshal(f.Geometry, &g); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float64{{0.000012345678901234567, 59.12345678901234}, {31, 61}}
+	if !reflect.DeepEqual(g.Coordinates, want) {
+		t.Errorf("координаты %v, ждали %v", g.Coordinates, want)
+	}
+
+	if _, _, err := repo.PatchedFeature(ctx, id, models.PatchJSON,
+		models.JSONData(`[{"op":"add","path":"/geometry/coordinates/99999999999","value":[0,0]}]`), nil); !errors.As(err, new(*models.PatchError)) {
+		t.Errorf("ждали *models.PatchError, получили %v", err)
+	}
+}
@@ -13,7 +13,8 @@ import (
 func Router(
 	userHandler *handlers.UserHandler,
 	authHandler *handlers.AuthHandler,
-	geoJSONHandler *handlers.GeoJSONHandler) *gin.Engine {
+	geoJSONHandler *handlers.GeoJSONHandler,
+	ogcHandler *handlers.OGCHandler) *gin.Engine {
 
 	router := gin.Default()
 	router.Use(gzip.Gzip(gzip.DefaultCompression))
@@ -21,8 +22,8 @@ func Router(
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "If-Match", "If-None-Match"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Crs", "Location", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -42,8 +43,26 @@ func Router(
 		{
 			collections.GET("", geoJSONHandler.GetAllCollections)
 			collections.GET("/:id", geoJSONHandler.GetCollection)
+			collections.GET("/:id/export", geoJSONHandler.ExportCollection)
 			collections.GET("/:id/features", geoJSONHandler.GetFeatures)
+			collections.POST("/:id/features/query", geoJSONHandler.QueryFeatures)
+			collections.GET("/:id/tiles/:z/:x/:y", geoJSONHandler.GetTile) // :y — номер с суффиксом .mvt
 		}
+		geojson.GET("/features/:id", geoJSONHandler.GetFeature)
+		geojson.GET("/features/:id/history", geoJSONHandler.GetFeatureHistory)
+		geojson.GET("/export", geoJSONHandler.ExportCollections) // несколько коллекций в одном GeoPackage
+	}
+
+	// OGC API – Features (Part 1: Core): только чтение, с тем же токеном,
+	// что и /geojson (QGIS и ArcGIS передают заголовок Authorization)
+	ogc := protected.Group("/ogc")
+	{
+		ogc.GET("", ogcHandler.Landing)
+		ogc.GET("/conformance", ogcHandler.Conformance)
+		ogc.GET("/collections", ogcHandler.Collections)
+		ogc.GET("/collections/:id", ogcHandler.Collection)
+		ogc.GET("/collections/:id/items", ogcHandler.Items)
+		ogc.GET("/collections/:id/items/:fid", ogcHandler.Item)
 	}
 
 	admin := protected.Group("/admin")
@@ -63,12 +82,27 @@ func Router(
 				adminCollections.POST("", geoJSONHandler.UploadGeoJSONBulk)
 				adminCollections.DELETE("/:id", geoJSONHandler.DeleteCollection)
 				adminCollections.POST("/:id/features", geoJSONHandler.AddSingleFeature)
+				adminCollections.POST("/:id/revert", geoJSONHandler.RevertCollection) // ?as_of=<RFC 3339>
 
 			}
 			adminFeatures := adminGeoJSON.Group("/features")
 			{
 				adminFeatures.PUT("/:id", geoJSONHandler.UpdateFeature)
+				adminFeatures.PATCH("/:id", geoJSONHandler.PatchFeature) // merge-patch+json или json-patch+json
 				adminFeatures.DELETE("/:id", geoJSONHandler.DeleteFeature)
+				adminFeatures.POST("/:id/revert", geoJSONHandler.RevertFeature) // ?version=N
+			}
+			trash := adminGeoJSON.Group("/trash")
+			{
+				trash.GET("/collections", geoJSONHandler.GetTrashedCollections)
+				trash.POST("/collections/:id/restore", geoJSONHandler.RestoreCollection)
+				trash.GET("/features", geoJSONHandler.GetTrashedFeatures)
+				trash.POST("/features/:id/restore", geoJSONHandler.RestoreFeature)
+			}
+			adminImports := adminGeoJSON.Group("/imports")
+			{
+				adminImports.GET("/:jobId", geoJSONHandler.GetImportJob)
+				adminImports.DELETE("/:jobId", geoJSONHandler.CancelImportJob)
 			}
 		}
 	}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"Datapolis/internal/formats"
+	"Datapolis/internal/geo"
+	"Datapolis/internal/models"
+	"Datapolis/internal/repository"
+)
+
+// ExportFormat описывает формат выгрузки одной коллекции.
+type ExportFormat struct {
+	ContentType string
+	Ext         string
+	WGS84       bool // формат хранит только долготу и широту WGS 84
+}
+
+// ExportFormats — форматы выгрузки коллекции по значению параметра format.
+var ExportFormats = map[string]ExportFormat{
+	"geojson":  {ContentType: "application/geo+json", Ext: ".geojson"},
+	"gpkg":     {ContentType: "application/geopackage+sqlite3", Ext: ".gpkg"},
+	"csv":      {ContentType: "text/csv; charset=utf-8", Ext: ".csv"},
+	"kml":      {ContentType: "application/vnd.google-earth.kml+xml", Ext: ".kml", WGS84: true},
+	"gpx":      {ContentType: "application/gpx+xml", Ext: ".gpx", WGS84: true},
+	"wkt":      {ContentType: "text/plain; charset=utf-8", Ext: ".wkt"},
+	"shp":      {ContentType: "application/zip", Ext: ".zip"},
+	"fgb":      {ContentType: "application/flatgeobuf", Ext: ".fgb"},
+	"parquet":  {ContentType: "application/vnd.apache.parquet", Ext: ".parquet", WGS84: true},
+	"topojson": {ContentType: "application/json", Ext: ".topojson"},
+}
+
+// ExportOptions — параметры выгрузки отдельных форматов.
+type ExportOptions struct {
+	Quantization int // размер сетки квантования TopoJSON
+}
+
+// Export выгружает коллекцию в формате format (ключ ExportFormats) в
+// системе координат srid. CSV, GPX, Shapefile, FlatGeobuf и GeoParquet
+// читают фичи дважды: схема столбцов и типы геометрий известны только
+// после полного прохода. Поэтому выгрузка читает один снимок базы,
+// и второй проход видит те же фичи, что и первый.
+func (s *GeoService) Export(ctx context.Context, col *models.GeoJSONCollection, srid int, format string, opts ExportOptions, w io.Writer) error {
+	if ExportFormats[format].WGS84 && srid != geo.SRID4326 {
+		return fmt.Errorf("формат %s хранит только координаты WGS 84", format)
+	}
+
+	switch format {
+	case "geojson":
+		return s.ExportGeoJSON(ctx, col, srid, w)
+
+	case "gpkg":
+		path, err := s.ExportGeoPackage(ctx, []*models.GeoJSONCollection{col}, srid)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(path)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	}
+
+	return s.repo.InSnapshot(ctx, func(tx *repository.GeoRepository) error {
+		return exportSnapshot(ctx, tx, col, srid, format, opts, w)
+	})
+}
+
+// exportSnapshot выгружает коллекцию в потоковых форматах; tx читает
+// снимок базы.
+func exportSnapshot(ctx context.Context, tx *repository.GeoRepository, col *models.GeoJSONCollection, srid int, format string, opts ExportOptions, w io.Writer) error {
+	outputSRID := 0
+	if srid != col.SRID {
+		outputSRID = srid
+	}
+	stream := func(fn func(*models.GeoJSONFeature) error) error {
+		return tx.StreamFeatures(ctx, col.ID, outputSRID, fn)
+	}
+
+	switch format {
+	case "csv":
+		schema := formats.NewPropertySchema()
+		err := stream(func(f *models.GeoJSONFeature) error {
+			props, err := formats.FlattenProperties(f.Properties)
+			if err != nil {
+				return fmt.Errorf("фича %d: свойства: %w", f.ID, err)
+			}
+			schema.Add(props)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cw, err := formats.NewCSVWriter(w, schema.Keys())
+		if err != nil {
+			return err
+		}
+		if err := stream(cw.Write); err != nil {
+			return err
+		}
+		return cw.Flush()
+
+	case "kml":
+		kw := formats.NewKMLWriter(w, col.Name)
+		if err := stream(kw.Write); err != nil {
+			return err
+		}
+		return kw.Close()
+
+	case "gpx":
+		gw := formats.NewGPXWriter(w)
+		if err := stream(gw.Waypoints); err != nil {
+			return err
+		}
+		if err := stream(gw.Tracks); err != nil {
+			return err
+		}
+		return gw.Close()
+
+	case "wkt":
+		ww := formats.NewWKTWriter(w)
+		if err := stream(ww.Write); err != nil {
+			return err
+		}
+		return ww.Flush()
+
+	case "shp":
+		_, _, prj, ok, err := tx.SpatialRefSys(ctx, srid)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: EPSG:%d", ErrUnknownCRS, srid)
+		}
+		dir, err := os.MkdirTemp("", "export-shp-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		sw := formats.NewShapefileWriter(dir, col.Name, prj)
+		if err := stream(sw.Scan); err != nil {
+			return err
+		}
+		if err := stream(sw.Write); err != nil {
+			sw.Discard()
+			return err
+		}
+		if err := sw.Close(w); err != nil {
+			sw.Discard()
+			return err
+		}
+		return nil
+
+	case "fgb":
+		org, code, wkt, ok, err := tx.SpatialRefSys(ctx, srid)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: EPSG:%d", ErrUnknownCRS, srid)
+		}
+		dir, err := os.MkdirTemp("", "export-fgb-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		fw := formats.NewFlatGeobufWriter(dir, col.Name, strings.ToUpper(org), code, wkt)
+		if err := stream(fw.Scan); err != nil {
+			return err
+		}
+		if err := stream(fw.Write); err != nil {
+			fw.Discard()
+			return err
+		}
+		if err := fw.Close(w); err != nil {
+			fw.Discard()
+			return err
+		}
+		return nil
+
+	case "parquet":
+		pw := formats.NewGeoParquetWriter(w)
+		if err := stream(pw.Scan); err != nil {
+			return err
+		}
+		if err := stream(pw.Write); err != nil {
+			return err
+		}
+		return pw.Close()
+
+	case "topojson":
+		// топология строится по всем фичам сразу, поэтому держится в памяти
+		tb := formats.NewTopologyBuilder(opts.Quantization)
+		if err := stream(tb.Add); err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(tb.Topology(formats.TopologyObject))
+	}
+	return fmt.Errorf("неизвестный формат выгрузки %q", format)
+}
+
+// ExportGeoPackage записывает коллекции в файл GeoPackage, по слою на
+// коллекцию, и возвращает путь к нему; удалить файл должен вызывающий.
+// SQLite пишет в файл, а не в поток, поэтому ошибка экспорта видна до
+// начала ответа. При srid > 0 все слои перепроецируются в него, иначе
+// каждый слой остаётся в SRID хранения своей коллекции.
+func (s *GeoService) ExportGeoPackage(ctx context.Context, cols []*models.GeoJSONCollection, srid int) (string, error) {
+	f, err := os.CreateTemp("", "export-*.gpkg")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	f.Close()
+
+	w, err := formats.CreateGeoPackage(path)
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	// слои читаются из одного снимка базы
+	err = s.repo.InSnapshot(ctx, func(tx *repository.GeoRepository) error {
+		return writeGeoPackage(ctx, tx, w, cols, srid)
+	})
+	if err != nil {
+		w.Abort()
+		os.Remove(path)
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+func writeGeoPackage(ctx context.Context, tx *repository.GeoRepository, w *formats.GeoPackageWriter, cols []*models.GeoJSONCollection, srid int) error {
+	for _, col := range cols {
+		layerSRID, outputSRID := col.SRID, 0
+		if srid > 0 && srid != col.SRID {
+			layerSRID, outputSRID = srid, srid
+		}
+		if !w.HasSRS(layerSRID) {
+			org, orgID, def, ok, err := tx.SpatialRefSys(ctx, layerSRID)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%w: EPSG:%d", ErrUnknownCRS, layerSRID)
+			}
+			name := fmt.Sprintf("%s:%d", strings.ToUpper(org), orgID)
+			if err := w.AddSRS(layerSRID, org, orgID, name, def); err != nil {
+				return err
+			}
+		}
+
+		layer, err := w.Layer(col.Name, col.Description, layerSRID)
+		if err != nil {
+			return err
+		}
+		if err := tx.StreamFeatures(ctx, col.ID, outputSRID, layer.Write); err != nil {
+			return fmt.Errorf("коллекция %d: %w", col.ID, err)
+		}
+		if err := layer.Finish(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
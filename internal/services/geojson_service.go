@@ -1,78 +1,155 @@
 package service
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"strconv"
+	"sort"
+	"time"
 
+	"Datapolis/internal/formats"
+	"Datapolis/internal/geo"
 	"Datapolis/internal/models"
 	"Datapolis/internal/repository"
 )
 
-const srid4326 = 4326
+var ErrCollectionNotFound = errors.New("коллекция не найдена")
+
+// ErrInvalidGeometry — геометрия некорректна и не была исправлена.
+var ErrInvalidGeometry = errors.New("некорректная геометрия")
+
+// ErrUnknownCRS — запрошенной системы координат нет в spatial_ref_sys.
+var ErrUnknownCRS = errors.New("неизвестная система координат")
+
+// ErrFeatureNotFound — фичи с таким ID нет.
+var ErrFeatureNotFound = errors.New("фича не найдена")
+
+// ErrPreconditionFailed — фича изменена после того, как клиент её прочитал
+// (условие If-Match не выполнено).
+var ErrPreconditionFailed = errors.New("фича изменена другим пользователем")
+
+// ErrVersionNotFound — в истории фичи нет запрошенной версии.
+var ErrVersionNotFound = errors.New("версия фичи не найдена")
+
+// tileLayer — имя слоя в векторных тайлах коллекций
+const tileLayer = "features"
 
 type GeoService struct {
-	repo *repository.GeoRepository
+	repo  *repository.GeoRepository
+	tiles *TileCache
 }
 
-func NewGeoService(r *repository.GeoRepository) *GeoService { return &GeoService{repo: r} }
+func NewGeoService(r *repository.GeoRepository, tiles *TileCache) *GeoService {
+	return &GeoService{repo: r, tiles: tiles}
+}
 
 // GetCollection получает коллекцию по ID
 func (s *GeoService) GetCollection(ctx context.Context, id int) (*models.GeoJSONCollection, error) {
 	return s.repo.GetCollectionByID(ctx, id)
 }
 
-func (s *GeoService) ExportGeoJSON(ctx context.Context, collectionID int) ([]byte, error) {
-	col, err := s.repo.GetCollectionByID(ctx, collectionID)
+// checkSRID проверяет, что система координат есть в spatial_ref_sys.
+func (s *GeoService) checkSRID(ctx context.Context, srid int) error {
+	ok, err := s.repo.SRIDExists(ctx, srid)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if col == nil {
-		return nil, errors.New("collection not found")
+	if !ok {
+		return fmt.Errorf("%w: EPSG:%d", ErrUnknownCRS, srid)
 	}
+	return nil
+}
 
-	feats, err := s.repo.FeaturesByCollection(ctx, collectionID)
+// ResolveSRID разбирает обозначение системы координат и проверяет,
+// что PostGIS умеет в неё перепроецировать.
+func (s *GeoService) ResolveSRID(ctx context.Context, crs string) (int, error) {
+	srid, err := geo.ParseSRID(crs)
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("%w: %v", ErrUnknownCRS, err)
+	}
+	if err := s.checkSRID(ctx, srid); err != nil {
+		return 0, err
 	}
+	return srid, nil
+}
 
-	out := map[string]any{
-		"type": "FeatureCollection",
-		"name": col.Name,
-		"crs": map[string]any{
-			"type":       "name",
-			"properties": map[string]any{"name": "EPSG:" + strconv.Itoa(col.SRID)},
-		},
+// ExportGeoJSON потоково пишет коллекцию в w как GeoJSON FeatureCollection
+// с членом crs; фичи не накапливаются в памяти. Геометрия перепроецируется
+// в srid, если он отличен от SRID хранения.
+func (s *GeoService) ExportGeoJSON(ctx context.Context, col *models.GeoJSONCollection, srid int, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	name, err := json.Marshal(col.Name)
+	if err != nil {
+		return err
 	}
-	flist := make([]map[string]any, 0, len(feats))
-	for _, f := range feats {
-		flist = append(flist, map[string]any{
+	fmt.Fprintf(bw,
+		`{"type":"FeatureCollection","name":%s,"crs":{"type":"name","properties":{"name":"EPSG:%d"}},"features":[`,
+		name, srid)
+
+	outputSRID := 0
+	if srid != col.SRID {
+		outputSRID = srid
+	}
+	first := true
+	err = s.repo.StreamFeatures(ctx, col.ID, outputSRID, func(f *models.GeoJSONFeature) error {
+		raw, err := json.Marshal(map[string]any{
 			"type":       "Feature",
-			"properties": json.RawMessage(f.Properties),
-			"geometry":   json.RawMessage(f.Geometry),
+			"properties": f.Properties,
+			"geometry":   f.Geometry,
 		})
+		if err != nil {
+			return err
+		}
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		_, err = bw.Write(raw)
+		return err
+	})
+	if err != nil {
+		return err
 	}
-	out["features"] = flist
-	return json.Marshal(out)
+
+	bw.WriteString("]}")
+	return bw.Flush()
 }
 
-// DeleteCollection удаляет коллекцию
+// DeleteCollection переносит коллекцию с фичами в корзину; удаление фич
+// попадает в историю от имени userID
 func (s *GeoService) DeleteCollection(ctx context.Context, collectionID, userID int) error {
-	return s.repo.DeleteCollection(ctx, collectionID, userID)
+	err := s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		return tx.DeleteCollection(ctx, collectionID, userID)
+	})
+	if err != nil {
+		return err
+	}
+	s.tiles.Invalidate(collectionID)
+	return nil
 }
 
-// GetFeatures получает все фичи коллекции
-func (s *GeoService) GetFeatures(ctx context.Context, collectionID int) ([]*models.GeoJSONFeature, error) {
-	return s.repo.GetFeaturesByCollectionID(ctx, collectionID)
+// GetFeatures получает страницу фич коллекции, отобранных по условиям q
+func (s *GeoService) GetFeatures(
+	ctx context.Context,
+	collectionID int,
+	q *models.FeatureQuery,
+) (*models.Page[*models.GeoJSONFeature], error) {
+	return s.repo.GetFeaturesByCollectionID(ctx, collectionID, q)
 }
 
-// AddSingleFeature добавляет новую фичу в коллекцию
+// AddSingleFeature добавляет новую фичу в коллекцию; некорректная
+// геометрия отклоняется или, в режиме make_valid, исправляется.
+// userID записывается в историю автором изменения
 func (s *GeoService) AddSingleFeature(
 	ctx context.Context,
 	feature *models.GeoJSONFeature,
+	repair string,
+	userID int,
 ) error {
 	// проверяем существование коллекции и получаем её SRID
 	col, err := s.repo.GetCollectionByID(ctx, feature.CollectionID)
@@ -80,91 +157,564 @@ func (s *GeoService) AddSingleFeature(
 		return err
 	}
 	if col == nil {
-		return fmt.Errorf("collection %d not found", feature.CollectionID)
+		return ErrCollectionNotFound
+	}
+	if err := validateFeature(ctx, s.repo, feature, repair); err != nil {
+		return err
 	}
 	// вызываем репозиторий
-	return s.repo.AddSingleFeature(ctx, feature, col.SRID)
+	err = s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		ok, err := tx.AddSingleFeature(ctx, feature, col.SRID, repair == models.RepairMakeValid)
+		if err == nil && !ok {
+			return ErrCollectionNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.tiles.Invalidate(feature.CollectionID)
+	return nil
 }
 
-// UpdateFeature обновляет фичу в коллекции
-func (s *GeoService) UpdateFeature(ctx context.Context, feature *models.GeoJSONFeature) error {
+// UpdateFeature обновляет фичу в коллекции; геометрия проверяется
+// так же, как в AddSingleFeature. При ifMatch != nil фича обновляется,
+// только если не менялась с одной из версий ifMatch (по updated_at),
+// иначе возвращается ErrPreconditionFailed
+func (s *GeoService) UpdateFeature(
+	ctx context.Context,
+	feature *models.GeoJSONFeature,
+	repair string,
+	userID int,
+	ifMatch []time.Time,
+) error {
 	if feature.ID == 0 {
 		return errors.New("ID фичи не установлен")
 	}
 	if feature.CollectionID == 0 {
 		return errors.New("ID коллекции не установлен")
 	}
-	return s.repo.UpdateFeature(ctx, feature, srid4326)
+	col, err := s.repo.GetCollectionByID(ctx, feature.CollectionID)
+	if err != nil {
+		return err
+	}
+	if col == nil {
+		return ErrCollectionNotFound
+	}
+	if err := validateFeature(ctx, s.repo, feature, repair); err != nil {
+		return err
+	}
+	// геометрия правки задана в SRID хранения, как её отдаёт GetFeatureByID
+	err = s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		ok, err := tx.UpdateFeature(ctx, feature, col.SRID, repair == models.RepairMakeValid, ifMatch)
+		if err != nil || ok {
+			return err
+		}
+		return featureConflict(ctx, tx, feature.ID)
+	})
+	if err != nil {
+		return err
+	}
+	s.tiles.Invalidate(feature.CollectionID)
+	return nil
 }
 
-func (s *GeoService) DeleteFeature(ctx context.Context, id int) error {
-	feature, err := s.repo.GetFeatureByID(ctx, id)
+// DeleteFeature переносит фичу в корзину; ifMatch — как в UpdateFeature
+func (s *GeoService) DeleteFeature(ctx context.Context, id, userID int, ifMatch []time.Time) error {
+	feature, err := s.repo.GetFeatureByID(ctx, id, 0)
 	if err != nil {
 		return err
 	}
 	if feature == nil {
-		return errors.New("фича не найдена")
+		return ErrFeatureNotFound
+	}
+	err = s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		ok, err := tx.DeleteFeature(ctx, id, ifMatch)
+		if err != nil || ok {
+			return err
+		}
+		return featureConflict(ctx, tx, id)
+	})
+	if err != nil {
+		return err
 	}
-	return s.repo.DeleteFeature(ctx, id)
+	s.tiles.Invalidate(feature.CollectionID)
+	return nil
 }
 
-func (s *GeoService) GetAllCollections(ctx context.Context) ([]*models.GeoJSONCollection, error) {
-	return s.repo.GetCollections(ctx)
+// PatchFeature изменяет фичу патчем формата kind (models.PatchMerge или
+// models.PatchJSON) к документу {"properties": ..., "geometry": ...}:
+// можно изменить только свойства или только геометрию. Изменённая
+// геометрия проверяется как в UpdateFeature, нетронутая записывается
+// без преобразований. Неприменимый патч — *models.PatchError; ifMatch —
+// как в UpdateFeature. Возвращает фичу после изменения
+func (s *GeoService) PatchFeature(
+	ctx context.Context,
+	id int,
+	kind string,
+	patch models.JSONData,
+	repair string,
+	userID int,
+	ifMatch []time.Time,
+) (*models.GeoJSONFeature, error) {
+	var feature *models.GeoJSONFeature
+	err := s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		f, geometryChanged, err := tx.PatchedFeature(ctx, id, kind, patch, ifMatch)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			return featureConflict(ctx, tx, id)
+		}
+		if err := checkProperties(f); err != nil {
+			return err
+		}
+
+		if geometryChanged {
+			col, err := tx.GetCollectionByID(ctx, f.CollectionID)
+			if err != nil {
+				return err
+			}
+			if col == nil {
+				return ErrCollectionNotFound
+			}
+			if err := validateFeature(ctx, tx, f, repair); err != nil {
+				return err
+			}
+			_, err = tx.UpdateFeature(ctx, f, col.SRID, repair == models.RepairMakeValid, nil)
+			if err != nil {
+				return err
+			}
+		} else if err := tx.UpdateFeatureProperties(ctx, f); err != nil {
+			return err
+		}
+		// перечитываем: геометрия могла быть исправлена ST_MakeValid
+		feature, err = tx.GetFeatureByID(ctx, id, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.tiles.Invalidate(feature.CollectionID)
+	return feature, nil
 }
 
-// services/geojson_service.go
+// checkProperties проверяет, что после патча свойства фичи — объект;
+// удалённые целиком свойства становятся пустым объектом.
+func checkProperties(f *models.GeoJSONFeature) error {
+	if len(f.Properties) == 0 || string(f.Properties) == "null" {
+		f.Properties = models.JSONData("{}")
+		return nil
+	}
+	if f.Properties[0] != '{' {
+		return &models.PatchError{Reason: "properties должны быть объектом"}
+	}
+	return nil
+}
+
+// featureConflict объясняет, почему изменение фичи не затронуло ни одной
+// строки: её нет или она изменена с версии из If-Match.
+func featureConflict(ctx context.Context, tx *repository.GeoRepository, id int) error {
+	f, err := tx.GetFeatureByID(ctx, id, 0)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return ErrFeatureNotFound
+	}
+	return ErrPreconditionFailed
+}
+
+// GetAllCollections получает страницу коллекций
+func (s *GeoService) GetAllCollections(
+	ctx context.Context,
+	p models.PageRequest,
+) (*models.Page[*models.GeoJSONCollection], error) {
+	return s.repo.GetCollections(ctx, p)
+}
 
-func (s *GeoService) ImportGeoJSONBulk(
+// ImportFeatures создаёт коллекцию из источника фич. Фичи загружаются COPY
+// во временную таблицу по мере чтения, геометрия строится, проверяется
+// и перепроецируется на сервере, поэтому память не зависит от размера файла.
+// Исходная система координат — opts.SourceSRID, затем объявленная в файле,
+// иначе EPSG:4326; SRID хранения — opts.TargetSRID или исходный.
+// Импорт атомарен: по умолчанию первая некорректная фича отменяет его
+// с *models.FeatureError; режимы skip и make_valid пропускают или
+// исправляют такие фичи, отчёт их перечисляет.
+func (s *GeoService) ImportFeatures(
 	ctx context.Context,
-	reader io.Reader,
+	src formats.FeatureSource,
 	name, description string,
 	userID int,
-) (*models.GeoJSONCollection, error) {
-	// 1) создаём коллекцию как раньше
-	col := &models.GeoJSONCollection{
-		Name:        name,
-		Description: description,
-		SRID:        4326, // или другой SRID по-умолчанию
-		UserID:      userID,
-	}
-	if err := s.repo.CreateCollection(ctx, col); err != nil {
+	opts models.ImportOptions,
+) (*models.GeoJSONCollection, *models.ImportReport, error) {
+	col := &models.GeoJSONCollection{Name: name, Description: description, UserID: userID}
+	var report *models.ImportReport
+	err := s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		var err error
+		report, err = s.importLayer(ctx, tx, src, col, opts)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	s.tiles.Invalidate(col.ID)
+	return col, report, nil
+}
+
+// ImportLayer — слой многослойного файла, импортируемый в отдельную коллекцию.
+type ImportLayer struct {
+	Name        string
+	Description string
+	Source      formats.FeatureSource
+}
+
+// ImportLayers создаёт по коллекции на каждый слой в одной транзакции:
+// ошибка в любом слое отменяет импорт всех. Параметры opts общие для слоёв.
+func (s *GeoService) ImportLayers(
+	ctx context.Context,
+	layers []ImportLayer,
+	userID int,
+	opts models.ImportOptions,
+) ([]*models.GeoJSONCollection, []*models.ImportReport, error) {
+	cols := make([]*models.GeoJSONCollection, len(layers))
+	reports := make([]*models.ImportReport, len(layers))
+	err := s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		for i, l := range layers {
+			cols[i] = &models.GeoJSONCollection{Name: l.Name, Description: l.Description, UserID: userID}
+			var err error
+			if reports[i], err = s.importLayer(ctx, tx, l.Source, cols[i], opts); err != nil {
+				return fmt.Errorf("слой %s: %w", l.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, col := range cols {
+		s.tiles.Invalidate(col.ID)
+	}
+	return cols, reports, nil
+}
+
+// importLayer загружает фичи src в новую коллекцию col внутри транзакции tx.
+func (s *GeoService) importLayer(
+	ctx context.Context,
+	tx *repository.GeoRepository,
+	src formats.FeatureSource,
+	col *models.GeoJSONCollection,
+	opts models.ImportOptions,
+) (*models.ImportReport, error) {
+	var (
+		makeValid = opts.Repair == models.RepairMakeValid
+		skip      = opts.Repair == models.RepairSkip
+		report    = &models.ImportReport{Rejected: []models.FeatureIssue{}, Repaired: []models.FeatureIssue{}}
+		repairs   = map[int]string{}
+	)
+
+	if err := tx.CreateImportStaging(ctx); err != nil {
 		return nil, err
 	}
 
-	// 2) парсим весь GeoJSON из reader
-	raw, err := io.ReadAll(reader)
+	// 1) потоково загружаем фичи, отсеивая геометрии, которые
+	// PostGIS не сможет построить
+	idx := -1
+	_, err := tx.CopyToStaging(ctx, func() (int, *models.GeoJSONFeature, error) {
+		for {
+			f, err := src.Next()
+			var recErr *formats.RecordError
+			if errors.As(err, &recErr) {
+				// запись не стала фичей, но занимает свою позицию в файле
+				idx++
+				if !skip {
+					return 0, nil, &models.FeatureError{Index: idx, Reason: recErr.Reason}
+				}
+				report.Rejected = append(report.Rejected, models.FeatureIssue{Index: idx, Reason: recErr.Reason})
+				continue
+			}
+			if err != nil {
+				return 0, nil, err
+			}
+			idx++
+			changed, err := checkStructure(f, makeValid)
+			if err == nil {
+				if changed {
+					repairs[idx] = "незамкнутые кольца замкнуты"
+				}
+				return idx, f, nil
+			}
+			if !skip {
+				return 0, nil, featureError(src, idx, err.Error())
+			}
+			report.Rejected = append(report.Rejected, models.FeatureIssue{Index: idx, Reason: err.Error()})
+		}
+	})
 	if err != nil {
 		return nil, err
 	}
-	var top struct {
-		Features []struct {
-			Properties json.RawMessage `json:"properties"`
-			Geometry   json.RawMessage `json:"geometry"`
-		} `json:"features"`
+
+	// 2) crs файла известен только после чтения всех фич
+	sourceSRID, err := s.importSourceSRID(ctx, src, opts)
+	if err != nil {
+		return nil, err
 	}
-	if err := json.Unmarshal(raw, &top); err != nil {
+	col.SRID = opts.TargetSRID
+	if col.SRID == 0 {
+		col.SRID = sourceSRID
+	} else if err := s.checkSRID(ctx, col.SRID); err != nil {
 		return nil, err
 	}
 
-	// 3) готовим slice моделей
-	feats := make([]*models.GeoJSONFeature, len(top.Features))
-	for i, f := range top.Features {
-		feats[i] = &models.GeoJSONFeature{
-			Properties:   models.JSONData(f.Properties), // json.RawMessage
-			Geometry:     models.JSONData(f.Geometry),   // json.RawMessage
-			CollectionID: col.ID,
+	// 3) топологическая корректность
+	invalid, err := tx.InvalidStaged(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(invalid) == 0:
+	case makeValid:
+		for _, it := range invalid {
+			if prev, ok := repairs[it.Index]; ok {
+				it.Reason = prev + "; " + it.Reason
+			}
+			repairs[it.Index] = it.Reason
+		}
+	case skip:
+		idxs := make([]int, len(invalid))
+		for i, it := range invalid {
+			idxs[i] = it.Index
 		}
+		if err := tx.DeleteStaged(ctx, idxs); err != nil {
+			return nil, err
+		}
+		report.Rejected = append(report.Rejected, invalid...)
+	default:
+		return nil, featureError(src, invalid[0].Index, invalid[0].Reason)
+	}
+
+	// 4) коллекция и фичи в той же транзакции: при ошибке
+	// не остаётся частично заполненной коллекции
+	if err := tx.CreateCollection(ctx, col); err != nil {
+		return nil, err
+	}
+	n, err := tx.InsertStaged(ctx, col.ID, sourceSRID, col.SRID, makeValid)
+	if err != nil {
+		return nil, err
+	}
+	report.Imported = int(n)
+
+	for i, reason := range repairs {
+		report.Repaired = append(report.Repaired, models.FeatureIssue{Index: i, Reason: reason})
+	}
+	sort.Slice(report.Repaired, func(a, b int) bool { return report.Repaired[a].Index < report.Repaired[b].Index })
+	sort.Slice(report.Rejected, func(a, b int) bool { return report.Rejected[a].Index < report.Rejected[b].Index })
+	return report, nil
+}
+
+// featureError — ошибка фичи index, отменяющая импорт; место записи
+// в файле (строка CSV) добавляется к причине, если источник его знает.
+func featureError(src formats.FeatureSource, index int, reason string) *models.FeatureError {
+	if l, ok := src.(formats.Locator); ok {
+		if loc := l.Locate(index); loc != "" {
+			reason = loc + ": " + reason
+		}
+	}
+	return &models.FeatureError{Index: index, Reason: reason}
+}
+
+// importSourceSRID выбирает исходную систему координат импорта.
+func (s *GeoService) importSourceSRID(ctx context.Context, src formats.FeatureSource, opts models.ImportOptions) (int, error) {
+	srid := opts.SourceSRID
+	if srid == 0 {
+		declared, err := src.SRID()
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrUnknownCRS, err)
+		}
+		srid = declared
+	}
+	if srid == 0 {
+		srid = geo.SRID4326
+	}
+	if err := s.checkSRID(ctx, srid); err != nil {
+		return 0, err
+	}
+	return srid, nil
+}
+
+// validateFeature проверяет геометрию одной фичи через repo — пул или
+// транзакцию, в которой фича затем записывается; отклонённая геометрия
+// возвращается как ErrInvalidGeometry с причиной.
+func validateFeature(ctx context.Context, repo *repository.GeoRepository, feature *models.GeoJSONFeature, repair string) error {
+	makeValid := repair == models.RepairMakeValid
+	if _, err := checkStructure(feature, makeValid); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidGeometry, err)
+	}
+	reason, err := repo.GeometryValidity(ctx, feature.Geometry)
+	if err != nil {
+		return err
+	}
+	if reason != "" && !makeValid {
+		return fmt.Errorf("%w: %s", ErrInvalidGeometry, reason)
+	}
+	return nil
+}
+
+// checkStructure проверяет структуру геометрии фичи (число точек,
+// замкнутость колец): на таких ошибках PostGIS прерывает весь запрос.
+// Замкнутые при closeRings кольца записываются обратно в f.Geometry.
+func checkStructure(f *models.GeoJSONFeature, closeRings bool) (changed bool, err error) {
+	if len(f.Geometry) == 0 || string(f.Geometry) == "null" {
+		return false, errors.New("нет геометрии")
+	}
+	var g geo.Geometry
+	if err := json.Unmarshal(f.Geometry, &g); err != nil {
+		return false, err
 	}
+	if changed, err = geo.CheckStructure(&g, closeRings); err != nil || !changed {
+		return false, err
+	}
+	raw, err := json.Marshal(&g)
+	if err != nil {
+		return false, err
+	}
+	f.Geometry = models.JSONData(raw)
+	return true, nil
+}
+
+// GetTile возвращает векторный тайл z/x/y коллекции, по возможности из кеша.
+// Пустой тайл (в нём нет фич) возвращается как срез нулевой длины.
+func (s *GeoService) GetTile(
+	ctx context.Context,
+	collectionID, z, x, y int,
+	opts models.TileOptions,
+) ([]byte, error) {
+	key := tileKey(z, x, y, opts)
+	if data, ok := s.tiles.Get(collectionID, key); ok {
+		return data, nil
+	}
+	version := s.tiles.Version(collectionID)
 
-	// 4) bulk‑вставка через batch
-	if err := s.repo.AddFeaturesBulk(ctx, feats, col.SRID); err != nil {
+	col, err := s.repo.GetCollectionByID(ctx, collectionID)
+	if err != nil {
 		return nil, err
 	}
+	if col == nil {
+		return nil, ErrCollectionNotFound
+	}
+
+	data, err := s.repo.GetTile(ctx, collectionID, z, x, y, tileLayer, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.tiles.Put(collectionID, version, key, data)
+	return data, nil
+}
+
+// tileKey формирует ключ кеша: координаты тайла и хеш параметров построения.
+func tileKey(z, x, y int, opts models.TileOptions) string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%v|%t|%g", opts.Properties, opts.Properties == nil, opts.Simplify)
+	return fmt.Sprintf("%d/%d/%d-%08x", z, x, y, h.Sum32())
+}
+
+// ListAllCollections получает все коллекции, проходя по страницам.
+func (s *GeoService) ListAllCollections(ctx context.Context) ([]*models.GeoJSONCollection, error) {
+	var (
+		all []*models.GeoJSONCollection
+		req = models.PageRequest{Limit: models.MaxPageLimit}
+	)
+	for {
+		page, err := s.repo.GetCollections(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Items...)
+		if page.Next == nil {
+			return all, nil
+		}
+		req.Cursor = page.Next
+	}
+}
 
-	return col, nil
+// GetCollectionExtents получает охваты коллекций; у пустых коллекций охвата нет
+func (s *GeoService) GetCollectionExtents(ctx context.Context, ids []int) (map[int]*models.Extent, error) {
+	return s.repo.GetCollectionExtents(ctx, ids)
 }
 
 // GetFeatureByID получает фичу по ID
 func (s *GeoService) GetFeatureByID(ctx context.Context, id int) (*models.GeoJSONFeature, error) {
-	return s.repo.GetFeatureByID(ctx, id)
+	return s.repo.GetFeatureByID(ctx, id, 0)
+}
+
+// GetFeatureHistory получает версии фичи, в том числе удалённой;
+// при srid > 0 геометрия перепроецируется в srid
+func (s *GeoService) GetFeatureHistory(ctx context.Context, id, srid int) ([]*models.FeatureVersion, error) {
+	return s.repo.FeatureHistory(ctx, id, srid)
+}
+
+// RevertFeature возвращает фичу к версии version от имени userID; возврат
+// записывается в историю новой версией. Результат — фича после возврата
+// или nil, если версия version — удаление
+func (s *GeoService) RevertFeature(ctx context.Context, id, version, userID int) (*models.GeoJSONFeature, error) {
+	var (
+		feature      *models.GeoJSONFeature
+		collectionID int
+	)
+	err := s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		v, err := tx.FeatureVersion(ctx, id, version)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return ErrVersionNotFound
+		}
+		if v.Operation != models.OperationDelete {
+			col, err := tx.GetCollectionByID(ctx, v.CollectionID)
+			if err != nil {
+				return err
+			}
+			if col == nil {
+				return ErrCollectionNotFound
+			}
+		}
+		if err := tx.RevertFeature(ctx, v); err != nil {
+			return err
+		}
+		collectionID = v.CollectionID
+		feature, err = tx.GetFeatureByID(ctx, id, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.tiles.Invalidate(collectionID)
+	return feature, nil
+}
+
+// RevertCollection одной транзакцией возвращает фичи коллекции
+// к состоянию на момент asOf от имени userID
+func (s *GeoService) RevertCollection(ctx context.Context, collectionID int, asOf time.Time, userID int) (*models.RevertReport, error) {
+	var report *models.RevertReport
+	err := s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		col, err := tx.GetCollectionByID(ctx, collectionID)
+		if err != nil {
+			return err
+		}
+		if col == nil {
+			return ErrCollectionNotFound
+		}
+		report, err = tx.RevertCollection(ctx, collectionID, asOf)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.tiles.Invalidate(collectionID)
+	return report, nil
+}
+
+// GetFeatureInSRID получает фичу с геометрией, перепроецированной в srid
+func (s *GeoService) GetFeatureInSRID(ctx context.Context, id, srid int) (*models.GeoJSONFeature, error) {
+	return s.repo.GetFeatureByID(ctx, id, srid)
 }
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+
+	"Datapolis/internal/models"
+)
+
+func TestCheckStructureFeature(t *testing.T) {
+	open := `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`
+	tests := []struct {
+		name    string
+		geom    string
+		close   bool
+		changed bool
+		want    string
+		wantErr bool
+	}{
+		{name: "нет геометрии", geom: ``, wantErr: true},
+		{name: "null", geom: `null`, wantErr: true},
+		{name: "не GeoJSON", geom: `{"type":"Circle"}`, wantErr: true},
+		{name: "незамкнутое кольцо", geom: open, wantErr: true},
+		// исходная запись геометрии сохраняется, если её не меняли
+		{name: "без изменений", geom: `{"coordinates":[30,60], "type":"Point"}`, want: `{"coordinates":[30,60], "type":"Point"}`},
+		{
+			name: "кольцо замыкается", geom: open, close: true, changed: true,
+			want: `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &models.GeoJSONFeature{Geometry: models.JSONData(tt.geom)}
+			changed, err := checkStructure(f, tt.close)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ждали ошибку")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.changed || string(f.Geometry) != tt.want {
+				t.Errorf("changed = %v, геометрия %s; ждали %v, %s", changed, f.Geometry, tt.changed, tt.want)
+			}
+		})
+	}
+}
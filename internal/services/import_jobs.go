@@ -0,0 +1,474 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"Datapolis/internal/formats"
+	"Datapolis/internal/models"
+	"Datapolis/internal/repository"
+)
+
+var (
+	ErrImportJobNotFound = errors.New("задача импорта не найдена")
+	ErrImportJobFinished = errors.New("задача импорта уже завершена")
+)
+
+const (
+	// importPollInterval — как часто свободный обработчик проверяет очередь,
+	// если его не разбудила новая задача
+	importPollInterval = 5 * time.Second
+	// progressEvery и progressInterval задают частоту записи прогресса
+	progressEvery    = 1000
+	progressInterval = 2 * time.Second
+	// importLease — сколько задача остаётся за обработчиком без отклика;
+	// обработчик продлевает аренду каждые importHeartbeatInterval
+	importLease             = 2 * time.Minute
+	importHeartbeatInterval = 20 * time.Second
+)
+
+// ImportJobs — очередь фоновых задач импорта. Задачи и загруженные файлы
+// хранятся в geo_import_jobs и каталоге dir, поэтому переживают перезапуск.
+// Выполняемая задача арендуется экземпляром сервера owner: несколько
+// серверов могут разбирать одну очередь, а задачи упавшего сервера
+// возвращаются в очередь, когда истекает их аренда.
+type ImportJobs struct {
+	repo    *repository.ImportJobRepository
+	geo     *GeoService
+	dir     string
+	workers int
+	owner   string
+	wake    chan struct{}
+
+	mu      sync.Mutex
+	running map[int]*runningJob
+}
+
+// runningJob — задача, которую выполняет этот сервер: отмена импорта
+// и признак того, что аренда задачи потеряна.
+type runningJob struct {
+	cancel context.CancelFunc
+	lost   atomic.Bool
+}
+
+func NewImportJobs(repo *repository.ImportJobRepository, geo *GeoService, dir string, workers int) *ImportJobs {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if workers <= 0 {
+		workers = 2
+	}
+	host, _ := os.Hostname()
+	return &ImportJobs{
+		repo:    repo,
+		geo:     geo,
+		dir:     dir,
+		workers: workers,
+		owner:   fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano()),
+		wake:    make(chan struct{}, workers),
+		running: make(map[int]*runningJob),
+	}
+}
+
+// Start возвращает в очередь задачи с истёкшей арендой и запускает
+// обработчики; они работают, пока жив ctx. Брошенные задачи проверяются
+// и дальше, раз в importLease.
+func (j *ImportJobs) Start(ctx context.Context) error {
+	if err := os.MkdirAll(j.dir, 0o755); err != nil {
+		return err
+	}
+	if err := j.requeue(ctx); err != nil {
+		return err
+	}
+	for i := 0; i < j.workers; i++ {
+		go j.work(ctx)
+	}
+	go func() {
+		ticker := time.NewTicker(importLease)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := j.requeue(ctx); err != nil {
+				log.Printf("Очередь импорта: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// requeue возвращает в очередь задачи, аренда которых истекла, и удаляет
+// файлы задач, отменённых за это время.
+func (j *ImportJobs) requeue(ctx context.Context) error {
+	cancelled, err := j.repo.Requeue(ctx, importLease)
+	if err != nil {
+		return err
+	}
+	for _, job := range cancelled {
+		os.Remove(job.FilePath)
+	}
+	return nil
+}
+
+// Submit сохраняет файл и ставит задачу в очередь.
+func (j *ImportJobs) Submit(ctx context.Context, file io.Reader, job *models.ImportJob) error {
+	f, err := os.CreateTemp(j.dir, "import-*.upload")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, file)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	job.FilePath = f.Name()
+	if err := j.repo.Create(ctx, job); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	select {
+	case j.wake <- struct{}{}:
+	default: // все обработчики и так скоро проверят очередь
+	}
+	return nil
+}
+
+// Get возвращает задачу по ID
+func (j *ImportJobs) Get(ctx context.Context, id int) (*models.ImportJob, error) {
+	job, err := j.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, ErrImportJobNotFound
+	}
+	return job, nil
+}
+
+// Cancel отменяет задачу: из очереди — сразу, выполняющуюся —
+// прерыванием импорта с откатом транзакции.
+func (j *ImportJobs) Cancel(ctx context.Context, id int) (*models.ImportJob, error) {
+	job, err := j.repo.RequestCancel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, ErrImportJobNotFound
+	}
+
+	switch job.State {
+	case models.JobCancelled:
+		os.Remove(job.FilePath)
+	case models.JobRunning:
+		j.mu.Lock()
+		if run, ok := j.running[id]; ok {
+			run.cancel()
+		}
+		j.mu.Unlock()
+	default:
+		return job, ErrImportJobFinished
+	}
+	return job, nil
+}
+
+func (j *ImportJobs) work(ctx context.Context) {
+	ticker := time.NewTicker(importPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// разбираем очередь, пока в ней есть задачи
+		for ctx.Err() == nil {
+			job, err := j.repo.ClaimNext(ctx, j.owner)
+			if err != nil {
+				log.Printf("Очередь импорта: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			j.run(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-j.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// run выполняет задачу и записывает её итог.
+func (j *ImportJobs) run(parent context.Context, job *models.ImportJob) {
+	ctx, cancel := context.WithCancel(parent)
+	run := &runningJob{cancel: cancel}
+	j.mu.Lock()
+	j.running[job.ID] = run
+	j.mu.Unlock()
+	defer func() {
+		j.mu.Lock()
+		delete(j.running, job.ID)
+		j.mu.Unlock()
+		cancel()
+	}()
+	go j.heartbeat(ctx, job.ID, run)
+
+	err := j.importFile(ctx, run, job)
+	if parent.Err() != nil {
+		// сервер останавливается: задача вернётся в очередь, когда
+		// истечёт её аренда
+		return
+	}
+	if run.lost.Load() {
+		log.Printf("Задача импорта %d: аренда истекла, задача возвращена в очередь", job.ID)
+		return
+	}
+
+	switch {
+	case err == nil:
+		job.State = models.JobDone
+	case ctx.Err() != nil:
+		job.State = models.JobCancelled
+	default:
+		job.State = models.JobFailed
+		job.Error = err.Error()
+		var fe *models.FeatureError
+		if errors.As(err, &fe) {
+			job.ErrorIndex = &fe.Index
+		}
+	}
+
+	ok, err := j.repo.Finish(context.Background(), job, j.owner)
+	if err != nil {
+		log.Printf("Задача импорта %d: не удалось сохранить итог: %v", job.ID, err)
+		return
+	}
+	if !ok {
+		log.Printf("Задача импорта %d: аренда истекла, итог не сохранён", job.ID)
+		return
+	}
+	os.Remove(job.FilePath)
+}
+
+// heartbeat продлевает аренду задачи, пока она выполняется, в том числе
+// пока импорт записывает фичи и прогресс не сохраняется. Потерянная
+// аренда или запрошенная отмена прерывают импорт.
+func (j *ImportJobs) heartbeat(ctx context.Context, id int, run *runningJob) {
+	ticker := time.NewTicker(importHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cancel, ok, err := j.repo.Heartbeat(ctx, id, j.owner)
+		switch {
+		case err != nil:
+			if ctx.Err() == nil {
+				log.Printf("Задача импорта %d: не удалось продлить аренду: %v", id, err)
+			}
+		case !ok:
+			run.lost.Store(true)
+			run.cancel()
+			return
+		case cancel:
+			run.cancel()
+			return
+		}
+	}
+}
+
+// importFile импортирует файл задачи и записывает в неё созданные
+// коллекции и отчёт.
+func (j *ImportJobs) importFile(ctx context.Context, run *runningJob, job *models.ImportJob) error {
+	var src formats.FeatureSource
+	switch job.Format {
+	case models.FormatGeoJSON:
+		f, err := os.Open(job.FilePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = formats.NewGeoJSONReader(bufio.NewReader(f))
+	case models.FormatShapefile:
+		shp, err := formats.OpenShapefileZip(job.FilePath, job.Options.Encoding)
+		if err != nil {
+			return err
+		}
+		defer shp.Close()
+		src = shp
+	case models.FormatCSV:
+		f, err := os.Open(job.FilePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		opts, err := csvOptions(job.Options)
+		if err != nil {
+			return err
+		}
+		if src, err = formats.NewCSVReader(f, opts); err != nil {
+			return err
+		}
+	case models.FormatGeoPackage:
+		return j.importGeoPackage(ctx, run, job)
+	default:
+		return fmt.Errorf("неизвестный формат %q", job.Format)
+	}
+
+	progress := j.progress(ctx, run, job, src)
+	col, report, err := j.geo.ImportFeatures(ctx, progress, job.Name, job.Description, job.UserID, job.Options)
+	if err != nil {
+		return err
+	}
+	job.Report = report
+	job.Rejected = len(report.Rejected)
+	job.CollectionID = &col.ID
+	return nil
+}
+
+// csvOptions переводит параметры импорта в параметры чтения CSV.
+func csvOptions(o models.ImportOptions) (formats.CSVOptions, error) {
+	opts := formats.CSVOptions{LatColumn: o.LatColumn, LonColumn: o.LonColumn, WKTColumn: o.WKTColumn}
+	if o.Delimiter != "" {
+		opts.Delimiter, _ = utf8.DecodeRuneInString(o.Delimiter)
+	}
+	if o.Encoding != "" {
+		enc, err := formats.ParseCodePage(o.Encoding)
+		if err != nil {
+			return opts, err
+		}
+		opts.Encoding = enc
+	}
+	return opts, nil
+}
+
+// importGeoPackage создаёт по коллекции на каждую векторную таблицу
+// GeoPackage или только на таблицу job.Options.Layer. Имя задачи
+// становится именем коллекции, если слой один; иначе коллекции
+// называются по слоям.
+func (j *ImportJobs) importGeoPackage(ctx context.Context, run *runningJob, job *models.ImportJob) error {
+	gpkg, err := formats.OpenGeoPackage(job.FilePath)
+	if err != nil {
+		return err
+	}
+	defer gpkg.Close()
+
+	all, err := gpkg.Layers(ctx)
+	if err != nil {
+		return err
+	}
+	var selected []*formats.GeoPackageLayer
+	for _, l := range all {
+		if job.Options.Layer == "" || l.Table == job.Options.Layer {
+			selected = append(selected, l)
+		}
+	}
+	if len(selected) == 0 {
+		if job.Options.Layer != "" {
+			return fmt.Errorf("%w: в GeoPackage нет векторной таблицы %q", formats.ErrInvalidFile, job.Options.Layer)
+		}
+		return fmt.Errorf("%w: в GeoPackage нет векторных таблиц", formats.ErrInvalidFile)
+	}
+
+	layers := make([]ImportLayer, len(selected))
+	for i, l := range selected {
+		features := gpkg.Features(ctx, l)
+		defer features.Close()
+		layers[i] = ImportLayer{
+			Name:        l.Identifier,
+			Description: l.Description,
+			Source:      j.progress(ctx, run, job, features),
+		}
+		if len(selected) == 1 && job.Name != "" {
+			layers[i].Name = job.Name
+		}
+		if job.Description != "" {
+			layers[i].Description = job.Description
+		}
+	}
+
+	cols, reports, err := j.geo.ImportLayers(ctx, layers, job.UserID, job.Options)
+	if err != nil {
+		return err
+	}
+	job.Layers = make([]models.ImportedLayer, len(cols))
+	for i, col := range cols {
+		job.Layers[i] = models.ImportedLayer{Layer: selected[i].Table, CollectionID: col.ID, Report: reports[i]}
+		job.Rejected += len(reports[i].Rejected)
+	}
+	if len(cols) == 1 {
+		job.Report = reports[0]
+		job.CollectionID = &cols[0].ID
+	}
+	return nil
+}
+
+// progressSource считает прочитанные фичи и периодически сохраняет
+// прогресс задачи; заодно узнаёт, не запрошена ли отмена.
+type progressSource struct {
+	formats.FeatureSource
+	ctx   context.Context
+	run   *runningJob
+	job   *models.ImportJob
+	repo  *repository.ImportJobRepository
+	owner string
+	saved time.Time
+}
+
+// Locate передаёт место записи от источника, если он его знает.
+func (p *progressSource) Locate(index int) string {
+	if l, ok := p.FeatureSource.(formats.Locator); ok {
+		return l.Locate(index)
+	}
+	return ""
+}
+
+func (j *ImportJobs) progress(ctx context.Context, run *runningJob, job *models.ImportJob, src formats.FeatureSource) *progressSource {
+	return &progressSource{FeatureSource: src, ctx: ctx, run: run, job: job, repo: j.repo, owner: j.owner}
+}
+
+func (p *progressSource) Next() (*models.GeoJSONFeature, error) {
+	if err := p.ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := p.FeatureSource.Next()
+	var recErr *formats.RecordError
+	if err != nil && !errors.As(err, &recErr) {
+		return nil, err
+	}
+	p.job.Processed++
+	if p.job.Processed%progressEvery == 0 || time.Since(p.saved) > progressInterval {
+		p.saved = time.Now()
+		cancelled, ok, err := p.repo.UpdateProgress(p.ctx, p.job.ID, p.owner, p.job.Processed)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			p.run.lost.Store(true)
+		}
+		if cancelled || !ok {
+			p.run.cancel()
+			return nil, context.Canceled
+		}
+	}
+	return f, err
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"Datapolis/internal/formats"
+	"Datapolis/internal/models"
+	"Datapolis/internal/repository"
+)
+
+// testService создаёт сервис над базой DATABASE_URL с применёнными
+// миграциями. Импорт фиксирует свои транзакции, поэтому тесты удаляют
+// созданные коллекции сами. Без DATABASE_URL тест пропускается.
+func testService(t *testing.T) (*GeoService, *pgxpool.Pool) {
+	t.Helper()
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL не задан")
+	}
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return NewGeoService(repository.NewGeoRepository(pool), NewTileCache(0, "")), pool
+}
+
+// dropCollections удаляет коллекции с именем name вместе с фичами и историей.
+func dropCollections(t *testing.T, pool *pgxpool.Pool, name string) {
+	ctx := context.Background()
+	if _, err := pool.Exec(ctx, `
+	DELETE FROM geo_features_history
+	 WHERE collection_id IN (SELECT id FROM geo_collections WHERE name = $1)`, name); err != nil {
+		t.Error(err)
+	}
+	if _, err := pool.Exec(ctx, `DELETE FROM geo_collections WHERE name = $1`, name); err != nil {
+		t.Error(err)
+	}
+}
+
+// countCollections возвращает число коллекций с именем name.
+func countCollections(t *testing.T, pool *pgxpool.Pool, name string) int {
+	t.Helper()
+	var n int
+	if err := pool.QueryRow(context.Background(),
+		`SELECT count(*) FROM geo_collections WHERE name = $1`, name,
+	).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// importFile — FeatureCollection из четырёх фич: корректной точки,
+// полигона с незамкнутым кольцом, самопересекающегося полигона и
+// ещё одной точки.
+const importFile = `{"type":"FeatureCollection","features":[
+	{"type":"Feature","properties":{"n":0},"geometry":{"type":"Point","coordinates":[30,60]}},
+	{"type":"Feature","properties":{"n":1},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}},
+	{"type":"Feature","properties":{"n":2},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,1],[1,0],[0,1],[0,0]]]}},
+	{"type":"Feature","properties":{"n":3},"geometry":{"type":"Point","coordinates":[31,61]}}
+]}`
+
+func TestImportFeaturesAtomic(t *testing.T) {
+	s, pool := testService(t)
+	ctx := context.Background()
+	name := fmt.Sprintf("import-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() { dropCollections(t, pool, name) })
+
+	// первая некорректная фича отменяет импорт целиком
+	_, _, err := s.ImportFeatures(ctx, formats.NewGeoJSONReader(strings.NewReader(importFile)), name, "", 1, models.ImportOptions{})
+	var fe *models.FeatureError
+	if !errors.As(err, &fe) || fe.Index != 1 {
+		t.Fatalf("ждали ошибку фичи 1, получили %v", err)
+	}
+	if n := countCollections(t, pool, name); n != 0 {
+		t.Fatalf("после отменённого импорта осталось коллекций: %d", n)
+	}
+
+	// самопересечение находит уже PostGIS, после загрузки во временную таблицу
+	file := strings.Replace(importFile, "[0,1]]]}}", "[0,1],[0,0]]]}}", 1)
+	_, _, err = s.ImportFeatures(ctx, formats.NewGeoJSONReader(strings.NewReader(file)), name, "", 1, models.ImportOptions{})
+	if !errors.As(err, &fe) || fe.Index != 2 || !strings.Contains(fe.Reason, "Self-intersection") {
+		t.Fatalf("ждали ошибку фичи 2, получили %v", err)
+	}
+	if n := countCollections(t, pool, name); n != 0 {
+		t.Fatalf("после отменённого импорта осталось коллекций: %d", n)
+	}
+}
+
+func TestImportFeaturesRepair(t *testing.T) {
+	s, pool := testService(t)
+	ctx := context.Background()
+	name := fmt.Sprintf("import-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() { dropCollections(t, pool, name) })
+
+	tests := []struct {
+		repair   string
+		imported int
+		rejected []int
+		repaired []int
+	}{
+		{models.RepairSkip, 2, []int{1, 2}, []int{}},
+		{models.RepairMakeValid, 4, []int{}, []int{1, 2}},
+	}
+	indexes := func(issues []models.FeatureIssue) []int {
+		idx := []int{}
+		for _, it := range issues {
+			idx = append(idx, it.Index)
+		}
+		return idx
+	}
+	for _, tt := range tests {
+		t.Run(tt.repair, func(t *testing.T) {
+			col, report, err := s.ImportFeatures(ctx, formats.NewGeoJSONReader(strings.NewReader(importFile)), name, "", 1,
+				models.ImportOptions{Repair: tt.repair})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Imported != tt.imported ||
+				!reflect.DeepEqual(indexes(report.Rejected), tt.rejected) ||
+				!reflect.DeepEqual(indexes(report.Repaired), tt.repaired) {
+				t.Errorf("отчёт %+v", report)
+			}
+			var n int
+			if err := pool.QueryRow(ctx, `SELECT count(*) FROM geo_features WHERE collection_id = $1`, col.ID).Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.imported {
+				t.Errorf("в коллекции %d фич, ждали %d", n, tt.imported)
+			}
+		})
+	}
+}
+
+// TestImportLayersAtomic проверяет, что ошибка во втором слое отменяет
+// и уже загруженный первый.
+func TestImportLayersAtomic(t *testing.T) {
+	s, pool := testService(t)
+	name := fmt.Sprintf("import-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() { dropCollections(t, pool, name) })
+
+	good := `{"type":"FeatureCollection","features":[{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[30,60]}}]}`
+	layers := []ImportLayer{
+		{Name: name, Source: formats.NewGeoJSONReader(strings.NewReader(good))},
+		{Name: name, Source: formats.NewGeoJSONReader(strings.NewReader(importFile))},
+	}
+	_, _, err := s.ImportLayers(context.Background(), layers, 1, models.ImportOptions{})
+	var fe *models.FeatureError
+	if !errors.As(err, &fe) || fe.Index != 1 {
+		t.Fatalf("ждали ошибку фичи 1 второго слоя, получили %v", err)
+	}
+	if n := countCollections(t, pool, name); n != 0 {
+		t.Errorf("после отменённого импорта осталось коллекций: %d", n)
+	}
+}
+
+// locatedSource — источник, сообщающий место записи, как CSVReader.
+type locatedSource struct{}
+
+func (locatedSource) Next() (*models.GeoJSONFeature, error) { return nil, io.EOF }
+func (locatedSource) SRID() (int, error)                    { return 0, nil }
+func (locatedSource) Locate(index int) string {
+	if index < 0 {
+		return ""
+	}
+	return fmt.Sprintf("строка %d", index+2)
+}
+
+func TestFeatureError(t *testing.T) {
+	err := featureError(locatedSource{}, 3, "нет геометрии")
+	if err.Index != 3 || err.Error() != "фича 3: строка 5: нет геометрии" {
+		t.Errorf("с местом записи: %v", err)
+	}
+	err = featureError(locatedSource{}, -1, "нет геометрии")
+	if err.Error() != "фича -1: нет геометрии" {
+		t.Errorf("без места записи: %v", err)
+	}
+	err = featureError(formats.NewGeoJSONReader(strings.NewReader("")), 0, "нет геометрии")
+	if err.Error() != "фича 0: нет геометрии" {
+		t.Errorf("источник без Locate: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// TileCache хранит готовые векторные тайлы: в памяти (LRU на maxItems
+// тайлов) и, если задан dir, на диске в dir/<id коллекции>/<ключ>.mvt.
+// Тайлы коллекции сбрасываются целиком при любом изменении её фич.
+type TileCache struct {
+	mu       sync.Mutex
+	maxItems int
+	dir      string
+	lru      *list.List
+	items    map[tileCacheKey]*list.Element
+	versions map[int]uint64
+}
+
+type tileCacheKey struct {
+	collectionID int
+	key          string
+}
+
+type tileCacheEntry struct {
+	key  tileCacheKey
+	data []byte
+}
+
+// NewTileCache создаёт кеш тайлов; пустой dir отключает дисковый уровень.
+func NewTileCache(maxItems int, dir string) *TileCache {
+	if maxItems <= 0 {
+		maxItems = 1024
+	}
+	return &TileCache{
+		maxItems: maxItems,
+		dir:      dir,
+		lru:      list.New(),
+		items:    make(map[tileCacheKey]*list.Element),
+		versions: make(map[int]uint64),
+	}
+}
+
+// Get возвращает тайл из памяти или с диска.
+func (tc *TileCache) Get(collectionID int, key string) ([]byte, bool) {
+	k := tileCacheKey{collectionID, key}
+
+	tc.mu.Lock()
+	if el, ok := tc.items[k]; ok {
+		tc.lru.MoveToFront(el)
+		data := el.Value.(*tileCacheEntry).data
+		tc.mu.Unlock()
+		return data, true
+	}
+	version := tc.versions[collectionID]
+	tc.mu.Unlock()
+
+	if tc.dir == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(tc.path(collectionID, key))
+	if err != nil {
+		return nil, false
+	}
+	tc.Put(collectionID, version, key, data)
+	return data, true
+}
+
+// Version возвращает текущее поколение тайлов коллекции. Его нужно
+// запомнить до построения тайла и передать в Put: тайл, построенный
+// до инвалидации, в кеш уже не попадёт.
+func (tc *TileCache) Version(collectionID int) uint64 {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.versions[collectionID]
+}
+
+// Put сохраняет тайл, если коллекция не менялась с момента version.
+func (tc *TileCache) Put(collectionID int, version uint64, key string, data []byte) {
+	k := tileCacheKey{collectionID, key}
+
+	tc.mu.Lock()
+	if tc.versions[collectionID] != version {
+		tc.mu.Unlock()
+		return
+	}
+	if el, ok := tc.items[k]; ok {
+		el.Value.(*tileCacheEntry).data = data
+		tc.lru.MoveToFront(el)
+	} else {
+		tc.items[k] = tc.lru.PushFront(&tileCacheEntry{key: k, data: data})
+		for tc.lru.Len() > tc.maxItems {
+			oldest := tc.lru.Back()
+			tc.lru.Remove(oldest)
+			delete(tc.items, oldest.Value.(*tileCacheEntry).key)
+		}
+	}
+	tc.mu.Unlock()
+
+	if tc.dir == "" {
+		return
+	}
+	if err := tc.writeFile(collectionID, key, data); err != nil {
+		log.Printf("Не удалось сохранить тайл на диск: %v", err)
+		return
+	}
+	// инвалидация могла случиться, пока файл писался
+	if tc.Version(collectionID) != version {
+		os.Remove(tc.path(collectionID, key))
+	}
+}
+
+// Invalidate удаляет все тайлы коллекции.
+func (tc *TileCache) Invalidate(collectionID int) {
+	tc.mu.Lock()
+	tc.versions[collectionID]++
+	for k, el := range tc.items {
+		if k.collectionID == collectionID {
+			tc.lru.Remove(el)
+			delete(tc.items, k)
+		}
+	}
+	tc.mu.Unlock()
+
+	if tc.dir != "" {
+		if err := os.RemoveAll(filepath.Join(tc.dir, strconv.Itoa(collectionID))); err != nil {
+			log.Printf("Не удалось очистить кеш тайлов коллекции %d: %v", collectionID, err)
+		}
+	}
+}
+
+func (tc *TileCache) path(collectionID int, key string) string {
+	return filepath.Join(tc.dir, strconv.Itoa(collectionID), filepath.FromSlash(key)+".mvt")
+}
+
+// writeFile пишет тайл через временный файл, чтобы читатели
+// не увидели его недописанным.
+func (tc *TileCache) writeFile(collectionID int, key string, data []byte) error {
+	path := tc.path(collectionID, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"Datapolis/internal/models"
+)
+
+func TestTileCacheLRU(t *testing.T) {
+	tc := NewTileCache(2, "")
+	tc.Put(1, 0, "0/0/0", []byte("a"))
+	tc.Put(1, 0, "1/0/0", []byte("b"))
+	// обращение поднимает тайл, вытесняется самый давний
+	if _, ok := tc.Get(1, "0/0/0"); !ok {
+		t.Fatal("тайл 0/0/0 не найден")
+	}
+	tc.Put(1, 0, "1/1/0", []byte("c"))
+
+	if _, ok := tc.Get(1, "1/0/0"); ok {
+		t.Error("давний тайл не вытеснен")
+	}
+	for key, want := range map[string]string{"0/0/0": "a", "1/1/0": "c"} {
+		if data, ok := tc.Get(1, key); !ok || string(data) != want {
+			t.Errorf("тайл %s = %q, %v", key, data, ok)
+		}
+	}
+
+	// повторный Put заменяет данные, не занимая места
+	tc.Put(1, 0, "0/0/0", []byte("a2"))
+	if data, _ := tc.Get(1, "0/0/0"); string(data) != "a2" {
+		t.Errorf("после замены %q", data)
+	}
+	if _, ok := tc.Get(1, "1/1/0"); !ok {
+		t.Error("замена вытеснила другой тайл")
+	}
+}
+
+func TestTileCacheInvalidate(t *testing.T) {
+	tc := NewTileCache(10, "")
+	v1 := tc.Version(1)
+	tc.Put(1, v1, "0/0/0", []byte("a"))
+	tc.Put(2, tc.Version(2), "0/0/0", []byte("b"))
+
+	tc.Invalidate(1)
+	if _, ok := tc.Get(1, "0/0/0"); ok {
+		t.Error("тайл коллекции 1 остался после инвалидации")
+	}
+	if _, ok := tc.Get(2, "0/0/0"); !ok {
+		t.Error("инвалидация задела коллекцию 2")
+	}
+
+	// тайл, построенный до инвалидации, в кеш не попадает
+	tc.Put(1, v1, "0/0/0", []byte("устаревший"))
+	if _, ok := tc.Get(1, "0/0/0"); ok {
+		t.Error("устаревший тайл сохранён")
+	}
+	tc.Put(1, tc.Version(1), "0/0/0", []byte("новый"))
+	if data, ok := tc.Get(1, "0/0/0"); !ok || string(data) != "новый" {
+		t.Errorf("новый тайл: %q, %v", data, ok)
+	}
+}
+
+func TestTileCacheDisk(t *testing.T) {
+	dir := t.TempDir()
+	tc := NewTileCache(1, dir)
+	tc.Put(7, 0, "3/2/1-0000abcd", []byte("a"))
+	tc.Put(7, 0, "3/2/2-0000abcd", []byte("b")) // вытесняет первый из памяти
+
+	if _, err := os.Stat(filepath.Join(dir, "7", "3", "2", "1-0000abcd.mvt")); err != nil {
+		t.Fatal(err)
+	}
+	// вытесненный из памяти тайл читается с диска
+	if data, ok := tc.Get(7, "3/2/1-0000abcd"); !ok || string(data) != "a" {
+		t.Errorf("с диска: %q, %v", data, ok)
+	}
+	// и переживает перезапуск
+	if data, ok := NewTileCache(1, dir).Get(7, "3/2/2-0000abcd"); !ok || string(data) != "b" {
+		t.Errorf("после перезапуска: %q, %v", data, ok)
+	}
+
+	tc.Invalidate(7)
+	if _, err := os.Stat(filepath.Join(dir, "7")); !os.IsNotExist(err) {
+		t.Errorf("каталог коллекции не удалён: %v", err)
+	}
+	if _, ok := NewTileCache(1, dir).Get(7, "3/2/1-0000abcd"); ok {
+		t.Error("тайл остался на диске после инвалидации")
+	}
+}
+
+func TestTileKey(t *testing.T) {
+	keys := map[string]models.TileOptions{}
+	for _, opts := range []models.TileOptions{
+		{Simplify: 1},
+		{Simplify: 0},
+		{Simplify: 1, Properties: []string{}},
+		{Simplify: 1, Properties: []string{"name"}},
+		{Simplify: 1, Properties: []string{"name", "type"}},
+	} {
+		key := tileKey(3, 2, 1, opts)
+		if prev, ok := keys[key]; ok {
+			t.Errorf("у %+v и %+v одинаковый ключ %s", prev, opts, key)
+		}
+		keys[key] = opts
+		if key != tileKey(3, 2, 1, opts) {
+			t.Errorf("ключ %+v нестабилен", opts)
+		}
+	}
+	if tileKey(3, 2, 1, models.TileOptions{}) == tileKey(3, 1, 2, models.TileOptions{}) {
+		t.Error("ключ не зависит от координат")
+	}
+}
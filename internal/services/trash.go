@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"Datapolis/internal/models"
+	"Datapolis/internal/repository"
+)
+
+// ErrNotInTrash — коллекции или фичи с таким ID в корзине нет.
+var ErrNotInTrash = errors.New("в корзине не найдено")
+
+const (
+	// DefaultTrashRetention — срок хранения в корзине, если он не задан
+	DefaultTrashRetention = 30 * 24 * time.Hour
+	// purgeInterval — как часто очистка проверяет корзину
+	purgeInterval = time.Hour
+)
+
+// GetTrashedCollections получает страницу коллекций в корзине
+func (s *GeoService) GetTrashedCollections(
+	ctx context.Context,
+	p models.PageRequest,
+) (*models.Page[*models.GeoJSONCollection], error) {
+	return s.repo.TrashedCollections(ctx, p)
+}
+
+// GetTrashedFeatures получает страницу фич, удалённых по отдельности;
+// фичи удалённых коллекций в неё не входят
+func (s *GeoService) GetTrashedFeatures(
+	ctx context.Context,
+	p models.PageRequest,
+) (*models.Page[*models.GeoJSONFeature], error) {
+	return s.repo.TrashedFeatures(ctx, p)
+}
+
+// RestoreCollection возвращает коллекцию из корзины вместе с фичами,
+// удалёнными вместе с ней; в истории фич это записывается от имени userID
+func (s *GeoService) RestoreCollection(ctx context.Context, id, userID int) (*models.GeoJSONCollection, error) {
+	var col *models.GeoJSONCollection
+	err := s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		ok, err := tx.RestoreCollection(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotInTrash
+		}
+		col, err = tx.GetCollectionByID(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.tiles.Invalidate(id)
+	return col, nil
+}
+
+// RestoreFeature возвращает фичу из корзины от имени userID; если
+// в корзине и её коллекция, возвращается ErrCollectionNotFound
+func (s *GeoService) RestoreFeature(ctx context.Context, id, userID int) (*models.GeoJSONFeature, error) {
+	var feature *models.GeoJSONFeature
+	err := s.repo.AsUser(ctx, userID, func(tx *repository.GeoRepository) error {
+		collectionID, ok, err := tx.TrashedFeatureCollection(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotInTrash
+		}
+		col, err := tx.GetCollectionByID(ctx, collectionID)
+		if err != nil {
+			return err
+		}
+		if col == nil {
+			return ErrCollectionNotFound
+		}
+		if err := tx.RestoreFeature(ctx, id); err != nil {
+			return err
+		}
+		feature, err = tx.GetFeatureByID(ctx, id, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.tiles.Invalidate(feature.CollectionID)
+	return feature, nil
+}
+
+// ParseTrashRetention разбирает срок хранения в корзине: число дней
+// с суффиксом d ("30d") или длительность Go ("36h", "90m"). Пустая
+// строка — DefaultTrashRetention.
+func ParseTrashRetention(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return DefaultTrashRetention, nil
+	}
+	var d time.Duration
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n > int(time.Duration(1<<63-1)/(24*time.Hour)) {
+			return 0, fmt.Errorf("срок хранения %q: ожидается число дней, например 30d", raw)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(raw); err != nil {
+			return 0, fmt.Errorf("срок хранения %q: ожидается 30d или длительность вида 36h", raw)
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("срок хранения %q должен быть положительным", raw)
+	}
+	return d, nil
+}
+
+// StartTrashPurge запускает фоновую очистку корзины: раз в purgeInterval
+// окончательно удаляется всё, что пролежало в ней дольше retention.
+// Очистка работает, пока жив ctx.
+func (s *GeoService) StartTrashPurge(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		retention = DefaultTrashRetention
+	}
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+		for {
+			s.purgeTrash(ctx, time.Now().Add(-retention))
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *GeoService) purgeTrash(ctx context.Context, before time.Time) {
+	collections, features, err := s.repo.PurgeTrash(ctx, before)
+	if err != nil {
+		log.Printf("Очистка корзины: %v", err)
+		return
+	}
+	if collections > 0 || features > 0 {
+		log.Printf("Очистка корзины: удалено коллекций %d, фич %d", collections, features)
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTrashRetention(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{raw: "", want: DefaultTrashRetention},
+		{raw: "30d", want: 30 * 24 * time.Hour},
+		{raw: " 7d ", want: 7 * 24 * time.Hour},
+		{raw: "36h", want: 36 * time.Hour},
+		{raw: "1h30m", want: 90 * time.Minute},
+		{raw: "0d", wantErr: true},
+		{raw: "-1d", wantErr: true},
+		{raw: "-5h", wantErr: true},
+		{raw: "1.5d", wantErr: true},
+		{raw: "d", wantErr: true},
+		{raw: "30", wantErr: true},
+		{raw: "месяц", wantErr: true},
+		{raw: "999999999d", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := ParseTrashRetention(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ждали ошибку, получили %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTrashRetention(%q) = %v, ждали %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+-- +goose Up
+
+CREATE INDEX IF NOT EXISTS geo_features_properties_gin
+    ON geo_features USING GIN (properties jsonb_path_ops);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS geo_features_properties_gin;
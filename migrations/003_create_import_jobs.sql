@@ -0,0 +1,32 @@
+-- +goose Up
+
+CREATE TABLE geo_import_jobs (
+                                 id               SERIAL PRIMARY KEY,
+                                 state            TEXT        NOT NULL DEFAULT 'queued',
+                                 format           TEXT        NOT NULL,
+                                 name             TEXT        NOT NULL,
+                                 description      TEXT,
+                                 user_id          INT         NOT NULL,
+                                 options          JSONB       NOT NULL DEFAULT '{}'::jsonb,
+                                 file_path        TEXT        NOT NULL,
+                                 processed        INT         NOT NULL DEFAULT 0,
+                                 rejected         INT         NOT NULL DEFAULT 0,
+                                 report           JSONB,
+                                 error            TEXT,
+                                 error_index      INT,
+                                 collection_id    INT REFERENCES geo_collections(id) ON DELETE SET NULL,
+                                 cancel_requested BOOLEAN     NOT NULL DEFAULT FALSE,
+                                 created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                 updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                 started_at       TIMESTAMPTZ,
+                                 finished_at      TIMESTAMPTZ
+);
+
+-- очередь выбирается по state, поэтому индексируем только незавершённые задачи
+CREATE INDEX geo_import_jobs_pending_idx ON geo_import_jobs (id)
+    WHERE state IN ('queued', 'running');
+
+-- +goose Down
+
+DROP INDEX IF EXISTS geo_import_jobs_pending_idx;
+DROP TABLE IF EXISTS geo_import_jobs;
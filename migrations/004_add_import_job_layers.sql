@@ -0,0 +1,8 @@
+-- +goose Up
+
+-- коллекции, созданные из слоёв многослойного файла (GeoPackage)
+ALTER TABLE geo_import_jobs ADD COLUMN layers JSONB;
+
+-- +goose Down
+
+ALTER TABLE geo_import_jobs DROP COLUMN IF EXISTS layers;
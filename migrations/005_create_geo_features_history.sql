@@ -0,0 +1,65 @@
+-- +goose Up
+
+-- история изменений фич: по строке на каждую вставку, изменение и удаление.
+-- Внешних ключей нет, чтобы история переживала удаление фичи и коллекции.
+CREATE TABLE geo_features_history (
+                                      id            BIGSERIAL PRIMARY KEY,
+                                      feature_id    INT         NOT NULL,
+                                      collection_id INT,
+                                      version       INT         NOT NULL,
+                                      operation     TEXT        NOT NULL,
+                                      properties    JSONB,
+                                      geometry      geometry,
+                                      user_id       INT,
+                                      changed_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX geo_features_history_version_idx ON geo_features_history (feature_id, version);
+-- восстановление состояния коллекции на момент времени (as_of)
+CREATE INDEX geo_features_history_as_of_idx ON geo_features_history (collection_id, changed_at);
+
+-- автор изменения берётся из настройки транзакции datapolis.user_id,
+-- которую выставляет репозиторий (GeoRepository.AsUser)
+-- +goose StatementBegin
+CREATE FUNCTION geo_features_record_history() RETURNS trigger AS $$
+DECLARE
+    rec geo_features%ROWTYPE;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        rec := OLD;
+    ELSE
+        rec := NEW;
+    END IF;
+
+    INSERT INTO geo_features_history
+        (feature_id, collection_id, version, operation, properties, geometry, user_id)
+    SELECT rec.id, rec.collection_id, COALESCE(max(h.version), 0) + 1, lower(TG_OP),
+           rec.properties, rec.geometry,
+           NULLIF(current_setting('datapolis.user_id', true), '')::int
+      FROM geo_features_history h
+     WHERE h.feature_id = rec.id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER geo_features_history_trg
+    AFTER INSERT OR UPDATE OR DELETE ON geo_features
+    FOR EACH ROW EXECUTE FUNCTION geo_features_record_history();
+
+-- Истории до этой миграции нет: текущее состояние существующей фичи
+-- записывается первой версией на момент её последнего изменения (updated_at),
+-- а не создания, — более ранних свойств и геометрии мы не знаем. Запрос
+-- as_of раньше updated_at такую фичу не видит, а откат коллекции на такой
+-- момент удаляет её.
+INSERT INTO geo_features_history
+    (feature_id, collection_id, version, operation, properties, geometry, changed_at)
+SELECT id, collection_id, 1, 'insert', properties, geometry, updated_at
+  FROM geo_features;
+
+-- +goose Down
+
+DROP TRIGGER IF EXISTS geo_features_history_trg ON geo_features;
+DROP FUNCTION IF EXISTS geo_features_record_history();
+DROP TABLE IF EXISTS geo_features_history;
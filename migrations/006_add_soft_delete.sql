@@ -0,0 +1,84 @@
+-- +goose Up
+
+-- мягкое удаление: строка остаётся в корзине до окончательного удаления
+ALTER TABLE geo_collections ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE geo_features ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- корзину и очистку по сроку хранения выбираем только среди удалённых
+CREATE INDEX geo_collections_deleted_idx ON geo_collections (deleted_at)
+    WHERE deleted_at IS NOT NULL;
+CREATE INDEX geo_features_deleted_idx ON geo_features (deleted_at)
+    WHERE deleted_at IS NOT NULL;
+
+-- в истории перенос в корзину — удаление, возврат из неё — restore;
+-- окончательное удаление из корзины повторно не записывается
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION geo_features_record_history() RETURNS trigger AS $$
+DECLARE
+    rec geo_features%ROWTYPE;
+    op  TEXT := lower(TG_OP);
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        rec := OLD;
+    ELSE
+        rec := NEW;
+    END IF;
+
+    IF TG_OP = 'UPDATE' THEN
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+            op := 'delete';
+        ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+            op := 'restore';
+        END IF;
+    END IF;
+
+    INSERT INTO geo_features_history
+        (feature_id, collection_id, version, operation, properties, geometry, user_id)
+    SELECT rec.id, rec.collection_id, COALESCE(max(h.version), 0) + 1, op,
+           rec.properties, rec.geometry,
+           NULLIF(current_setting('datapolis.user_id', true), '')::int
+      FROM geo_features_history h
+     WHERE h.feature_id = rec.id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- содержимое корзины удаляется окончательно, пока история ещё не пишет это удалением
+DELETE FROM geo_features WHERE deleted_at IS NOT NULL;
+DELETE FROM geo_collections WHERE deleted_at IS NOT NULL;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION geo_features_record_history() RETURNS trigger AS $$
+DECLARE
+    rec geo_features%ROWTYPE;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        rec := OLD;
+    ELSE
+        rec := NEW;
+    END IF;
+
+    INSERT INTO geo_features_history
+        (feature_id, collection_id, version, operation, properties, geometry, user_id)
+    SELECT rec.id, rec.collection_id, COALESCE(max(h.version), 0) + 1, lower(TG_OP),
+           rec.properties, rec.geometry,
+           NULLIF(current_setting('datapolis.user_id', true), '')::int
+      FROM geo_features_history h
+     WHERE h.feature_id = rec.id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP INDEX IF EXISTS geo_features_deleted_idx;
+DROP INDEX IF EXISTS geo_collections_deleted_idx;
+ALTER TABLE geo_features DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE geo_collections DROP COLUMN IF EXISTS deleted_at;
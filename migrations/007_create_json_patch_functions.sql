@@ -0,0 +1,164 @@
+-- +goose Up
+
+-- PATCH фичи: документ {"properties": ..., "geometry": ...} изменяется
+-- на стороне сервера по JSON Merge Patch (RFC 7396) или JSON Patch
+-- (RFC 6902). Ошибки патча — SQLSTATE 22023 с текстом для клиента.
+
+-- +goose StatementBegin
+CREATE FUNCTION jsonb_merge_patch(target jsonb, patch jsonb) RETURNS jsonb AS $$
+BEGIN
+    IF jsonb_typeof(patch) IS DISTINCT FROM 'object' THEN
+        RETURN patch;
+    END IF;
+    IF jsonb_typeof(target) IS DISTINCT FROM 'object' THEN
+        target := '{}';
+    END IF;
+    -- null в патче удаляет ключ, объекты сливаются рекурсивно
+    RETURN COALESCE((
+        SELECT jsonb_object_agg(m.key, m.value)
+          FROM (SELECT t.key, t.value
+                  FROM jsonb_each(target) t
+                 WHERE NOT patch ? t.key
+                UNION ALL
+                SELECT p.key, jsonb_merge_patch(target -> p.key, p.value)
+                  FROM jsonb_each(patch) p
+                 WHERE jsonb_typeof(p.value) <> 'null') m
+    ), '{}');
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+-- +goose StatementEnd
+
+-- JSON Pointer (RFC 6901) → путь для операторов #>, #- и jsonb_set
+-- +goose StatementBegin
+CREATE FUNCTION jsonb_patch_path(pointer text) RETURNS text[] AS $$
+BEGIN
+    IF pointer IS NULL THEN
+        RAISE EXCEPTION 'JSON Patch: не указан путь' USING ERRCODE = '22023';
+    END IF;
+    IF pointer = '' THEN
+        RETURN '{}';
+    END IF;
+    IF left(pointer, 1) <> '/' THEN
+        RAISE EXCEPTION 'JSON Patch: путь % должен начинаться с /', pointer USING ERRCODE = '22023';
+    END IF;
+    RETURN ARRAY(
+        SELECT replace(replace(s.part, '~1', '/'), '~0', '~')
+          FROM unnest(string_to_array(substr(pointer, 2), '/')) WITH ORDINALITY AS s(part, n)
+         ORDER BY s.n);
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION jsonb_patch_add(doc jsonb, path text[], val jsonb) RETURNS jsonb AS $$
+DECLARE
+    n      int := cardinality(path);
+    parent jsonb;
+    idx    text;
+BEGIN
+    IF n = 0 THEN
+        RETURN val;
+    END IF;
+    parent := doc #> path[1:n - 1];
+    CASE jsonb_typeof(parent)
+    WHEN 'object' THEN
+        RETURN jsonb_set(doc, path, val, true);
+    WHEN 'array' THEN
+        idx := path[n];
+        IF idx = '-' THEN
+            idx := jsonb_array_length(parent)::text;
+        ELSIF idx !~ '^(0|[1-9][0-9]*)$' OR idx::int > jsonb_array_length(parent) THEN
+            RAISE EXCEPTION 'JSON Patch: неверный индекс массива %', idx USING ERRCODE = '22023';
+        END IF;
+        -- индекс, равный длине, jsonb_insert понимает как добавление в конец
+        RETURN jsonb_insert(doc, path[1:n - 1] || idx, val);
+    ELSE
+        RAISE EXCEPTION 'JSON Patch: путь /% не существует', array_to_string(path, '/') USING ERRCODE = '22023';
+    END CASE;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION jsonb_patch_remove(doc jsonb, path text[]) RETURNS jsonb AS $$
+DECLARE
+    n int := cardinality(path);
+BEGIN
+    IF n = 0 THEN
+        RAISE EXCEPTION 'JSON Patch: нельзя удалить весь документ' USING ERRCODE = '22023';
+    END IF;
+    IF doc #> path IS NULL
+       OR (jsonb_typeof(doc #> path[1:n - 1]) = 'array' AND path[n] !~ '^(0|[1-9][0-9]*)$') THEN
+        RAISE EXCEPTION 'JSON Patch: путь /% не существует', array_to_string(path, '/') USING ERRCODE = '22023';
+    END IF;
+    RETURN doc #- path;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION jsonb_json_patch(doc jsonb, patch jsonb) RETURNS jsonb AS $$
+DECLARE
+    op   jsonb;
+    path text[];
+    src  text[];
+    val  jsonb;
+BEGIN
+    IF jsonb_typeof(patch) IS DISTINCT FROM 'array' THEN
+        RAISE EXCEPTION 'JSON Patch должен быть массивом операций' USING ERRCODE = '22023';
+    END IF;
+
+    FOR op IN SELECT value FROM jsonb_array_elements(patch) LOOP
+        path := jsonb_patch_path(op ->> 'path');
+        IF op ->> 'op' IN ('add', 'replace', 'test') THEN
+            IF NOT op ? 'value' THEN
+                RAISE EXCEPTION 'JSON Patch: у операции % нет value', op ->> 'op' USING ERRCODE = '22023';
+            END IF;
+            val := op -> 'value';
+        ELSIF op ->> 'op' IN ('move', 'copy') THEN
+            src := jsonb_patch_path(op ->> 'from');
+            val := doc #> src;
+            IF val IS NULL THEN
+                RAISE EXCEPTION 'JSON Patch: путь % не существует', op ->> 'from' USING ERRCODE = '22023';
+            END IF;
+        END IF;
+
+        CASE op ->> 'op'
+        WHEN 'add' THEN
+            doc := jsonb_patch_add(doc, path, val);
+        WHEN 'remove' THEN
+            doc := jsonb_patch_remove(doc, path);
+        WHEN 'replace' THEN
+            IF cardinality(path) = 0 THEN
+                doc := val;
+            ELSE
+                doc := jsonb_patch_add(jsonb_patch_remove(doc, path), path, val);
+            END IF;
+        WHEN 'move' THEN
+            IF path[1:cardinality(src)] = src AND cardinality(path) > cardinality(src) THEN
+                RAISE EXCEPTION 'JSON Patch: нельзя переместить % внутрь себя', op ->> 'from' USING ERRCODE = '22023';
+            END IF;
+            doc := jsonb_patch_add(jsonb_patch_remove(doc, src), path, val);
+        WHEN 'copy' THEN
+            doc := jsonb_patch_add(doc, path, val);
+        WHEN 'test' THEN
+            IF doc #> path IS DISTINCT FROM val THEN
+                RAISE EXCEPTION 'JSON Patch: проверка % не прошла', op ->> 'path' USING ERRCODE = '22023';
+            END IF;
+        ELSE
+            RAISE EXCEPTION 'JSON Patch: неизвестная операция %', COALESCE(op ->> 'op', 'null') USING ERRCODE = '22023';
+        END CASE;
+    END LOOP;
+
+    RETURN doc;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+
+DROP FUNCTION IF EXISTS jsonb_json_patch(jsonb, jsonb);
+DROP FUNCTION IF EXISTS jsonb_patch_remove(jsonb, text[]);
+DROP FUNCTION IF EXISTS jsonb_patch_add(jsonb, text[], jsonb);
+DROP FUNCTION IF EXISTS jsonb_patch_path(text);
+DROP FUNCTION IF EXISTS jsonb_merge_patch(jsonb, jsonb);
@@ -0,0 +1,12 @@
+-- +goose Up
+
+-- аренда задачи импорта: обработчик, который её выполняет, и время его
+-- последнего отклика. Задача с истёкшей арендой возвращается в очередь,
+-- живые задачи других экземпляров сервера не трогаются.
+ALTER TABLE geo_import_jobs ADD COLUMN locked_by TEXT;
+ALTER TABLE geo_import_jobs ADD COLUMN heartbeat_at TIMESTAMPTZ;
+
+-- +goose Down
+
+ALTER TABLE geo_import_jobs DROP COLUMN IF EXISTS heartbeat_at;
+ALTER TABLE geo_import_jobs DROP COLUMN IF EXISTS locked_by;
@@ -0,0 +1,19 @@
+-- +goose Up
+
+-- Значение свойства как timestamptz для сравнений CQL2 с TIMESTAMP и DATE.
+-- Строка, похожая на дату, но не разбираемая (2024-02-30, 2024-13-01,
+-- 2024-03-01 в 25:00), даёт NULL, а сравнение — ложь, как для значений
+-- других типов, вместо ошибки 22008 на весь запрос.
+-- +goose StatementBegin
+CREATE FUNCTION cql2_timestamptz(value text) RETURNS timestamptz AS $$
+BEGIN
+    RETURN value::timestamptz;
+EXCEPTION WHEN data_exception THEN
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql STABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+
+DROP FUNCTION IF EXISTS cql2_timestamptz(text);
@@ -0,0 +1,11 @@
+-- +goose Up
+
+-- Индекс для фильтра dwithin: расстояние в метрах считается по geography
+-- в WGS 84, а по geo_features_geom_gix в SRID хранения его не отобрать.
+-- Выражение должно совпадать с условием в featureConditions.
+CREATE INDEX geo_features_geog_gix
+    ON geo_features USING GIST ((ST_Transform(geometry, 4326)::geography));
+
+-- +goose Down
+
+DROP INDEX IF EXISTS geo_features_geog_gix;
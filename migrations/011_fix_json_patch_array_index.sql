@@ -0,0 +1,66 @@
+-- +goose Up
+
+-- Индекс массива в пути add сравнивается с длиной массива как numeric:
+-- приведение к int на индексе длиннее 10 цифр давало ошибку 22003
+-- на весь запрос вместо ошибки патча 22023.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION jsonb_patch_add(doc jsonb, path text[], val jsonb) RETURNS jsonb AS $$
+DECLARE
+    n      int := cardinality(path);
+    parent jsonb;
+    idx    text;
+BEGIN
+    IF n = 0 THEN
+        RETURN val;
+    END IF;
+    parent := doc #> path[1:n - 1];
+    CASE jsonb_typeof(parent)
+    WHEN 'object' THEN
+        RETURN jsonb_set(doc, path, val, true);
+    WHEN 'array' THEN
+        idx := path[n];
+        IF idx = '-' THEN
+            idx := jsonb_array_length(parent)::text;
+        ELSIF idx !~ '^(0|[1-9][0-9]*)$' OR idx::numeric > jsonb_array_length(parent) THEN
+            RAISE EXCEPTION 'JSON Patch: неверный индекс массива %', idx USING ERRCODE = '22023';
+        END IF;
+        -- индекс, равный длине, jsonb_insert понимает как добавление в конец
+        RETURN jsonb_insert(doc, path[1:n - 1] || idx, val);
+    ELSE
+        RAISE EXCEPTION 'JSON Patch: путь /% не существует', array_to_string(path, '/') USING ERRCODE = '22023';
+    END CASE;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION jsonb_patch_add(doc jsonb, path text[], val jsonb) RETURNS jsonb AS $$
+DECLARE
+    n      int := cardinality(path);
+    parent jsonb;
+    idx    text;
+BEGIN
+    IF n = 0 THEN
+        RETURN val;
+    END IF;
+    parent := doc #> path[1:n - 1];
+    CASE jsonb_typeof(parent)
+    WHEN 'object' THEN
+        RETURN jsonb_set(doc, path, val, true);
+    WHEN 'array' THEN
+        idx := path[n];
+        IF idx = '-' THEN
+            idx := jsonb_array_length(parent)::text;
+        ELSIF idx !~ '^(0|[1-9][0-9]*)$' OR idx::int > jsonb_array_length(parent) THEN
+            RAISE EXCEPTION 'JSON Patch: неверный индекс массива %', idx USING ERRCODE = '22023';
+        END IF;
+        -- индекс, равный длине, jsonb_insert понимает как добавление в конец
+        RETURN jsonb_insert(doc, path[1:n - 1] || idx, val);
+    ELSE
+        RAISE EXCEPTION 'JSON Patch: путь /% не существует', array_to_string(path, '/') USING ERRCODE = '22023';
+    END CASE;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+
+-- Индекс из миграции 010 вычислял ST_Transform при каждой записи, и запись
+-- геометрии, которую нельзя перепроецировать (SRID 0 или неизвестный),
+-- падала на обновлении индекса. Теперь dwithin в метрических проекциях
+-- идёт по geo_features_geom_gix, а geography без перепроецирования
+-- индексируется только для геометрий в WGS 84. Условие индекса совпадает
+-- с условием в dwithinCondition.
+DROP INDEX IF EXISTS geo_features_geog_gix;
+CREATE INDEX geo_features_geog_gix
+    ON geo_features USING GIST ((geometry::geography))
+    WHERE ST_SRID(geometry) = 4326;
+
+-- +goose Down
+
+DROP INDEX IF EXISTS geo_features_geog_gix;
+CREATE INDEX geo_features_geog_gix
+    ON geo_features USING GIST ((ST_Transform(geometry, 4326)::geography));